@@ -20,16 +20,31 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	BPELProcessService_CreateProcess_FullMethodName      = "/bpel.BPELProcessService/CreateProcess"
-	BPELProcessService_GetProcess_FullMethodName         = "/bpel.BPELProcessService/GetProcess"
-	BPELProcessService_UpdateProcess_FullMethodName      = "/bpel.BPELProcessService/UpdateProcess"
-	BPELProcessService_DeleteProcess_FullMethodName      = "/bpel.BPELProcessService/DeleteProcess"
-	BPELProcessService_DeleteAllProcesses_FullMethodName = "/bpel.BPELProcessService/DeleteAllProcesses"
-	BPELProcessService_GetAllProcesses_FullMethodName    = "/bpel.BPELProcessService/GetAllProcesses"
-	BPELProcessService_ExecuteProcess_FullMethodName     = "/bpel.BPELProcessService/ExecuteProcess"
-	BPELProcessService_Publish_FullMethodName            = "/bpel.BPELProcessService/Publish"
-	BPELProcessService_Subscribe_FullMethodName          = "/bpel.BPELProcessService/Subscribe"
-	BPELProcessService_GetProcessStatus_FullMethodName   = "/bpel.BPELProcessService/GetProcessStatus"
+	BPELProcessService_CreateProcess_FullMethodName       = "/bpel.BPELProcessService/CreateProcess"
+	BPELProcessService_GetProcess_FullMethodName          = "/bpel.BPELProcessService/GetProcess"
+	BPELProcessService_UpdateProcess_FullMethodName       = "/bpel.BPELProcessService/UpdateProcess"
+	BPELProcessService_DeleteProcess_FullMethodName       = "/bpel.BPELProcessService/DeleteProcess"
+	BPELProcessService_DeleteAllProcesses_FullMethodName  = "/bpel.BPELProcessService/DeleteAllProcesses"
+	BPELProcessService_GetAllProcesses_FullMethodName     = "/bpel.BPELProcessService/GetAllProcesses"
+	BPELProcessService_ExecuteProcess_FullMethodName      = "/bpel.BPELProcessService/ExecuteProcess"
+	BPELProcessService_Publish_FullMethodName             = "/bpel.BPELProcessService/Publish"
+	BPELProcessService_CancelPublication_FullMethodName   = "/bpel.BPELProcessService/CancelPublication"
+	BPELProcessService_ListRunMethods_FullMethodName      = "/bpel.BPELProcessService/ListRunMethods"
+	BPELProcessService_Subscribe_FullMethodName           = "/bpel.BPELProcessService/Subscribe"
+	BPELProcessService_GetProcessStatus_FullMethodName    = "/bpel.BPELProcessService/GetProcessStatus"
+	BPELProcessService_WatchInstance_FullMethodName       = "/bpel.BPELProcessService/WatchInstance"
+	BPELProcessService_WatchProcess_FullMethodName        = "/bpel.BPELProcessService/WatchProcess"
+	BPELProcessService_GetInstanceHistory_FullMethodName  = "/bpel.BPELProcessService/GetInstanceHistory"
+	BPELProcessService_ListInstances_FullMethodName       = "/bpel.BPELProcessService/ListInstances"
+	BPELProcessService_ListProcesses_FullMethodName       = "/bpel.BPELProcessService/ListProcesses"
+	BPELProcessService_CreateSchedule_FullMethodName      = "/bpel.BPELProcessService/CreateSchedule"
+	BPELProcessService_ListSchedules_FullMethodName       = "/bpel.BPELProcessService/ListSchedules"
+	BPELProcessService_PauseSchedule_FullMethodName       = "/bpel.BPELProcessService/PauseSchedule"
+	BPELProcessService_ResumeSchedule_FullMethodName      = "/bpel.BPELProcessService/ResumeSchedule"
+	BPELProcessService_DeleteSchedule_FullMethodName      = "/bpel.BPELProcessService/DeleteSchedule"
+	BPELProcessService_ListCircuitBreakers_FullMethodName = "/bpel.BPELProcessService/ListCircuitBreakers"
+	BPELProcessService_ReloadConfig_FullMethodName        = "/bpel.BPELProcessService/ReloadConfig"
+	BPELProcessService_ListAuditEvents_FullMethodName     = "/bpel.BPELProcessService/ListAuditEvents"
 )
 
 // BPELProcessServiceClient is the client API for BPELProcessService service.
@@ -44,8 +59,23 @@ type BPELProcessServiceClient interface {
 	GetAllProcesses(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*GetAllProcessesResponse, error)
 	ExecuteProcess(ctx context.Context, in *ExecuteProcessRequest, opts ...grpc.CallOption) (*ExecuteProcessResponse, error)
 	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	CancelPublication(ctx context.Context, in *CancelPublicationRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListRunMethods(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListRunMethodsResponse, error)
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	GetProcessStatus(ctx context.Context, in *GetProcessStatusRequest, opts ...grpc.CallOption) (*GetProcessStatusResponse, error)
+	WatchInstance(ctx context.Context, in *WatchInstanceRequest, opts ...grpc.CallOption) (BPELProcessService_WatchInstanceClient, error)
+	WatchProcess(ctx context.Context, in *WatchProcessRequest, opts ...grpc.CallOption) (BPELProcessService_WatchProcessClient, error)
+	GetInstanceHistory(ctx context.Context, in *GetInstanceHistoryRequest, opts ...grpc.CallOption) (*GetInstanceHistoryResponse, error)
+	ListInstances(ctx context.Context, in *ListInstancesRequest, opts ...grpc.CallOption) (*ListInstancesResponse, error)
+	ListProcesses(ctx context.Context, in *ListProcessesRequest, opts ...grpc.CallOption) (*ListProcessesResponse, error)
+	CreateSchedule(ctx context.Context, in *Schedule, opts ...grpc.CallOption) (*Schedule, error)
+	ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error)
+	PauseSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*Schedule, error)
+	ResumeSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*Schedule, error)
+	DeleteSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListCircuitBreakers(ctx context.Context, in *ListCircuitBreakersRequest, opts ...grpc.CallOption) (*ListCircuitBreakersResponse, error)
+	ReloadConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+	ListAuditEvents(ctx context.Context, in *ListAuditEventsRequest, opts ...grpc.CallOption) (*ListAuditEventsResponse, error)
 }
 
 type bPELProcessServiceClient struct {
@@ -136,6 +166,26 @@ func (c *bPELProcessServiceClient) Publish(ctx context.Context, in *PublishReque
 	return out, nil
 }
 
+func (c *bPELProcessServiceClient) CancelPublication(ctx context.Context, in *CancelPublicationRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, BPELProcessService_CancelPublication_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListRunMethods(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListRunMethodsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRunMethodsResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListRunMethods_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *bPELProcessServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(emptypb.Empty)
@@ -156,6 +206,182 @@ func (c *bPELProcessServiceClient) GetProcessStatus(ctx context.Context, in *Get
 	return out, nil
 }
 
+func (c *bPELProcessServiceClient) WatchInstance(ctx context.Context, in *WatchInstanceRequest, opts ...grpc.CallOption) (BPELProcessService_WatchInstanceClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BPELProcessService_ServiceDesc.Streams[0], BPELProcessService_WatchInstance_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bPELProcessServiceWatchInstanceClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BPELProcessService_WatchInstanceClient interface {
+	Recv() (*InstanceEvent, error)
+	grpc.ClientStream
+}
+
+type bPELProcessServiceWatchInstanceClient struct {
+	grpc.ClientStream
+}
+
+func (x *bPELProcessServiceWatchInstanceClient) Recv() (*InstanceEvent, error) {
+	m := new(InstanceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bPELProcessServiceClient) WatchProcess(ctx context.Context, in *WatchProcessRequest, opts ...grpc.CallOption) (BPELProcessService_WatchProcessClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &BPELProcessService_ServiceDesc.Streams[1], BPELProcessService_WatchProcess_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bPELProcessServiceWatchProcessClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BPELProcessService_WatchProcessClient interface {
+	Recv() (*InstanceEvent, error)
+	grpc.ClientStream
+}
+
+type bPELProcessServiceWatchProcessClient struct {
+	grpc.ClientStream
+}
+
+func (x *bPELProcessServiceWatchProcessClient) Recv() (*InstanceEvent, error) {
+	m := new(InstanceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bPELProcessServiceClient) GetInstanceHistory(ctx context.Context, in *GetInstanceHistoryRequest, opts ...grpc.CallOption) (*GetInstanceHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetInstanceHistoryResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_GetInstanceHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListInstances(ctx context.Context, in *ListInstancesRequest, opts ...grpc.CallOption) (*ListInstancesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListInstancesResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListInstances_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListProcesses(ctx context.Context, in *ListProcessesRequest, opts ...grpc.CallOption) (*ListProcessesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListProcessesResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListProcesses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) CreateSchedule(ctx context.Context, in *Schedule, opts ...grpc.CallOption) (*Schedule, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Schedule)
+	err := c.cc.Invoke(ctx, BPELProcessService_CreateSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSchedulesResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListSchedules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) PauseSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*Schedule, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Schedule)
+	err := c.cc.Invoke(ctx, BPELProcessService_PauseSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ResumeSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*Schedule, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Schedule)
+	err := c.cc.Invoke(ctx, BPELProcessService_ResumeSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) DeleteSchedule(ctx context.Context, in *ScheduleRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, BPELProcessService_DeleteSchedule_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListCircuitBreakers(ctx context.Context, in *ListCircuitBreakersRequest, opts ...grpc.CallOption) (*ListCircuitBreakersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListCircuitBreakersResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListCircuitBreakers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ReloadConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ReloadConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bPELProcessServiceClient) ListAuditEvents(ctx context.Context, in *ListAuditEventsRequest, opts ...grpc.CallOption) (*ListAuditEventsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAuditEventsResponse)
+	err := c.cc.Invoke(ctx, BPELProcessService_ListAuditEvents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BPELProcessServiceServer is the server API for BPELProcessService service.
 // All implementations must embed UnimplementedBPELProcessServiceServer
 // for forward compatibility
@@ -168,8 +394,23 @@ type BPELProcessServiceServer interface {
 	GetAllProcesses(context.Context, *emptypb.Empty) (*GetAllProcessesResponse, error)
 	ExecuteProcess(context.Context, *ExecuteProcessRequest) (*ExecuteProcessResponse, error)
 	Publish(context.Context, *PublishRequest) (*emptypb.Empty, error)
+	CancelPublication(context.Context, *CancelPublicationRequest) (*emptypb.Empty, error)
+	ListRunMethods(context.Context, *emptypb.Empty) (*ListRunMethodsResponse, error)
 	Subscribe(context.Context, *SubscribeRequest) (*emptypb.Empty, error)
 	GetProcessStatus(context.Context, *GetProcessStatusRequest) (*GetProcessStatusResponse, error)
+	WatchInstance(*WatchInstanceRequest, BPELProcessService_WatchInstanceServer) error
+	WatchProcess(*WatchProcessRequest, BPELProcessService_WatchProcessServer) error
+	GetInstanceHistory(context.Context, *GetInstanceHistoryRequest) (*GetInstanceHistoryResponse, error)
+	ListInstances(context.Context, *ListInstancesRequest) (*ListInstancesResponse, error)
+	ListProcesses(context.Context, *ListProcessesRequest) (*ListProcessesResponse, error)
+	CreateSchedule(context.Context, *Schedule) (*Schedule, error)
+	ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error)
+	PauseSchedule(context.Context, *ScheduleRequest) (*Schedule, error)
+	ResumeSchedule(context.Context, *ScheduleRequest) (*Schedule, error)
+	DeleteSchedule(context.Context, *ScheduleRequest) (*emptypb.Empty, error)
+	ListCircuitBreakers(context.Context, *ListCircuitBreakersRequest) (*ListCircuitBreakersResponse, error)
+	ReloadConfig(context.Context, *emptypb.Empty) (*ReloadConfigResponse, error)
+	ListAuditEvents(context.Context, *ListAuditEventsRequest) (*ListAuditEventsResponse, error)
 	mustEmbedUnimplementedBPELProcessServiceServer()
 }
 
@@ -201,12 +442,57 @@ func (UnimplementedBPELProcessServiceServer) ExecuteProcess(context.Context, *Ex
 func (UnimplementedBPELProcessServiceServer) Publish(context.Context, *PublishRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
 }
+func (UnimplementedBPELProcessServiceServer) CancelPublication(context.Context, *CancelPublicationRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelPublication not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListRunMethods(context.Context, *emptypb.Empty) (*ListRunMethodsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRunMethods not implemented")
+}
 func (UnimplementedBPELProcessServiceServer) Subscribe(context.Context, *SubscribeRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
 func (UnimplementedBPELProcessServiceServer) GetProcessStatus(context.Context, *GetProcessStatusRequest) (*GetProcessStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetProcessStatus not implemented")
 }
+func (UnimplementedBPELProcessServiceServer) WatchInstance(*WatchInstanceRequest, BPELProcessService_WatchInstanceServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchInstance not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) WatchProcess(*WatchProcessRequest, BPELProcessService_WatchProcessServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchProcess not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) GetInstanceHistory(context.Context, *GetInstanceHistoryRequest) (*GetInstanceHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInstanceHistory not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListInstances(context.Context, *ListInstancesRequest) (*ListInstancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListInstances not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListProcesses(context.Context, *ListProcessesRequest) (*ListProcessesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListProcesses not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) CreateSchedule(context.Context, *Schedule) (*Schedule, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSchedule not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchedules not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) PauseSchedule(context.Context, *ScheduleRequest) (*Schedule, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseSchedule not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ResumeSchedule(context.Context, *ScheduleRequest) (*Schedule, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeSchedule not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) DeleteSchedule(context.Context, *ScheduleRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSchedule not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListCircuitBreakers(context.Context, *ListCircuitBreakersRequest) (*ListCircuitBreakersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCircuitBreakers not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ReloadConfig(context.Context, *emptypb.Empty) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedBPELProcessServiceServer) ListAuditEvents(context.Context, *ListAuditEventsRequest) (*ListAuditEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuditEvents not implemented")
+}
 func (UnimplementedBPELProcessServiceServer) mustEmbedUnimplementedBPELProcessServiceServer() {}
 
 // UnsafeBPELProcessServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -364,6 +650,42 @@ func _BPELProcessService_Publish_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BPELProcessService_CancelPublication_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelPublicationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).CancelPublication(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_CancelPublication_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).CancelPublication(ctx, req.(*CancelPublicationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListRunMethods_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListRunMethods(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListRunMethods_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListRunMethods(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _BPELProcessService_Subscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SubscribeRequest)
 	if err := dec(in); err != nil {
@@ -400,6 +722,246 @@ func _BPELProcessService_GetProcessStatus_Handler(srv interface{}, ctx context.C
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BPELProcessService_WatchInstance_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchInstanceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BPELProcessServiceServer).WatchInstance(m, &bPELProcessServiceWatchInstanceServer{ServerStream: stream})
+}
+
+type BPELProcessService_WatchInstanceServer interface {
+	Send(*InstanceEvent) error
+	grpc.ServerStream
+}
+
+type bPELProcessServiceWatchInstanceServer struct {
+	grpc.ServerStream
+}
+
+func (x *bPELProcessServiceWatchInstanceServer) Send(m *InstanceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BPELProcessService_WatchProcess_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchProcessRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BPELProcessServiceServer).WatchProcess(m, &bPELProcessServiceWatchProcessServer{ServerStream: stream})
+}
+
+type BPELProcessService_WatchProcessServer interface {
+	Send(*InstanceEvent) error
+	grpc.ServerStream
+}
+
+type bPELProcessServiceWatchProcessServer struct {
+	grpc.ServerStream
+}
+
+func (x *bPELProcessServiceWatchProcessServer) Send(m *InstanceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BPELProcessService_GetInstanceHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInstanceHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).GetInstanceHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_GetInstanceHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).GetInstanceHistory(ctx, req.(*GetInstanceHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListInstances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListInstancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListInstances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListInstances_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListInstances(ctx, req.(*ListInstancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListProcesses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListProcessesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListProcesses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListProcesses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListProcesses(ctx, req.(*ListProcessesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_CreateSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Schedule)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).CreateSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_CreateSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).CreateSchedule(ctx, req.(*Schedule))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListSchedules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchedulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListSchedules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListSchedules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListSchedules(ctx, req.(*ListSchedulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_PauseSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).PauseSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_PauseSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).PauseSchedule(ctx, req.(*ScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ResumeSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ResumeSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ResumeSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ResumeSchedule(ctx, req.(*ScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_DeleteSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).DeleteSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_DeleteSchedule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).DeleteSchedule(ctx, req.(*ScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListCircuitBreakers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCircuitBreakersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListCircuitBreakers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListCircuitBreakers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListCircuitBreakers(ctx, req.(*ListCircuitBreakersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ReloadConfig(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BPELProcessService_ListAuditEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAuditEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BPELProcessServiceServer).ListAuditEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BPELProcessService_ListAuditEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BPELProcessServiceServer).ListAuditEvents(ctx, req.(*ListAuditEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // BPELProcessService_ServiceDesc is the grpc.ServiceDesc for BPELProcessService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -439,6 +1001,14 @@ var BPELProcessService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Publish",
 			Handler:    _BPELProcessService_Publish_Handler,
 		},
+		{
+			MethodName: "CancelPublication",
+			Handler:    _BPELProcessService_CancelPublication_Handler,
+		},
+		{
+			MethodName: "ListRunMethods",
+			Handler:    _BPELProcessService_ListRunMethods_Handler,
+		},
 		{
 			MethodName: "Subscribe",
 			Handler:    _BPELProcessService_Subscribe_Handler,
@@ -447,7 +1017,62 @@ var BPELProcessService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetProcessStatus",
 			Handler:    _BPELProcessService_GetProcessStatus_Handler,
 		},
+		{
+			MethodName: "GetInstanceHistory",
+			Handler:    _BPELProcessService_GetInstanceHistory_Handler,
+		},
+		{
+			MethodName: "ListInstances",
+			Handler:    _BPELProcessService_ListInstances_Handler,
+		},
+		{
+			MethodName: "ListProcesses",
+			Handler:    _BPELProcessService_ListProcesses_Handler,
+		},
+		{
+			MethodName: "CreateSchedule",
+			Handler:    _BPELProcessService_CreateSchedule_Handler,
+		},
+		{
+			MethodName: "ListSchedules",
+			Handler:    _BPELProcessService_ListSchedules_Handler,
+		},
+		{
+			MethodName: "PauseSchedule",
+			Handler:    _BPELProcessService_PauseSchedule_Handler,
+		},
+		{
+			MethodName: "ResumeSchedule",
+			Handler:    _BPELProcessService_ResumeSchedule_Handler,
+		},
+		{
+			MethodName: "DeleteSchedule",
+			Handler:    _BPELProcessService_DeleteSchedule_Handler,
+		},
+		{
+			MethodName: "ListCircuitBreakers",
+			Handler:    _BPELProcessService_ListCircuitBreakers_Handler,
+		},
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _BPELProcessService_ReloadConfig_Handler,
+		},
+		{
+			MethodName: "ListAuditEvents",
+			Handler:    _BPELProcessService_ListAuditEvents_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchInstance",
+			Handler:       _BPELProcessService_WatchInstance_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchProcess",
+			Handler:       _BPELProcessService_WatchProcess_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/bpel.proto",
 }
@@ -0,0 +1,10 @@
+package api
+
+import (
+    _ "embed"
+)
+
+// OpenAPI is the OpenAPI description of the REST gateway, generated from bpel.proto.
+//
+//go:embed bpel.swagger.json
+var OpenAPI []byte
@@ -0,0 +1,134 @@
+// Command devtoken issues bearer tokens for development and testing. It
+// keeps a P-256 signing key in -key, creating it on first use, writes the
+// matching public key set to -jwks for the server's auth.jwksFile, and
+// prints a token for -sub.
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "math/big"
+    "os"
+    "strings"
+    "time"
+
+    "gobpel/pkg/auth"
+)
+
+func main() {
+    keyFile := flag.String("key", "dev-key.json", "signing key, created if missing")
+    jwksFile := flag.String("jwks", "dev-jwks.json", "public key set written for the server")
+    subject := flag.String("sub", "developer", "subject of the token")
+    issuer := flag.String("iss", "gobpel-dev", "issuer of the token")
+    audience := flag.String("aud", "gobpel", "audience of the token")
+    ttl := flag.Duration("ttl", time.Hour, "lifetime of the token")
+    var extra claimFlags
+    flag.Var(&extra, "claim", "extra claim as name=value, repeatable; comma separated values become a list")
+    flag.Parse()
+
+    key, kid, err := loadKey(*keyFile)
+    if err != nil {
+        log.Fatalf("failed to load signing key: %v", err)
+    }
+    public := auth.JWKS{Keys: []auth.JWK{ecJWK(key, kid, false)}}
+    data, _ := json.MarshalIndent(public, "", "  ")
+    if err := os.WriteFile(*jwksFile, data, 0644); err != nil {
+        log.Fatalf("failed to write key set: %v", err)
+    }
+
+    now := time.Now()
+    claims := map[string]interface{}{
+        "sub": *subject,
+        "iss": *issuer,
+        "aud": *audience,
+        "iat": now.Unix(),
+        "exp": now.Add(*ttl).Unix(),
+    }
+    for name, value := range extra {
+        claims[name] = value
+    }
+    token, err := auth.SignES256(claims, key, kid)
+    if err != nil {
+        log.Fatalf("failed to sign token: %v", err)
+    }
+    fmt.Println(token)
+}
+
+type claimFlags map[string]interface{}
+
+func (c *claimFlags) String() string {
+    return ""
+}
+
+func (c *claimFlags) Set(value string) error {
+    name, v, ok := strings.Cut(value, "=")
+    if !ok {
+        return errors.New("expected name=value")
+    }
+    if *c == nil {
+        *c = make(claimFlags)
+    }
+    if strings.Contains(v, ",") {
+        (*c)[name] = strings.Split(v, ",")
+    } else {
+        (*c)[name] = v
+    }
+    return nil
+}
+
+func loadKey(name string) (*ecdsa.PrivateKey, string, error) {
+    data, err := os.ReadFile(name)
+    if errors.Is(err, os.ErrNotExist) {
+        key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+        if err != nil {
+            return nil, "", err
+        }
+        kid := fmt.Sprintf("dev-%d", time.Now().Unix())
+        data, _ := json.MarshalIndent(ecJWK(key, kid, true), "", "  ")
+        return key, kid, os.WriteFile(name, data, 0600)
+    }
+    if err != nil {
+        return nil, "", err
+    }
+    var jwk auth.JWK
+    if err := json.Unmarshal(data, &jwk); err != nil {
+        return nil, "", err
+    }
+    public, err := jwk.PublicKey()
+    if err != nil {
+        return nil, "", err
+    }
+    ecPublic, ok := public.(*ecdsa.PublicKey)
+    d, err2 := base64.RawURLEncoding.DecodeString(jwk.D)
+    if !ok || ecPublic.Curve != elliptic.P256() || err2 != nil {
+        return nil, "", errors.New("not a P-256 private key")
+    }
+    return &ecdsa.PrivateKey{PublicKey: *ecPublic, D: new(big.Int).SetBytes(d)}, jwk.Kid, nil
+}
+
+func ecJWK(key *ecdsa.PrivateKey, kid string, private bool) auth.JWK {
+    jwk := auth.JWK{
+        Kty: "EC",
+        Kid: kid,
+        Use: "sig",
+        Alg: "ES256",
+        Crv: "P-256",
+        X:   encodeInt(key.X),
+        Y:   encodeInt(key.Y),
+    }
+    if private {
+        jwk.D = encodeInt(key.D)
+    }
+    return jwk
+}
+
+func encodeInt(n *big.Int) string {
+    return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+}
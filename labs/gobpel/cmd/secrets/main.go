@@ -0,0 +1,93 @@
+// Command secrets edits the encrypted secrets file read by the server with
+// secrets.provider file. It keeps the key in -key, creating it on first use.
+//
+//	secrets set openai-key < key.txt
+//	secrets set rag-key s3cr3t
+//	secrets delete rag-key
+//	secrets list
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sort"
+    "strings"
+
+    "gobpel/pkg/secrets"
+)
+
+func main() {
+    file := flag.String("file", "secrets.json.enc", "encrypted secrets file")
+    keyFile := flag.String("key", "secrets.key", "key of the secrets file, created if missing")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: secrets [-file name] [-key name] set <name> [value] | delete <name> | list")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    args := flag.Args()
+    if len(args) == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    key, err := loadKey(*keyFile)
+    if err != nil {
+        log.Fatalf("failed to load key: %v", err)
+    }
+    stored, err := secrets.ReadFile(*file, key)
+    if err != nil {
+        log.Fatalf("failed to read secrets: %v", err)
+    }
+
+    switch {
+    case args[0] == "set" && (len(args) == 2 || len(args) == 3):
+        // Values read from stdin stay out of the shell history
+        var value string
+        if len(args) == 3 {
+            value = args[2]
+        } else {
+            data, err := io.ReadAll(os.Stdin)
+            if err != nil {
+                log.Fatalf("failed to read value: %v", err)
+            }
+            value = strings.TrimRight(string(data), "\r\n")
+        }
+        stored[args[1]] = value
+    case args[0] == "delete" && len(args) == 2:
+        if _, ok := stored[args[1]]; !ok {
+            log.Fatalf("secret %s not found", args[1])
+        }
+        delete(stored, args[1])
+    case args[0] == "list" && len(args) == 1:
+        names := make([]string, 0, len(stored))
+        for name := range stored {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            fmt.Println(name)
+        }
+        return
+    default:
+        flag.Usage()
+        os.Exit(2)
+    }
+    if err := secrets.WriteFile(*file, key, stored); err != nil {
+        log.Fatalf("failed to write secrets: %v", err)
+    }
+}
+
+func loadKey(name string) ([]byte, error) {
+    key, err := secrets.ReadKey(name)
+    if !errors.Is(err, os.ErrNotExist) {
+        return key, err
+    }
+    if key, err = secrets.NewKey(); err != nil {
+        return nil, err
+    }
+    return key, secrets.WriteKey(name, key)
+}
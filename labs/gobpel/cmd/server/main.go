@@ -1,42 +1,529 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
+    "encoding/base64"
+    "errors"
+    "expvar"
+    "flag"
+    "fmt"
+    "io"
     "log"
     "net"
+    "net/http"
+    "net/url"
+    "os"
+    "os/signal"
+    "reflect"
+    "slices"
+    "syscall"
+    "time"
 
+    "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.uber.org/zap"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
     "google.golang.org/grpc/reflection"
-    "go.uber.org/zap"
 
     "gobpel/api"
+    "gobpel/pkg/auth"
+    "gobpel/pkg/authz"
     "gobpel/pkg/bpel"
+    "gobpel/pkg/broker"
+    "gobpel/pkg/certs"
+    "gobpel/pkg/config"
     "gobpel/pkg/db"
+    "gobpel/pkg/envelope"
+    "gobpel/pkg/gateway"
+    "gobpel/pkg/metrics"
+    "gobpel/pkg/secrets"
 )
 
 func main() {
+    cfg, err := config.Load(os.Args[1:])
+    if err == flag.ErrHelp {
+        fmt.Fprintf(os.Stderr, "Usage of %s:\n%s", os.Args[0], config.Usage())
+        return
+    }
+    if err != nil {
+        log.Fatalf("invalid configuration:\n%v", err)
+    }
+
+    logConfig := zap.NewProductionConfig()
+    logConfig.Level.UnmarshalText([]byte(cfg.Observability.LogLevel))
+    logger, err := logConfig.Build()
+    if err != nil {
+        log.Fatalf("failed to create logger: %v", err)
+    }
+
+    // The W3C trace context of callers is passed on to partners, and spans
+    // are exported when an exporter is configured
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+    tracing, err := tracerProvider(cfg)
+    if err != nil {
+        log.Fatalf("failed to create trace exporter: %v", err)
+    }
+    if tracing != nil {
+        otel.SetTracerProvider(tracing)
+    }
+
     // Initialize MongoDB
-    mongoURI := "mongodb://mongodb:27017"
-    err := db.InitMongoDB(mongoURI)
+    err = db.InitMongoDB(cfg.Storage.MongoDB.URI)
     if err != nil {
         log.Fatalf("failed to connect to MongoDB: %v", err)
     }
+    keys, err := keyring(cfg)
+    if err != nil {
+        log.Fatalf("failed to load encryption keys: %v", err)
+    }
+    db.SetKeyProvider(keys)
 
-    lis, err := net.Listen("tcp", ":50051")
+    lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
     if err != nil {
         log.Fatalf("failed to listen: %v", err)
     }
 
-    grpcServer := grpc.NewServer()
+    // With TLS the gateway connects to the gRPC server presenting the server
+    // certificate, which the server accepts as a client certificate
+    serverOptions := []grpc.ServerOption{
+        grpc.StatsHandler(otelgrpc.NewServerHandler()),
+        grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor()),
+        grpc.ChainStreamInterceptor(metrics.StreamServerInterceptor()),
+    }
+    gatewayCreds := insecure.NewCredentials()
+    var serverCerts *certs.Certificates
+    optionalClientCerts := cfg.TLS.ClientAuth == "optional"
+    if cfg.TLS.Enabled() {
+        serverCerts, err = certs.Load(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+        if err != nil {
+            log.Fatalf("failed to load server certificates: %v", err)
+        }
+        serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(serverCerts.ServerConfig(optionalClientCerts))))
+        gatewayCreds = credentials.NewTLS(serverCerts.LoopbackConfig())
+    }
+
     server := bpel.NewServer()
+    server.SetIdempotencyWindow(cfg.Server.IdempotencyWindow)
+    if cfg.Server.ReplicaId != "" {
+        server.SetReplicaId(cfg.Server.ReplicaId)
+    }
+    server.SetWorkerPoolSize(cfg.WorkerPool.Workers)
+    expvar.Publish("executionQueue", expvar.Func(func() interface{} { return server.QueueStats() }))
+    if err := metrics.Register(server.Collector()); err != nil {
+        log.Fatalf("failed to register metrics: %v", err)
+    }
+
+    // With auth every call needs a bearer token issued by the configured
+    // identity provider, through the gateway as well, and is authorized by
+    // the role of the caller
+    var verifier *auth.Verifier
+    var policy *authz.Policy
+    if cfg.Auth.Enabled() {
+        keys, err := auth.NewKeySet(cfg.Auth.JWKSFile, cfg.Auth.JWKSURL)
+        if err != nil {
+            log.Fatalf("failed to load JWKS: %v", err)
+        }
+        verifier = auth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Audience, cfg.Auth.TenantClaim)
+        verifier.SetDefaultTenant(cfg.Auth.DefaultTenant)
+        if policy, err = loadPolicy(cfg); err != nil {
+            log.Fatalf("failed to load authorization policy: %v", err)
+        }
+        server.SetPolicy(policy)
+        serverOptions = append(serverOptions,
+            grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(verifier), server.UnaryAuthorizer()),
+            grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(verifier), server.StreamAuthorizer()),
+        )
+    }
+    grpcServer := grpc.NewServer(serverOptions...)
+
+    // Optional message broker for partner invocations and engine events
+    var b broker.Broker
+    if cfg.Broker.NATSURL != "" {
+        b, err = broker.NewNATS(cfg.Broker.NATSURL)
+        if err != nil {
+            log.Fatalf("failed to connect to NATS: %v", err)
+        }
+        server.SetBroker(b)
+    }
+    var brokerTransport bpel.Transport
+    if b != nil {
+        brokerTransport = bpel.NewBrokerTransport(b, cfg.Broker.InvokeTimeout)
+    }
+    partners, err := partnerSettings(cfg, brokerTransport)
+    if err != nil {
+        log.Fatalf("invalid partner registry: %v", err)
+    }
+    server.SetPartners(partners)
+    server.SetTenantQuotas(tenantQuotas(cfg))
+    server.SetRedaction(cfg.Redaction.Fields, cfg.Redaction.Processes)
+
+    // The partner registry and its secrets, the worker pool, the log level,
+    // the authorization policy, tenant quotas, encryption keys and redacted
+    // fields are reloaded on SIGHUP, when the configuration file changes and
+    // through ReloadConfig. Reloads run one at a time
+    running := cfg
+    server.SetReloader(func() ([]string, []string, error) {
+        next, err := config.Load(os.Args[1:])
+        if err != nil {
+            return nil, nil, err
+        }
+        // Certificates are also reloaded when their files change
+        if serverCerts != nil {
+            if err := serverCerts.Reload(); err != nil {
+                return nil, nil, fmt.Errorf("tls: %v", err)
+            }
+        }
+        partners, err := partnerSettings(next, brokerTransport)
+        if err != nil {
+            return nil, nil, err
+        }
+        nextKeys, err := keyring(next)
+        if err != nil {
+            return nil, nil, fmt.Errorf("encryption: %v", err)
+        }
+        applied, restartRequired := running.Diff(next)
+        if policy != nil {
+            nextPolicy, err := loadPolicy(next)
+            if err != nil {
+                return nil, nil, err
+            }
+            if !reflect.DeepEqual(nextPolicy, policy) && !slices.Contains(applied, "authorization") {
+                applied = append(applied, "authorization")
+            }
+            policy = nextPolicy
+            server.SetPolicy(policy)
+        }
+        server.SetPartners(partners)
+        server.SetWorkerPoolSize(next.WorkerPool.Workers)
+        server.SetTenantQuotas(tenantQuotas(next))
+        server.SetRedaction(next.Redaction.Fields, next.Redaction.Processes)
+        db.SetKeyProvider(nextKeys)
+        if slices.Contains(applied, "encryption") {
+            go rewrapKeys(server.ReplicaId())
+        }
+        logConfig.Level.UnmarshalText([]byte(next.Observability.LogLevel))
+        running.Partners, running.Secrets = next.Partners, next.Secrets
+        running.WorkerPool, running.Observability = next.WorkerPool, next.Observability
+        running.Authorization, running.Tenants = next.Authorization, next.Tenants
+        running.Encryption, running.Redaction = next.Encryption, next.Redaction
+        return applied, restartRequired, nil
+    })
+
+    // Replicas renew the leases of their instances and take over those of
+    // replicas that stopped. Every replica runs the scheduler; the one holding
+    // the lease fires
+    runCtx, stopRunning := context.WithCancel(context.Background())
+    go server.RunOwnership(runCtx)
+    go server.RunScheduler(runCtx, server.ReplicaId())
+    go rewrapKeys(server.ReplicaId())
+    if cfg.File != "" {
+        go config.Watch(runCtx, cfg.File, 2*time.Second, func() { server.Reload("file") })
+    }
+    if cfg.Authorization.PolicyFile != "" {
+        go config.Watch(runCtx, cfg.Authorization.PolicyFile, 2*time.Second, func() { server.Reload("file") })
+    }
+    hangups := make(chan os.Signal, 1)
+    signal.Notify(hangups, syscall.SIGHUP)
+    go func() {
+        for range hangups {
+            server.Reload("signal")
+        }
+    }()
 
     api.RegisterBPELProcessServiceServer(grpcServer, server)
     reflection.Register(grpcServer)
 
-    logger, _ := zap.NewProduction()
     logger.Info("Server listening at", zap.String("address", lis.Addr().String()))
 
-    if err := grpcServer.Serve(lis); err != nil {
-        logger.Fatal("failed to serve", zap.Error(err))
+    // REST/JSON gateway proxying to the gRPC server
+    handler, err := gateway.New(context.Background(), dialAddr(cfg.Server.GRPCAddr), gatewayCreds)
+    if err != nil {
+        log.Fatalf("failed to create gateway: %v", err)
+    }
+    if verifier != nil {
+        handler = verifier.Middleware(handler, "/openapi.json")
+    }
+    gatewayServer := &http.Server{Addr: cfg.Server.GatewayAddr, Handler: handler}
+    go func() {
+        logger.Info("Gateway listening at", zap.String("address", cfg.Server.GatewayAddr))
+        serve := gatewayServer.ListenAndServe
+        if serverCerts != nil {
+            gatewayServer.TLSConfig = serverCerts.ServerConfig(optionalClientCerts)
+            serve = func() error { return gatewayServer.ListenAndServeTLS("", "") }
+        }
+        if err := serve(); err != nil && err != http.ErrServerClosed {
+            logger.Fatal("failed to serve gateway", zap.Error(err))
+        }
+    }()
+
+    // Scrapers reach the metrics listener without a token
+    var metricsServer *http.Server
+    if cfg.Server.MetricsAddr != "" {
+        metricsMux := http.NewServeMux()
+        metricsMux.Handle("/metrics", metrics.Handler())
+        metricsServer = &http.Server{Addr: cfg.Server.MetricsAddr, Handler: metricsMux}
+        go func() {
+            logger.Info("Metrics listening at", zap.String("address", cfg.Server.MetricsAddr))
+            if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+                logger.Fatal("failed to serve metrics", zap.Error(err))
+            }
+        }()
+    }
+
+    signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stopSignals()
+    go func() {
+        if err := grpcServer.Serve(lis); err != nil {
+            logger.Fatal("failed to serve", zap.Error(err))
+        }
+    }()
+    <-signals.Done()
+
+    // Running activities get the shutdown grace period to reach a checkpoint;
+    // what is left is taken over by other replicas
+    grace := cfg.Server.ShutdownGracePeriod
+    logger.Info("Shutting down", zap.Duration("gracePeriod", grace))
+    drainCtx, cancelDrain := context.WithTimeout(context.Background(), grace)
+    if err := server.Drain(drainCtx); err != nil {
+        logger.Warn("instances interrupted at the end of the grace period", zap.Error(err))
+    }
+    cancelDrain()
+    stopRunning()
+
+    shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancelShutdown()
+    gatewayServer.Shutdown(shutdownCtx)
+    if metricsServer != nil {
+        metricsServer.Shutdown(shutdownCtx)
+    }
+    stopped := make(chan struct{})
+    go func() {
+        grpcServer.GracefulStop()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-shutdownCtx.Done():
+        // Streams such as WatchInstance do not end by themselves
+        grpcServer.Stop()
+    }
+
+    flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancelFlush()
+    if err := server.FlushEvents(flushCtx); err != nil {
+        logger.Warn("undelivered events left in the outbox", zap.Error(err))
     }
+    if b != nil {
+        b.Close()
+    }
+    if err := db.Disconnect(flushCtx); err != nil {
+        logger.Error("failed to disconnect from MongoDB", zap.Error(err))
+    }
+    if tracing != nil {
+        if err := tracing.Shutdown(flushCtx); err != nil {
+            logger.Warn("failed to export the remaining spans", zap.Error(err))
+        }
+    }
+    logger.Info("Server stopped")
+}
+
+// partnerSettings builds the partner registry of cfg. Partner links on the
+// broker use brokerTransport, which is nil without a broker.
+func partnerSettings(cfg *config.Config, brokerTransport bpel.Transport) (map[string]bpel.PartnerSettings, error) {
+    provider, err := secretProvider(cfg)
+    if err != nil {
+        return nil, fmt.Errorf("secrets: %v", err)
+    }
+    partners := make(map[string]bpel.PartnerSettings)
+    for partnerLink, partner := range cfg.Partners {
+        if partner == nil {
+            continue
+        }
+        transport, err := partnerTransport(partnerLink, partner, provider, brokerTransport)
+        if err != nil {
+            for _, settings := range partners {
+                if closer, ok := settings.Transport.(io.Closer); ok && settings.Transport != brokerTransport {
+                    closer.Close()
+                }
+            }
+            return nil, fmt.Errorf("partners.%s: %v", partnerLink, err)
+        }
+        settings := bpel.PartnerSettings{
+            Transport:      transport,
+            MaxConcurrency: partner.MaxConcurrency,
+            RateLimit:      partner.RateLimit,
+            Burst:          partner.Burst,
+        }
+        if partner.CircuitBreaker != nil {
+            settings.Breaker = bpel.BreakerPolicy{
+                FailureThreshold: partner.CircuitBreaker.FailureThreshold,
+                OpenTimeout:      partner.CircuitBreaker.OpenTimeout,
+            }
+        }
+        partners[partnerLink] = settings
+    }
+    return partners, nil
+}
+
+// partnerTransport returns the transport of a partner link, or nil for
+// plain HTTP to http://<partnerLink>.
+func partnerTransport(partnerLink string, partner *config.PartnerConfig, provider secrets.Provider, brokerTransport bpel.Transport) (bpel.Transport, error) {
+    var tlsConfig *tls.Config
+    if partner.TLS != nil {
+        partnerCerts, err := certs.Load(partner.TLS.CertFile, partner.TLS.KeyFile, partner.TLS.CAFile)
+        if err != nil {
+            return nil, err
+        }
+        tlsConfig = partnerCerts.ClientConfig(partner.TLS.ServerName)
+    }
+
+    switch partner.Transport {
+    case "broker":
+        if brokerTransport == nil {
+            return nil, fmt.Errorf("the broker is not connected")
+        }
+        return brokerTransport, nil
+    case "grpc":
+        target, _ := url.Parse(partner.URL)
+        if target.Scheme == "grpcs" && tlsConfig == nil {
+            tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+        }
+        service := partner.Service
+        if service == "" {
+            service = partnerLink
+        }
+        return bpel.NewGRPCTransport(target.Host, service, partner.Headers, provider, tlsConfig)
+    }
+    if partner.URL == "" && len(partner.Headers) == 0 {
+        return nil, nil
+    }
+    return bpel.NewHTTPTransport(partner.URL, partner.Headers, provider, tlsConfig), nil
+}
+
+// secretProvider returns the provider the secrets in partner headers are
+// resolved from.
+func secretProvider(cfg *config.Config) (secrets.Provider, error) {
+    switch cfg.Secrets.Provider {
+    case "file":
+        return secrets.OpenFile(cfg.Secrets.File.Path, cfg.Secrets.File.KeyFile)
+    case "vault":
+        vault := cfg.Secrets.Vault
+        return secrets.NewVault(vault.Addr, vault.Token, vault.Mount, vault.CacheTTL), nil
+    }
+    return secrets.Env{Prefix: cfg.Secrets.Env.Prefix}, nil
 }
 
+// loadPolicy returns the authorization policy of cfg, the default policy
+// without a policy file.
+func loadPolicy(cfg *config.Config) (*authz.Policy, error) {
+    if cfg.Authorization.PolicyFile == "" {
+        return authz.DefaultPolicy(), nil
+    }
+    return authz.LoadPolicy(cfg.Authorization.PolicyFile)
+}
+
+// tenantQuotas returns the quota of every tenant in cfg and the quotas of
+// the tenants configured apart.
+func tenantQuotas(cfg *config.Config) (bpel.TenantQuota, map[string]bpel.TenantQuota) {
+    quota := func(q config.QuotaConfig) bpel.TenantQuota {
+        return bpel.TenantQuota{
+            MaxProcesses:       q.MaxProcesses,
+            MaxActiveInstances: q.MaxActiveInstances,
+            MaxSchedules:       q.MaxSchedules,
+        }
+    }
+    quotas := make(map[string]bpel.TenantQuota)
+    for tenant, q := range cfg.Tenants.Quotas {
+        if q != nil {
+            quotas[tenant] = quota(*q)
+        }
+    }
+    return quota(cfg.Tenants.DefaultQuota), quotas
+}
+
+// keyring returns the keys values are encrypted with in the store, with the
+// secrets among them resolved, or nil without keys.
+func keyring(cfg *config.Config) (envelope.KeyProvider, error) {
+    if len(cfg.Encryption.Keys) == 0 {
+        return nil, nil
+    }
+    provider, err := secretProvider(cfg)
+    if err != nil {
+        return nil, err
+    }
+    keys := make(map[string][]byte)
+    for keyId, value := range cfg.Encryption.Keys {
+        value, err := secrets.Expand(context.Background(), provider, value)
+        if err != nil {
+            return nil, fmt.Errorf("key %s: %v", keyId, err)
+        }
+        key, err := base64.StdEncoding.DecodeString(value)
+        if err != nil {
+            return nil, fmt.Errorf("key %s: not base64 encoded", keyId)
+        }
+        keys[keyId] = key
+    }
+    return envelope.NewKeyring(cfg.Encryption.ActiveKey, keys)
+}
+
+// rewrapKeys wraps the data keys of values encrypted with other keys than
+// the active one with the active key, on one replica at a time. Keys can be
+// removed from the configuration once it has run.
+func rewrapKeys(replicaId string) {
+    acquired, err := db.AcquireLease("rewrapKeys", replicaId, time.Hour)
+    if err != nil || !acquired {
+        return
+    }
+    n, err := db.RewrapKeys(context.Background())
+    if errors.Is(err, envelope.ErrNoActiveKey) {
+        return
+    }
+    if err != nil {
+        log.Printf("Error rewrapping encryption keys: %v", err)
+    } else if n > 0 {
+        log.Printf("Rewrapped %d values with the active encryption key", n)
+    }
+    db.ReleaseLease("rewrapKeys", replicaId)
+}
+
+// tracerProvider returns the provider recording the spans of the server and
+// exporting them as cfg selects, or nil when they are not exported.
+func tracerProvider(cfg *config.Config) (*sdktrace.TracerProvider, error) {
+    var exporter sdktrace.SpanExporter
+    var err error
+    switch cfg.Tracing.Exporter {
+    case "otlp":
+        exporter, err = otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(cfg.Tracing.Endpoint))
+    case "stdout":
+        exporter, err = stdouttrace.New()
+    default:
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+        sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.Tracing.ServiceName))),
+    ), nil
+}
+
+// dialAddr returns the address the gateway reaches the gRPC server at when
+// it listens on addr.
+func dialAddr(addr string) string {
+    host, port, _ := net.SplitHostPort(addr)
+    if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+        host = "localhost"
+    }
+    return net.JoinHostPort(host, port)
+}
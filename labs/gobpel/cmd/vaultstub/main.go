@@ -0,0 +1,51 @@
+// Command vaultstub serves an in-memory stand-in for the KV version 2
+// engine of Vault, for running the server with secrets.provider vault
+// without a Vault server. Entries are lost when it stops.
+//
+//	vaultstub -secret openai/api-key=sk-test -secret rag#key=s3cr3t
+package main
+
+import (
+    "errors"
+    "flag"
+    "log"
+    "net/http"
+    "strings"
+
+    "gobpel/pkg/secrets"
+)
+
+type secretFlags []string
+
+func (s *secretFlags) String() string {
+    return strings.Join(*s, ",")
+}
+
+func (s *secretFlags) Set(value string) error {
+    if !strings.Contains(value, "=") {
+        return errors.New("expected path[#field]=value")
+    }
+    *s = append(*s, value)
+    return nil
+}
+
+func main() {
+    addr := flag.String("addr", "127.0.0.1:8200", "address to listen on")
+    token := flag.String("token", "dev-token", "token callers must present")
+    mount := flag.String("mount", "secret", "path the engine is mounted at")
+    var seed secretFlags
+    flag.Var(&seed, "secret", "entry as path[#field]=value, repeatable; the field defaults to value")
+    flag.Parse()
+
+    stub := secrets.NewVaultStub(*token, *mount)
+    for _, entry := range seed {
+        name, value, _ := strings.Cut(entry, "=")
+        path, field, _ := strings.Cut(name, "#")
+        if field == "" {
+            field = "value"
+        }
+        stub.Put(path, field, value)
+    }
+    log.Printf("Vault stub listening on %s", *addr)
+    log.Fatal(http.ListenAndServe(*addr, stub))
+}
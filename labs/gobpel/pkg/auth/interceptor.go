@@ -0,0 +1,123 @@
+package auth
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+// Tokens must name a tenant unless the verifier has a default tenant
+const errNoTenant = "token names no tenant"
+
+// UnaryServerInterceptor rejects calls without a valid bearer token in the
+// authorization metadata and passes the identity of the caller on in the
+// context of the handler.
+func UnaryServerInterceptor(v *Verifier) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        ctx, err := v.authenticate(ctx, info.FullMethod)
+        if err != nil {
+            return nil, err
+        }
+        return handler(ctx, req)
+    }
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor(v *Verifier) grpc.StreamServerInterceptor {
+    return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+        ctx, err := v.authenticate(stream.Context(), info.FullMethod)
+        if err != nil {
+            return err
+        }
+        return handler(srv, &identityStream{ServerStream: stream, ctx: ctx})
+    }
+}
+
+type identityStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+    return s.ctx
+}
+
+func (v *Verifier) authenticate(ctx context.Context, method string) (context.Context, error) {
+    md, _ := metadata.FromIncomingContext(ctx)
+    token, ok := bearerToken(md.Get("authorization"))
+    if !ok {
+        return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+    }
+    identity, err := v.Verify(token)
+    if err != nil {
+        log.Printf("Rejected call to %s: %v", method, err)
+        return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+    }
+    if identity.Tenant == "" {
+        log.Printf("Rejected call to %s: token of %s names no tenant", method, identity.Subject)
+        return nil, status.Error(codes.PermissionDenied, errNoTenant)
+    }
+    return NewContext(ctx, identity), nil
+}
+
+func bearerToken(values []string) (string, bool) {
+    for _, value := range values {
+        scheme, token, ok := strings.Cut(value, " ")
+        if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+            return strings.TrimSpace(token), true
+        }
+    }
+    return "", false
+}
+
+// Middleware rejects HTTP requests without a valid bearer token, except
+// for the paths in public, with a 401 and a JSON status body like the
+// gateway's. The identity of the caller is passed on in the request context.
+func (v *Verifier) Middleware(next http.Handler, public ...string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        for _, path := range public {
+            if r.URL.Path == path {
+                next.ServeHTTP(w, r)
+                return
+            }
+        }
+        token, ok := bearerToken(r.Header.Values("Authorization"))
+        if !ok {
+            unauthorized(w, "missing bearer token")
+            return
+        }
+        identity, err := v.Verify(token)
+        if err != nil {
+            log.Printf("Rejected request to %s: %v", r.URL.Path, err)
+            unauthorized(w, "invalid token: "+err.Error())
+            return
+        }
+        if identity.Tenant == "" {
+            log.Printf("Rejected request to %s: token of %s names no tenant", r.URL.Path, identity.Subject)
+            writeStatus(w, http.StatusForbidden, codes.PermissionDenied, errNoTenant)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+    })
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+    w.Header().Set("WWW-Authenticate", "Bearer")
+    writeStatus(w, http.StatusUnauthorized, codes.Unauthenticated, message)
+}
+
+func writeStatus(w http.ResponseWriter, httpCode int, code codes.Code, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(httpCode)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "code":    code,
+        "message": message,
+    })
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+func TestInterceptorTenant(t *testing.T) {
+    keys := newTestKeys(t)
+    set, err := NewKeySet(writeKeySet(t, keys.jwks()), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    withTenant := keys.sign(t, "ES256", "ec", "ec", validClaims())
+    withoutTenant := keys.sign(t, "ES256", "ec", "ec", withClaims(map[string]interface{}{"tenant": nil}))
+
+    tests := []struct {
+        name          string
+        token         string
+        defaultTenant string
+        tenant        string
+        code          codes.Code
+        httpCode      int
+    }{
+        {"tenant claim", withTenant, "", "ml-research", codes.OK, http.StatusOK},
+        {"no tenant claim", withoutTenant, "", "", codes.PermissionDenied, http.StatusForbidden},
+        {"default tenant", withoutTenant, "default", "default", codes.OK, http.StatusOK},
+        {"claim over default tenant", withTenant, "default", "ml-research", codes.OK, http.StatusOK},
+        {"no token", "", "default", "", codes.Unauthenticated, http.StatusUnauthorized},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            verifier := NewVerifier(set, "", "", "tenant")
+            verifier.SetDefaultTenant(test.defaultTenant)
+
+            ctx := context.Background()
+            if test.token != "" {
+                ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+test.token))
+            }
+            var tenant string
+            handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+                identity, _ := FromContext(ctx)
+                tenant = identity.Tenant
+                return nil, nil
+            }
+            _, err := UnaryServerInterceptor(verifier)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/bpel.BPELProcessService/GetAllProcesses"}, handler)
+            if status.Code(err) != test.code || tenant != test.tenant {
+                t.Errorf("got tenant %q, %v, want %q, %s", tenant, err, test.tenant, test.code)
+            }
+
+            req := httptest.NewRequest(http.MethodGet, "/v1/processes", nil)
+            if test.token != "" {
+                req.Header.Set("Authorization", "Bearer "+test.token)
+            }
+            rec := httptest.NewRecorder()
+            verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+            if rec.Code != test.httpCode {
+                t.Errorf("got HTTP %d, want %d", rec.Code, test.httpCode)
+            }
+        })
+    }
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math/big"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+const (
+    // A JWKS file is checked for changes at most this often
+    fileCheckInterval = 10 * time.Second
+    // A JWKS URL is fetched again this often, or sooner for a token signed
+    // by an unknown key, but not more than once per minFetchInterval
+    urlRefreshInterval = 10 * time.Minute
+    minFetchInterval   = 30 * time.Second
+)
+
+// JWK is a JSON Web Key. Private keys carry D.
+type JWK struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid,omitempty"`
+    Use string `json:"use,omitempty"`
+    Alg string `json:"alg,omitempty"`
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+    Y   string `json:"y,omitempty"`
+    D   string `json:"d,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+    Keys []JWK `json:"keys"`
+}
+
+// publicKey is a verification key of the set.
+type publicKey struct {
+    kid string
+    alg string
+    key crypto.PublicKey
+}
+
+// KeySet holds the keys tokens are verified with, read from a JWKS file or
+// URL and refreshed while the server runs.
+type KeySet struct {
+    file   string
+    url    string
+    client *http.Client
+
+    // refreshMu serializes refreshes; mu guards what they load
+    refreshMu sync.Mutex
+    mu        sync.Mutex
+    keys      []publicKey
+    version   string
+    checked   time.Time
+    fetched   time.Time
+}
+
+// NewKeySet loads the key set in file, or at url when file is empty.
+func NewKeySet(file, url string) (*KeySet, error) {
+    k := &KeySet{file: file, url: url, client: &http.Client{Timeout: 10 * time.Second}}
+    if err := k.refresh(true); err != nil {
+        return nil, err
+    }
+    return k, nil
+}
+
+// lookup returns the keys a token with kid and alg may be signed with.
+func (k *KeySet) lookup(kid, alg string) []publicKey {
+    k.mu.Lock()
+    stale := k.file != "" && time.Since(k.checked) >= fileCheckInterval ||
+        k.url != "" && time.Since(k.fetched) >= urlRefreshInterval
+    k.mu.Unlock()
+    if stale {
+        k.refresh(false)
+    }
+    keys := k.match(kid, alg)
+    if len(keys) == 0 && k.url != "" {
+        // Keys may have been rotated since the last fetch
+        k.mu.Lock()
+        recent := time.Since(k.fetched) < minFetchInterval
+        k.mu.Unlock()
+        if !recent {
+            k.refresh(true)
+            keys = k.match(kid, alg)
+        }
+    }
+    return keys
+}
+
+func (k *KeySet) match(kid, alg string) []publicKey {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    var keys []publicKey
+    for _, key := range k.keys {
+        if (kid == "" || key.kid == kid) && (key.alg == "" || key.alg == alg) {
+            keys = append(keys, key)
+        }
+    }
+    return keys
+}
+
+// refresh reloads the key set, from a file only when it changed unless
+// force is set. On error the keys loaded before are kept. Refreshes run one
+// at a time without holding mu, so tokens are verified with the current keys
+// meanwhile, and a JWKS URL is fetched at most once per minFetchInterval.
+func (k *KeySet) refresh(force bool) error {
+    k.refreshMu.Lock()
+    defer k.refreshMu.Unlock()
+    k.mu.Lock()
+    loaded, version, checked, fetched := k.keys != nil, k.version, k.checked, k.fetched
+    k.mu.Unlock()
+
+    var data []byte
+    var err error
+    if k.file != "" {
+        // A refresh that waited for another one has nothing left to do
+        if loaded && !force && time.Since(checked) < fileCheckInterval {
+            return nil
+        }
+        now := time.Now()
+        info, statErr := os.Stat(k.file)
+        if statErr != nil {
+            err = statErr
+        } else {
+            current := fmt.Sprintf("%v/%d", info.ModTime(), info.Size())
+            if current == version && !force {
+                k.mu.Lock()
+                k.checked = now
+                k.mu.Unlock()
+                return nil
+            }
+            version = current
+            data, err = os.ReadFile(k.file)
+        }
+        checked = now
+    } else {
+        if loaded && time.Since(fetched) < minFetchInterval {
+            return nil
+        }
+        fetched = time.Now()
+        data, err = k.fetch()
+    }
+    var keys []publicKey
+    if err == nil {
+        keys, err = parseKeySet(data)
+    }
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    k.checked, k.fetched = checked, fetched
+    if err == nil {
+        k.keys, k.version = keys, version
+        return nil
+    }
+    if k.keys != nil {
+        log.Printf("Error refreshing JWKS %s%s: %v", k.file, k.url, err)
+    }
+    return err
+}
+
+func (k *KeySet) fetch() ([]byte, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := k.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("%s returned %s", k.url, resp.Status)
+    }
+    return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func parseKeySet(data []byte) ([]publicKey, error) {
+    var set JWKS
+    if err := json.Unmarshal(data, &set); err != nil {
+        return nil, fmt.Errorf("invalid JWKS: %v", err)
+    }
+    var keys []publicKey
+    for _, jwk := range set.Keys {
+        if jwk.Use != "" && jwk.Use != "sig" {
+            continue
+        }
+        key, err := jwk.PublicKey()
+        if err != nil {
+            return nil, fmt.Errorf("key %q: %v", jwk.Kid, err)
+        }
+        keys = append(keys, publicKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+    }
+    if len(keys) == 0 {
+        return nil, errors.New("JWKS has no signing keys")
+    }
+    return keys, nil
+}
+
+// PublicKey decodes the RSA, EC or Ed25519 public key of a JWK.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+    switch j.Kty {
+    case "RSA":
+        n, err := decodeInt(j.N)
+        if err != nil {
+            return nil, err
+        }
+        e, err := decodeInt(j.E)
+        if err != nil {
+            return nil, err
+        }
+        return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+    case "EC":
+        curve, err := ellipticCurve(j.Crv)
+        if err != nil {
+            return nil, err
+        }
+        x, err := decodeInt(j.X)
+        if err != nil {
+            return nil, err
+        }
+        y, err := decodeInt(j.Y)
+        if err != nil {
+            return nil, err
+        }
+        if !curve.IsOnCurve(x, y) {
+            return nil, errors.New("point is not on the curve")
+        }
+        return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+    case "OKP":
+        if j.Crv != "Ed25519" {
+            return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+        }
+        x, err := base64.RawURLEncoding.DecodeString(j.X)
+        if err != nil || len(x) != ed25519.PublicKeySize {
+            return nil, errors.New("invalid Ed25519 key")
+        }
+        return ed25519.PublicKey(x), nil
+    }
+    return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+func ellipticCurve(crv string) (elliptic.Curve, error) {
+    switch crv {
+    case "P-256":
+        return elliptic.P256(), nil
+    case "P-384":
+        return elliptic.P384(), nil
+    case "P-521":
+        return elliptic.P521(), nil
+    }
+    return nil, fmt.Errorf("unsupported curve %q", crv)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+    b, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil || len(b) == 0 {
+        return nil, errors.New("invalid key parameter")
+    }
+    return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// jwksServer serves a key set that tests can replace, counting fetches.
+type jwksServer struct {
+    *httptest.Server
+    mu      sync.Mutex
+    set     JWKS
+    status  int
+    fetches int32
+}
+
+func newJWKSServer(t *testing.T, set JWKS) *jwksServer {
+    s := &jwksServer{set: set, status: http.StatusOK}
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&s.fetches, 1)
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        w.WriteHeader(s.status)
+        json.NewEncoder(w).Encode(s.set)
+    }))
+    t.Cleanup(s.Close)
+    return s
+}
+
+func (s *jwksServer) serve(set JWKS, status int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.set, s.status = set, status
+}
+
+// age makes the last fetch and file check of k older by d.
+func (k *KeySet) age(d time.Duration) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    k.fetched = k.fetched.Add(-d)
+    k.checked = k.checked.Add(-d)
+}
+
+func TestKeySetUnknownKid(t *testing.T) {
+    old, rotated := newTestKeys(t), newTestKeys(t)
+    server := newJWKSServer(t, JWKS{Keys: []JWK{ecJWK(&old.ec.PublicKey, "old", "ES256")}})
+    set, err := NewKeySet("", server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := NewVerifier(set, "", "", "")
+    token, err := SignES256(validClaims(), rotated.ec, "new")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The provider rotated its keys right after the first fetch
+    server.serve(JWKS{Keys: []JWK{ecJWK(&rotated.ec.PublicKey, "new", "ES256")}}, http.StatusOK)
+    if _, err := verifier.Verify(token); err == nil {
+        t.Fatal("verified with a key fetched within the minimum interval")
+    }
+    if n := atomic.LoadInt32(&server.fetches); n != 1 {
+        t.Fatalf("got %d fetches, want 1", n)
+    }
+
+    set.age(minFetchInterval)
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, err := verifier.Verify(token); err != nil {
+                t.Error(err)
+            }
+        }()
+    }
+    wg.Wait()
+    if n := atomic.LoadInt32(&server.fetches); n != 2 {
+        t.Errorf("got %d fetches, want one more for the unknown kid", n)
+    }
+}
+
+func TestKeySetURLRefresh(t *testing.T) {
+    old, rotated := newTestKeys(t), newTestKeys(t)
+    server := newJWKSServer(t, JWKS{Keys: []JWK{ecJWK(&old.ec.PublicKey, "key", "")}})
+    set, err := NewKeySet("", server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := NewVerifier(set, "", "", "")
+    oldToken, _ := SignES256(validClaims(), old.ec, "key")
+    rotatedToken, _ := SignES256(validClaims(), rotated.ec, "key")
+
+    // A failed fetch keeps the keys
+    server.serve(JWKS{}, http.StatusInternalServerError)
+    set.age(urlRefreshInterval)
+    if _, err := verifier.Verify(oldToken); err != nil {
+        t.Fatalf("keys lost after a failed fetch: %v", err)
+    }
+
+    server.serve(JWKS{Keys: []JWK{ecJWK(&rotated.ec.PublicKey, "key", "")}}, http.StatusOK)
+    set.age(urlRefreshInterval)
+    if _, err := verifier.Verify(rotatedToken); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := verifier.Verify(oldToken); err == nil {
+        t.Error("verified with a key removed from the set")
+    }
+    if n := atomic.LoadInt32(&server.fetches); n != 3 {
+        t.Errorf("got %d fetches, want 3", n)
+    }
+}
+
+func TestKeySetFile(t *testing.T) {
+    old, rotated := newTestKeys(t), newTestKeys(t)
+    file := writeKeySet(t, JWKS{Keys: []JWK{ecJWK(&old.ec.PublicKey, "old", "")}})
+    set, err := NewKeySet(file, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := NewVerifier(set, "", "", "")
+    token, _ := SignES256(validClaims(), rotated.ec, "new")
+
+    data, _ := json.Marshal(JWKS{Keys: []JWK{ecJWK(&rotated.ec.PublicKey, "new", ""), ecJWK(&old.ec.PublicKey, "old", "")}})
+    if err := os.WriteFile(file, data, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := verifier.Verify(token); err == nil {
+        t.Fatal("file checked within the check interval")
+    }
+    set.age(fileCheckInterval)
+    if _, err := verifier.Verify(token); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestNewKeySetInvalid(t *testing.T) {
+    keys := newTestKeys(t)
+    for name, set := range map[string]JWKS{
+        "empty":       {},
+        "encryption":  {Keys: []JWK{{Kty: "EC", Use: "enc", Crv: "P-256", X: ecJWK(&keys.ec.PublicKey, "", "").X, Y: ecJWK(&keys.ec.PublicKey, "", "").Y}}},
+        "off curve":   {Keys: []JWK{{Kty: "EC", Crv: "P-256", X: encode([]byte{1}), Y: encode([]byte{2})}}},
+        "curve":       {Keys: []JWK{{Kty: "EC", Crv: "P-224", X: encode([]byte{1}), Y: encode([]byte{2})}}},
+        "key type":    {Keys: []JWK{{Kty: "oct"}}},
+        "Ed448":       {Keys: []JWK{{Kty: "OKP", Crv: "Ed448", X: encode(make([]byte, 57))}}},
+        "short EdDSA": {Keys: []JWK{{Kty: "OKP", Crv: "Ed25519", X: encode(make([]byte, 31))}}},
+    } {
+        if _, err := NewKeySet(writeKeySet(t, set), ""); err == nil {
+            t.Errorf("%s: key set loaded", name)
+        }
+    }
+    if _, err := NewKeySet("", "http://127.0.0.1:1/jwks"); err == nil {
+        t.Error("unreachable URL: key set loaded")
+    }
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash"
+    "math/big"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// Clock skew tolerated when checking exp and nbf.
+const leeway = time.Minute
+
+// Identity is the authenticated caller of a request. Tenant is empty when
+// the token does not name one.
+type Identity struct {
+    Subject string
+    Issuer  string
+    Tenant  string
+    Claims  map[string]interface{}
+}
+
+// Tenant names are used in store records and broker subjects.
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,62}$`)
+
+// ValidTenant reports whether name can name a tenant.
+func ValidTenant(name string) bool {
+    return tenantPattern.MatchString(name)
+}
+
+type identityKey struct{}
+
+// NewContext returns ctx carrying the identity of the caller.
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+    return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the caller, if authenticated.
+func FromContext(ctx context.Context) (*Identity, bool) {
+    identity, ok := ctx.Value(identityKey{}).(*Identity)
+    return identity, ok
+}
+
+// Verifier checks bearer tokens: JWTs signed by a key of its key set,
+// issued by issuer for audience when those are set, and not expired. The
+// tenant of the caller is read from tenantClaim.
+type Verifier struct {
+    keys          *KeySet
+    issuer        string
+    audience      string
+    tenantClaim   string
+    defaultTenant string
+}
+
+func NewVerifier(keys *KeySet, issuer, audience, tenantClaim string) *Verifier {
+    return &Verifier{keys: keys, issuer: issuer, audience: audience, tenantClaim: tenantClaim}
+}
+
+// SetDefaultTenant sets the tenant of callers whose token has no tenant
+// claim. Without one their calls are rejected.
+func (v *Verifier) SetDefaultTenant(tenant string) {
+    v.defaultTenant = tenant
+}
+
+type header struct {
+    Alg string `json:"alg"`
+    Kid string `json:"kid"`
+}
+
+// Verify returns the identity a valid token was issued to.
+func (v *Verifier) Verify(token string) (*Identity, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, errors.New("malformed token")
+    }
+    var h header
+    if err := decodeSegment(parts[0], &h); err != nil {
+        return nil, errors.New("malformed token header")
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, errors.New("malformed token signature")
+    }
+    if _, ok := algorithms[h.Alg]; !ok {
+        return nil, fmt.Errorf("unsupported algorithm %q", h.Alg)
+    }
+
+    signed := []byte(parts[0] + "." + parts[1])
+    verified := false
+    for _, key := range v.keys.lookup(h.Kid, h.Alg) {
+        if verifySignature(h.Alg, key.key, signed, signature) {
+            verified = true
+            break
+        }
+    }
+    if !verified {
+        return nil, errors.New("invalid token signature")
+    }
+
+    var claims map[string]interface{}
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return nil, errors.New("malformed token claims")
+    }
+    if err := v.checkClaims(claims); err != nil {
+        return nil, err
+    }
+    identity := &Identity{Claims: claims}
+    identity.Subject, _ = claims["sub"].(string)
+    identity.Issuer, _ = claims["iss"].(string)
+    if identity.Subject == "" {
+        return nil, errors.New("token has no subject")
+    }
+    if tenant, ok := claims[v.tenantClaim]; ok && v.tenantClaim != "" {
+        identity.Tenant, _ = tenant.(string)
+        if !ValidTenant(identity.Tenant) {
+            return nil, fmt.Errorf("invalid tenant %v", tenant)
+        }
+    }
+    if identity.Tenant == "" {
+        identity.Tenant = v.defaultTenant
+    }
+    return identity, nil
+}
+
+func (v *Verifier) checkClaims(claims map[string]interface{}) error {
+    now := time.Now()
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return errors.New("token has no expiry")
+    }
+    if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+        return errors.New("token is expired")
+    }
+    if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+        return errors.New("token is not valid yet")
+    }
+    if v.issuer != "" && claims["iss"] != v.issuer {
+        return fmt.Errorf("token is not issued by %s", v.issuer)
+    }
+    if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+        return fmt.Errorf("token is not issued for %s", v.audience)
+    }
+    return nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of
+// strings, contains audience.
+func hasAudience(aud interface{}, audience string) bool {
+    switch aud := aud.(type) {
+    case string:
+        return aud == audience
+    case []interface{}:
+        for _, a := range aud {
+            if a == audience {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// algorithms are the supported signature algorithms and their hashes.
+var algorithms = map[string]crypto.Hash{
+    "RS256": crypto.SHA256,
+    "RS384": crypto.SHA384,
+    "RS512": crypto.SHA512,
+    "PS256": crypto.SHA256,
+    "PS384": crypto.SHA384,
+    "PS512": crypto.SHA512,
+    "ES256": crypto.SHA256,
+    "ES384": crypto.SHA384,
+    "ES512": crypto.SHA512,
+    "EdDSA": 0,
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+    hashed := digest(algorithms[alg], signed)
+    switch key := key.(type) {
+    case *rsa.PublicKey:
+        switch alg[:2] {
+        case "RS":
+            return rsa.VerifyPKCS1v15(key, algorithms[alg], hashed, signature) == nil
+        case "PS":
+            return rsa.VerifyPSS(key, algorithms[alg], hashed, signature, nil) == nil
+        }
+    case *ecdsa.PublicKey:
+        size := (key.Curve.Params().BitSize + 7) / 8
+        if alg[:2] != "ES" || len(signature) != 2*size {
+            return false
+        }
+        r := new(big.Int).SetBytes(signature[:size])
+        s := new(big.Int).SetBytes(signature[size:])
+        return ecdsa.Verify(key, hashed, r, s)
+    case ed25519.PublicKey:
+        return alg == "EdDSA" && ed25519.Verify(key, signed, signature)
+    }
+    return false
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+    var hasher hash.Hash
+    switch h {
+    case crypto.SHA256:
+        hasher = sha256.New()
+    case crypto.SHA384:
+        hasher = sha512.New384()
+    case crypto.SHA512:
+        hasher = sha512.New()
+    default:
+        return data
+    }
+    hasher.Write(data)
+    return hasher.Sum(nil)
+}
+
+// SignES256 returns claims as a JWT signed with key, a P-256 key, under kid.
+// It is meant for development tokens; production tokens come from the
+// identity provider.
+func SignES256(claims map[string]interface{}, key *ecdsa.PrivateKey, kid string) (string, error) {
+    h, err := json.Marshal(header{Alg: "ES256", Kid: kid})
+    if err != nil {
+        return "", err
+    }
+    c, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+    r, s, err := ecdsa.Sign(rand.Reader, key, digest(crypto.SHA256, []byte(signed)))
+    if err != nil {
+        return "", err
+    }
+    signature := make([]byte, 64)
+    r.FillBytes(signature[:32])
+    s.FillBytes(signature[32:])
+    return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
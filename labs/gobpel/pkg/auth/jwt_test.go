@@ -0,0 +1,222 @@
+package auth
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// testKeys are the private keys of the local test key set.
+type testKeys struct {
+    ec      *ecdsa.PrivateKey
+    rsa     *rsa.PrivateKey
+    ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+    t.Helper()
+    ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, edKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testKeys{ec: ec, rsa: rsaKey, ed25519: edKey}
+}
+
+func encode(b []byte) string {
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ecJWK(key *ecdsa.PublicKey, kid, alg string) JWK {
+    return JWK{Kty: "EC", Kid: kid, Alg: alg, Crv: "P-256", X: encode(key.X.FillBytes(make([]byte, 32))), Y: encode(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func (k *testKeys) jwks() JWKS {
+    return JWKS{Keys: []JWK{
+        ecJWK(&k.ec.PublicKey, "ec", "ES256"),
+        {Kty: "RSA", Kid: "rsa", N: encode(k.rsa.N.Bytes()), E: encode(big.NewInt(int64(k.rsa.E)).Bytes())},
+        {Kty: "OKP", Kid: "ed25519", Crv: "Ed25519", X: encode(k.ed25519.Public().(ed25519.PublicKey))},
+    }}
+}
+
+func writeKeySet(t *testing.T, set JWKS) string {
+    t.Helper()
+    data, err := json.Marshal(set)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file := filepath.Join(t.TempDir(), "jwks.json")
+    if err := os.WriteFile(file, data, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return file
+}
+
+// sign returns claims as a JWT with the header alg and kid, signed with the
+// key of the test key set signer names: "ec", "rsa", "rsa-pkcs1" (RS
+// signatures whatever alg says) or "ed25519".
+func (k *testKeys) sign(t *testing.T, alg, kid, signer string, claims map[string]interface{}) string {
+    t.Helper()
+    h, _ := json.Marshal(header{Alg: alg, Kid: kid})
+    c, _ := json.Marshal(claims)
+    signed := encode(h) + "." + encode(c)
+    hash := algorithms[alg]
+    if hash == 0 {
+        hash = crypto.SHA256
+    }
+    hashed := digest(hash, []byte(signed))
+    var signature []byte
+    var err error
+    switch signer {
+    case "ec":
+        var r, s *big.Int
+        if r, s, err = ecdsa.Sign(rand.Reader, k.ec, hashed); err == nil {
+            signature = make([]byte, 64)
+            r.FillBytes(signature[:32])
+            s.FillBytes(signature[32:])
+        }
+    case "rsa":
+        if strings.HasPrefix(alg, "PS") {
+            signature, err = rsa.SignPSS(rand.Reader, k.rsa, hash, hashed, nil)
+        } else {
+            signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, hash, hashed)
+        }
+    case "rsa-pkcs1":
+        signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, hash, hashed)
+    case "ed25519":
+        signature = ed25519.Sign(k.ed25519, []byte(signed))
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signed + "." + encode(signature)
+}
+
+func validClaims() map[string]interface{} {
+    return map[string]interface{}{
+        "sub":    "alice",
+        "iss":    "https://login.example.com",
+        "aud":    []interface{}{"other", "gobpel"},
+        "exp":    float64(time.Now().Add(time.Hour).Unix()),
+        "tenant": "ml-research",
+    }
+}
+
+func withClaims(changes map[string]interface{}) map[string]interface{} {
+    claims := validClaims()
+    for name, value := range changes {
+        if value == nil {
+            delete(claims, name)
+        } else {
+            claims[name] = value
+        }
+    }
+    return claims
+}
+
+func TestVerify(t *testing.T) {
+    keys := newTestKeys(t)
+    set, err := NewKeySet(writeKeySet(t, keys.jwks()), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := NewVerifier(set, "https://login.example.com", "gobpel", "tenant")
+    hour := float64(time.Hour / time.Second)
+    now := float64(time.Now().Unix())
+
+    tests := []struct {
+        name   string
+        alg    string
+        kid    string
+        signer string
+        claims map[string]interface{}
+        err    string
+    }{
+        {"ES256", "ES256", "ec", "ec", validClaims(), ""},
+        {"RS256", "RS256", "rsa", "rsa", validClaims(), ""},
+        {"RS512", "RS512", "rsa", "rsa", validClaims(), ""},
+        {"PS256", "PS256", "rsa", "rsa", validClaims(), ""},
+        {"EdDSA", "EdDSA", "ed25519", "ed25519", validClaims(), ""},
+        {"none", "none", "ec", "ec", validClaims(), `unsupported algorithm "none"`},
+        {"HS256", "HS256", "rsa", "rsa", validClaims(), `unsupported algorithm "HS256"`},
+        {"RSA key as ES256", "ES256", "rsa", "rsa", validClaims(), "invalid token signature"},
+        {"EC key pinned to ES256 as ES384", "ES384", "ec", "ec", validClaims(), "invalid token signature"},
+        {"PKCS1 signature as PS256", "PS256", "rsa", "rsa-pkcs1", validClaims(), "invalid token signature"},
+        {"unknown kid", "ES256", "other", "ec", validClaims(), "invalid token signature"},
+        {"expired", "ES256", "ec", "ec", withClaims(map[string]interface{}{"exp": now - hour}), "token is expired"},
+        {"expired within leeway", "ES256", "ec", "ec", withClaims(map[string]interface{}{"exp": now - 30}), ""},
+        {"no expiry", "ES256", "ec", "ec", withClaims(map[string]interface{}{"exp": nil}), "token has no expiry"},
+        {"not valid yet", "ES256", "ec", "ec", withClaims(map[string]interface{}{"nbf": now + hour}), "token is not valid yet"},
+        {"valid from now", "ES256", "ec", "ec", withClaims(map[string]interface{}{"nbf": now}), ""},
+        {"other issuer", "ES256", "ec", "ec", withClaims(map[string]interface{}{"iss": "https://evil.example.com"}), "token is not issued by https://login.example.com"},
+        {"other audience", "ES256", "ec", "ec", withClaims(map[string]interface{}{"aud": "other"}), "token is not issued for gobpel"},
+        {"audience string", "ES256", "ec", "ec", withClaims(map[string]interface{}{"aud": "gobpel"}), ""},
+        {"no subject", "ES256", "ec", "ec", withClaims(map[string]interface{}{"sub": nil}), "token has no subject"},
+        {"invalid tenant", "ES256", "ec", "ec", withClaims(map[string]interface{}{"tenant": "../admin"}), "invalid tenant"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            token := keys.sign(t, test.alg, test.kid, test.signer, test.claims)
+            identity, err := verifier.Verify(token)
+            if test.err == "" {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if identity.Subject != "alice" || identity.Tenant != "ml-research" {
+                    t.Errorf("got %+v", identity)
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
+
+func TestVerifyTampered(t *testing.T) {
+    keys := newTestKeys(t)
+    set, err := NewKeySet(writeKeySet(t, keys.jwks()), "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := NewVerifier(set, "", "", "tenant")
+    token := keys.sign(t, "ES256", "ec", "ec", validClaims())
+    parts := strings.Split(token, ".")
+
+    forged, _ := json.Marshal(withClaims(map[string]interface{}{"sub": "admin"}))
+    signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+    signature[0] ^= 1
+    other := newTestKeys(t).sign(t, "ES256", "ec", "ec", validClaims())
+
+    for name, token := range map[string]string{
+        "claims":           parts[0] + "." + encode(forged) + "." + parts[2],
+        "signature":        parts[0] + "." + parts[1] + "." + encode(signature),
+        "no signature":     parts[0] + "." + parts[1] + ".",
+        "other key":        other,
+        "malformed":        parts[0] + "." + parts[1],
+        "invalid encoding": parts[0] + "." + parts[1] + ".!!",
+    } {
+        if _, err := verifier.Verify(token); err == nil {
+            t.Errorf("%s: token verified", name)
+        }
+    }
+}
@@ -0,0 +1,239 @@
+package authz
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strings"
+
+    "gobpel/api"
+    "gobpel/pkg/auth"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Roles, each allowed everything the roles before it are. Viewers read,
+// operators run processes, authors change them and admins administer the
+// engine.
+const (
+    RoleViewer   = "viewer"
+    RoleOperator = "operator"
+    RoleAuthor   = "author"
+    RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+    RoleViewer:   1,
+    RoleOperator: 2,
+    RoleAuthor:   3,
+    RoleAdmin:    4,
+}
+
+// Process actions restricted by the ACL of a process.
+const (
+    ActionExecute = "execute"
+    ActionEdit    = "edit"
+)
+
+// Rule is what a call needs: at least Role and, on a process with an ACL
+// for Action, an entry in it.
+type Rule struct {
+    Role   string
+    Action string
+}
+
+var rules = map[string]Rule{
+    api.BPELProcessService_GetProcess_FullMethodName:          {Role: RoleViewer},
+    api.BPELProcessService_GetAllProcesses_FullMethodName:     {Role: RoleViewer},
+    api.BPELProcessService_ListProcesses_FullMethodName:       {Role: RoleViewer},
+    api.BPELProcessService_ListRunMethods_FullMethodName:      {Role: RoleViewer},
+    api.BPELProcessService_GetProcessStatus_FullMethodName:    {Role: RoleViewer},
+    api.BPELProcessService_WatchInstance_FullMethodName:       {Role: RoleViewer},
+    api.BPELProcessService_WatchProcess_FullMethodName:        {Role: RoleViewer},
+    api.BPELProcessService_GetInstanceHistory_FullMethodName:  {Role: RoleViewer},
+    api.BPELProcessService_ListInstances_FullMethodName:       {Role: RoleViewer},
+    api.BPELProcessService_ListSchedules_FullMethodName:       {Role: RoleViewer},
+    api.BPELProcessService_ListCircuitBreakers_FullMethodName: {Role: RoleViewer},
+    api.BPELProcessService_ExecuteProcess_FullMethodName:      {Role: RoleOperator, Action: ActionExecute},
+    api.BPELProcessService_PauseSchedule_FullMethodName:       {Role: RoleOperator, Action: ActionExecute},
+    api.BPELProcessService_ResumeSchedule_FullMethodName:      {Role: RoleOperator, Action: ActionExecute},
+    api.BPELProcessService_Publish_FullMethodName:             {Role: RoleOperator},
+    api.BPELProcessService_CancelPublication_FullMethodName:   {Role: RoleOperator},
+    api.BPELProcessService_CreateProcess_FullMethodName:       {Role: RoleAuthor, Action: ActionEdit},
+    api.BPELProcessService_UpdateProcess_FullMethodName:       {Role: RoleAuthor, Action: ActionEdit},
+    api.BPELProcessService_DeleteProcess_FullMethodName:       {Role: RoleAuthor, Action: ActionEdit},
+    api.BPELProcessService_CreateSchedule_FullMethodName:      {Role: RoleAuthor, Action: ActionEdit},
+    api.BPELProcessService_DeleteSchedule_FullMethodName:      {Role: RoleAuthor, Action: ActionEdit},
+    api.BPELProcessService_DeleteAllProcesses_FullMethodName:  {Role: RoleAdmin},
+    api.BPELProcessService_Subscribe_FullMethodName:           {Role: RoleAdmin},
+    api.BPELProcessService_ReloadConfig_FullMethodName:        {Role: RoleAdmin},
+    api.BPELProcessService_ListAuditEvents_FullMethodName:     {Role: RoleAdmin},
+}
+
+// RuleFor returns the rule of a gRPC method. Server reflection is open to
+// viewers; methods of the service without a rule need admin.
+func RuleFor(method string) Rule {
+    if rule, ok := rules[method]; ok {
+        return rule
+    }
+    if strings.HasPrefix(method, "/grpc.reflection.") {
+        return Rule{Role: RoleViewer}
+    }
+    return Rule{Role: RoleAdmin}
+}
+
+// Policy maps callers to roles and holds the ACLs of processes. Entries of
+// an ACL are subjects, or role:<name> for callers with that role or higher.
+type Policy struct {
+    // RoleClaim is the token claim holding the roles of the caller, a
+    // string or a list, e.g. roles or realm_access.roles
+    RoleClaim   string                 `yaml:"roleClaim"`
+    DefaultRole string                 `yaml:"defaultRole"`
+    Subjects    map[string]string      `yaml:"subjects"`
+    Processes   map[string]*ProcessACL `yaml:"processes"`
+}
+
+type ProcessACL struct {
+    Execute []string `yaml:"execute"`
+    Edit    []string `yaml:"edit"`
+}
+
+// DefaultPolicy takes roles from the roles claim and gives callers without
+// one no access.
+func DefaultPolicy() *Policy {
+    return &Policy{RoleClaim: "roles"}
+}
+
+// LoadPolicy reads a policy file. Unknown keys and roles are errors.
+func LoadPolicy(name string) (*Policy, error) {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return nil, err
+    }
+    policy := DefaultPolicy()
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    decoder.KnownFields(true)
+    if err := decoder.Decode(policy); err != nil && err != io.EOF {
+        return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    if err := policy.validate(); err != nil {
+        return nil, fmt.Errorf("%s: %w", name, err)
+    }
+    return policy, nil
+}
+
+func (p *Policy) validate() error {
+    var errs []error
+    if p.DefaultRole != "" && roleRank[p.DefaultRole] == 0 {
+        errs = append(errs, fmt.Errorf("defaultRole: unknown role %q", p.DefaultRole))
+    }
+    subjects := make([]string, 0, len(p.Subjects))
+    for subject := range p.Subjects {
+        subjects = append(subjects, subject)
+    }
+    sort.Strings(subjects)
+    for _, subject := range subjects {
+        if roleRank[p.Subjects[subject]] == 0 {
+            errs = append(errs, fmt.Errorf("subjects.%s: unknown role %q", subject, p.Subjects[subject]))
+        }
+    }
+    processes := make([]string, 0, len(p.Processes))
+    for processId := range p.Processes {
+        processes = append(processes, processId)
+    }
+    sort.Strings(processes)
+    for _, processId := range processes {
+        acl := p.Processes[processId]
+        if acl == nil {
+            continue
+        }
+        for _, list := range []struct {
+            action  string
+            entries []string
+        }{{ActionExecute, acl.Execute}, {ActionEdit, acl.Edit}} {
+            for _, entry := range list.entries {
+                if role, ok := strings.CutPrefix(entry, "role:"); ok && roleRank[role] == 0 {
+                    errs = append(errs, fmt.Errorf("processes.%s.%s: unknown role %q", processId, list.action, role))
+                }
+            }
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// Role returns the highest known role of the caller, or "" without one.
+func (p *Policy) Role(identity *auth.Identity) string {
+    role := p.DefaultRole
+    consider := func(r string) {
+        if roleRank[r] > roleRank[role] {
+            role = r
+        }
+    }
+    consider(p.Subjects[identity.Subject])
+    switch roles := claim(identity.Claims, p.RoleClaim).(type) {
+    case string:
+        for _, r := range strings.Fields(roles) {
+            consider(r)
+        }
+    case []interface{}:
+        for _, r := range roles {
+            if r, ok := r.(string); ok {
+                consider(r)
+            }
+        }
+    }
+    return role
+}
+
+// claim looks up a claim by a dotted path into nested objects.
+func claim(claims map[string]interface{}, path string) interface{} {
+    var value interface{} = claims
+    for _, name := range strings.Split(path, ".") {
+        object, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = object[name]
+    }
+    return value
+}
+
+// Authorize returns an error describing why the caller may not call method
+// on processId, which is empty for calls not about a process.
+func (p *Policy) Authorize(identity *auth.Identity, method, processId string) error {
+    rule := RuleFor(method)
+    role := p.Role(identity)
+    if roleRank[role] < roleRank[rule.Role] {
+        if role == "" {
+            return fmt.Errorf("%s requires role %s, caller has no role", method, rule.Role)
+        }
+        return fmt.Errorf("%s requires role %s, caller is %s", method, rule.Role, role)
+    }
+    if rule.Action == "" || processId == "" || role == RoleAdmin {
+        return nil
+    }
+    acl := p.Processes[processId]
+    if acl == nil {
+        return nil
+    }
+    entries := acl.Execute
+    if rule.Action == ActionEdit {
+        entries = acl.Edit
+    }
+    if entries == nil {
+        return nil
+    }
+    for _, entry := range entries {
+        if r, ok := strings.CutPrefix(entry, "role:"); ok {
+            if roleRank[role] >= roleRank[r] {
+                return nil
+            }
+        } else if entry == identity.Subject {
+            return nil
+        }
+    }
+    return fmt.Errorf("%s: %s on process %s is not granted to %s", method, rule.Action, processId, identity.Subject)
+}
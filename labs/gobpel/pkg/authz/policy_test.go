@@ -0,0 +1,147 @@
+package authz
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "gobpel/api"
+    "gobpel/pkg/auth"
+)
+
+func identity(subject string, claims map[string]interface{}) *auth.Identity {
+    if claims == nil {
+        claims = map[string]interface{}{}
+    }
+    return &auth.Identity{Subject: subject, Claims: claims}
+}
+
+func TestRole(t *testing.T) {
+    policy := &Policy{
+        RoleClaim: "realm_access.roles",
+        Subjects:  map[string]string{"ci": RoleAuthor},
+    }
+    realm := func(roles interface{}) map[string]interface{} {
+        return map[string]interface{}{"realm_access": map[string]interface{}{"roles": roles}}
+    }
+    tests := []struct {
+        name        string
+        defaultRole string
+        identity    *auth.Identity
+        role        string
+    }{
+        {"no role", "", identity("alice", nil), ""},
+        {"default role", RoleViewer, identity("alice", nil), RoleViewer},
+        {"claim list", "", identity("alice", realm([]interface{}{"operator", "offline_access"})), RoleOperator},
+        {"claim string", "", identity("alice", realm("viewer admin")), RoleAdmin},
+        {"highest of several", "", identity("alice", realm([]interface{}{"author", "viewer", 3})), RoleAuthor},
+        {"unknown roles", RoleViewer, identity("alice", realm([]interface{}{"superuser"})), RoleViewer},
+        {"claim not nested", "", identity("alice", map[string]interface{}{"realm_access": "admin"}), ""},
+        {"subject", "", identity("ci", nil), RoleAuthor},
+        {"subject and claim", "", identity("ci", realm([]interface{}{"operator"})), RoleAuthor},
+        {"default below claim", RoleOperator, identity("alice", realm([]interface{}{"viewer"})), RoleOperator},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            policy.DefaultRole = test.defaultRole
+            if role := policy.Role(test.identity); role != test.role {
+                t.Errorf("got %q, want %q", role, test.role)
+            }
+        })
+    }
+}
+
+func TestAuthorize(t *testing.T) {
+    policy := &Policy{
+        RoleClaim: "roles",
+        Processes: map[string]*ProcessACL{
+            "training": {Execute: []string{"alice", "role:author"}, Edit: []string{"bob"}},
+            "open":     {Edit: []string{"role:admin"}},
+            "nobody":   {Execute: []string{}},
+        },
+    }
+    as := func(subject string, role string) *auth.Identity {
+        return identity(subject, map[string]interface{}{"roles": role})
+    }
+    execute := api.BPELProcessService_ExecuteProcess_FullMethodName
+    update := api.BPELProcessService_UpdateProcess_FullMethodName
+
+    tests := []struct {
+        name      string
+        identity  *auth.Identity
+        method    string
+        processId string
+        err       string
+    }{
+        {"viewer reads", as("carol", RoleViewer), api.BPELProcessService_GetProcess_FullMethodName, "training", ""},
+        {"no role", identity("carol", nil), api.BPELProcessService_GetProcess_FullMethodName, "", "requires role viewer, caller has no role"},
+        {"viewer executes", as("carol", RoleViewer), execute, "other", "requires role operator, caller is viewer"},
+        {"operator executes without ACL", as("carol", RoleOperator), execute, "other", ""},
+        {"operator not in ACL", as("carol", RoleOperator), execute, "training", "execute on process training is not granted to carol"},
+        {"operator in ACL", as("alice", RoleOperator), execute, "training", ""},
+        {"role entry", as("carol", RoleAuthor), execute, "training", ""},
+        {"admin ignores empty ACL", as("carol", RoleAdmin), execute, "nobody", ""},
+        {"empty ACL", as("alice", RoleAuthor), execute, "nobody", "not granted to alice"},
+        {"no execute list", as("carol", RoleOperator), execute, "open", ""},
+        {"edit ACL", as("alice", RoleAuthor), update, "training", "edit on process training is not granted to alice"},
+        {"edit granted", as("bob", RoleAuthor), update, "training", ""},
+        {"edit needs author", as("bob", RoleOperator), update, "training", "requires role author, caller is operator"},
+        {"admin ignores ACL", as("carol", RoleAdmin), update, "open", ""},
+        {"call not about a process", as("carol", RoleOperator), api.BPELProcessService_Publish_FullMethodName, "", ""},
+        {"admin only", as("carol", RoleAuthor), api.BPELProcessService_ReloadConfig_FullMethodName, "", "requires role admin"},
+        {"method without rule", as("carol", RoleAuthor), "/bpel.BPELProcessService/NewMethod", "", "requires role admin"},
+        {"reflection", as("carol", RoleViewer), "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", "", ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            err := policy.Authorize(test.identity, test.method, test.processId)
+            if test.err == "" {
+                if err != nil {
+                    t.Errorf("got %v", err)
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
+
+func TestLoadPolicy(t *testing.T) {
+    tests := []struct {
+        name   string
+        policy string
+        err    string
+    }{
+        {"empty", "", ""},
+        {"valid", "roleClaim: groups\ndefaultRole: viewer\nsubjects: {ci: author}\nprocesses: {training: {execute: [alice, role:operator]}}", ""},
+        {"unknown key", "role: admin", "field role not found"},
+        {"default role", "defaultRole: owner", `defaultRole: unknown role "owner"`},
+        {"subject role", "subjects: {ci: root}", `subjects.ci: unknown role "root"`},
+        {"ACL role", "processes: {training: {edit: [role:editor]}}", `processes.training.edit: unknown role "editor"`},
+        {"all errors", "defaultRole: owner\nsubjects: {ci: root}", `subjects.ci`},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            file := filepath.Join(t.TempDir(), "policy.yaml")
+            if err := os.WriteFile(file, []byte(test.policy), 0o600); err != nil {
+                t.Fatal(err)
+            }
+            policy, err := LoadPolicy(file)
+            if test.err == "" {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if policy.RoleClaim == "" {
+                    t.Error("roleClaim not defaulted")
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
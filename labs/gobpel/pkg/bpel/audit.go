@@ -0,0 +1,65 @@
+package bpel
+
+import (
+    "context"
+    "log"
+
+    "gobpel/api"
+    "gobpel/pkg/auth"
+    "gobpel/pkg/db"
+
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Audit actions and outcomes. The audit log records administrative changes
+// to the engine, as opposed to the history of an instance.
+const (
+    AuditConfigReloaded      = "configReloaded"
+    AuditProcessDeleted      = "processDeleted"
+    AuditAllProcessesDeleted = "allProcessesDeleted"
+
+    AuditSucceeded = "succeeded"
+    AuditFailed    = "failed"
+)
+
+// actor names the caller of an RPC in the audit log: the subject of its
+// token, or anonymous without authentication.
+func actor(ctx context.Context) string {
+    if identity, ok := auth.FromContext(ctx); ok {
+        return identity.Subject
+    }
+    return "anonymous"
+}
+
+// audit records an action in the audit log of tenant. A log that cannot be
+// stored is logged instead.
+func (s *Server) audit(tenant, action, actor, outcome, detail string) {
+    event := &api.AuditEvent{
+        Tenant:    tenant,
+        EventId:   newInstanceId(),
+        Time:      timestamppb.Now(),
+        Action:    action,
+        Actor:     actor,
+        ReplicaId: s.replicaId,
+        Outcome:   outcome,
+        Detail:    detail,
+    }
+    if err := db.InsertAuditEvent(event); err != nil {
+        log.Printf("Error storing audit event %s by %s (%s: %s): %v", action, actor, outcome, detail, err)
+    }
+}
+
+func (s *Server) ListAuditEvents(ctx context.Context, req *api.ListAuditEventsRequest) (*api.ListAuditEventsResponse, error) {
+    limit, offset, err := listPage(req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+    events, err := db.ListAuditEvents(tenantOf(ctx), req.Action, offset, limit)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    return &api.ListAuditEventsResponse{
+        Events:        events,
+        NextPageToken: nextPageToken(offset, limit, len(events)),
+    }, nil
+}
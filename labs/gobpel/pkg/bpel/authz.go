@@ -0,0 +1,92 @@
+package bpel
+
+import (
+    "context"
+    "log"
+
+    "gobpel/api"
+    "gobpel/pkg/auth"
+    "gobpel/pkg/authz"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    AuditPermissionDenied = "permissionDenied"
+
+    AuditDenied = "denied"
+)
+
+// SetPolicy sets the policy calls are authorized by. The interceptors let
+// every call through without one.
+func (s *Server) SetPolicy(policy *authz.Policy) {
+    s.mu.Lock()
+    s.policy = policy
+    s.mu.Unlock()
+}
+
+// UnaryAuthorizer rejects calls the policy does not allow the caller, who
+// is authenticated by an interceptor before it, with PermissionDenied.
+func (s *Server) UnaryAuthorizer() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        if err := s.authorize(ctx, info.FullMethod, processOf(ctx, req)); err != nil {
+            return nil, err
+        }
+        return handler(ctx, req)
+    }
+}
+
+// StreamAuthorizer is UnaryAuthorizer for streaming calls, which are only
+// checked for the role of the caller.
+func (s *Server) StreamAuthorizer() grpc.StreamServerInterceptor {
+    return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+        if err := s.authorize(stream.Context(), info.FullMethod, ""); err != nil {
+            return err
+        }
+        return handler(srv, stream)
+    }
+}
+
+func (s *Server) authorize(ctx context.Context, method, processId string) error {
+    s.mu.Lock()
+    policy := s.policy
+    s.mu.Unlock()
+    if policy == nil {
+        return nil
+    }
+    identity, ok := auth.FromContext(ctx)
+    if !ok {
+        return status.Error(codes.Unauthenticated, "caller is not authenticated")
+    }
+    tenant := tenantOf(ctx)
+    if processId != "" {
+        processId = qualifiedName(tenant, processId)
+    }
+    err := policy.Authorize(identity, method, processId)
+    if err == nil {
+        return nil
+    }
+    log.Printf("Denied %s to %s: %v", method, identity.Subject, err)
+    s.audit(tenant, AuditPermissionDenied, identity.Subject, AuditDenied, err.Error())
+    return status.Errorf(codes.PermissionDenied, "permission denied: %v", err)
+}
+
+// processOf returns the process a request is about, if any.
+func processOf(ctx context.Context, req interface{}) string {
+    switch req := req.(type) {
+    case *api.Process:
+        return req.Name
+    case *api.ScheduleRequest:
+        schedule, err := db.GetSchedule(tenantOf(ctx), req.ScheduleId)
+        if err != nil {
+            return ""
+        }
+        return schedule.ProcessId
+    case interface{ GetProcessId() string }:
+        return req.GetProcessId()
+    }
+    return ""
+}
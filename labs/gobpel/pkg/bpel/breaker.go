@@ -0,0 +1,305 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "gobpel/api"
+
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Circuit breaker states. A closed breaker lets invokes through and opens
+// after FailureThreshold consecutive failures. An open breaker fails invokes
+// at once until OpenTimeout has passed, then turns half-open and lets a
+// single trial invoke through, which closes it again or reopens it. Each
+// tenant has its own breaker for a partner link; the rate limit of a partner
+// link is shared by all tenants.
+const (
+    BreakerClosed   = "closed"
+    BreakerOpen     = "open"
+    BreakerHalfOpen = "halfOpen"
+)
+
+const (
+    defaultFailureThreshold = 5
+    defaultOpenTimeout      = 30 * time.Second
+    // Breakers of partner links without settings that were closed and unused
+    // for this long are dropped
+    breakerIdleTimeout = 10 * time.Minute
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerPolicy configures the circuit breaker of a partner link.
+type BreakerPolicy struct {
+    FailureThreshold int
+    OpenTimeout      time.Duration
+}
+
+var defaultBreakerPolicy = BreakerPolicy{
+    FailureThreshold: defaultFailureThreshold,
+    OpenTimeout:      defaultOpenTimeout,
+}
+
+type circuitBreaker struct {
+    tenant      string
+    partnerLink string
+    mu          sync.Mutex
+    policy      BreakerPolicy
+    state       string
+    failures    int
+    openedAt    time.Time
+    trial       bool
+    lastUsed    time.Time
+    limiter     *tokenBucket
+}
+
+// tokenBucket admits rate invokes per second with bursts of up to burst.
+type tokenBucket struct {
+    rate  float64
+    burst float64
+
+    mu     sync.Mutex
+    tokens float64
+    last   time.Time
+}
+
+// SetCircuitBreaker replaces the breaker policy of partnerLink for tenant.
+// Partner links without a policy open after 5 failures for 30 seconds.
+func (s *Server) SetCircuitBreaker(tenant, partnerLink string, policy BreakerPolicy) {
+    b := s.breakerFor(tenant, partnerLink)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.policy = breakerPolicy(policy)
+}
+
+// breakerPolicy fills in the defaults of unset policy values.
+func breakerPolicy(policy BreakerPolicy) BreakerPolicy {
+    if policy.FailureThreshold <= 0 {
+        policy.FailureThreshold = defaultFailureThreshold
+    }
+    if policy.OpenTimeout <= 0 {
+        policy.OpenTimeout = defaultOpenTimeout
+    }
+    return policy
+}
+
+// SetPartnerLinkRateLimit limits invokes on partnerLink to rate per second
+// with bursts of up to burst, counting the invokes of all tenants together.
+// Invokes over the limit wait for a token. A rate of zero removes the limit.
+func (s *Server) SetPartnerLinkRateLimit(partnerLink string, rate float64, burst int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.setLimit(partnerLink, rate, burst)
+}
+
+// setLimit replaces the rate limit of partnerLink unless it is unchanged,
+// which keeps the tokens already spent. s.mu must be held.
+func (s *Server) setLimit(partnerLink string, rate float64, burst int) {
+    if burst < 1 {
+        burst = 1
+    }
+    limiter := s.limiters[partnerLink]
+    switch {
+    case rate <= 0:
+        limiter = nil
+        delete(s.limiters, partnerLink)
+    case limiter == nil || limiter.rate != rate || limiter.burst != float64(burst):
+        limiter = &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+        s.limiters[partnerLink] = limiter
+    }
+    for _, b := range s.breakers {
+        if b.partnerLink == partnerLink {
+            b.mu.Lock()
+            b.limiter = limiter
+            b.mu.Unlock()
+        }
+    }
+}
+
+// breakerFor returns the breaker of tenant for partnerLink, created with the
+// settings of the partner link.
+func (s *Server) breakerFor(tenant, partnerLink string) *circuitBreaker {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    name := qualifiedName(tenant, partnerLink)
+    b, ok := s.breakers[name]
+    if !ok {
+        s.pruneBreakers()
+        b = newCircuitBreaker(tenant, partnerLink, s.partners[partnerLink])
+        b.limiter = s.limiters[partnerLink]
+        s.breakers[name] = b
+    }
+    return b
+}
+
+func newCircuitBreaker(tenant, partnerLink string, partner PartnerSettings) *circuitBreaker {
+    return &circuitBreaker{
+        tenant:      tenant,
+        partnerLink: partnerLink,
+        policy:      breakerPolicy(partner.Breaker),
+        state:       BreakerClosed,
+        lastUsed:    time.Now(),
+    }
+}
+
+// pruneBreakers drops the breakers of partner links without settings that
+// have nothing to remember, so that the breakers of every tenant and partner
+// link ever invoked do not pile up. s.mu must be held.
+func (s *Server) pruneBreakers() {
+    for name, b := range s.breakers {
+        if _, configured := s.partners[b.partnerLink]; configured {
+            continue
+        }
+        b.mu.Lock()
+        idle := b.state == BreakerClosed && b.failures == 0 && time.Since(b.lastUsed) > breakerIdleTimeout
+        b.mu.Unlock()
+        if idle {
+            delete(s.breakers, name)
+        }
+    }
+}
+
+// allow reports errCircuitOpen unless the breaker lets an invoke through. It
+// reports whether the invoke is the trial of a half-open breaker, which is
+// passed on to record.
+func (b *circuitBreaker) allow() (bool, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.lastUsed = time.Now()
+    switch b.state {
+    case BreakerOpen:
+        if time.Since(b.openedAt) < b.policy.OpenTimeout {
+            return false, fmt.Errorf("%w for %s", errCircuitOpen, b.partnerLink)
+        }
+        b.setState(BreakerHalfOpen)
+        b.trial = true
+        return true, nil
+    case BreakerHalfOpen:
+        if b.trial {
+            return false, fmt.Errorf("%w for %s", errCircuitOpen, b.partnerLink)
+        }
+        b.trial = true
+        return true, nil
+    }
+    return false, nil
+}
+
+// record counts the outcome of an invoke the breaker let through, the trial
+// when allow said so. Only the trial decides a half-open breaker; invokes let
+// through before it opened only count failures. Invokes ended by their own
+// context, such as a cancelled instance, do not count.
+func (b *circuitBreaker) record(ctx context.Context, trial bool, err error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if trial {
+        b.trial = false
+    }
+    switch {
+    case err != nil && ctx.Err() != nil:
+    case err == nil:
+        b.failures = 0
+        if trial && b.state == BreakerHalfOpen {
+            b.setState(BreakerClosed)
+        }
+    case trial && b.state == BreakerHalfOpen:
+        b.openedAt = time.Now()
+        b.setState(BreakerOpen)
+    default:
+        b.failures++
+        if b.state == BreakerClosed && b.failures >= b.policy.FailureThreshold {
+            b.openedAt = time.Now()
+            b.setState(BreakerOpen)
+        }
+    }
+}
+
+func (b *circuitBreaker) setState(state string) {
+    if b.state != state {
+        log.Printf("Circuit breaker of %s is %s", qualifiedName(b.tenant, b.partnerLink), state)
+    }
+    b.state = state
+}
+
+// wait blocks until the rate limit of the partner link admits an invoke.
+func (b *circuitBreaker) wait(ctx context.Context) error {
+    b.mu.Lock()
+    limiter := b.limiter
+    b.mu.Unlock()
+    if limiter == nil {
+        return nil
+    }
+    limiter.mu.Lock()
+    now := time.Now()
+    limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+    if limiter.tokens > limiter.burst {
+        limiter.tokens = limiter.burst
+    }
+    limiter.last = now
+    // The token is taken now and the invoke waits until it is earned
+    limiter.tokens--
+    delay := time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+    limiter.mu.Unlock()
+    if delay <= 0 {
+        return nil
+    }
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        limiter.mu.Lock()
+        limiter.tokens++
+        limiter.mu.Unlock()
+        return ctx.Err()
+    }
+}
+
+func (b *circuitBreaker) toProto() *api.CircuitBreaker {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    breaker := &api.CircuitBreaker{
+        PartnerLink:      b.partnerLink,
+        State:            b.state,
+        Failures:         int32(b.failures),
+        FailureThreshold: int32(b.policy.FailureThreshold),
+        OpenSeconds:      int32(b.policy.OpenTimeout / time.Second),
+    }
+    if !b.openedAt.IsZero() {
+        breaker.OpenedAt = timestamppb.New(b.openedAt)
+    }
+    if b.limiter != nil {
+        breaker.RateLimit = b.limiter.rate
+        breaker.Burst = int32(b.limiter.burst)
+    }
+    return breaker
+}
+
+// ListCircuitBreakers reports the breakers of the caller's tenant for the
+// partner links invoked or configured on this replica.
+func (s *Server) ListCircuitBreakers(ctx context.Context, req *api.ListCircuitBreakersRequest) (*api.ListCircuitBreakersResponse, error) {
+    tenant := tenantOf(ctx)
+    s.mu.Lock()
+    breakers := make([]*circuitBreaker, 0, len(s.breakers))
+    for _, b := range s.breakers {
+        if b.tenant == tenant && (req.PartnerLink == "" || req.PartnerLink == b.partnerLink) {
+            breakers = append(breakers, b)
+        }
+    }
+    s.mu.Unlock()
+
+    sort.Slice(breakers, func(i, j int) bool { return breakers[i].partnerLink < breakers[j].partnerLink })
+    resp := &api.ListCircuitBreakersResponse{}
+    for _, b := range breakers {
+        resp.CircuitBreakers = append(resp.CircuitBreakers, b.toProto())
+    }
+    return resp, nil
+}
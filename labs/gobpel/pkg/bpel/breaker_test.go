@@ -0,0 +1,184 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+    failed := errors.New("partner failed")
+    cancelled, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    // Each step is an invoke that allow lets through or not and then ends
+    // with err, unless it is still in flight
+    type step struct {
+        wait    time.Duration
+        allowed bool
+        err     error
+        ctx     context.Context
+        state   string
+    }
+    tests := []struct {
+        name  string
+        steps []step
+    }{
+        {"opens after the threshold", []step{
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerOpen},
+            {allowed: false, state: BreakerOpen},
+        }},
+        {"success resets failures", []step{
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerClosed},
+        }},
+        {"cancelled invokes do not count", []step{
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: failed, state: BreakerClosed},
+            {allowed: true, err: context.Canceled, ctx: cancelled, state: BreakerClosed},
+            {allowed: true, err: context.Canceled, ctx: cancelled, state: BreakerClosed},
+        }},
+        {"trial closes", []step{
+            {allowed: true, err: failed}, {allowed: true, err: failed}, {allowed: true, err: failed},
+            {wait: time.Hour, allowed: true, state: BreakerClosed},
+            {allowed: true, state: BreakerClosed},
+        }},
+        {"trial reopens", []step{
+            {allowed: true, err: failed}, {allowed: true, err: failed}, {allowed: true, err: failed},
+            {wait: time.Hour, allowed: true, err: failed, state: BreakerOpen},
+            {allowed: false, state: BreakerOpen},
+        }},
+        {"cancelled trial lets another through", []step{
+            {allowed: true, err: failed}, {allowed: true, err: failed}, {allowed: true, err: failed},
+            {wait: time.Hour, allowed: true, err: context.Canceled, ctx: cancelled, state: BreakerHalfOpen},
+            {allowed: true, state: BreakerClosed},
+        }},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            b := newCircuitBreaker("default", "ragservice", PartnerSettings{Breaker: BreakerPolicy{FailureThreshold: 3, OpenTimeout: time.Minute}})
+            for i, step := range test.steps {
+                if step.wait > 0 {
+                    b.openedAt = b.openedAt.Add(-step.wait)
+                }
+                trial, err := b.allow()
+                if (err == nil) != step.allowed {
+                    t.Fatalf("step %d: got %v, want allowed %v", i, err, step.allowed)
+                }
+                if err == nil {
+                    ctx := step.ctx
+                    if ctx == nil {
+                        ctx = context.Background()
+                    }
+                    b.record(ctx, trial, step.err)
+                }
+                if step.state != "" && b.state != step.state {
+                    t.Fatalf("step %d: got %s, want %s", i, b.state, step.state)
+                }
+            }
+        })
+    }
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+    failed := errors.New("partner failed")
+    b := newCircuitBreaker("default", "ragservice", PartnerSettings{Breaker: BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute}})
+
+    // An invoke let through while closed is still in flight when the breaker
+    // opens and turns half-open
+    early, err := b.allow()
+    if err != nil || early {
+        t.Fatalf("got trial %v, %v", early, err)
+    }
+    trial, _ := b.allow()
+    b.record(context.Background(), trial, failed)
+    b.openedAt = b.openedAt.Add(-time.Hour)
+    trial, err = b.allow()
+    if err != nil || !trial {
+        t.Fatalf("got trial %v, %v, want the trial", trial, err)
+    }
+    if _, err := b.allow(); !errors.Is(err, errCircuitOpen) {
+        t.Fatalf("got %v, want a single trial", err)
+    }
+
+    b.record(context.Background(), early, nil)
+    if b.state != BreakerHalfOpen {
+        t.Fatalf("early invoke decided the breaker: got %s", b.state)
+    }
+    if _, err := b.allow(); !errors.Is(err, errCircuitOpen) {
+        t.Fatalf("early invoke consumed the trial: got %v", err)
+    }
+    b.record(context.Background(), trial, nil)
+    if b.state != BreakerClosed {
+        t.Fatalf("got %s, want %s", b.state, BreakerClosed)
+    }
+}
+
+func TestBreakersPerTenant(t *testing.T) {
+    s := NewServer()
+    s.SetPartners(map[string]PartnerSettings{"ragservice": {RateLimit: 5, Burst: 10}})
+    a, b := s.breakerFor("default", "ragservice"), s.breakerFor("ml-research", "ragservice")
+    if a == b {
+        t.Fatal("tenants share a breaker")
+    }
+    if b.limiter == nil || b.limiter.rate != 5 || b.limiter.burst != 10 {
+        t.Errorf("got limiter %+v, want the configured one", b.limiter)
+    }
+    if a.limiter != b.limiter {
+        t.Error("tenants have their own rate limit")
+    }
+
+    idle := s.breakerFor("ml-research", "other")
+    idle.lastUsed = time.Now().Add(-2 * breakerIdleTimeout)
+    b.lastUsed = idle.lastUsed
+    s.breakerFor("ml-research", "new")
+    if _, ok := s.breakers["ml-research/other"]; ok {
+        t.Error("idle breaker kept")
+    }
+    if _, ok := s.breakers["ml-research/ragservice"]; !ok {
+        t.Error("breaker of a configured partner link dropped")
+    }
+}
+
+func TestSetPartnersKeepsTenantBreakers(t *testing.T) {
+    s := NewServer()
+    partners := map[string]PartnerSettings{"ragservice": {
+        RateLimit: 5,
+        Burst:     10,
+        Breaker:   BreakerPolicy{FailureThreshold: 3, OpenTimeout: time.Minute},
+    }}
+    s.SetPartners(partners)
+    b := s.breakerFor("ml-research", "ragservice")
+    b.record(context.Background(), false, errors.New("partner failed"))
+    limiter := b.limiter
+
+    // A reload with unchanged settings
+    s.SetPartners(partners)
+    if b.policy.FailureThreshold != 3 || b.policy.OpenTimeout != time.Minute {
+        t.Errorf("got policy %+v, want the configured one", b.policy)
+    }
+    if b.limiter != limiter {
+        t.Errorf("got limiter %+v, want the one kept", b.limiter)
+    }
+    if b.failures != 1 {
+        t.Errorf("got %d failures, want 1", b.failures)
+    }
+
+    s.SetPartners(map[string]PartnerSettings{"ragservice": {RateLimit: 1}})
+    if b.policy != defaultBreakerPolicy {
+        t.Errorf("got policy %+v, want the default", b.policy)
+    }
+    if b.limiter == nil || b.limiter.rate != 1 || b.limiter.burst != 1 || b.limiter != s.breakerFor("default", "ragservice").limiter {
+        t.Errorf("got limiter %+v", b.limiter)
+    }
+    s.SetPartners(nil)
+    if b.limiter != nil {
+        t.Errorf("got limiter %+v, want none", b.limiter)
+    }
+}
@@ -0,0 +1,156 @@
+package bpel
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/broker"
+    "gobpel/pkg/db"
+)
+
+// InvokeSubject is the subject partners listening on the broker serve
+// operation requests on.
+func InvokeSubject(partnerLink, operation string) string {
+    return "gobpel.invoke." + partnerLink + "." + operation
+}
+
+// ReceiveSubject is the subject that starts instances of processes of
+// tenant whose sequence opens with <receive createInstance="yes">.
+func ReceiveSubject(tenant, partnerLink, operation string) string {
+    return tenantSubject(tenant, "receive."+partnerLink+"."+operation)
+}
+
+func EventSubject(tenant, eventType string) string {
+    return tenantSubject(tenant, "events."+eventType)
+}
+
+// tenantSubject keeps the subjects of the default tenant as they were
+// before tenants.
+func tenantSubject(tenant, subject string) string {
+    if tenant == db.DefaultTenant {
+        return "gobpel." + subject
+    }
+    return "gobpel.tenants." + tenant + "." + subject
+}
+
+type brokerTransport struct {
+    broker  broker.Broker
+    timeout time.Duration
+}
+
+// NewBrokerTransport invokes partners with a request/reply on InvokeSubject,
+// waiting up to timeout for the partner to answer.
+func NewBrokerTransport(b broker.Broker, timeout time.Duration) Transport {
+    return &brokerTransport{broker: b, timeout: timeout}
+}
+
+func (t *brokerTransport) Call(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+    ctx, cancel := context.WithTimeout(ctx, t.timeout)
+    defer cancel()
+    return t.broker.Request(ctx, InvokeSubject(invoke.PartnerLink, invoke.Operation), payload)
+}
+
+type brokerSink struct {
+    broker broker.Broker
+}
+
+// NewBrokerEventSink publishes engine events on the EventSubject of their
+// tenant.
+func NewBrokerEventSink(b broker.Broker) EventSink {
+    return &brokerSink{broker: b}
+}
+
+func (k *brokerSink) Emit(ctx context.Context, eventType string, data []byte) error {
+    return k.broker.Publish(ctx, EventSubject(tenantOf(ctx), eventType), data)
+}
+
+// SetBroker publishes engine events to b and starts instances of processes
+// when messages arrive on their receive subjects.
+func (s *Server) SetBroker(b broker.Broker) {
+    s.mu.Lock()
+    s.broker = b
+    s.sinks = append(s.sinks, NewBrokerEventSink(b))
+    processes := make([]*api.Process, 0, len(s.workflows))
+    for _, process := range s.workflows {
+        processes = append(processes, process)
+    }
+    s.mu.Unlock()
+
+    for _, process := range processes {
+        s.registerTrigger(process)
+    }
+}
+
+func (s *Server) registerTrigger(process *api.Process) {
+    name := qualifiedName(process.Tenant, process.Name)
+    s.unregisterTrigger(name)
+
+    s.mu.Lock()
+    b := s.broker
+    s.mu.Unlock()
+    if b == nil {
+        return
+    }
+
+    // Definitions that fail to parse are reported when they are executed
+    bpelProcess, err := ParseBPEL(process.BpelDefinition)
+    if err != nil {
+        return
+    }
+    receive := bpelProcess.InitialReceive()
+    if receive == nil {
+        return
+    }
+
+    handler := func(msg *broker.Message) {
+        var input interface{}
+        if len(msg.Data) > 0 {
+            input = decodeMessage(msg.Data)
+        }
+        event := processEvent{Tenant: process.Tenant, ProcessId: process.Name}
+        inst, err := s.runProcess(context.Background(), process, nil, input)
+        if err != nil {
+            log.Printf("Error starting %s from %s: %v", name, msg.Subject, err)
+            event.Status = err.Error()
+        } else {
+            <-inst.done
+            event.InstanceId = inst.id
+            state, _ := s.instanceResult(inst)
+            event.Status = statusText(state)
+        }
+        reply, _ := json.Marshal(event)
+        msg.Respond(reply)
+    }
+
+    subject := ReceiveSubject(process.Tenant, receive.PartnerLink, receive.Operation)
+    var sub broker.Subscription
+    if qs, ok := b.(broker.QueueSubscriber); ok {
+        // Replicas share the queue so each message starts a single instance
+        sub, err = qs.QueueSubscribe(subject, "gobpel", handler)
+    } else {
+        sub, err = b.Subscribe(subject, handler)
+    }
+    if err != nil {
+        log.Printf("Error subscribing %s to %s: %v", name, subject, err)
+        return
+    }
+
+    s.mu.Lock()
+    s.triggers[name] = sub
+    s.mu.Unlock()
+}
+
+// unregisterTrigger stops starting the process with the qualified name from
+// the broker.
+func (s *Server) unregisterTrigger(name string) {
+    s.mu.Lock()
+    sub, exists := s.triggers[name]
+    delete(s.triggers, name)
+    s.mu.Unlock()
+    if exists {
+        sub.Unsubscribe()
+    }
+}
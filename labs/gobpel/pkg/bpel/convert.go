@@ -0,0 +1,399 @@
+package bpel
+
+import (
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "strings"
+
+    "gobpel/api"
+)
+
+// ParseBPEL parses BPEL XML into the activity model.
+func ParseBPEL(definition string) (*BPELProcess, error) {
+    process := &BPELProcess{}
+    if err := xml.Unmarshal([]byte(definition), process); err != nil {
+        return nil, err
+    }
+    return process, nil
+}
+
+// prepareDefinition brings the definition forms of a process in line before
+// it is stored. BPEL XML is parsed into the structured fields; the structured
+// fields, when set, and YAML or JSON workflows are exported to bpelDefinition,
+// which the engine executes.
+func prepareDefinition(process *api.Process) error {
+    format := strings.ToLower(process.DefinitionFormat)
+    var compiled *BPELProcess
+    var err error
+    switch format {
+    case "", FormatBPEL:
+        switch {
+        case process.Activity != nil:
+            compiled, err = FromProto(process)
+        case process.BpelDefinition != "":
+            compiled, err = ParseBPEL(process.BpelDefinition)
+            if err != nil {
+                return err
+            }
+            // Keep the XML as written
+            compiled.ToProto(process)
+            return nil
+        default:
+            return nil
+        }
+    case FormatYAML, FormatJSON:
+        if process.Definition == "" {
+            return errors.New("definition is required for the " + format + " format")
+        }
+        process.DefinitionFormat = format
+        compiled, err = CompileWorkflow([]byte(process.Definition), format)
+    default:
+        return fmt.Errorf("unsupported definition format %q", process.DefinitionFormat)
+    }
+    if err != nil {
+        return err
+    }
+
+    if process.Name == "" {
+        process.Name = compiled.Name
+    }
+    if process.TargetNamespace == "" {
+        process.TargetNamespace = compiled.TargetNS
+    }
+    compiled.Name = process.Name
+    compiled.TargetNS = process.TargetNamespace
+    compiled.ToProto(process)
+    process.BpelDefinition, err = compiled.Export()
+    return err
+}
+
+// withStructure fills the structured fields of processes stored before they
+// were kept alongside bpelDefinition.
+func withStructure(processes ...*api.Process) {
+    for _, process := range processes {
+        if process.Activity != nil || process.BpelDefinition == "" {
+            continue
+        }
+        if compiled, err := ParseBPEL(process.BpelDefinition); err == nil {
+            compiled.ToProto(process)
+        }
+    }
+}
+
+// ToProto sets the structured fields of process from the activity model.
+func (p *BPELProcess) ToProto(process *api.Process) {
+    process.PartnerLinks = nil
+    for _, pl := range p.PartnerLinks {
+        process.PartnerLinks = append(process.PartnerLinks, &api.PartnerLink{
+            Name:            pl.Name,
+            PartnerLinkType: pl.PartnerLinkType,
+            MyRole:          pl.MyRole,
+            PartnerRole:     pl.PartnerRole,
+        })
+    }
+    process.Variables = nil
+    for _, v := range p.Variables {
+        process.Variables = append(process.Variables, &api.Variable{Name: v.Name, MessageType: v.MessageType, Type: v.Type})
+    }
+    process.CorrelationSets = nil
+    for _, cs := range p.CorrelationSets {
+        process.CorrelationSets = append(process.CorrelationSets, &api.CorrelationSet{Name: cs.Name, Properties: cs.Properties})
+    }
+    process.FaultHandlers = faultHandlersToProto(p.FaultHandlers)
+    process.EventHandlers = nil
+    if p.EventHandlers != nil {
+        process.EventHandlers = &api.EventHandlers{}
+        for _, o := range p.EventHandlers.OnEvents {
+            process.EventHandlers.OnEvents = append(process.EventHandlers.OnEvents, &api.OnEvent{
+                PartnerLink: o.PartnerLink,
+                Operation:   o.Operation,
+                Variable:    o.Variable,
+                Activity:    activityToProto(o.Activity),
+            })
+        }
+        for _, o := range p.EventHandlers.OnAlarms {
+            process.EventHandlers.OnAlarms = append(process.EventHandlers.OnAlarms, &api.OnAlarm{
+                For:      o.For,
+                Until:    o.Until,
+                Activity: activityToProto(o.Activity),
+            })
+        }
+    }
+    process.Activity = activityToProto(Activity{Sequence: &p.Sequence})
+}
+
+// FromProto builds the activity model from the structured fields of process.
+func FromProto(process *api.Process) (*BPELProcess, error) {
+    root, err := activityFromProto(process.Activity)
+    if err != nil {
+        return nil, err
+    }
+    p := &BPELProcess{Name: process.Name, TargetNS: process.TargetNamespace}
+    if root.Sequence != nil {
+        p.Sequence = *root.Sequence
+    } else {
+        // The engine runs a sequence at the top level
+        p.Sequence.Activities = []Activity{root}
+    }
+
+    for _, pl := range process.PartnerLinks {
+        p.PartnerLinks = append(p.PartnerLinks, PartnerLink{
+            Name:            pl.Name,
+            PartnerLinkType: pl.PartnerLinkType,
+            MyRole:          pl.MyRole,
+            PartnerRole:     pl.PartnerRole,
+        })
+    }
+    for _, v := range process.Variables {
+        p.Variables = append(p.Variables, Variable{Name: v.Name, MessageType: v.MessageType, Type: v.Type})
+    }
+    for _, cs := range process.CorrelationSets {
+        p.CorrelationSets = append(p.CorrelationSets, CorrelationSet{Name: cs.Name, Properties: cs.Properties})
+    }
+    if p.FaultHandlers, err = faultHandlersFromProto(process.FaultHandlers); err != nil {
+        return nil, fmt.Errorf("faultHandlers: %v", err)
+    }
+    if eh := process.EventHandlers; eh != nil {
+        p.EventHandlers = &EventHandlers{}
+        for _, o := range eh.OnEvents {
+            activity, err := activityFromProto(o.Activity)
+            if err != nil {
+                return nil, fmt.Errorf("onEvent %s: %v", o.Operation, err)
+            }
+            p.EventHandlers.OnEvents = append(p.EventHandlers.OnEvents, OnEvent{
+                PartnerLink: o.PartnerLink,
+                Operation:   o.Operation,
+                Variable:    o.Variable,
+                Activity:    activity,
+            })
+        }
+        for _, o := range eh.OnAlarms {
+            activity, err := activityFromProto(o.Activity)
+            if err != nil {
+                return nil, fmt.Errorf("onAlarm: %v", err)
+            }
+            p.EventHandlers.OnAlarms = append(p.EventHandlers.OnAlarms, OnAlarm{For: o.For, Until: o.Until, Activity: activity})
+        }
+    }
+    return p, nil
+}
+
+func activityToProto(a Activity) *api.Activity {
+    switch {
+    case a.Sequence != nil:
+        sequence := &api.Sequence{Name: a.Sequence.Name}
+        for _, child := range a.Sequence.Activities {
+            sequence.Activities = append(sequence.Activities, activityToProto(child))
+        }
+        return &api.Activity{Sequence: sequence}
+    case a.Receive != nil:
+        return &api.Activity{Receive: &api.Receive{
+            Name:           a.Receive.Name,
+            PartnerLink:    a.Receive.PartnerLink,
+            Operation:      a.Receive.Operation,
+            Variable:       a.Receive.Variable,
+            CreateInstance: a.Receive.CreateInstance == "yes",
+        }}
+    case a.Invoke != nil:
+        return &api.Activity{Invoke: invokeToProto(*a.Invoke)}
+    case a.Reply != nil:
+        return &api.Activity{Reply: &api.Reply{
+            Name:        a.Reply.Name,
+            PartnerLink: a.Reply.PartnerLink,
+            Operation:   a.Reply.Operation,
+            Variable:    a.Reply.Variable,
+        }}
+    case a.Assign != nil:
+        assign := &api.Assign{Name: a.Assign.Name}
+        for _, c := range a.Assign.Copies {
+            from := &api.From{Variable: c.From.Variable, Part: c.From.Part, Expression: c.From.Expression}
+            if c.From.Literal != nil {
+                from.Literal = *c.From.Literal
+            }
+            assign.Copies = append(assign.Copies, &api.Copy{
+                From: from,
+                To:   &api.To{Variable: c.To.Variable, Part: c.To.Part},
+            })
+        }
+        return &api.Activity{Assign: assign}
+    case a.If != nil:
+        f := &api.If{Name: a.If.Name, Condition: a.If.Condition, Activity: activityToProto(a.If.Activity)}
+        for _, branch := range a.If.ElseIfs {
+            f.ElseIfs = append(f.ElseIfs, &api.Branch{Condition: branch.Condition, Activity: activityToProto(branch.Activity)})
+        }
+        if a.If.Else != nil {
+            f.Else = &api.Branch{Activity: activityToProto(a.If.Else.Activity)}
+        }
+        return &api.Activity{If: f}
+    case a.Scope != nil:
+        return &api.Activity{Scope: &api.Scope{
+            Name:          a.Scope.Name,
+            FaultHandlers: faultHandlersToProto(a.Scope.FaultHandlers),
+            Activity:      activityToProto(a.Scope.Activity),
+        }}
+    case a.Empty != nil:
+        return &api.Activity{Empty: &api.Empty{Name: a.Empty.Name}}
+    }
+    return nil
+}
+
+func invokeToProto(invoke Invoke) *api.Invoke {
+    converted := &api.Invoke{
+        Name:           invoke.Name,
+        PartnerLink:    invoke.PartnerLink,
+        Operation:      invoke.Operation,
+        InputVariable:  invoke.InputVar,
+        OutputVariable: invoke.OutputVar,
+    }
+    for _, handler := range invoke.FaultHandlers {
+        converted.FaultHandlers = append(converted.FaultHandlers, invokeToProto(handler))
+    }
+    return converted
+}
+
+func faultHandlersToProto(handlers *FaultHandlers) *api.FaultHandlers {
+    if handlers == nil {
+        return nil
+    }
+    converted := &api.FaultHandlers{}
+    for _, c := range handlers.Catches {
+        converted.Catches = append(converted.Catches, &api.Catch{FaultName: c.FaultName, Activity: activityToProto(c.Activity)})
+    }
+    if handlers.CatchAll != nil {
+        converted.CatchAll = &api.Catch{Activity: activityToProto(handlers.CatchAll.Activity)}
+    }
+    return converted
+}
+
+func activityFromProto(a *api.Activity) (Activity, error) {
+    if a == nil {
+        return Activity{}, errors.New("activity is required")
+    }
+    set := 0
+    for _, field := range []bool{a.Sequence != nil, a.Receive != nil, a.Invoke != nil, a.Reply != nil,
+        a.Assign != nil, a.If != nil, a.Scope != nil, a.Empty != nil} {
+        if field {
+            set++
+        }
+    }
+    if set != 1 {
+        return Activity{}, fmt.Errorf("activity must set exactly one kind, got %d", set)
+    }
+
+    switch {
+    case a.Sequence != nil:
+        sequence := &Sequence{Name: a.Sequence.Name}
+        for i, child := range a.Sequence.Activities {
+            activity, err := activityFromProto(child)
+            if err != nil {
+                return Activity{}, fmt.Errorf("sequence %s activity %d: %v", a.Sequence.Name, i+1, err)
+            }
+            sequence.Activities = append(sequence.Activities, activity)
+        }
+        return Activity{Sequence: sequence}, nil
+    case a.Receive != nil:
+        receive := &Receive{
+            Name:        a.Receive.Name,
+            PartnerLink: a.Receive.PartnerLink,
+            Operation:   a.Receive.Operation,
+            Variable:    a.Receive.Variable,
+        }
+        if a.Receive.CreateInstance {
+            receive.CreateInstance = "yes"
+        }
+        return Activity{Receive: receive}, nil
+    case a.Invoke != nil:
+        invoke := invokeFromProto(a.Invoke)
+        return Activity{Invoke: &invoke}, nil
+    case a.Reply != nil:
+        return Activity{Reply: &Reply{
+            Name:        a.Reply.Name,
+            PartnerLink: a.Reply.PartnerLink,
+            Operation:   a.Reply.Operation,
+            Variable:    a.Reply.Variable,
+        }}, nil
+    case a.Assign != nil:
+        assign := &Assign{Name: a.Assign.Name}
+        for _, c := range a.Assign.Copies {
+            if c.From == nil || c.To == nil {
+                return Activity{}, fmt.Errorf("assign %s: copy needs from and to", a.Assign.Name)
+            }
+            from := From{Variable: c.From.Variable, Part: c.From.Part, Expression: c.From.Expression}
+            if c.From.Literal != "" {
+                literal := c.From.Literal
+                from.Literal = &literal
+            }
+            assign.Copies = append(assign.Copies, Copy{From: from, To: To{Variable: c.To.Variable, Part: c.To.Part}})
+        }
+        return Activity{Assign: assign}, nil
+    case a.If != nil:
+        activity, err := activityFromProto(a.If.Activity)
+        if err != nil {
+            return Activity{}, fmt.Errorf("if %s: %v", a.If.Name, err)
+        }
+        f := &If{Name: a.If.Name, Condition: a.If.Condition, Activity: activity}
+        for _, branch := range a.If.ElseIfs {
+            activity, err := activityFromProto(branch.Activity)
+            if err != nil {
+                return Activity{}, fmt.Errorf("if %s elseif: %v", a.If.Name, err)
+            }
+            f.ElseIfs = append(f.ElseIfs, Branch{Condition: branch.Condition, Activity: activity})
+        }
+        if a.If.Else != nil {
+            activity, err := activityFromProto(a.If.Else.Activity)
+            if err != nil {
+                return Activity{}, fmt.Errorf("if %s else: %v", a.If.Name, err)
+            }
+            f.Else = &Branch{Activity: activity}
+        }
+        return Activity{If: f}, nil
+    case a.Scope != nil:
+        activity, err := activityFromProto(a.Scope.Activity)
+        if err != nil {
+            return Activity{}, fmt.Errorf("scope %s: %v", a.Scope.Name, err)
+        }
+        handlers, err := faultHandlersFromProto(a.Scope.FaultHandlers)
+        if err != nil {
+            return Activity{}, fmt.Errorf("scope %s: %v", a.Scope.Name, err)
+        }
+        return Activity{Scope: &Scope{Name: a.Scope.Name, FaultHandlers: handlers, Activity: activity}}, nil
+    }
+    return Activity{Empty: &Empty{Name: a.Empty.Name}}, nil
+}
+
+func invokeFromProto(invoke *api.Invoke) Invoke {
+    converted := Invoke{
+        Name:        invoke.Name,
+        PartnerLink: invoke.PartnerLink,
+        Operation:   invoke.Operation,
+        InputVar:    invoke.InputVariable,
+        OutputVar:   invoke.OutputVariable,
+    }
+    for _, handler := range invoke.FaultHandlers {
+        converted.FaultHandlers = append(converted.FaultHandlers, invokeFromProto(handler))
+    }
+    return converted
+}
+
+func faultHandlersFromProto(handlers *api.FaultHandlers) (*FaultHandlers, error) {
+    if handlers == nil {
+        return nil, nil
+    }
+    converted := &FaultHandlers{}
+    for _, c := range handlers.Catches {
+        activity, err := activityFromProto(c.Activity)
+        if err != nil {
+            return nil, fmt.Errorf("catch %s: %v", c.FaultName, err)
+        }
+        converted.Catches = append(converted.Catches, Catch{FaultName: c.FaultName, Activity: activity})
+    }
+    if handlers.CatchAll != nil {
+        activity, err := activityFromProto(handlers.CatchAll.Activity)
+        if err != nil {
+            return nil, fmt.Errorf("catchAll: %v", err)
+        }
+        converted.CatchAll = &Catch{Activity: activity}
+    }
+    return converted, nil
+}
@@ -0,0 +1,146 @@
+package bpel
+
+import (
+    "strings"
+    "testing"
+
+    "gobpel/api"
+
+    "google.golang.org/protobuf/proto"
+)
+
+// structuredProcess sets every section and activity kind of the
+// structured model.
+func structuredProcess() *api.Process {
+    invoke := func(operation string) *api.Activity {
+        return &api.Activity{Invoke: &api.Invoke{PartnerLink: "trainer", Operation: operation, InputVariable: "dataset", OutputVariable: "model"}}
+    }
+    return &api.Process{
+        Name:            "structured",
+        TargetNamespace: "http://example.com/structured",
+        PartnerLinks:    []*api.PartnerLink{{Name: "trainer", PartnerLinkType: "tns:trainerLT", PartnerRole: "trainer"}},
+        Variables:       []*api.Variable{{Name: "dataset", MessageType: "tns:dataset"}, {Name: "model", Type: "xsd:string"}},
+        CorrelationSets: []*api.CorrelationSet{{Name: "run", Properties: "tns:runId"}},
+        FaultHandlers: &api.FaultHandlers{
+            Catches:  []*api.Catch{{FaultName: FaultInvocationFailure, Activity: invoke("logError")}},
+            CatchAll: &api.Catch{Activity: &api.Activity{Empty: &api.Empty{Name: "ignore"}}},
+        },
+        EventHandlers: &api.EventHandlers{
+            OnEvents: []*api.OnEvent{{PartnerLink: "trainer", Operation: "cancel", Variable: "reason", Activity: invoke("stop")}},
+            OnAlarms: []*api.OnAlarm{{For: "'PT1H'", Activity: invoke("timeout")}},
+        },
+        Activity: &api.Activity{Sequence: &api.Sequence{Name: "main", Activities: []*api.Activity{
+            {Receive: &api.Receive{Name: "start", PartnerLink: "client", Operation: "run", Variable: "dataset", CreateInstance: true}},
+            {Assign: &api.Assign{Name: "prepare", Copies: []*api.Copy{
+                {From: &api.From{Variable: "dataset", Part: "rows"}, To: &api.To{Variable: "model", Part: "input"}},
+                {From: &api.From{Literal: "10"}, To: &api.To{Variable: "epochs"}},
+                {From: &api.From{Expression: "$dataset.size * 2"}, To: &api.To{Variable: "batches"}},
+            }}},
+            {If: &api.If{
+                Name:      "large",
+                Condition: "$dataset.size > 1000",
+                Activity:  invoke("trainDistributed"),
+                ElseIfs:   []*api.Branch{{Condition: "$dataset.size > 0", Activity: invoke("trainModel")}},
+                Else:      &api.Branch{Activity: &api.Activity{Empty: &api.Empty{}}},
+            }},
+            {Scope: &api.Scope{
+                Name:          "deploy",
+                FaultHandlers: &api.FaultHandlers{CatchAll: &api.Catch{Activity: invoke("rollback")}},
+                Activity: &api.Activity{Invoke: &api.Invoke{
+                    Name:          "deployModel",
+                    PartnerLink:   "deployer",
+                    Operation:     "deploy",
+                    InputVariable: "model",
+                    FaultHandlers: []*api.Invoke{{PartnerLink: "log", Operation: "logError", InputVariable: "model"}},
+                }},
+            }},
+            {Reply: &api.Reply{Name: "done", PartnerLink: "client", Operation: "run", Variable: "model"}},
+        }}},
+    }
+}
+
+// structureOf keeps only the structured fields of process.
+func structureOf(process *api.Process) *api.Process {
+    return &api.Process{
+        PartnerLinks:    process.PartnerLinks,
+        Variables:       process.Variables,
+        CorrelationSets: process.CorrelationSets,
+        FaultHandlers:   process.FaultHandlers,
+        EventHandlers:   process.EventHandlers,
+        Activity:        process.Activity,
+    }
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+    process := structuredProcess()
+    if err := prepareDefinition(process); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(process.BpelDefinition, `<if name="large">`) {
+        t.Fatalf("got bpelDefinition %s", process.BpelDefinition)
+    }
+
+    // The XML converts back to the same structure
+    parsed, err := ParseBPEL(process.BpelDefinition)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if parsed.Name != "structured" || parsed.TargetNS != "http://example.com/structured" {
+        t.Errorf("got process %s in %s", parsed.Name, parsed.TargetNS)
+    }
+    converted := &api.Process{}
+    parsed.ToProto(converted)
+    if want := structureOf(structuredProcess()); !proto.Equal(structureOf(converted), want) {
+        t.Errorf("got %v\nwant %v", structureOf(converted), want)
+    }
+}
+
+func TestPrepareDefinition(t *testing.T) {
+    const definition = `<process name="written" xmlns="` + BPELNamespace + `">
+  <sequence>
+    <invoke partnerLink="trainer" operation="trainModel" inputVariable="dataset"/>
+  </sequence>
+</process>`
+
+    // XML is kept as written and fills the structure
+    process := &api.Process{Name: "written", BpelDefinition: definition}
+    if err := prepareDefinition(process); err != nil {
+        t.Fatal(err)
+    }
+    if process.BpelDefinition != definition {
+        t.Errorf("got bpelDefinition %s, want it as written", process.BpelDefinition)
+    }
+    activities := process.Activity.GetSequence().GetActivities()
+    if len(activities) != 1 || activities[0].GetInvoke().GetOperation() != "trainModel" {
+        t.Errorf("got activity %v", process.Activity)
+    }
+
+    // The structure wins over XML sent with it
+    process = structuredProcess()
+    process.BpelDefinition = definition
+    if err := prepareDefinition(process); err != nil {
+        t.Fatal(err)
+    }
+    if process.BpelDefinition == definition || !strings.Contains(process.BpelDefinition, "trainDistributed") {
+        t.Errorf("got bpelDefinition %s, want the structure exported", process.BpelDefinition)
+    }
+
+    tests := []struct {
+        name     string
+        activity *api.Activity
+        err      string
+    }{
+        {"no kind", &api.Activity{}, "activity must set exactly one kind, got 0"},
+        {"two kinds", &api.Activity{Empty: &api.Empty{}, Invoke: &api.Invoke{}}, "got 2"},
+        {"nested", &api.Activity{Sequence: &api.Sequence{Name: "main", Activities: []*api.Activity{{Empty: &api.Empty{}}, {}}}}, "sequence main activity 2"},
+        {"copy", &api.Activity{Assign: &api.Assign{Name: "prepare", Copies: []*api.Copy{{To: &api.To{Variable: "x"}}}}}, "assign prepare: copy needs from and to"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            err := prepareDefinition(&api.Process{Name: "invalid", Activity: test.activity})
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
@@ -0,0 +1,124 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+)
+
+var errSuspended = errors.New("instance suspended for shutdown")
+
+// drainPollInterval is how often Drain checks for running instances.
+const drainPollInterval = 100 * time.Millisecond
+
+// Drain prepares this replica to stop. New executions are refused and queued
+// instances are handed to other replicas. Running instances finish the
+// activities in progress and stop at the next checkpoint, between two
+// activities of a sequence, to be taken over by another replica. Instances
+// still in an activity when ctx is done are interrupted and taken over too.
+func (s *Server) Drain(ctx context.Context) error {
+    s.mu.Lock()
+    s.draining = true
+    publications := make([]context.CancelFunc, 0, len(s.publications))
+    for _, cancel := range s.publications {
+        publications = append(publications, cancel)
+    }
+    s.mu.Unlock()
+    for _, cancel := range publications {
+        cancel()
+    }
+
+    // Runs submitted from now on, and those still being stored, are
+    // suspended by submit
+    s.pool.mu.Lock()
+    s.pool.draining = true
+    var queued, storing []*queuedRun
+    for _, run := range s.pool.queue {
+        if run.stored {
+            queued = append(queued, run)
+        } else {
+            storing = append(storing, run)
+        }
+    }
+    s.pool.queue = storing
+    s.pool.mu.Unlock()
+    for _, run := range queued {
+        s.suspend(run.inst)
+    }
+
+    err := s.awaitIdle(ctx)
+    if err != nil {
+        s.mu.Lock()
+        var running []*instance
+        for _, inst := range s.instances {
+            if inst.state == InstanceRunning && !inst.fenced {
+                inst.suspended = true
+                running = append(running, inst)
+            }
+        }
+        s.mu.Unlock()
+        log.Printf("Grace period over, interrupting %d instances", len(running))
+        for _, inst := range running {
+            inst.cancel()
+        }
+        // Interrupted invokes return at once
+        waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        s.awaitIdle(waitCtx)
+    }
+    return err
+}
+
+func (s *Server) isDraining() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.draining
+}
+
+func (s *Server) isSuspended(inst *instance) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return inst.suspended
+}
+
+// awaitIdle waits until no instance runs on a worker of this replica.
+func (s *Server) awaitIdle(ctx context.Context) error {
+    ticker := time.NewTicker(drainPollInterval)
+    defer ticker.Stop()
+    for {
+        s.pool.mu.Lock()
+        running := s.pool.running
+        s.pool.mu.Unlock()
+        if running == 0 {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+    }
+}
+
+// suspend stops an instance without finishing it and releases its lease so
+// another replica takes it over at once.
+func (s *Server) suspend(inst *instance) {
+    s.record(inst, &api.InstanceEvent{Type: EventInstanceSuspended})
+    if err := db.ReleaseInstanceLease(inst.id, inst.fencingToken); err != nil {
+        log.Printf("Error releasing lease of instance %s: %v", inst.id, err)
+    }
+
+    s.mu.Lock()
+    inst.fenced = true
+    delete(s.instances, inst.id)
+    s.mu.Unlock()
+    if inst.cancel != nil {
+        inst.cancel()
+    }
+    close(inst.done)
+    endInstanceSpan(inst, "suspended")
+}
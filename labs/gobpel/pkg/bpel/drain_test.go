@@ -0,0 +1,124 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+)
+
+func TestDrainResume(t *testing.T) {
+    tenant := testTenant(t)
+    invoked := make(chan struct{}, 1)
+    release := make(chan struct{})
+    a := testServer(t, tenant, "a", partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+        invoked <- struct{}{}
+        <-release
+        return payload, nil
+    }))
+    a.SetWorkerPoolSize(1)
+    b := testServer(t, tenant, "b", echoPartner())
+    // A second step gives the running instance a checkpoint after its invoke
+    process, err := a.CreateProcess(withTenant(context.Background(), tenant), &api.Process{
+        Name:             "drain",
+        DefinitionFormat: FormatYAML,
+        Definition: `name: drain
+inputs: [request]
+steps:
+  - id: call
+    service: partner
+    operation: run
+    input: request
+    output: response
+  - id: again
+    service: partner
+    operation: run
+    input: response
+    output: result
+outputs:
+  result: result
+`,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    running, err := a.runProcess(context.Background(), process, nil, map[string]interface{}{"x": 1.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+    await(t, invoked, "the invoke of the running instance")
+    queued, err := a.runProcess(context.Background(), process, nil, map[string]interface{}{"x": 2.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    drained := make(chan error, 1)
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        drained <- a.Drain(ctx)
+    }()
+    await(t, queued.done, "the queued instance to be handed over")
+
+    a.pool.mu.Lock()
+    busy := a.pool.running
+    a.pool.mu.Unlock()
+    if busy != 1 {
+        t.Errorf("%d instances run on the draining replica, want the one already running", busy)
+    }
+
+    close(release)
+    if err := await(t, drained, "Drain"); err != nil {
+        t.Fatalf("drain: %v", err)
+    }
+    // The running instance stops at the checkpoint after its invoke
+    awaitHistory(t, tenant, running.id, EventInstanceSuspended)
+
+    b.takeOverExpired()
+    for _, inst := range []*instance{running, queued} {
+        awaitHistory(t, tenant, inst.id, EventInstanceCompleted)
+        record, err := db.GetInstance(tenant, inst.id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if record.State != InstanceCompleted || record.Owner != b.replicaId {
+            t.Errorf("instance %s: got %s owned by %s", inst.id, record.State, record.Owner)
+        }
+    }
+}
+
+func TestSubmitWhileDraining(t *testing.T) {
+    tenant := testTenant(t)
+    a, b := testServer(t, tenant, "a", echoPartner()), testServer(t, tenant, "b", echoPartner())
+    process := createTestProcess(t, a, tenant, "late")
+
+    // The execution got past the draining check before Drain began and
+    // reaches the pool after it
+    a.pool.mu.Lock()
+    a.pool.draining = true
+    a.pool.mu.Unlock()
+    late, err := a.runProcess(context.Background(), process, nil, map[string]interface{}{"x": 1.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+    await(t, late.done, "the instance to be handed over")
+    a.pool.mu.Lock()
+    busy, queued := a.pool.running, len(a.pool.queue)
+    a.pool.mu.Unlock()
+    if busy != 0 || queued != 0 {
+        t.Errorf("got %d running and %d queued on the draining replica", busy, queued)
+    }
+
+    b.takeOverExpired()
+    awaitHistory(t, tenant, late.id, EventInstanceCompleted)
+    record, err := db.GetInstance(tenant, late.id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if record.State != InstanceCompleted || record.Owner != b.replicaId {
+        t.Errorf("got %s owned by %s", record.State, record.Owner)
+    }
+}
@@ -0,0 +1,255 @@
+package bpel
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/metrics"
+)
+
+// Standard faults raised by the engine. Catch handlers select them by name.
+const (
+    FaultInvocationFailure = "invocationFailure"
+    FaultCircuitOpen       = "circuitOpen"
+    FaultSelectionFailure  = "bpel:selectionFailure"
+    FaultInvalidExpression = "bpel:invalidExpressionValue"
+)
+
+// Fault is a named BPEL fault raised by an activity.
+type Fault struct {
+    Name string
+    Err  error
+}
+
+func (f *Fault) Error() string {
+    return f.Name + ": " + f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+    return f.Err
+}
+
+func faultName(err error) string {
+    var fault *Fault
+    if errors.As(err, &fault) {
+        return fault.Name
+    }
+    return ""
+}
+
+// runActivity executes an activity of the tree and the activities nested in
+// it, each in its own span.
+func (s *Server) runActivity(ctx context.Context, inst *instance, a Activity) error {
+    start := time.Now()
+    ctx, span := startActivitySpan(ctx, a)
+    err := s.execActivity(ctx, inst, a)
+    endSpan(span, err)
+    metrics.ActivityDuration.WithLabelValues(inst.process(), a.Kind()).Observe(time.Since(start).Seconds())
+    return err
+}
+
+func (s *Server) execActivity(ctx context.Context, inst *instance, a Activity) error {
+    switch {
+    case a.Sequence != nil:
+        for _, child := range a.Sequence.Activities {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            // Between two activities an instance may stop for a shutdown
+            if s.isDraining() {
+                return errSuspended
+            }
+            if err := s.runActivity(ctx, inst, child); err != nil {
+                return err
+            }
+        }
+        return nil
+    case a.Invoke != nil:
+        err := s.invokeActivity(ctx, inst, "invoke", *a.Invoke)
+        if err != nil && len(a.Invoke.FaultHandlers) > 0 && !errors.Is(err, errSuspended) {
+            for _, handler := range a.Invoke.FaultHandlers {
+                s.invokeActivity(ctx, inst, "faultHandler", handler)
+            }
+        }
+        return err
+    case a.Reply != nil:
+        if value, ok := inst.variable(a.Reply.Variable); ok {
+            inst.mu.Lock()
+            inst.reply = value
+            inst.mu.Unlock()
+        }
+        // Replies are delivered on a best effort basis and never fault the instance
+        s.invokeActivity(ctx, inst, "reply", Invoke{
+            Name:        a.Reply.Name,
+            PartnerLink: a.Reply.PartnerLink,
+            Operation:   a.Reply.Operation,
+            InputVar:    a.Reply.Variable,
+        })
+        return nil
+    }
+
+    kind := a.Kind()
+    name := activityName(a)
+    s.record(inst, activityEvent(EventActivityStarted, kind, name))
+    var err error
+    switch {
+    case a.Assign != nil:
+        err = s.assign(inst, a.Assign)
+    case a.If != nil:
+        err = s.runIf(ctx, inst, a.If)
+    case a.Scope != nil:
+        err = s.runActivity(ctx, inst, a.Scope.Activity)
+        if err != nil {
+            err = s.handleFault(ctx, inst, a.Scope.FaultHandlers, err)
+        }
+    }
+    if errors.Is(err, errSuspended) {
+        // The activity is still in progress for the replica taking over
+        return err
+    }
+    if err != nil {
+        event := activityEvent(EventFault, kind, name)
+        event.Fault = err.Error()
+        event.FaultName = faultName(err)
+        s.record(inst, event)
+        return err
+    }
+    s.record(inst, activityEvent(EventActivityCompleted, kind, name))
+    return nil
+}
+
+func (s *Server) runIf(ctx context.Context, inst *instance, f *If) error {
+    branches := append([]Branch{{Condition: f.Condition, Activity: f.Activity}}, f.ElseIfs...)
+    for _, branch := range branches {
+        ok, err := evalCondition(branch.Condition, inst.variable)
+        if err != nil {
+            return &Fault{Name: FaultInvalidExpression, Err: err}
+        }
+        if ok {
+            return s.runActivity(ctx, inst, branch.Activity)
+        }
+    }
+    if f.Else != nil {
+        return s.runActivity(ctx, inst, f.Else.Activity)
+    }
+    return nil
+}
+
+func (s *Server) assign(inst *instance, assign *Assign) error {
+    for _, c := range assign.Copies {
+        value, err := inst.evalFrom(c.From)
+        if err != nil {
+            return err
+        }
+        if c.To.Part == "" {
+            inst.setVariable(c.To.Variable, value)
+        } else {
+            message, _ := inst.variable(c.To.Variable)
+            parts, ok := message.(map[string]interface{})
+            if !ok {
+                parts = make(map[string]interface{})
+            }
+            parts[c.To.Part] = value
+            inst.setVariable(c.To.Variable, parts)
+        }
+
+        event := activityEvent(EventVariableUpdated, "assign", assign.Name)
+        event.Variable = c.To.Variable
+        s.record(inst, event)
+    }
+    return nil
+}
+
+// evalFrom resolves the source of a copy.
+func (inst *instance) evalFrom(from From) (interface{}, error) {
+    switch {
+    case from.Variable != "":
+        value, ok := inst.variable(from.Variable)
+        if !ok {
+            return nil, &Fault{Name: FaultSelectionFailure, Err: fmt.Errorf("variable %q is not set", from.Variable)}
+        }
+        if from.Part != "" {
+            value = selectField(value, from.Part)
+        }
+        return value, nil
+    case from.Literal != nil:
+        return decodeLiteral(*from.Literal), nil
+    }
+    value, err := evalExpression(strings.TrimSpace(from.Expression), inst.variable)
+    if err != nil {
+        return nil, &Fault{Name: FaultInvalidExpression, Err: err}
+    }
+    return value, nil
+}
+
+// invokePayload is the message sent for an invoke: the JSON value of its input
+// variable. Variables that were never set are sent by name, as they were
+// before the engine kept variables.
+func (inst *instance) invokePayload(invoke Invoke) ([]byte, error) {
+    if value, ok := inst.variable(invoke.InputVar); ok {
+        return json.Marshal(value)
+    }
+    return json.Marshal(map[string]string{"input": invoke.InputVar})
+}
+
+// decodeMessage turns a partner response into a variable value, keeping
+// responses that are not JSON as strings.
+func decodeMessage(data []byte) interface{} {
+    var value interface{}
+    if err := json.Unmarshal(data, &value); err != nil {
+        return string(data)
+    }
+    return value
+}
+
+// decodeLiteral reads a literal as JSON, falling back to plain text.
+func decodeLiteral(literal string) interface{} {
+    var value interface{}
+    if err := json.Unmarshal([]byte(literal), &value); err != nil {
+        return literal
+    }
+    return value
+}
+
+func activityName(a Activity) string {
+    switch {
+    case a.Sequence != nil:
+        return a.Sequence.Name
+    case a.Receive != nil:
+        return a.Receive.Name
+    case a.Invoke != nil:
+        return a.Invoke.Name
+    case a.Reply != nil:
+        return a.Reply.Name
+    case a.Assign != nil:
+        return a.Assign.Name
+    case a.If != nil:
+        return a.If.Name
+    case a.Scope != nil:
+        return a.Scope.Name
+    case a.Empty != nil:
+        return a.Empty.Name
+    }
+    return ""
+}
+
+func activityEvent(eventType, kind, name string) *api.InstanceEvent {
+    return &api.InstanceEvent{
+        Type:         eventType,
+        Activity:     kind,
+        ActivityName: name,
+    }
+}
+
+// invokeEvent describes an invoke-style activity for the journal.
+func invokeEvent(eventType, activity string, invoke Invoke) *api.InstanceEvent {
+    event := activityEvent(eventType, activity, invoke.Name)
+    event.PartnerLink = invoke.PartnerLink
+    event.Operation = invoke.Operation
+    return event
+}
@@ -0,0 +1,94 @@
+package bpel
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "sync/atomic"
+    "time"
+)
+
+// EventSink receives engine events such as processExecuted.
+type EventSink interface {
+    Emit(ctx context.Context, eventType string, data []byte) error
+}
+
+type processEvent struct {
+    Tenant     string `json:"tenant"`
+    ProcessId  string `json:"processId"`
+    InstanceId string `json:"instanceId,omitempty"`
+    Status     string `json:"status"`
+}
+
+func (s *Server) AddEventSink(sink EventSink) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.sinks = append(s.sinks, sink)
+}
+
+// outboxSize is how many events may wait for delivery before emitting blocks.
+const outboxSize = 1024
+
+type outboxEvent struct {
+    tenant    string
+    eventType string
+    data      []byte
+}
+
+// emit queues an event of tenant in the outbox, from which it is delivered
+// to the URLs the tenant registered through Subscribe and to every
+// configured event sink.
+func (s *Server) emit(tenant, eventType string, event interface{}) {
+    data, err := json.Marshal(event)
+    if err != nil {
+        log.Printf("Error encoding %s event: %v", eventType, err)
+        return
+    }
+    atomic.AddInt64(&s.pendingEvents, 1)
+    s.outbox <- outboxEvent{tenant: tenant, eventType: eventType, data: data}
+}
+
+// deliverEvents delivers the events of the outbox in the order they were emitted.
+func (s *Server) deliverEvents() {
+    for event := range s.outbox {
+        s.deliver(withTenant(context.Background(), event.tenant), event.eventType, event.data)
+        atomic.AddInt64(&s.pendingEvents, -1)
+    }
+}
+
+func (s *Server) deliver(ctx context.Context, eventType string, data []byte) {
+    s.mu.Lock()
+    notifyURLs := append([]string(nil), s.subscribers[qualifiedName(tenantOf(ctx), eventType)]...)
+    sinks := append([]EventSink(nil), s.sinks...)
+    s.mu.Unlock()
+
+    for _, notifyURL := range notifyURLs {
+        resp, err := http.Post(notifyURL, "application/json", bytes.NewBuffer(data))
+        if err != nil {
+            log.Printf("Error notifying %s: %v", notifyURL, err)
+            continue
+        }
+        resp.Body.Close()
+    }
+    for _, sink := range sinks {
+        if err := sink.Emit(ctx, eventType, data); err != nil {
+            log.Printf("Error emitting %s event: %v", eventType, err)
+        }
+    }
+}
+
+// FlushEvents waits until every emitted event has been delivered or ctx is done.
+func (s *Server) FlushEvents(ctx context.Context) error {
+    ticker := time.NewTicker(50 * time.Millisecond)
+    defer ticker.Stop()
+    for atomic.LoadInt64(&s.pendingEvents) > 0 {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+    }
+    return nil
+}
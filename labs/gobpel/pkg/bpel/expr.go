@@ -0,0 +1,444 @@
+package bpel
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// Expressions used in conditions and assign copies are a small language over
+// instance variables:
+//
+//     $evaluationMetrics.accuracy >= 0.9 && $deploymentStatus.state != "failed"
+//
+// Variables are referenced by name, optionally prefixed with "$", and their
+// JSON fields and array elements are selected with ".field" and "[index]".
+// Numbers, strings in single or double quotes, true, false and null are
+// literals. The operators are ! && || (or "not", "and", "or"), == != < <= > >=,
+// + - * / and parentheses.
+
+type lookupFunc func(name string) (interface{}, bool)
+
+// evalExpression evaluates expr against the variables returned by lookup.
+func evalExpression(expr string, lookup lookupFunc) (interface{}, error) {
+    tokens, err := tokenize(expr)
+    if err != nil {
+        return nil, err
+    }
+    p := &exprParser{tokens: tokens, lookup: lookup}
+    value, err := p.or()
+    if err != nil {
+        return nil, err
+    }
+    if p.pos < len(p.tokens) {
+        return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos].text)
+    }
+    return value, nil
+}
+
+// evalCondition evaluates expr and requires a boolean result.
+func evalCondition(expr string, lookup lookupFunc) (bool, error) {
+    value, err := evalExpression(expr, lookup)
+    if err != nil {
+        return false, err
+    }
+    result, ok := value.(bool)
+    if !ok {
+        return false, fmt.Errorf("condition %q is not a boolean", expr)
+    }
+    return result, nil
+}
+
+const (
+    tokenNumber = iota
+    tokenString
+    tokenIdent
+    tokenOperator
+)
+
+type token struct {
+    kind int
+    text string
+}
+
+func tokenize(expr string) ([]token, error) {
+    var tokens []token
+    runes := []rune(expr)
+    for i := 0; i < len(runes); {
+        r := runes[i]
+        switch {
+        case unicode.IsSpace(r):
+            i++
+        case unicode.IsDigit(r):
+            start := i
+            for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+                i++
+            }
+            tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+        case r == '"' || r == '\'':
+            var sb strings.Builder
+            i++
+            for i < len(runes) && runes[i] != r {
+                if runes[i] == '\\' && i+1 < len(runes) {
+                    i++
+                }
+                sb.WriteRune(runes[i])
+                i++
+            }
+            if i >= len(runes) {
+                return nil, fmt.Errorf("unterminated string in expression %q", expr)
+            }
+            i++
+            tokens = append(tokens, token{tokenString, sb.String()})
+        case r == '$' || r == '_' || unicode.IsLetter(r):
+            start := i
+            i++
+            for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+                i++
+            }
+            tokens = append(tokens, token{tokenIdent, strings.TrimPrefix(string(runes[start:i]), "$")})
+        default:
+            two := ""
+            if i+1 < len(runes) {
+                two = string(runes[i : i+2])
+            }
+            switch two {
+            case "==", "!=", "<=", ">=", "&&", "||":
+                tokens = append(tokens, token{tokenOperator, two})
+                i += 2
+                continue
+            }
+            if !strings.ContainsRune("!<>+-*/().[]", r) {
+                return nil, fmt.Errorf("unexpected %q in expression %q", r, expr)
+            }
+            tokens = append(tokens, token{tokenOperator, string(r)})
+            i++
+        }
+    }
+    return tokens, nil
+}
+
+type exprParser struct {
+    tokens []token
+    pos    int
+    lookup lookupFunc
+}
+
+func (p *exprParser) peek(ops ...string) string {
+    if p.pos >= len(p.tokens) {
+        return ""
+    }
+    t := p.tokens[p.pos]
+    for _, op := range ops {
+        if (t.kind == tokenOperator || t.kind == tokenIdent) && t.text == op {
+            return op
+        }
+    }
+    return ""
+}
+
+func (p *exprParser) expect(op string) error {
+    if p.peek(op) == "" {
+        return fmt.Errorf("expected %q in expression", op)
+    }
+    p.pos++
+    return nil
+}
+
+func (p *exprParser) or() (interface{}, error) {
+    left, err := p.and()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek("||", "or") != "" {
+        p.pos++
+        right, err := p.and()
+        if err != nil {
+            return nil, err
+        }
+        left, err = logical(left, right, func(a, b bool) bool { return a || b })
+        if err != nil {
+            return nil, err
+        }
+    }
+    return left, nil
+}
+
+func (p *exprParser) and() (interface{}, error) {
+    left, err := p.not()
+    if err != nil {
+        return nil, err
+    }
+    for p.peek("&&", "and") != "" {
+        p.pos++
+        right, err := p.not()
+        if err != nil {
+            return nil, err
+        }
+        left, err = logical(left, right, func(a, b bool) bool { return a && b })
+        if err != nil {
+            return nil, err
+        }
+    }
+    return left, nil
+}
+
+func (p *exprParser) not() (interface{}, error) {
+    if p.peek("!", "not") != "" {
+        p.pos++
+        value, err := p.not()
+        if err != nil {
+            return nil, err
+        }
+        b, ok := value.(bool)
+        if !ok {
+            return nil, fmt.Errorf("cannot negate %v", value)
+        }
+        return !b, nil
+    }
+    return p.comparison()
+}
+
+func (p *exprParser) comparison() (interface{}, error) {
+    left, err := p.additive()
+    if err != nil {
+        return nil, err
+    }
+    op := p.peek("==", "!=", "<", "<=", ">", ">=")
+    if op == "" {
+        return left, nil
+    }
+    p.pos++
+    right, err := p.additive()
+    if err != nil {
+        return nil, err
+    }
+    return compare(op, left, right)
+}
+
+func (p *exprParser) additive() (interface{}, error) {
+    left, err := p.multiplicative()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        op := p.peek("+", "-")
+        if op == "" {
+            return left, nil
+        }
+        p.pos++
+        right, err := p.multiplicative()
+        if err != nil {
+            return nil, err
+        }
+        if ls, ok := left.(string); ok && op == "+" {
+            left = ls + fmt.Sprint(right)
+            continue
+        }
+        left, err = arithmetic(op, left, right)
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+func (p *exprParser) multiplicative() (interface{}, error) {
+    left, err := p.unary()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        op := p.peek("*", "/")
+        if op == "" {
+            return left, nil
+        }
+        p.pos++
+        right, err := p.unary()
+        if err != nil {
+            return nil, err
+        }
+        left, err = arithmetic(op, left, right)
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+func (p *exprParser) unary() (interface{}, error) {
+    if p.peek("-") != "" {
+        p.pos++
+        value, err := p.unary()
+        if err != nil {
+            return nil, err
+        }
+        return arithmetic("-", 0.0, value)
+    }
+    return p.primary()
+}
+
+func (p *exprParser) primary() (interface{}, error) {
+    if p.pos >= len(p.tokens) {
+        return nil, fmt.Errorf("unexpected end of expression")
+    }
+    t := p.tokens[p.pos]
+    p.pos++
+    switch t.kind {
+    case tokenNumber:
+        return strconv.ParseFloat(t.text, 64)
+    case tokenString:
+        return t.text, nil
+    case tokenIdent:
+        switch t.text {
+        case "true":
+            return true, nil
+        case "false":
+            return false, nil
+        case "null":
+            return nil, nil
+        }
+        return p.path(t.text)
+    }
+    if t.text == "(" {
+        value, err := p.or()
+        if err != nil {
+            return nil, err
+        }
+        return value, p.expect(")")
+    }
+    return nil, fmt.Errorf("unexpected %q in expression", t.text)
+}
+
+func (p *exprParser) path(name string) (interface{}, error) {
+    value, ok := p.lookup(name)
+    if !ok {
+        return nil, fmt.Errorf("variable %q is not set", name)
+    }
+    for {
+        switch p.peek(".", "[") {
+        case ".":
+            p.pos++
+            if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenIdent {
+                return nil, fmt.Errorf("expected field name after %q", name)
+            }
+            field := p.tokens[p.pos].text
+            p.pos++
+            value = selectField(value, field)
+        case "[":
+            p.pos++
+            index, err := p.or()
+            if err != nil {
+                return nil, err
+            }
+            if err := p.expect("]"); err != nil {
+                return nil, err
+            }
+            value = selectIndex(value, index)
+        default:
+            return value, nil
+        }
+    }
+}
+
+func selectField(value interface{}, field string) interface{} {
+    if m, ok := value.(map[string]interface{}); ok {
+        return m[field]
+    }
+    return nil
+}
+
+func selectIndex(value, index interface{}) interface{} {
+    switch v := value.(type) {
+    case []interface{}:
+        if i, ok := index.(float64); ok && int(i) >= 0 && int(i) < len(v) {
+            return v[int(i)]
+        }
+    case map[string]interface{}:
+        if key, ok := index.(string); ok {
+            return v[key]
+        }
+    }
+    return nil
+}
+
+func logical(left, right interface{}, op func(a, b bool) bool) (interface{}, error) {
+    a, ok := left.(bool)
+    b, ok2 := right.(bool)
+    if !ok || !ok2 {
+        return nil, fmt.Errorf("logical operands must be booleans, got %v and %v", left, right)
+    }
+    return op(a, b), nil
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+    a, ok := toNumber(left)
+    b, ok2 := toNumber(right)
+    if !ok || !ok2 {
+        return nil, fmt.Errorf("cannot apply %s to %v and %v", op, left, right)
+    }
+    switch op {
+    case "+":
+        return a + b, nil
+    case "-":
+        return a - b, nil
+    case "*":
+        return a * b, nil
+    }
+    if b == 0 {
+        return nil, fmt.Errorf("division by zero")
+    }
+    return a / b, nil
+}
+
+func compare(op string, left, right interface{}) (interface{}, error) {
+    if a, ok := toNumber(left); ok {
+        if b, ok := toNumber(right); ok {
+            switch op {
+            case "==":
+                return a == b, nil
+            case "!=":
+                return a != b, nil
+            case "<":
+                return a < b, nil
+            case "<=":
+                return a <= b, nil
+            case ">":
+                return a > b, nil
+            }
+            return a >= b, nil
+        }
+    }
+    switch op {
+    case "==":
+        return reflect.DeepEqual(left, right), nil
+    case "!=":
+        return !reflect.DeepEqual(left, right), nil
+    }
+    a, ok := left.(string)
+    b, ok2 := right.(string)
+    if !ok || !ok2 {
+        return nil, fmt.Errorf("cannot compare %v %s %v", left, op, right)
+    }
+    switch op {
+    case "<":
+        return a < b, nil
+    case "<=":
+        return a <= b, nil
+    case ">":
+        return a > b, nil
+    }
+    return a >= b, nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case float64:
+        return v, true
+    case int:
+        return float64(v), true
+    case int64:
+        return float64(v), true
+    }
+    return 0, false
+}
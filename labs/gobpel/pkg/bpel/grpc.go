@@ -0,0 +1,120 @@
+package bpel
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "sync"
+
+    "gobpel/pkg/secrets"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/metadata"
+)
+
+// grpcTransport invokes partners as the unary gRPC method
+// /<service>/<operation>, with the payload and response encoded as JSON.
+type grpcTransport struct {
+    conn    *grpc.ClientConn
+    service string
+    headers map[string]string
+    secrets secrets.Provider
+    mu      sync.Mutex
+    calls   int
+    closed  bool
+}
+
+// NewGRPCTransport invokes the gRPC service at target, over TLS when
+// tlsConfig is set. Headers are sent as request metadata, with references
+// to secrets resolved from provider on every invoke.
+func NewGRPCTransport(target, service string, headers map[string]string, provider secrets.Provider, tlsConfig *tls.Config) (Transport, error) {
+    creds := insecure.NewCredentials()
+    if tlsConfig != nil {
+        creds = credentials.NewTLS(tlsConfig)
+    }
+    conn, err := grpc.NewClient(target,
+        grpc.WithTransportCredentials(creds),
+        grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &grpcTransport{conn: conn, service: service, headers: headers, secrets: provider}, nil
+}
+
+func (t *grpcTransport) Call(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+    t.mu.Lock()
+    if t.closed {
+        t.mu.Unlock()
+        return nil, errors.New("transport is closed")
+    }
+    t.calls++
+    t.mu.Unlock()
+    defer t.done()
+
+    callCtx := outgoingTraceContext(ctx)
+    for name, value := range t.headers {
+        value, err := secrets.Expand(ctx, t.secrets, value)
+        if err != nil {
+            return nil, fmt.Errorf("header %s: %v", name, err)
+        }
+        callCtx = metadata.AppendToOutgoingContext(callCtx, name, value)
+    }
+    var resp []byte
+    if err := t.conn.Invoke(callCtx, "/"+t.service+"/"+invoke.Operation, payload, &resp); err != nil {
+        return nil, err
+    }
+    return resp, nil
+}
+
+func (t *grpcTransport) done() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.calls--
+    if t.closed && t.calls == 0 {
+        t.conn.Close()
+    }
+}
+
+// Close closes the connection once the calls in flight are done.
+func (t *grpcTransport) Close() error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed {
+        return nil
+    }
+    t.closed = true
+    if t.calls == 0 {
+        return t.conn.Close()
+    }
+    return nil
+}
+
+// jsonCodec passes JSON payloads through unchanged.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+    switch v := v.(type) {
+    case []byte:
+        return v, nil
+    case *[]byte:
+        return *v, nil
+    }
+    return nil, fmt.Errorf("cannot encode %T as JSON payload", v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+    resp, ok := v.(*[]byte)
+    if !ok {
+        return fmt.Errorf("cannot decode JSON payload into %T", v)
+    }
+    *resp = append([]byte(nil), data...)
+    return nil
+}
+
+func (jsonCodec) Name() string {
+    return "json"
+}
@@ -0,0 +1,60 @@
+package bpel
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "strconv"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    defaultHistoryPageSize = 100
+    maxHistoryPageSize     = 1000
+)
+
+// digest identifies a payload in the history, e.g. to tell which dataset
+// version an instance trained on.
+func digest(payload []byte) string {
+    sum := sha256.Sum256(payload)
+    return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (s *Server) GetInstanceHistory(ctx context.Context, req *api.GetInstanceHistoryRequest) (*api.GetInstanceHistoryResponse, error) {
+    pageSize := int64(req.PageSize)
+    if pageSize <= 0 {
+        pageSize = defaultHistoryPageSize
+    }
+    if pageSize > maxHistoryPageSize {
+        pageSize = maxHistoryPageSize
+    }
+
+    // Page tokens are the sequence of the last event already returned
+    afterSequence := int64(0)
+    if req.PageToken != "" {
+        var err error
+        afterSequence, err = strconv.ParseInt(req.PageToken, 10, 64)
+        if err != nil {
+            return nil, status.Error(codes.InvalidArgument, "invalid pageToken")
+        }
+    }
+
+    events, err := db.GetInstanceHistory(tenantOf(ctx), req.InstanceId, afterSequence, pageSize)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    for i, event := range events {
+        events[i] = s.redactEvent(event)
+    }
+
+    resp := &api.GetInstanceHistoryResponse{Events: events}
+    if int64(len(events)) == pageSize {
+        resp.NextPageToken = strconv.FormatInt(events[len(events)-1].Sequence, 10)
+    }
+    return resp, nil
+}
@@ -0,0 +1,84 @@
+package bpel
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestInstanceHistory(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+        if bytes.Contains(payload, []byte("fail")) {
+            return nil, errors.New("partner failed")
+        }
+        return payload, nil
+    }))
+    ctx := withTenant(context.Background(), tenant)
+    createTestProcess(t, s, tenant, "history")
+
+    resp, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{ProcessId: "history", Input: testInput(t, "dataset-v3")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    awaitHistory(t, tenant, resp.InstanceId, EventInstanceCompleted)
+
+    // Pages follow each other without gaps
+    var events []*api.InstanceEvent
+    req := &api.GetInstanceHistoryRequest{InstanceId: resp.InstanceId, PageSize: 2}
+    for {
+        page, err := s.GetInstanceHistory(ctx, req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        events = append(events, page.Events...)
+        if page.NextPageToken == "" {
+            break
+        }
+        req.PageToken = page.NextPageToken
+    }
+    for i, event := range events {
+        if event.Sequence != int64(i+1) || event.InstanceId != resp.InstanceId {
+            t.Fatalf("event %d: got sequence %d of instance %s", i, event.Sequence, event.InstanceId)
+        }
+    }
+    if events[0].Type != EventInstanceStarted {
+        t.Errorf("history starts with %s", events[0].Type)
+    }
+    var invoke *api.InstanceEvent
+    for _, event := range events {
+        if event.Type == EventActivityCompleted && event.PartnerLink == "partner" {
+            invoke = event
+        }
+    }
+    if invoke == nil || invoke.RequestDigest == "" || invoke.RequestDigest != invoke.ResponseDigest ||
+        !bytes.Contains(invoke.RequestPayload, []byte("dataset-v3")) {
+        t.Errorf("got invoke event %v", invoke)
+    }
+
+    resp, err = s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{ProcessId: "history", Input: testInput(t, "fail")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    history := awaitHistory(t, tenant, resp.InstanceId, EventInstanceFaulted)
+    counts := countEvents(history)
+    if counts[EventFault+"/partner"] == 0 {
+        t.Errorf("got events %v, want a fault of the invoke", counts)
+    }
+    for _, event := range history {
+        if event.Type == EventFault && event.PartnerLink == "partner" && (event.FaultName != FaultInvocationFailure || event.Fault != "partner failed") {
+            t.Errorf("got fault %s: %s", event.FaultName, event.Fault)
+        }
+    }
+
+    _, err = s.GetInstanceHistory(ctx, &api.GetInstanceHistoryRequest{InstanceId: resp.InstanceId, PageToken: "next"})
+    if status.Code(err) != codes.InvalidArgument {
+        t.Errorf("got %v for an invalid page token, want InvalidArgument", err)
+    }
+}
@@ -0,0 +1,104 @@
+package bpel
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+    "gobpel/pkg/metrics"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+)
+
+// Requests made with the same idempotency key within the window are answered
+// from the first one.
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// A key is reserved for this long until the request has created something,
+// so that the key of a request lost with its replica can be used again.
+const pendingIdempotencyTTL = time.Minute
+
+const (
+    scopeCreateProcess  = "CreateProcess"
+    scopeExecuteProcess = "ExecuteProcess"
+)
+
+func (s *Server) SetIdempotencyWindow(window time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.idempotencyWindow = window
+}
+
+// reserveIdempotencyKey claims key of tenant for req. It returns the record
+// of the earlier request when the key has been used, and fails when that
+// request was different or has not got as far as creating anything yet.
+func (s *Server) reserveIdempotencyKey(tenant, scope, key string, req proto.Message) (*db.IdempotencyRecord, error) {
+    data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+    if err != nil {
+        return nil, err
+    }
+    record := &db.IdempotencyRecord{
+        Tenant:        tenant,
+        Scope:         scope,
+        Key:           key,
+        RequestDigest: digest(data),
+        ExpiresAt:     time.Now().Add(pendingIdempotencyTTL),
+    }
+    existing, err := db.ReserveIdempotencyKey(record)
+    if err != nil || existing == nil {
+        return nil, err
+    }
+    if existing.RequestDigest != record.RequestDigest {
+        return nil, status.Error(codes.InvalidArgument, "idempotency key was used for a different request")
+    }
+    metrics.Replays.WithLabelValues("request").Inc()
+    if existing.Resource == "" {
+        return nil, status.Error(codes.Aborted, "a request with this idempotency key is in progress")
+    }
+    return existing, nil
+}
+
+// completeIdempotencyKey records resource as created by the request holding
+// key and keeps the key for the idempotency window.
+func (s *Server) completeIdempotencyKey(tenant, scope, key, resource string) error {
+    s.mu.Lock()
+    window := s.idempotencyWindow
+    s.mu.Unlock()
+    err := db.SetIdempotencyResource(tenant, scope, key, resource, time.Now().Add(window))
+    if errors.Is(err, db.ErrNotFound) {
+        return status.Error(codes.Aborted, "the idempotency key expired before the request completed")
+    }
+    return storeError(err)
+}
+
+func (s *Server) releaseIdempotencyKey(tenant, scope, key string) {
+    if err := db.ReleaseIdempotencyKey(tenant, scope, key); err != nil {
+        log.Printf("Error releasing idempotency key %s: %v", key, err)
+    }
+}
+
+// uniqueBusinessKey joins the business keys a process requires to be unique
+// among its active instances, e.g. "farm=42,season=2026".
+func uniqueBusinessKey(process *api.Process, businessKeys map[string]string) (string, error) {
+    if len(process.UniqueBusinessKeys) == 0 {
+        return "", nil
+    }
+    names := append([]string(nil), process.UniqueBusinessKeys...)
+    sort.Strings(names)
+    parts := make([]string, 0, len(names))
+    for _, name := range names {
+        value, ok := businessKeys[name]
+        if !ok {
+            return "", status.Errorf(codes.InvalidArgument, "business key %q is required", name)
+        }
+        parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+    }
+    return strings.Join(parts, ","), nil
+}
@@ -0,0 +1,137 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+func echoPartner() Transport {
+    return partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+        return payload, nil
+    })
+}
+
+func testInput(t *testing.T, request string) *structpb.Struct {
+    t.Helper()
+    input, err := structpb.NewStruct(map[string]interface{}{"request": request})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return input
+}
+
+func TestIdempotentExecute(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    process := createTestProcess(t, s, tenant, "replay")
+
+    req := &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "first"), IdempotencyKey: "replay"}
+    first, err := s.ExecuteProcess(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.State != InstanceCompleted {
+        t.Fatalf("got state %s", first.State)
+    }
+    retry, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "first"), IdempotencyKey: "replay"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if retry.InstanceId != first.InstanceId {
+        t.Errorf("retry started instance %s, want %s", retry.InstanceId, first.InstanceId)
+    }
+
+    other := &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "second"), IdempotencyKey: "replay"}
+    if _, err := s.ExecuteProcess(ctx, other); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("got %v for a different request, want InvalidArgument", err)
+    }
+
+    // Creating a process again with its key returns it
+    if _, err := s.CreateProcess(ctx, &api.Process{Name: "created", DefinitionFormat: FormatYAML, Definition: "name: created" + testWorkflow, IdempotencyKey: "create"}); err != nil {
+        t.Fatal(err)
+    }
+    again, err := s.CreateProcess(ctx, &api.Process{Name: "created", DefinitionFormat: FormatYAML, Definition: "name: created" + testWorkflow, IdempotencyKey: "create"})
+    if err != nil {
+        t.Fatalf("retrying CreateProcess: %v", err)
+    }
+    if again.Name != "created" {
+        t.Errorf("got process %s", again.Name)
+    }
+}
+
+func TestIdempotencyPending(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    process := createTestProcess(t, s, tenant, "pending")
+
+    // Another request holds the key and has not started its instance yet
+    fingerprint := &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x")}
+    if _, err := s.reserveIdempotencyKey(tenant, scopeExecuteProcess, "pending", fingerprint); err != nil {
+        t.Fatal(err)
+    }
+    req := &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x"), IdempotencyKey: "pending"}
+    if _, err := s.ExecuteProcess(ctx, req); status.Code(err) != codes.Aborted {
+        t.Fatalf("got %v while the key is reserved, want Aborted", err)
+    }
+
+    // The reservation of a request that never completed lapses
+    lapsed := &db.IdempotencyRecord{Tenant: tenant, Scope: scopeExecuteProcess, Key: "lapsed", RequestDigest: "other", ExpiresAt: time.Now().Add(-time.Second)}
+    if _, err := db.ReserveIdempotencyKey(lapsed); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.completeIdempotencyKey(tenant, scopeExecuteProcess, "lapsed", "instance"); status.Code(err) != codes.Aborted {
+        t.Errorf("got %v completing a lapsed key, want Aborted", err)
+    }
+    req = &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x"), IdempotencyKey: "lapsed"}
+    resp, err := s.ExecuteProcess(ctx, req)
+    if err != nil {
+        t.Fatalf("reusing a lapsed key: %v", err)
+    }
+    if resp.State != InstanceCompleted {
+        t.Errorf("got state %s", resp.State)
+    }
+    err = db.SetIdempotencyResource(tenant, scopeExecuteProcess, "lapsed", "again", time.Now().Add(time.Hour))
+    if !errors.Is(err, db.ErrNotFound) {
+        t.Errorf("got %v setting the resource twice, want ErrNotFound", err)
+    }
+}
+
+func TestIdempotencyAbort(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    process, err := s.CreateProcess(ctx, &api.Process{
+        Name:               "unique",
+        DefinitionFormat:   FormatYAML,
+        Definition:         "name: unique" + testWorkflow,
+        UniqueBusinessKeys: []string{"farm"},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // A failed request releases its key for the retry
+    req := &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x"), IdempotencyKey: "abort"}
+    if _, err := s.ExecuteProcess(ctx, req); status.Code(err) != codes.InvalidArgument {
+        t.Fatalf("got %v without business keys, want InvalidArgument", err)
+    }
+    req = &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x"), IdempotencyKey: "abort", BusinessKeys: map[string]string{"farm": "42"}}
+    resp, err := s.ExecuteProcess(ctx, req)
+    if err != nil {
+        t.Fatalf("retrying with the key of a failed request: %v", err)
+    }
+    if resp.State != InstanceCompleted {
+        t.Errorf("got state %s", resp.State)
+    }
+}
@@ -0,0 +1,298 @@
+package bpel
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+    "gobpel/pkg/metrics"
+
+    "go.opentelemetry.io/otel/trace"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/types/known/anypb"
+    "google.golang.org/protobuf/types/known/structpb"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+    InstanceQueued    = "queued"
+    InstanceRunning   = "running"
+    InstanceCompleted = "completed"
+    InstanceFaulted   = "faulted"
+    InstanceCancelled = "cancelled"
+)
+
+// InputVariable receives the input of ExecuteProcess when the process does
+// not start with a receive naming its own variable. OutputVariable is the
+// instance output unless a reply sent another variable.
+const (
+    InputVariable  = "input"
+    OutputVariable = "output"
+)
+
+const (
+    EventInstanceStarted   = "instanceStarted"
+    EventInstanceQueued    = "instanceQueued"
+    EventInstanceDequeued  = "instanceDequeued"
+    EventInstanceResumed   = "instanceResumed"
+    EventInstanceSuspended = "instanceSuspended"
+    EventActivityStarted   = "activityStarted"
+    EventActivityCompleted = "activityCompleted"
+    EventVariableUpdated   = "variableUpdated"
+    EventFault             = "fault"
+    EventInstanceCompleted = "instanceCompleted"
+    EventInstanceFaulted   = "instanceFaulted"
+    EventInstanceCancelled = "instanceCancelled"
+)
+
+// instance is a single execution of a process definition.
+type instance struct {
+    id             string
+    tenant         string
+    processId      string
+    processVersion string
+    businessKeys   map[string]string
+    state          string
+    startTime      time.Time
+    endTime        time.Time
+    output         *structpb.Struct
+    done           chan struct{}
+    cancel         context.CancelFunc
+    uniqueKey      string
+    inputVariable  string
+    input          []byte
+    owner          string
+    fencingToken   int64
+    fenced         bool
+    suspended      bool
+    span           trace.Span
+    traceParent    string
+
+    mu        sync.Mutex
+    variables map[string]interface{}
+    reply     interface{}
+    replay    []*api.InstanceEvent
+}
+
+// process is the qualified name of the process of the instance.
+func (inst *instance) process() string {
+    return qualifiedName(inst.tenant, inst.processId)
+}
+
+func newInstanceId() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// startInstance creates an instance owned by this replica with input bound
+// to variable, traced as a child of the span in ctx.
+func (s *Server) startInstance(ctx context.Context, id string, process *api.Process, businessKeys map[string]string, variable string, input interface{}) (*instance, error) {
+    inst := &instance{
+        id:             id,
+        tenant:         process.Tenant,
+        processId:      process.Name,
+        processVersion: process.Version,
+        businessKeys:   businessKeys,
+        state:          InstanceRunning,
+        startTime:      time.Now(),
+        done:           make(chan struct{}),
+        owner:          s.replicaId,
+        fencingToken:   1,
+        variables:      make(map[string]interface{}),
+    }
+    if input != nil {
+        data, err := json.Marshal(input)
+        if err != nil {
+            return nil, err
+        }
+        inst.inputVariable = variable
+        inst.input = data
+        inst.setVariable(variable, input)
+    }
+
+    traceInstance(ctx, inst, "")
+
+    // Stored before it is listed so heartbeats never find it missing
+    s.saveInstance(inst)
+    s.mu.Lock()
+    s.instances[inst.id] = inst
+    s.mu.Unlock()
+
+    s.record(inst, &api.InstanceEvent{Type: EventInstanceStarted})
+    if input != nil {
+        s.record(inst, &api.InstanceEvent{Type: EventVariableUpdated, Activity: "input", Variable: variable})
+    }
+    return inst, nil
+}
+
+func (s *Server) finishInstance(inst *instance, state string) {
+    if s.isFenced(inst) {
+        // The new owner finishes the instance
+        s.mu.Lock()
+        if s.instances[inst.id] == inst {
+            delete(s.instances, inst.id)
+        }
+        s.mu.Unlock()
+        close(inst.done)
+        endInstanceSpan(inst, "fenced")
+        return
+    }
+
+    output, err := inst.result()
+    if err != nil {
+        log.Printf("Error converting output of instance %s: %v", inst.id, err)
+    }
+
+    s.mu.Lock()
+    inst.state = state
+    inst.output = output
+    inst.endTime = time.Now()
+    s.mu.Unlock()
+    close(inst.done)
+
+    if inst.uniqueKey != "" {
+        if err := db.ReleaseBusinessKey(inst.tenant, inst.processId, inst.uniqueKey, inst.id); err != nil {
+            log.Printf("Error releasing business key of instance %s: %v", inst.id, err)
+        }
+    }
+
+    s.saveInstance(inst)
+    eventType := EventInstanceCompleted
+    switch state {
+    case InstanceFaulted:
+        eventType = EventInstanceFaulted
+    case InstanceCancelled:
+        eventType = EventInstanceCancelled
+    }
+    s.record(inst, &api.InstanceEvent{Type: eventType})
+    // Waiters hold the instance; later callers read it from the store
+    s.mu.Lock()
+    if s.instances[inst.id] == inst {
+        delete(s.instances, inst.id)
+    }
+    s.mu.Unlock()
+    metrics.InstancesFinished.WithLabelValues(inst.process(), state).Inc()
+    endInstanceSpan(inst, state)
+}
+
+// instanceResult returns the state and output of an instance.
+func (s *Server) instanceResult(inst *instance) (string, *structpb.Struct) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return inst.state, inst.output
+}
+
+// result is the output of the instance: the variable of its last reply or
+// else the output variable. Values that are not objects are wrapped as
+// {"value": ...}.
+func (inst *instance) result() (*structpb.Struct, error) {
+    inst.mu.Lock()
+    value := inst.reply
+    if value == nil {
+        value = inst.variables[OutputVariable]
+    }
+    inst.mu.Unlock()
+    if value == nil {
+        return nil, nil
+    }
+    fields, ok := value.(map[string]interface{})
+    if !ok {
+        fields = map[string]interface{}{"value": value}
+    }
+    return structpb.NewStruct(fields)
+}
+
+// anyInput decodes typed ExecuteProcess input into a variable value. Messages
+// of types unknown to the server are passed on as their type URL and bytes.
+func anyInput(input *anypb.Any) (interface{}, error) {
+    data, err := protojson.Marshal(input)
+    if err != nil {
+        return map[string]interface{}{
+            "@type": input.TypeUrl,
+            "value": base64.StdEncoding.EncodeToString(input.Value),
+        }, nil
+    }
+    return decodeMessage(data), nil
+}
+
+// variable returns the value of a variable and whether it has been set.
+func (inst *instance) variable(name string) (interface{}, bool) {
+    inst.mu.Lock()
+    defer inst.mu.Unlock()
+    value, ok := inst.variables[name]
+    return value, ok
+}
+
+func (inst *instance) setVariable(name string, value interface{}) {
+    inst.mu.Lock()
+    defer inst.mu.Unlock()
+    inst.variables[name] = value
+}
+
+func (s *Server) saveInstance(inst *instance) {
+    record := inst.toProto()
+    record.LeaseExpiresAt = timestamppb.New(time.Now().Add(instanceLeaseTTL))
+    err := db.SaveInstance(record)
+    if errors.Is(err, db.ErrFenced) {
+        s.fence(inst)
+        return
+    }
+    if err != nil {
+        log.Printf("Error saving instance %s: %v", inst.id, err)
+    }
+}
+
+func (inst *instance) toProto() *api.Instance {
+    record := &api.Instance{
+        InstanceId:     inst.id,
+        Tenant:         inst.tenant,
+        ProcessId:      inst.processId,
+        ProcessVersion: inst.processVersion,
+        State:          inst.state,
+        StartTime:      timestamppb.New(inst.startTime),
+        BusinessKeys:   inst.businessKeys,
+        Output:         inst.output,
+        Input:          inst.input,
+        InputVariable:  inst.inputVariable,
+        Owner:          inst.owner,
+        FencingToken:   inst.fencingToken,
+        TraceParent:    inst.traceParent,
+    }
+    if !inst.endTime.IsZero() {
+        record.EndTime = timestamppb.New(inst.endTime)
+    }
+    return record
+}
+
+// record stamps an event with its instance, appends it to the journal and
+// persists it in the instance history. Events a previous owner of the
+// instance already recorded are not recorded again while it is replayed.
+func (s *Server) record(inst *instance, event *api.InstanceEvent) {
+    if s.isFenced(inst) || inst.replayed(event) {
+        return
+    }
+    event.InstanceId = inst.id
+    event.Tenant = inst.tenant
+    event.ProcessId = inst.processId
+    event.Timestamp = timestamppb.Now()
+    event.TraceId = inst.traceId()
+    s.journal.append(event)
+
+    err := db.AppendInstanceEvent(event, inst.fencingToken)
+    if errors.Is(err, db.ErrFenced) {
+        s.fence(inst)
+        return
+    }
+    if err != nil {
+        log.Printf("Error saving history of instance %s: %v", inst.id, err)
+    }
+}
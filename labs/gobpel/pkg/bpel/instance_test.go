@@ -0,0 +1,56 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gobpel/api"
+)
+
+func TestFinishedInstanceEvicted(t *testing.T) {
+    tenant := testTenant(t)
+    release := make(chan struct{})
+    s := testServer(t, tenant, "a", partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+        <-release
+        return []byte(`{"ok":true}`), nil
+    }))
+    ctx := withTenant(context.Background(), tenant)
+    process := createTestProcess(t, s, tenant, "evict")
+
+    resp, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{ProcessId: process.Name, Input: testInput(t, "x"), Async: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.mu.Lock()
+    inst := s.instances[resp.InstanceId]
+    s.mu.Unlock()
+    if inst == nil {
+        t.Fatal("the running instance is not listed")
+    }
+
+    close(release)
+    await(t, inst.done, "the instance to finish")
+    deadline := time.Now().Add(10 * time.Second)
+    for {
+        s.mu.Lock()
+        _, listed := s.instances[inst.id]
+        s.mu.Unlock()
+        if !listed {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("the finished instance is still listed")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    status, err := s.GetProcessStatus(ctx, &api.GetProcessStatusRequest{ProcessId: process.Name, InstanceId: inst.id})
+    if err != nil {
+        t.Fatal(err)
+    }
+    result, _ := status.Output.AsMap()["result"].(map[string]interface{})
+    if status.State != InstanceCompleted || result["ok"] != true {
+        t.Errorf("got %s with output %v from the store", status.State, status.Output.AsMap())
+    }
+}
@@ -0,0 +1,93 @@
+package bpel
+
+import (
+    "context"
+    "strconv"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    defaultListPageSize = 50
+    maxListPageSize     = 500
+)
+
+// listPage turns a page size and offset page token into a limit and offset.
+func listPage(pageSize int32, pageToken string) (int64, int64, error) {
+    limit := int64(pageSize)
+    if limit <= 0 {
+        limit = defaultListPageSize
+    }
+    if limit > maxListPageSize {
+        limit = maxListPageSize
+    }
+
+    offset := int64(0)
+    if pageToken != "" {
+        var err error
+        offset, err = strconv.ParseInt(pageToken, 10, 64)
+        if err != nil || offset < 0 {
+            return 0, 0, status.Error(codes.InvalidArgument, "invalid pageToken")
+        }
+    }
+    return limit, offset, nil
+}
+
+// nextPageToken is empty when fewer than limit results came back.
+func nextPageToken(offset, limit int64, count int) string {
+    if int64(count) < limit {
+        return ""
+    }
+    return strconv.FormatInt(offset+limit, 10)
+}
+
+func (s *Server) ListInstances(ctx context.Context, req *api.ListInstancesRequest) (*api.ListInstancesResponse, error) {
+    limit, offset, err := listPage(req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+
+    filter := db.InstanceFilter{
+        Tenant:         tenantOf(ctx),
+        ProcessId:      req.ProcessId,
+        ProcessVersion: req.ProcessVersion,
+        State:          req.State,
+        BusinessKeys:   req.BusinessKeys,
+    }
+    if req.StartedAfter != nil {
+        filter.StartedAfter = req.StartedAfter.AsTime()
+    }
+    if req.StartedBefore != nil {
+        filter.StartedBefore = req.StartedBefore.AsTime()
+    }
+
+    instances, err := db.ListInstances(filter, req.OrderBy, req.Descending, offset, limit)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    return &api.ListInstancesResponse{
+        Instances:     instances,
+        NextPageToken: nextPageToken(offset, limit, len(instances)),
+    }, nil
+}
+
+func (s *Server) ListProcesses(ctx context.Context, req *api.ListProcessesRequest) (*api.ListProcessesResponse, error) {
+    limit, offset, err := listPage(req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+
+    processes, err := db.ListProcesses(tenantOf(ctx), req.Name, req.Version, req.OrderBy, req.Descending, offset, limit)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    withStructure(processes...)
+    return &api.ListProcessesResponse{
+        Processes:     processes,
+        NextPageToken: nextPageToken(offset, limit, len(processes)),
+    }, nil
+}
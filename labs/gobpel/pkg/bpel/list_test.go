@@ -0,0 +1,130 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestListInstances(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    createTestProcess(t, s, tenant, "alpha")
+    createTestProcess(t, s, tenant, "beta")
+    for i, run := range []struct{ process, key string }{{"alpha", "1"}, {"alpha", "2"}, {"alpha", "3"}, {"beta", "4"}} {
+        resp, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{
+            ProcessId:    run.process,
+            Input:        testInput(t, run.key),
+            BusinessKeys: map[string]string{"run": run.key},
+        })
+        if err != nil {
+            t.Fatalf("run %d: %v", i, err)
+        }
+        awaitHistory(t, tenant, resp.InstanceId, EventInstanceCompleted)
+    }
+
+    tests := []struct {
+        name  string
+        req   *api.ListInstancesRequest
+        count int
+        first string
+    }{
+        {"all", &api.ListInstancesRequest{}, 4, ""},
+        {"process", &api.ListInstancesRequest{ProcessId: "alpha"}, 3, "alpha"},
+        {"state", &api.ListInstancesRequest{State: InstanceCompleted}, 4, ""},
+        {"other state", &api.ListInstancesRequest{State: InstanceFaulted}, 0, ""},
+        {"business key", &api.ListInstancesRequest{BusinessKeys: map[string]string{"run": "4"}}, 1, "beta"},
+        {"started later", &api.ListInstancesRequest{StartedAfter: timestamppb.New(time.Now().Add(time.Hour))}, 0, ""},
+        {"started before", &api.ListInstancesRequest{StartedBefore: timestamppb.New(time.Now().Add(time.Hour))}, 4, ""},
+        {"order", &api.ListInstancesRequest{OrderBy: "processId", Descending: true}, 4, "beta"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            resp, err := s.ListInstances(ctx, test.req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(resp.Instances) != test.count {
+                t.Fatalf("got %d instances, want %d", len(resp.Instances), test.count)
+            }
+            if test.first != "" && resp.Instances[0].ProcessId != test.first {
+                t.Errorf("got %s first, want %s", resp.Instances[0].ProcessId, test.first)
+            }
+        })
+    }
+
+    // Pages of three cover every instance once
+    seen := make(map[string]bool)
+    req := &api.ListInstancesRequest{OrderBy: "processId", PageSize: 3}
+    for pages := 0; ; pages++ {
+        if pages > 2 {
+            t.Fatal("too many pages")
+        }
+        resp, err := s.ListInstances(ctx, req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, inst := range resp.Instances {
+            seen[inst.InstanceId] = true
+        }
+        if resp.NextPageToken == "" {
+            break
+        }
+        req.PageToken = resp.NextPageToken
+    }
+    if len(seen) != 4 {
+        t.Errorf("pages covered %d instances, want 4", len(seen))
+    }
+
+    for _, req := range []*api.ListInstancesRequest{{OrderBy: "owner"}, {PageToken: "-1"}} {
+        if _, err := s.ListInstances(ctx, req); status.Code(err) != codes.InvalidArgument {
+            t.Errorf("got %v for %v, want InvalidArgument", err, req)
+        }
+    }
+}
+
+func TestListProcesses(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    for _, name := range []string{"gamma", "alpha", "beta"} {
+        createTestProcess(t, s, tenant, name)
+    }
+
+    var names []string
+    req := &api.ListProcessesRequest{PageSize: 2}
+    for {
+        resp, err := s.ListProcesses(ctx, req)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, process := range resp.Processes {
+            names = append(names, process.Name)
+        }
+        if resp.NextPageToken == "" {
+            break
+        }
+        req.PageToken = resp.NextPageToken
+    }
+    if len(names) != 3 || names[0] != "alpha" || names[1] != "beta" || names[2] != "gamma" {
+        t.Errorf("got %v, want the processes by name", names)
+    }
+
+    resp, err := s.ListProcesses(ctx, &api.ListProcessesRequest{Name: "beta"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(resp.Processes) != 1 || resp.Processes[0].Name != "beta" || resp.NextPageToken != "" {
+        t.Errorf("got %v", resp)
+    }
+    if _, err := s.ListProcesses(ctx, &api.ListProcessesRequest{OrderBy: "owner"}); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("got %v for an unknown order, want InvalidArgument", err)
+    }
+}
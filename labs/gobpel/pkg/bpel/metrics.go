@@ -0,0 +1,97 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "sync/atomic"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    instancesDesc = prometheus.NewDesc("gobpel_instances",
+        "Queued and running instances of this replica by state.", []string{"state"}, nil)
+    queueDepthDesc = prometheus.NewDesc("gobpel_queue_depth",
+        "Instances of this replica waiting for a worker.", nil, nil)
+    workersDesc = prometheus.NewDesc("gobpel_workers",
+        "Workers of this replica by whether they run an instance.", []string{"state"}, nil)
+    outboxDesc = prometheus.NewDesc("gobpel_outbox_events",
+        "Engine events waiting for delivery.", nil, nil)
+    breakerDesc = prometheus.NewDesc("gobpel_circuit_breaker_state",
+        "State of the circuit breaker of each tenant and partner link, 1 for the current state.", []string{"tenant", "partner_link", "state"}, nil)
+)
+
+// Collector reports the state of the server, such as its instances and the
+// depth of its queue, when metrics are collected.
+func (s *Server) Collector() prometheus.Collector {
+    return serverCollector{s}
+}
+
+type serverCollector struct {
+    server *Server
+}
+
+func (c serverCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- instancesDesc
+    ch <- queueDepthDesc
+    ch <- workersDesc
+    ch <- outboxDesc
+    ch <- breakerDesc
+}
+
+func (c serverCollector) Collect(ch chan<- prometheus.Metric) {
+    s := c.server
+    states := make(map[string]int)
+    for _, state := range []string{InstanceQueued, InstanceRunning} {
+        states[state] = 0
+    }
+    s.mu.Lock()
+    for _, inst := range s.instances {
+        // Finished instances are evicted once they are stored
+        if _, ok := states[inst.state]; ok {
+            states[inst.state]++
+        }
+    }
+    breakers := make([]*circuitBreaker, 0, len(s.breakers))
+    for _, b := range s.breakers {
+        breakers = append(breakers, b)
+    }
+    s.mu.Unlock()
+    for state, n := range states {
+        ch <- prometheus.MustNewConstMetric(instancesDesc, prometheus.GaugeValue, float64(n), state)
+    }
+
+    stats := s.QueueStats()
+    ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(stats.Queued))
+    ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(stats.Running), "busy")
+    ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(stats.Workers-stats.Running), "idle")
+    ch <- prometheus.MustNewConstMetric(outboxDesc, prometheus.GaugeValue, float64(atomic.LoadInt64(&s.pendingEvents)))
+
+    for _, b := range breakers {
+        b.mu.Lock()
+        current := b.state
+        b.mu.Unlock()
+        for _, state := range []string{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+            value := 0.0
+            if state == current {
+                value = 1
+            }
+            ch <- prometheus.MustNewConstMetric(breakerDesc, prometheus.GaugeValue, value, b.tenant, b.partnerLink, state)
+        }
+    }
+}
+
+// partnerStatus labels the outcome of a partner call in metrics.
+func partnerStatus(err error) string {
+    switch {
+    case err == nil:
+        return "ok"
+    case errors.Is(err, errCircuitOpen):
+        return "circuitOpen"
+    case errors.Is(err, context.DeadlineExceeded):
+        return "timeout"
+    case errors.Is(err, context.Canceled):
+        return "cancelled"
+    }
+    return "error"
+}
@@ -0,0 +1,88 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// gather collects c and returns its samples as name{labels} => value.
+func gather(t *testing.T, c prometheus.Collector) map[string]float64 {
+    t.Helper()
+    registry := prometheus.NewPedanticRegistry()
+    if err := registry.Register(c); err != nil {
+        t.Fatal(err)
+    }
+    families, err := registry.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    samples := make(map[string]float64)
+    for _, family := range families {
+        for _, metric := range family.Metric {
+            var labels []string
+            for _, label := range metric.Label {
+                labels = append(labels, label.GetName()+"="+label.GetValue())
+            }
+            sort.Strings(labels)
+            samples[fmt.Sprintf("%s{%s}", family.GetName(), strings.Join(labels, ","))] = metric.GetGauge().GetValue()
+        }
+    }
+    return samples
+}
+
+func TestCollector(t *testing.T) {
+    s := NewServer()
+    s.SetWorkerPoolSize(4)
+    s.pool.running = 1
+    for id, state := range map[string]string{"i1": InstanceQueued, "i2": InstanceRunning, "i3": InstanceRunning, "i4": InstanceCompleted} {
+        s.instances[id] = &instance{id: id, tenant: "farm", processId: "train", state: state}
+    }
+    s.pool.queue = []*queuedRun{{inst: s.instances["i1"], stored: true}}
+    b := s.breakerFor("farm", "trainer")
+    b.state = BreakerOpen
+
+    samples := gather(t, s.Collector())
+    want := map[string]float64{
+        "gobpel_instances{state=queued}": 1,
+        "gobpel_instances{state=running}": 2,
+        "gobpel_queue_depth{}": 1,
+        "gobpel_workers{state=busy}": 1,
+        "gobpel_workers{state=idle}": 3,
+        "gobpel_outbox_events{}": 0,
+        "gobpel_circuit_breaker_state{partner_link=trainer,state=open,tenant=farm}": 1,
+        "gobpel_circuit_breaker_state{partner_link=trainer,state=closed,tenant=farm}": 0,
+        "gobpel_circuit_breaker_state{partner_link=trainer,state=halfOpen,tenant=farm}": 0,
+    }
+    for name, value := range want {
+        if got, ok := samples[name]; !ok || got != value {
+            t.Errorf("%s: got %v, want %v", name, got, value)
+        }
+    }
+    if len(samples) != len(want) {
+        t.Errorf("got samples %v, want %v", samples, want)
+    }
+}
+
+func TestPartnerStatus(t *testing.T) {
+    tests := []struct {
+        err  error
+        want string
+    }{
+        {nil, "ok"},
+        {fmt.Errorf("trainer: %w", errCircuitOpen), "circuitOpen"},
+        {context.DeadlineExceeded, "timeout"},
+        {context.Canceled, "cancelled"},
+        {errors.New("partner failed"), "error"},
+    }
+    for _, test := range tests {
+        if got := partnerStatus(test.err); got != test.want {
+            t.Errorf("%v: got %s, want %s", test.err, got, test.want)
+        }
+    }
+}
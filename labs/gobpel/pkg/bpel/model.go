@@ -0,0 +1,540 @@
+package bpel
+
+import (
+    "encoding/xml"
+    "strings"
+)
+
+const BPELNamespace = "http://docs.oasis-open.org/wsbpel/2.0/process/executable"
+
+type BPELProcess struct {
+    XMLName       xml.Name       `xml:"process"`
+    Name          string         `xml:"name,attr"`
+    TargetNS      string         `xml:"targetNamespace,attr"`
+    PartnerLinks    []PartnerLink    `xml:"partnerLinks>partnerLink"`
+    Variables       []Variable       `xml:"variables>variable"`
+    CorrelationSets []CorrelationSet `xml:"correlationSets>correlationSet"`
+    FaultHandlers   *FaultHandlers   `xml:"faultHandlers"`
+    EventHandlers   *EventHandlers   `xml:"eventHandlers"`
+    Sequence        Sequence         `xml:"sequence"`
+}
+
+type PartnerLink struct {
+    Name            string `xml:"name,attr"`
+    PartnerLinkType string `xml:"partnerLinkType,attr,omitempty"`
+    MyRole          string `xml:"myRole,attr,omitempty"`
+    PartnerRole     string `xml:"partnerRole,attr,omitempty"`
+}
+
+type Variable struct {
+    Name        string `xml:"name,attr"`
+    MessageType string `xml:"messageType,attr,omitempty"`
+    Type        string `xml:"type,attr,omitempty"`
+}
+
+type CorrelationSet struct {
+    Name       string `xml:"name,attr"`
+    Properties string `xml:"properties,attr,omitempty"`
+}
+
+// EventHandlers are kept in the model and exported, but not run by the engine.
+type EventHandlers struct {
+    OnEvents []OnEvent `xml:"onEvent"`
+    OnAlarms []OnAlarm `xml:"onAlarm"`
+}
+
+type OnEvent struct {
+    PartnerLink string
+    Operation   string
+    Variable    string
+    Activity    Activity
+}
+
+type OnAlarm struct {
+    For      string
+    Until    string
+    Activity Activity
+}
+
+// Activity is a node of the activity tree. Exactly one field is set.
+type Activity struct {
+    Sequence *Sequence
+    Receive  *Receive
+    Invoke   *Invoke
+    Reply    *Reply
+    Assign   *Assign
+    If       *If
+    Scope    *Scope
+    Empty    *Empty
+}
+
+type Sequence struct {
+    Name       string
+    Activities []Activity
+}
+
+type Receive struct {
+    Name           string `xml:"name,attr,omitempty"`
+    PartnerLink    string `xml:"partnerLink,attr"`
+    Operation      string `xml:"operation,attr"`
+    Variable       string `xml:"variable,attr,omitempty"`
+    CreateInstance string `xml:"createInstance,attr,omitempty"`
+}
+
+type Invoke struct {
+    Name          string   `xml:"name,attr,omitempty"`
+    PartnerLink   string   `xml:"partnerLink,attr"`
+    Operation     string   `xml:"operation,attr"`
+    InputVar      string   `xml:"inputVariable,attr,omitempty"`
+    OutputVar     string   `xml:"outputVariable,attr,omitempty"`
+    FaultHandlers []Invoke `xml:"faultHandlers>invoke"`
+}
+
+type Reply struct {
+    Name        string `xml:"name,attr,omitempty"`
+    PartnerLink string `xml:"partnerLink,attr"`
+    Operation   string `xml:"operation,attr"`
+    Variable    string `xml:"variable,attr,omitempty"`
+}
+
+type Assign struct {
+    Name   string `xml:"name,attr,omitempty"`
+    Copies []Copy `xml:"copy"`
+}
+
+type Copy struct {
+    From From `xml:"from"`
+    To   To   `xml:"to"`
+}
+
+// From is the source of a copy: a variable (part), a literal or an expression.
+type From struct {
+    Variable   string  `xml:"variable,attr,omitempty"`
+    Part       string  `xml:"part,attr,omitempty"`
+    Literal    *string `xml:"literal"`
+    Expression string  `xml:",chardata"`
+}
+
+type To struct {
+    Variable string `xml:"variable,attr"`
+    Part     string `xml:"part,attr,omitempty"`
+}
+
+type If struct {
+    Name      string
+    Condition string
+    Activity  Activity
+    ElseIfs   []Branch
+    Else      *Branch
+}
+
+// Branch is an elseif (with a condition) or else (without one) of an If.
+type Branch struct {
+    Condition string
+    Activity  Activity
+}
+
+type Scope struct {
+    Name          string
+    FaultHandlers *FaultHandlers
+    Activity      Activity
+}
+
+type Empty struct {
+    Name string `xml:"name,attr,omitempty"`
+}
+
+type FaultHandlers struct {
+    Catches  []Catch `xml:"catch"`
+    CatchAll *Catch  `xml:"catchAll"`
+}
+
+type Catch struct {
+    FaultName string
+    Activity  Activity
+}
+
+// InitialReceive returns the receive that starts the process, if any.
+func (p *BPELProcess) InitialReceive() *Receive {
+    if len(p.Sequence.Activities) == 0 {
+        return nil
+    }
+    receive := p.Sequence.Activities[0].Receive
+    if receive == nil || receive.CreateInstance != "yes" {
+        return nil
+    }
+    return receive
+}
+
+// processXML is the exported form of a process; absent sections are omitted.
+type processXML struct {
+    XMLName         xml.Name         `xml:"process"`
+    Xmlns           string           `xml:"xmlns,attr"`
+    Name            string           `xml:"name,attr"`
+    TargetNS        string           `xml:"targetNamespace,attr,omitempty"`
+    PartnerLinks    *partnerLinks    `xml:"partnerLinks"`
+    Variables       *variables       `xml:"variables"`
+    CorrelationSets *correlationSets `xml:"correlationSets"`
+    FaultHandlers   *FaultHandlers   `xml:"faultHandlers"`
+    EventHandlers   *EventHandlers   `xml:"eventHandlers"`
+    Sequence        Sequence         `xml:"sequence"`
+}
+
+type partnerLinks struct {
+    PartnerLinks []PartnerLink `xml:"partnerLink"`
+}
+
+type variables struct {
+    Variables []Variable `xml:"variable"`
+}
+
+type correlationSets struct {
+    CorrelationSets []CorrelationSet `xml:"correlationSet"`
+}
+
+// Export renders the process as BPEL XML.
+func (p *BPELProcess) Export() (string, error) {
+    exported := processXML{
+        Xmlns:         BPELNamespace,
+        Name:          p.Name,
+        TargetNS:      p.TargetNS,
+        FaultHandlers: p.FaultHandlers,
+        EventHandlers: p.EventHandlers,
+        Sequence:      p.Sequence,
+    }
+    if len(p.PartnerLinks) > 0 {
+        exported.PartnerLinks = &partnerLinks{p.PartnerLinks}
+    }
+    if len(p.Variables) > 0 {
+        exported.Variables = &variables{p.Variables}
+    }
+    if len(p.CorrelationSets) > 0 {
+        exported.CorrelationSets = &correlationSets{p.CorrelationSets}
+    }
+    out, err := xml.MarshalIndent(exported, "", "  ")
+    if err != nil {
+        return "", err
+    }
+    return string(out), nil
+}
+
+func (a Activity) element() (string, interface{}) {
+    switch {
+    case a.Sequence != nil:
+        return "sequence", a.Sequence
+    case a.Receive != nil:
+        return "receive", a.Receive
+    case a.Invoke != nil:
+        return "invoke", a.Invoke
+    case a.Reply != nil:
+        return "reply", a.Reply
+    case a.Assign != nil:
+        return "assign", a.Assign
+    case a.If != nil:
+        return "if", a.If
+    case a.Scope != nil:
+        return "scope", a.Scope
+    case a.Empty != nil:
+        return "empty", a.Empty
+    }
+    return "", nil
+}
+
+// Kind is the element name of the activity, e.g. "invoke".
+func (a Activity) Kind() string {
+    kind, _ := a.element()
+    return kind
+}
+
+func (a Activity) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    kind, value := a.element()
+    if value == nil {
+        return nil
+    }
+    return e.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: kind}})
+}
+
+// decodeActivity decodes se into an activity, or skips it and returns nil when
+// it is not a supported activity.
+func decodeActivity(d *xml.Decoder, se xml.StartElement) (*Activity, error) {
+    a := &Activity{}
+    var value interface{}
+    switch se.Name.Local {
+    case "sequence":
+        a.Sequence = &Sequence{}
+        value = a.Sequence
+    case "receive":
+        a.Receive = &Receive{}
+        value = a.Receive
+    case "invoke":
+        a.Invoke = &Invoke{}
+        value = a.Invoke
+    case "reply":
+        a.Reply = &Reply{}
+        value = a.Reply
+    case "assign":
+        a.Assign = &Assign{}
+        value = a.Assign
+    case "if":
+        a.If = &If{}
+        value = a.If
+    case "scope":
+        a.Scope = &Scope{}
+        value = a.Scope
+    case "empty":
+        a.Empty = &Empty{}
+        value = a.Empty
+    default:
+        return nil, d.Skip()
+    }
+    if err := d.DecodeElement(value, &se); err != nil {
+        return nil, err
+    }
+    return a, nil
+}
+
+// decodeChildren calls visit for every child element; visit must consume it.
+func decodeChildren(d *xml.Decoder, visit func(se xml.StartElement) error) error {
+    for {
+        tok, err := d.Token()
+        if err != nil {
+            return err
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            if err := visit(t); err != nil {
+                return err
+            }
+        case xml.EndElement:
+            return nil
+        }
+    }
+}
+
+// decodeSingleActivity visits children, keeping the first activity in a.
+func decodeSingleActivity(d *xml.Decoder, se xml.StartElement, a *Activity) error {
+    activity, err := decodeActivity(d, se)
+    if err != nil {
+        return err
+    }
+    if activity != nil && a.Kind() == "" {
+        *a = *activity
+    }
+    return nil
+}
+
+func attr(start xml.StartElement, name string) string {
+    for _, a := range start.Attr {
+        if a.Name.Local == name {
+            return a.Value
+        }
+    }
+    return ""
+}
+
+func nameAttr(start xml.StartElement, name string) xml.StartElement {
+    start.Attr = nil
+    if name != "" {
+        start.Attr = []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}}
+    }
+    return start
+}
+
+// element encodes value as a child element called name.
+type element struct {
+    name  string
+    value interface{}
+}
+
+func (el element) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    return e.EncodeElement(el.value, xml.StartElement{Name: xml.Name{Local: el.name}})
+}
+
+func encodeChildren(e *xml.Encoder, start xml.StartElement, children ...interface{}) error {
+    if err := e.EncodeToken(start); err != nil {
+        return err
+    }
+    for _, child := range children {
+        if err := e.Encode(child); err != nil {
+            return err
+        }
+    }
+    return e.EncodeToken(start.End())
+}
+
+func (q *Sequence) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    q.Name = attr(start, "name")
+    return decodeChildren(d, func(se xml.StartElement) error {
+        activity, err := decodeActivity(d, se)
+        if activity != nil {
+            q.Activities = append(q.Activities, *activity)
+        }
+        return err
+    })
+}
+
+func (q Sequence) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    children := make([]interface{}, len(q.Activities))
+    for i := range q.Activities {
+        children[i] = q.Activities[i]
+    }
+    return encodeChildren(e, nameAttr(start, q.Name), children...)
+}
+
+func (i Invoke) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    // Inline fault handlers are only written when there are any
+    type invoke Invoke
+    type faultHandlers struct {
+        Invokes []Invoke `xml:"invoke"`
+    }
+    exported := struct {
+        invoke
+        FaultHandlers *faultHandlers `xml:"faultHandlers"`
+    }{invoke: invoke(i)}
+    exported.invoke.FaultHandlers = nil
+    if len(i.FaultHandlers) > 0 {
+        exported.FaultHandlers = &faultHandlers{i.FaultHandlers}
+    }
+    return e.EncodeElement(exported, start)
+}
+
+func (f *From) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    type from From
+    if err := d.DecodeElement((*from)(f), &start); err != nil {
+        return err
+    }
+    f.Expression = strings.TrimSpace(f.Expression)
+    return nil
+}
+
+func (f *If) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    f.Name = attr(start, "name")
+    return decodeChildren(d, func(se xml.StartElement) error {
+        switch se.Name.Local {
+        case "condition":
+            return d.DecodeElement(&f.Condition, &se)
+        case "elseif":
+            var branch Branch
+            if err := d.DecodeElement(&branch, &se); err != nil {
+                return err
+            }
+            f.ElseIfs = append(f.ElseIfs, branch)
+            return nil
+        case "else":
+            f.Else = &Branch{}
+            return d.DecodeElement(f.Else, &se)
+        }
+        return decodeSingleActivity(d, se, &f.Activity)
+    })
+}
+
+func (f If) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    children := []interface{}{element{"condition", f.Condition}, f.Activity}
+    for _, branch := range f.ElseIfs {
+        children = append(children, element{"elseif", branch})
+    }
+    if f.Else != nil {
+        children = append(children, element{"else", *f.Else})
+    }
+    return encodeChildren(e, nameAttr(start, f.Name), children...)
+}
+
+func (b *Branch) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    return decodeChildren(d, func(se xml.StartElement) error {
+        if se.Name.Local == "condition" {
+            return d.DecodeElement(&b.Condition, &se)
+        }
+        return decodeSingleActivity(d, se, &b.Activity)
+    })
+}
+
+func (b Branch) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    start.Attr = nil
+    if b.Condition == "" {
+        return encodeChildren(e, start, b.Activity)
+    }
+    return encodeChildren(e, start, element{"condition", b.Condition}, b.Activity)
+}
+
+func (c *Scope) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    c.Name = attr(start, "name")
+    return decodeChildren(d, func(se xml.StartElement) error {
+        if se.Name.Local == "faultHandlers" {
+            c.FaultHandlers = &FaultHandlers{}
+            return d.DecodeElement(c.FaultHandlers, &se)
+        }
+        return decodeSingleActivity(d, se, &c.Activity)
+    })
+}
+
+func (c Scope) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    var children []interface{}
+    if c.FaultHandlers != nil {
+        children = append(children, element{"faultHandlers", c.FaultHandlers})
+    }
+    children = append(children, c.Activity)
+    return encodeChildren(e, nameAttr(start, c.Name), children...)
+}
+
+func (c *Catch) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    c.FaultName = attr(start, "faultName")
+    return decodeChildren(d, func(se xml.StartElement) error {
+        return decodeSingleActivity(d, se, &c.Activity)
+    })
+}
+
+func (c Catch) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    start.Attr = nil
+    if c.FaultName != "" {
+        start.Attr = []xml.Attr{{Name: xml.Name{Local: "faultName"}, Value: c.FaultName}}
+    }
+    return encodeChildren(e, start, c.Activity)
+}
+
+func (o *OnEvent) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    o.PartnerLink = attr(start, "partnerLink")
+    o.Operation = attr(start, "operation")
+    o.Variable = attr(start, "variable")
+    return decodeChildren(d, func(se xml.StartElement) error {
+        return decodeSingleActivity(d, se, &o.Activity)
+    })
+}
+
+func (o OnEvent) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    start.Attr = nil
+    for _, a := range []xml.Attr{
+        {Name: xml.Name{Local: "partnerLink"}, Value: o.PartnerLink},
+        {Name: xml.Name{Local: "operation"}, Value: o.Operation},
+        {Name: xml.Name{Local: "variable"}, Value: o.Variable},
+    } {
+        if a.Value != "" {
+            start.Attr = append(start.Attr, a)
+        }
+    }
+    return encodeChildren(e, start, o.Activity)
+}
+
+func (o *OnAlarm) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+    return decodeChildren(d, func(se xml.StartElement) error {
+        switch se.Name.Local {
+        case "for":
+            return d.DecodeElement(&o.For, &se)
+        case "until":
+            return d.DecodeElement(&o.Until, &se)
+        }
+        return decodeSingleActivity(d, se, &o.Activity)
+    })
+}
+
+func (o OnAlarm) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+    start.Attr = nil
+    var children []interface{}
+    if o.For != "" {
+        children = append(children, element{"for", o.For})
+    }
+    if o.Until != "" {
+        children = append(children, element{"until", o.Until})
+    }
+    children = append(children, o.Activity)
+    return encodeChildren(e, start, children...)
+}
@@ -0,0 +1,267 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+    "gobpel/pkg/metrics"
+)
+
+// Each active instance is owned by the replica running it through a lease on
+// its stored record. The owner renews its leases with every heartbeat; when
+// a replica dies its leases expire and other replicas take the instances
+// over. Taking over issues a new fencing token, and records and history
+// saved with an older token are rejected, so a replica that lost an instance
+// stops it. A replica never takes over an instance it still runs.
+//
+// A taken over instance runs again from the start with its stored input.
+// Invokes the previous owner completed, as recorded in the history, are
+// replayed from their recorded responses instead of being sent again.
+
+const (
+    instanceLeaseTTL  = 30 * time.Second
+    heartbeatInterval = 10 * time.Second
+    maxTakeovers      = 100
+)
+
+// RunOwnership renews the leases of the instances of this replica and takes
+// over instances whose owner stopped renewing them, until ctx is done.
+func (s *Server) RunOwnership(ctx context.Context) {
+    ticker := time.NewTicker(heartbeatInterval)
+    defer ticker.Stop()
+    for {
+        s.heartbeat()
+        s.takeOverExpired()
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// heartbeat renews the leases of this replica and stops the instances it no
+// longer owns.
+func (s *Server) heartbeat() {
+    // Listed before the store is read; instances are stored before they are listed
+    s.mu.Lock()
+    var active []*instance
+    var ids []string
+    for _, inst := range s.instances {
+        if inst.state == InstanceRunning || inst.state == InstanceQueued {
+            active = append(active, inst)
+            ids = append(ids, inst.id)
+        }
+    }
+    s.mu.Unlock()
+    if len(active) == 0 {
+        return
+    }
+
+    // Only instances running here are renewed, so those a previous process
+    // with the same replica id left behind are taken over once they expire
+    owned, err := db.RenewInstanceLeases(s.replicaId, ids, time.Now().Add(instanceLeaseTTL))
+    if err != nil {
+        log.Printf("Error renewing instance leases: %v", err)
+        return
+    }
+    for _, inst := range active {
+        if !owned[inst.id] {
+            s.fence(inst)
+        }
+    }
+}
+
+func (s *Server) takeOverExpired() {
+    if s.isDraining() {
+        return
+    }
+    expired, err := db.ExpiredInstances(time.Now(), maxTakeovers)
+    if err != nil {
+        log.Printf("Error loading expired instances: %v", err)
+        return
+    }
+    for _, record := range expired {
+        s.mu.Lock()
+        _, running := s.instances[record.InstanceId]
+        s.mu.Unlock()
+        if running {
+            // The lease of an instance running here lapsed; the next
+            // heartbeat renews it unless another replica took it over
+            continue
+        }
+        taken, err := db.TakeOverInstance(record, s.replicaId, time.Now().Add(instanceLeaseTTL))
+        if err != nil {
+            log.Printf("Error taking over instance %s: %v", record.InstanceId, err)
+            continue
+        }
+        if taken == nil {
+            continue
+        }
+        log.Printf("Replica %s took over instance %s from %s", s.replicaId, taken.InstanceId, record.Owner)
+        if err := s.resume(taken); err != nil {
+            log.Printf("Error resuming instance %s: %v", taken.InstanceId, err)
+        }
+    }
+}
+
+// resume runs an instance taken over from another replica.
+func (s *Server) resume(record *api.Instance) error {
+    process, err := db.GetProcess(record.Tenant, record.ProcessId)
+    if err != nil {
+        return err
+    }
+    bpelProcess, err := ParseBPEL(process.BpelDefinition)
+    if err != nil {
+        return err
+    }
+    history, err := instanceHistory(record.Tenant, record.InstanceId, 0)
+    if err != nil {
+        return err
+    }
+
+    inst := &instance{
+        id:             record.InstanceId,
+        tenant:         record.Tenant,
+        processId:      record.ProcessId,
+        processVersion: record.ProcessVersion,
+        businessKeys:   record.BusinessKeys,
+        state:          record.State,
+        startTime:      record.StartTime.AsTime(),
+        done:           make(chan struct{}),
+        inputVariable:  record.InputVariable,
+        input:          record.Input,
+        owner:          s.replicaId,
+        fencingToken:   record.FencingToken,
+        variables:      make(map[string]interface{}),
+    }
+    inst.uniqueKey, _ = uniqueBusinessKey(process, record.BusinessKeys)
+    if record.InputVariable != "" {
+        inst.setVariable(record.InputVariable, decodeMessage(record.Input))
+    }
+    for _, event := range history {
+        if !lifecycleEvent(event) {
+            inst.replay = append(inst.replay, event)
+        }
+    }
+    if len(history) > 0 {
+        // Events continue the sequence of the instance history
+        s.journal.advance(history[len(history)-1].Sequence)
+    }
+    traceInstance(context.Background(), inst, record.TraceParent)
+    ctx, cancel := inst.runContext()
+    inst.cancel = cancel
+
+    s.mu.Lock()
+    s.instances[inst.id] = inst
+    s.mu.Unlock()
+    s.record(inst, &api.InstanceEvent{Type: EventInstanceResumed})
+    metrics.Replays.WithLabelValues("takeover").Inc()
+
+    run := &queuedRun{ctx: ctx, inst: inst, process: bpelProcess, limit: int(process.MaxConcurrentInstances)}
+    if record.State == InstanceQueued {
+        s.requeue(run)
+    } else {
+        s.submit(run)
+    }
+    return nil
+}
+
+// instanceHistory returns the stored events of an instance after
+// afterSequence.
+func instanceHistory(tenant, instanceId string, afterSequence int64) ([]*api.InstanceEvent, error) {
+    var history []*api.InstanceEvent
+    for {
+        events, err := db.GetInstanceHistory(tenant, instanceId, afterSequence, maxHistoryPageSize)
+        if err != nil {
+            return nil, err
+        }
+        history = append(history, events...)
+        if len(events) < maxHistoryPageSize {
+            return history, nil
+        }
+        afterSequence = events[len(events)-1].Sequence
+    }
+}
+
+// lifecycleEvent reports whether event records a change of the instance
+// rather than a step of its execution, which replays reproduce.
+func lifecycleEvent(event *api.InstanceEvent) bool {
+    switch event.Type {
+    case EventInstanceStarted, EventInstanceQueued, EventInstanceDequeued, EventInstanceResumed, EventInstanceSuspended,
+        EventInstanceCompleted, EventInstanceFaulted, EventInstanceCancelled:
+        return true
+    }
+    return event.Type == EventVariableUpdated && event.Activity == "input"
+}
+
+// fence stops an instance another replica has taken over. It records and
+// stores nothing more.
+func (s *Server) fence(inst *instance) {
+    s.mu.Lock()
+    fenced := inst.fenced
+    inst.fenced = true
+    s.mu.Unlock()
+    if !fenced {
+        log.Printf("Instance %s is owned by another replica, stopping it", inst.id)
+        if inst.cancel != nil {
+            inst.cancel()
+        }
+    }
+}
+
+func (s *Server) isFenced(inst *instance) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return inst.fenced
+}
+
+// replayed reports whether event is the next event recorded by a previous
+// owner, consuming it. The replay ends at the first event that differs.
+func (inst *instance) replayed(event *api.InstanceEvent) bool {
+    if lifecycleEvent(event) {
+        return false
+    }
+    inst.mu.Lock()
+    defer inst.mu.Unlock()
+    if len(inst.replay) == 0 {
+        return false
+    }
+    next := inst.replay[0]
+    if next.Type != event.Type || next.Activity != event.Activity || next.ActivityName != event.ActivityName ||
+        next.PartnerLink != event.PartnerLink || next.Variable != event.Variable {
+        inst.replay = nil
+        return false
+    }
+    inst.replay = inst.replay[1:]
+    return true
+}
+
+// replayedInvoke returns the recorded outcome of an invoke when the next
+// replayed event is one.
+func (inst *instance) replayedInvoke(activity string, invoke Invoke) ([]byte, bool, error) {
+    inst.mu.Lock()
+    defer inst.mu.Unlock()
+    if len(inst.replay) == 0 {
+        return nil, false, nil
+    }
+    next := inst.replay[0]
+    if next.Activity != activity || next.ActivityName != invoke.Name || next.PartnerLink != invoke.PartnerLink {
+        return nil, false, nil
+    }
+    switch next.Type {
+    case EventActivityCompleted:
+        return next.ResponsePayload, true, nil
+    case EventFault:
+        name := next.FaultName
+        if name == "" {
+            name = FaultInvocationFailure
+        }
+        return nil, true, &Fault{Name: name, Err: errors.New(next.Fault)}
+    }
+    return nil, false, nil
+}
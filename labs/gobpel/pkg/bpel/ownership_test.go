@@ -0,0 +1,87 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+
+    "gobpel/pkg/db"
+)
+
+func TestTakeOver(t *testing.T) {
+    tenant := testTenant(t)
+    calls := make(chan string, 4)
+    release := make(chan struct{})
+    blocking := func(replica string) Transport {
+        return partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+            calls <- replica
+            select {
+            case <-release:
+                return []byte(`{"ok":true}`), nil
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            }
+        })
+    }
+    a, b := testServer(t, tenant, "a", blocking("a")), testServer(t, tenant, "b", blocking("b"))
+    process := createTestProcess(t, a, tenant, "takeover")
+
+    inst, err := a.runProcess(context.Background(), process, nil, map[string]interface{}{"x": 1.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if replica := await(t, calls, "the invoke of a"); replica != "a" {
+        t.Fatalf("invoked by %s", replica)
+    }
+
+    // The lease lapses while a still runs the instance
+    if err := db.ReleaseInstanceLease(inst.id, inst.fencingToken); err != nil {
+        t.Fatal(err)
+    }
+    a.takeOverExpired()
+    record, err := db.GetInstance(tenant, inst.id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if record.FencingToken != 1 {
+        t.Fatalf("a took over its own instance: fencing token %d", record.FencingToken)
+    }
+
+    b.takeOverExpired()
+    if replica := await(t, calls, "the invoke of b"); replica != "b" {
+        t.Fatalf("invoked by %s", replica)
+    }
+    b.mu.Lock()
+    resumed := b.instances[inst.id]
+    b.mu.Unlock()
+    if resumed == nil || resumed.fencingToken != 2 {
+        t.Fatalf("got %+v, want the instance taken over by b", resumed)
+    }
+
+    // a has not heard of the takeover when its invoke completes; its
+    // sequences are moved past those of b so that only fencing stops them
+    a.journal.advance(1000)
+    close(release)
+    await(t, inst.done, "a to stop the instance")
+    await(t, resumed.done, "b to finish the instance")
+    if !a.isFenced(inst) {
+        t.Error("a not fenced")
+    }
+    a.mu.Lock()
+    _, running := a.instances[inst.id]
+    a.mu.Unlock()
+    if running {
+        t.Error("a still lists the instance")
+    }
+
+    counts := countEvents(awaitHistory(t, tenant, inst.id, EventInstanceCompleted))
+    if counts[EventActivityStarted+"/partner"] != 1 || counts[EventActivityCompleted+"/partner"] != 1 || counts[EventInstanceCompleted] != 1 || counts[EventInstanceResumed] != 1 {
+        t.Errorf("got history %v, want the invoke of a replayed and completed by b only", counts)
+    }
+    record, err = db.GetInstance(tenant, inst.id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if record.State != InstanceCompleted || record.Owner != b.replicaId {
+        t.Errorf("got %s owned by %s", record.State, record.Owner)
+    }
+}
@@ -0,0 +1,268 @@
+package bpel
+
+import (
+    "context"
+    "log"
+    "sync"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+)
+
+// Instances run on a bounded pool of workers. An instance started while every
+// worker is busy, or while its process already runs maxConcurrentInstances,
+// waits in a FIFO queue. The queue is stored so depth is known across
+// replicas and queued instances survive a restart through takeover. Invokes
+// on a partner link with a concurrency limit wait for a free slot.
+
+const defaultWorkers = 64
+
+// queuedRun is an instance together with what it needs to run on a worker.
+// A queued run is only taken off the queue once it is stored as queued.
+type queuedRun struct {
+    ctx     context.Context
+    inst    *instance
+    process *BPELProcess
+    limit   int
+    stored  bool
+}
+
+type workerPool struct {
+    mu           sync.Mutex
+    workers      int
+    running      int
+    processes    map[string]int
+    queue        []*queuedRun
+    partnerLinks map[string]chan struct{}
+    draining     bool
+}
+
+func newWorkerPool() *workerPool {
+    return &workerPool{
+        workers:      defaultWorkers,
+        processes:    make(map[string]int),
+        partnerLinks: make(map[string]chan struct{}),
+    }
+}
+
+// QueueStats is the load of the worker pool of a replica. Processes are
+// keyed by their qualified name.
+type QueueStats struct {
+    Workers   int                     `json:"workers"`
+    Running   int                     `json:"running"`
+    Queued    int                     `json:"queued"`
+    Processes map[string]*ProcessLoad `json:"processes"`
+}
+
+type ProcessLoad struct {
+    Running int `json:"running"`
+    Queued  int `json:"queued"`
+}
+
+// SetWorkerPoolSize sets how many instances run at the same time.
+func (s *Server) SetWorkerPoolSize(workers int) {
+    s.pool.mu.Lock()
+    s.pool.workers = workers
+    runs := s.pool.next()
+    s.pool.mu.Unlock()
+    s.startQueued(runs)
+}
+
+// SetPartnerLinkConcurrency limits the invokes in flight on partnerLink
+// across all instances. A limit of zero removes the limit.
+func (s *Server) SetPartnerLinkConcurrency(partnerLink string, limit int) {
+    s.pool.mu.Lock()
+    defer s.pool.mu.Unlock()
+    if limit <= 0 {
+        delete(s.pool.partnerLinks, partnerLink)
+        return
+    }
+    s.pool.partnerLinks[partnerLink] = make(chan struct{}, limit)
+}
+
+// submit runs an instance on a worker, or queues it when no worker may take it.
+// Once the replica drains, runs are suspended for another replica instead.
+func (s *Server) submit(run *queuedRun) {
+    s.pool.mu.Lock()
+    if s.pool.draining {
+        s.pool.mu.Unlock()
+        s.suspend(run.inst)
+        return
+    }
+    if s.pool.available(run) {
+        s.pool.take(run)
+        s.pool.mu.Unlock()
+        go s.work(run)
+        return
+    }
+    // The run keeps its place in the queue while it is stored
+    s.pool.queue = append(s.pool.queue, run)
+    s.pool.mu.Unlock()
+
+    entry := &db.QueueEntry{
+        Tenant:     run.inst.tenant,
+        InstanceId: run.inst.id,
+        ProcessId:  run.inst.processId,
+        EnqueuedAt: time.Now(),
+    }
+    if err := db.EnqueueInstance(entry); err != nil {
+        log.Printf("Error queueing instance %s: %v", run.inst.id, err)
+    }
+    s.mu.Lock()
+    run.inst.state = InstanceQueued
+    s.mu.Unlock()
+    s.saveInstance(run.inst)
+    s.record(run.inst, &api.InstanceEvent{Type: EventInstanceQueued})
+
+    // A worker freed while the run was stored passed over it, and Drain
+    // left it to be suspended here
+    s.pool.mu.Lock()
+    run.stored = true
+    if s.pool.draining {
+        s.pool.remove(run)
+        s.pool.mu.Unlock()
+        s.suspend(run.inst)
+        return
+    }
+    runs := s.pool.next()
+    s.pool.mu.Unlock()
+    s.startQueued(runs)
+}
+
+// work executes an instance and hands its worker to the queue.
+func (s *Server) work(run *queuedRun) {
+    s.executeBPELProcess(run.ctx, run.inst, run.process)
+
+    s.pool.mu.Lock()
+    s.pool.release(run)
+    runs := s.pool.next()
+    s.pool.mu.Unlock()
+    s.startQueued(runs)
+}
+
+// startQueued runs instances taken off the queue.
+func (s *Server) startQueued(runs []*queuedRun) {
+    for _, run := range runs {
+        if err := db.DequeueInstance(run.inst.id); err != nil {
+            log.Printf("Error dequeueing instance %s: %v", run.inst.id, err)
+        }
+        s.mu.Lock()
+        run.inst.state = InstanceRunning
+        s.mu.Unlock()
+        s.saveInstance(run.inst)
+        s.record(run.inst, &api.InstanceEvent{Type: EventInstanceDequeued})
+        go s.work(run)
+    }
+}
+
+func (p *workerPool) available(run *queuedRun) bool {
+    if p.running >= p.workers {
+        return false
+    }
+    return run.limit <= 0 || p.processes[run.inst.process()] < run.limit
+}
+
+func (p *workerPool) take(run *queuedRun) {
+    p.running++
+    p.processes[run.inst.process()]++
+}
+
+func (p *workerPool) release(run *queuedRun) {
+    p.running--
+    p.processes[run.inst.process()]--
+    if p.processes[run.inst.process()] == 0 {
+        delete(p.processes, run.inst.process())
+    }
+}
+
+// remove takes run off the queue.
+func (p *workerPool) remove(run *queuedRun) {
+    for i, queued := range p.queue {
+        if queued == run {
+            p.queue = append(p.queue[:i], p.queue[i+1:]...)
+            return
+        }
+    }
+}
+
+// next takes the queued instances that may run now, oldest first.
+func (p *workerPool) next() []*queuedRun {
+    var runs []*queuedRun
+    queue := p.queue[:0]
+    for _, run := range p.queue {
+        if run.stored && p.available(run) {
+            p.take(run)
+            runs = append(runs, run)
+        } else {
+            queue = append(queue, run)
+        }
+    }
+    p.queue = queue
+    return runs
+}
+
+// acquirePartnerLink waits for a free slot on partnerLink and returns the
+// function giving it back.
+func (s *Server) acquirePartnerLink(ctx context.Context, partnerLink string) (func(), error) {
+    s.pool.mu.Lock()
+    slots, limited := s.pool.partnerLinks[partnerLink]
+    s.pool.mu.Unlock()
+    if !limited {
+        return func() {}, nil
+    }
+    select {
+    case slots <- struct{}{}:
+        return func() { <-slots }, nil
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+// queuePosition returns the 1-based position of inst in the queue, or 0 when
+// it is not queued.
+func (s *Server) queuePosition(inst *instance) int {
+    s.pool.mu.Lock()
+    defer s.pool.mu.Unlock()
+    for i, run := range s.pool.queue {
+        if run.inst == inst {
+            return i + 1
+        }
+    }
+    return 0
+}
+
+// QueueStats reports the running and queued instances of this replica.
+func (s *Server) QueueStats() QueueStats {
+    s.pool.mu.Lock()
+    defer s.pool.mu.Unlock()
+    stats := QueueStats{
+        Workers:   s.pool.workers,
+        Running:   s.pool.running,
+        Queued:    len(s.pool.queue),
+        Processes: make(map[string]*ProcessLoad),
+    }
+    for process, running := range s.pool.processes {
+        stats.Processes[process] = &ProcessLoad{Running: running}
+    }
+    for _, run := range s.pool.queue {
+        load, ok := stats.Processes[run.inst.process()]
+        if !ok {
+            load = &ProcessLoad{}
+            stats.Processes[run.inst.process()] = load
+        }
+        load.Queued++
+    }
+    return stats
+}
+
+// requeue queues an instance taken over while it was queued. Its queue entry
+// is already stored.
+func (s *Server) requeue(run *queuedRun) {
+    run.stored = true
+    s.pool.mu.Lock()
+    s.pool.queue = append(s.pool.queue, run)
+    runs := s.pool.next()
+    s.pool.mu.Unlock()
+    s.startQueued(runs)
+}
@@ -0,0 +1,117 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "gobpel/api"
+)
+
+func TestWorkerPoolLimits(t *testing.T) {
+    tenant := testTenant(t)
+    invoked := make(chan string, 10)
+    release := make(map[string]chan struct{})
+    for _, request := range []string{"limited-1", "limited-2", "free-1", "free-2"} {
+        release[request] = make(chan struct{})
+    }
+    s := testServer(t, tenant, "a", partnerFunc(func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+        request := strings.Trim(string(payload), `"`)
+        invoked <- request
+        <-release[request]
+        return payload, nil
+    }))
+    s.SetWorkerPoolSize(2)
+    ctx := withTenant(context.Background(), tenant)
+    _, err := s.CreateProcess(ctx, &api.Process{
+        Name:                   "limited",
+        DefinitionFormat:       FormatYAML,
+        Definition:             "name: limited" + testWorkflow,
+        MaxConcurrentInstances: 1,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    createTestProcess(t, s, tenant, "free")
+
+    // limited-2 waits for its process and free-2 for a worker
+    ids := make(map[string]string)
+    for _, request := range []string{"limited-1", "limited-2", "free-1", "free-2"} {
+        resp, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{
+            ProcessId: strings.Split(request, "-")[0],
+            Input:     testInput(t, request),
+            Async:     true,
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids[request] = resp.InstanceId
+    }
+    started := map[string]bool{await(t, invoked, "an invoke"): true, await(t, invoked, "an invoke"): true}
+    if !started["limited-1"] || !started["free-1"] {
+        t.Fatalf("got %v running, want limited-1 and free-1", started)
+    }
+    stats := s.QueueStats()
+    limited := stats.Processes[qualifiedName(tenant, "limited")]
+    if stats.Running != 2 || stats.Queued != 2 || limited == nil || limited.Running != 1 || limited.Queued != 1 {
+        t.Errorf("got stats %+v, limited %+v", stats, limited)
+    }
+    status, err := s.GetProcessStatus(ctx, &api.GetProcessStatusRequest{ProcessId: "free", InstanceId: ids["free-2"]})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if status.State != InstanceQueued || status.QueuePosition != 2 {
+        t.Errorf("free-2: got %s at position %d, want queued second", status.State, status.QueuePosition)
+    }
+
+    // A free worker goes to the oldest run allowed to use it
+    close(release["free-1"])
+    if request := await(t, invoked, "an invoke"); request != "free-2" {
+        t.Errorf("got %s started, want free-2 ahead of the limited process", request)
+    }
+    close(release["limited-1"])
+    if request := await(t, invoked, "an invoke"); request != "limited-2" {
+        t.Errorf("got %s started, want limited-2", request)
+    }
+    close(release["limited-2"])
+    close(release["free-2"])
+    for request, id := range ids {
+        counts := countEvents(awaitHistory(t, tenant, id, EventInstanceCompleted))
+        queued := counts[EventInstanceQueued] == 1 && counts[EventInstanceDequeued] == 1
+        if want := request == "limited-2" || request == "free-2"; queued != want {
+            t.Errorf("%s: got events %v", request, counts)
+        }
+    }
+    if stats := s.QueueStats(); stats.Running != 0 || stats.Queued != 0 || len(stats.Processes) != 0 {
+        t.Errorf("got stats %+v after all instances completed", stats)
+    }
+}
+
+func TestPartnerLinkConcurrency(t *testing.T) {
+    s := NewServer()
+    s.SetPartnerLinkConcurrency("trainer", 1)
+    done, err := s.acquirePartnerLink(context.Background(), "trainer")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if _, err := s.acquirePartnerLink(ctx, "trainer"); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("got %v for a second invoke, want it to wait", err)
+    }
+    if _, err := s.acquirePartnerLink(ctx, "other"); err != nil {
+        t.Errorf("got %v on a partner link without a limit", err)
+    }
+    done()
+    if _, err := s.acquirePartnerLink(context.Background(), "trainer"); err != nil {
+        t.Errorf("got %v once the slot was given back", err)
+    }
+
+    s.SetPartnerLinkConcurrency("trainer", 0)
+    if _, err := s.acquirePartnerLink(ctx, "trainer"); err != nil {
+        t.Errorf("got %v once the limit was removed", err)
+    }
+}
@@ -2,232 +2,578 @@ package bpel
 
 import (
     "context"
-    "encoding/json"
-    "encoding/xml"
     "errors"
-    "io"
+    "fmt"
     "log"
-    "net/http"
+    "os"
+    "strings"
     "sync"
-    "bytes" // Import the bytes package
+    "time"
 
     "gobpel/api"
+    "gobpel/pkg/authz"
+    "gobpel/pkg/broker"
     "gobpel/pkg/db"
+    "gobpel/pkg/metrics"
 
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
     "google.golang.org/protobuf/types/known/emptypb"
 )
 
-type BPELProcess struct {
-    XMLName       xml.Name       `xml:"process"`
-    Name          string         `xml:"name,attr"`
-    TargetNS      string         `xml:"targetNamespace,attr"`
-    PartnerLinks  []PartnerLink  `xml:"partnerLinks>partnerLink"`
-    Sequence      Sequence       `xml:"sequence"`
-    FaultHandlers FaultHandlers  `xml:"faultHandlers"`
-}
-
-type PartnerLink struct {
-    Name            string `xml:"name,attr"`
-    PartnerLinkType string `xml:"partnerLinkType,attr"`
-    MyRole          string `xml:"myRole,attr"`
-    PartnerRole     string `xml:"partnerRole,attr"`
-}
-
-type Sequence struct {
-    Invokes []Invoke `xml:"invoke"`
-    Reply   Reply    `xml:"reply"`
-}
-
-type Invoke struct {
-    PartnerLink string `xml:"partnerLink,attr"`
-    Operation   string `xml:"operation,attr"`
-    InputVar    string `xml:"inputVariable,attr"`
-    OutputVar   string `xml:"outputVariable,attr"`
-    FaultHandlers []Invoke `xml:"faultHandlers>invoke"`
+type Server struct {
+    api.UnimplementedBPELProcessServiceServer
+    workflows         map[string]*api.Process
+    mu                sync.Mutex
+    subscribers       map[string][]string
+    publications      map[string]context.CancelFunc
+    transports        map[string]Transport
+    sinks             []EventSink
+    broker            broker.Broker
+    triggers          map[string]broker.Subscription
+    instances         map[string]*instance
+    journal           *journal
+    idempotencyWindow time.Duration
+    scheduled         map[string]*scheduledRuns
+    pool              *workerPool
+    replicaId         string
+    breakers          map[string]*circuitBreaker
+    limiters          map[string]*tokenBucket
+    partners          map[string]PartnerSettings
+    outbox            chan outboxEvent
+    pendingEvents     int64
+    draining          bool
+    reloader          Reloader
+    reloadMu          sync.Mutex
+    policy            *authz.Policy
+    defaultQuota      TenantQuota
+    quotas            map[string]TenantQuota
+    redaction         map[string][][]string
 }
 
-type Reply struct {
-    PartnerLink string `xml:"partnerLink,attr"`
-    Operation   string `xml:"operation,attr"`
-    Variable    string `xml:"variable,attr"`
+func NewServer() *Server {
+    hostname, _ := os.Hostname()
+    s := &Server{
+        workflows:         make(map[string]*api.Process),
+        subscribers:       make(map[string][]string),
+        publications:      make(map[string]context.CancelFunc),
+        transports:        make(map[string]Transport),
+        triggers:          make(map[string]broker.Subscription),
+        instances:         make(map[string]*instance),
+        journal:           newJournal(),
+        idempotencyWindow: defaultIdempotencyWindow,
+        scheduled:         make(map[string]*scheduledRuns),
+        pool:              newWorkerPool(),
+        replicaId:         hostname + "-" + newInstanceId()[:8],
+        breakers:          make(map[string]*circuitBreaker),
+        limiters:          make(map[string]*tokenBucket),
+        outbox:            make(chan outboxEvent, outboxSize),
+    }
+    go s.deliverEvents()
+    return s
 }
 
-type FaultHandlers struct {
-    CatchAll CatchAll `xml:"catchAll"`
+// SetReplicaId names this replica as the owner of its instances. It must be
+// unique among running replicas and defaults to the host name with a random
+// suffix.
+func (s *Server) SetReplicaId(replicaId string) {
+    s.replicaId = replicaId
 }
 
-type CatchAll struct {
-    Invoke Invoke `xml:"invoke"`
+func (s *Server) ReplicaId() string {
+    return s.replicaId
 }
 
-type Server struct {
-    api.UnimplementedBPELProcessServiceServer
-    workflows   map[string]*api.Process
-    mu          sync.Mutex
-    subscribers map[string][]string
-}
+func (s *Server) CreateProcess(ctx context.Context, req *api.Process) (*api.Process, error) {
+    // The key only identifies the request and is not stored with the process
+    key := req.IdempotencyKey
+    req.IdempotencyKey = ""
+    tenant := tenantOf(ctx)
+    req.Tenant = tenant
+    if err := prepareDefinition(req); err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    if strings.Contains(req.Name, "/") {
+        return nil, status.Error(codes.InvalidArgument, "process names must not contain /")
+    }
 
-func NewServer() *Server {
-    return &Server{
-        workflows:   make(map[string]*api.Process),
-        subscribers: make(map[string][]string),
+    if key != "" {
+        existing, err := s.reserveIdempotencyKey(tenant, scopeCreateProcess, key, req)
+        if err != nil {
+            return nil, err
+        }
+        if existing != nil {
+            return s.GetProcess(ctx, &api.GetProcessRequest{ProcessId: existing.Resource})
+        }
     }
-}
 
-func (s *Server) CreateProcess(ctx context.Context, req *api.Process) (*api.Process, error) {
-    err := db.CreateProcess(req)
+    err := checkQuota(tenant, "processes", s.quotaFor(tenant).MaxProcesses, db.CountProcesses)
+    if err == nil {
+        err = db.CreateProcess(req)
+    }
     if err != nil {
-        return nil, err
+        if key != "" {
+            s.releaseIdempotencyKey(tenant, scopeCreateProcess, key)
+        }
+        return nil, storeError(err)
+    }
+    if key != "" {
+        if err := s.completeIdempotencyKey(tenant, scopeCreateProcess, key, req.Name); err != nil {
+            // A retry must not find the process without its key
+            if _, err := db.DeleteProcess(tenant, req.Name); err != nil {
+                log.Printf("Error deleting process %s: %v", req.Name, err)
+            }
+            s.releaseIdempotencyKey(tenant, scopeCreateProcess, key)
+            return nil, err
+        }
     }
 
     s.mu.Lock()
-    defer s.mu.Unlock()
-    s.workflows[req.Name] = req
+    s.workflows[qualifiedName(tenant, req.Name)] = req
+    s.mu.Unlock()
+
+    s.registerTrigger(req)
     return req, nil
 }
 
 func (s *Server) GetProcess(ctx context.Context, req *api.GetProcessRequest) (*api.Process, error) {
-    return db.GetProcess(req.ProcessId)
+    process, err := db.GetProcess(tenantOf(ctx), req.ProcessId)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    withStructure(process)
+    return process, nil
 }
 
 func (s *Server) UpdateProcess(ctx context.Context, req *api.Process) (*api.Process, error) {
-    return db.UpdateProcess(req)
+    req.Tenant = tenantOf(ctx)
+    if err := prepareDefinition(req); err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    process, err := db.UpdateProcess(req)
+    if err != nil {
+        return nil, storeError(err)
+    }
+
+    name := qualifiedName(process.Tenant, process.Name)
+    s.mu.Lock()
+    _, exists := s.workflows[name]
+    if exists {
+        s.workflows[name] = process
+    }
+    s.mu.Unlock()
+    if exists {
+        s.registerTrigger(process)
+    }
+    return process, nil
 }
 
 func (s *Server) DeleteProcess(ctx context.Context, req *api.GetProcessRequest) (*emptypb.Empty, error) {
-    _, err := db.DeleteProcess(req.ProcessId)
+    tenant := tenantOf(ctx)
+    _, err := db.DeleteProcess(tenant, req.ProcessId)
     if err != nil {
-        return nil, err
+        return nil, storeError(err)
     }
+
+    name := qualifiedName(tenant, req.ProcessId)
+    s.unregisterTrigger(name)
+    s.mu.Lock()
+    delete(s.workflows, name)
+    s.mu.Unlock()
+    s.audit(tenant, AuditProcessDeleted, actor(ctx), AuditSucceeded, req.ProcessId)
     return &emptypb.Empty{}, nil
 }
 
+// DeleteAllProcesses deletes the processes of the tenant of the caller.
 func (s *Server) DeleteAllProcesses(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
-    err := db.DeleteAllProcesses()
+    tenant := tenantOf(ctx)
+    err := db.DeleteAllProcesses(tenant)
     if err != nil {
         return nil, err
     }
+
+    s.mu.Lock()
+    var names []string
+    for name, process := range s.workflows {
+        if process.Tenant == tenant {
+            names = append(names, name)
+            delete(s.workflows, name)
+        }
+    }
+    s.mu.Unlock()
+    for _, name := range names {
+        s.unregisterTrigger(name)
+    }
+    s.audit(tenant, AuditAllProcessesDeleted, actor(ctx), AuditSucceeded, fmt.Sprintf("%d processes", len(names)))
     return &emptypb.Empty{}, nil
 }
 
 func (s *Server) GetAllProcesses(ctx context.Context, req *emptypb.Empty) (*api.GetAllProcessesResponse, error) {
-    processes, err := db.GetAllProcesses()
+    processes, err := db.GetAllProcesses(tenantOf(ctx))
     if err != nil {
         return nil, err
     }
+    withStructure(processes...)
     return &api.GetAllProcessesResponse{Processes: processes}, nil
 }
 
 func (s *Server) ExecuteProcess(ctx context.Context, req *api.ExecuteProcessRequest) (*api.ExecuteProcessResponse, error) {
-    s.mu.Lock()
-    process, exists := s.workflows[req.ProcessId]
-    s.mu.Unlock()
-    if !exists {
-        return nil, errors.New("process not found")
+    tenant := tenantOf(ctx)
+    // The store has the processes created on every replica and before restarts
+    process, err := db.GetProcess(tenant, req.ProcessId)
+    if err != nil {
+        return nil, storeError(err)
+    }
+
+    var input interface{}
+    switch {
+    case req.Input != nil && req.TypedInput != nil:
+        return nil, status.Error(codes.InvalidArgument, "set either input or typedInput")
+    case req.Input != nil:
+        input = req.Input.AsMap()
+    case req.TypedInput != nil:
+        var err error
+        if input, err = anyInput(req.TypedInput); err != nil {
+            return nil, status.Error(codes.InvalidArgument, err.Error())
+        }
     }
 
-    bpelProcess := BPELProcess{}
-    err := xml.Unmarshal([]byte(process.BpelDefinition), &bpelProcess)
+    if req.IdempotencyKey != "" {
+        fingerprint := proto.Clone(req).(*api.ExecuteProcessRequest)
+        fingerprint.IdempotencyKey = ""
+        fingerprint.Async = false
+        fingerprint.TimeoutSeconds = 0
+        existing, err := s.reserveIdempotencyKey(tenant, scopeExecuteProcess, req.IdempotencyKey, fingerprint)
+        if err != nil {
+            return nil, err
+        }
+        if existing != nil {
+            // A retry answers for the instance the first request started
+            return s.awaitInstance(ctx, req, process, existing.Resource)
+        }
+    }
+
+    inst, err := s.runProcess(ctx, process, req.BusinessKeys, input)
     if err != nil {
+        if req.IdempotencyKey != "" {
+            s.releaseIdempotencyKey(tenant, scopeExecuteProcess, req.IdempotencyKey)
+        }
         return nil, err
     }
-
-    status := s.executeBPELProcess(bpelProcess)
-    return &api.ExecuteProcessResponse{
-        Status:    status,
-        Processes: []*api.Process{process},
-    }, nil
+    if req.IdempotencyKey != "" {
+        if err := s.completeIdempotencyKey(tenant, scopeExecuteProcess, req.IdempotencyKey, inst.id); err != nil {
+            // A retry starts an instance of its own
+            inst.cancel()
+            s.releaseIdempotencyKey(tenant, scopeExecuteProcess, req.IdempotencyKey)
+            return nil, err
+        }
+    }
+    return s.awaitInstance(ctx, req, process, inst.id)
 }
 
-func (s *Server) executeBPELProcess(bpelProcess BPELProcess) string {
-    for _, invoke := range bpelProcess.Sequence.Invokes {
-        // Call the corresponding microservice based on the partner link and operation
-        err := s.callMicroservice(invoke)
+// awaitInstance waits for an instance as requested and reports its result.
+func (s *Server) awaitInstance(ctx context.Context, req *api.ExecuteProcessRequest, process *api.Process, instanceId string) (*api.ExecuteProcessResponse, error) {
+    resp := &api.ExecuteProcessResponse{
+        Processes:  []*api.Process{process},
+        InstanceId: instanceId,
+    }
+
+    s.mu.Lock()
+    inst, exists := s.instances[instanceId]
+    s.mu.Unlock()
+    if !exists {
+        // Started by another replica or before a restart
+        record, err := db.GetInstance(process.Tenant, instanceId)
         if err != nil {
-            s.handleFault(invoke, err)
-            return "BPEL process execution failed"
+            return nil, storeError(err)
         }
+        resp.State = record.State
+        resp.Status = statusText(record.State)
+        resp.Output = record.Output
+        return resp, nil
     }
 
-    // Handle the reply
-    s.callMicroservice(Invoke{
-        PartnerLink: bpelProcess.Sequence.Reply.PartnerLink,
-        Operation:   bpelProcess.Sequence.Reply.Operation,
-        InputVar:    bpelProcess.Sequence.Reply.Variable,
-    })
+    if !req.Async {
+        var timeout <-chan time.Time
+        if req.TimeoutSeconds > 0 {
+            timer := time.NewTimer(time.Duration(req.TimeoutSeconds) * time.Second)
+            defer timer.Stop()
+            timeout = timer.C
+        }
+        // The instance keeps running when the wait ends early
+        select {
+        case <-inst.done:
+        case <-timeout:
+        case <-ctx.Done():
+            return nil, status.FromContextError(ctx.Err()).Err()
+        }
+    }
 
-    return "BPEL process executed successfully"
+    resp.State, resp.Output = s.instanceResult(inst)
+    resp.Status = statusText(resp.State)
+    return resp, nil
 }
 
-func (s *Server) callMicroservice(invoke Invoke) error {
-    // Logic to call the microservice based on the invoke details
-    // For simplicity, we assume all microservices are HTTP endpoints
-
-    // Sample HTTP call to the microservice
-    url := "http://" + invoke.PartnerLink + "/" + invoke.Operation
-    payload := bytes.NewBuffer([]byte(`{"input": "` + invoke.InputVar + `"}`))
+// runProcess starts an instance of process with input bound to its input
+// variable and runs it in the background, once a worker is free, until it
+// completes or faults. The instance is traced as a child of the span in ctx.
+func (s *Server) runProcess(ctx context.Context, process *api.Process, businessKeys map[string]string, input interface{}) (*instance, error) {
+    if s.isDraining() {
+        return nil, status.Error(codes.Unavailable, "server is shutting down")
+    }
+    quota := s.quotaFor(process.Tenant)
+    if err := checkQuota(process.Tenant, "active instances", quota.MaxActiveInstances, db.CountActiveInstances); err != nil {
+        return nil, err
+    }
+    bpelProcess, err := ParseBPEL(process.BpelDefinition)
+    if err != nil {
+        return nil, err
+    }
 
-    resp, err := http.Post(url, "application/json", payload)
+    key, err := uniqueBusinessKey(process, businessKeys)
     if err != nil {
-        // Handle error
-        log.Printf("Error calling microservice: %v", err)
-        return err
+        return nil, err
+    }
+    id := newInstanceId()
+    if key != "" {
+        holder, err := db.AcquireBusinessKey(process.Tenant, process.Name, key, id)
+        if errors.Is(err, db.ErrDuplicate) {
+            return nil, status.Errorf(codes.AlreadyExists, "instance %s of %s is already active for %s", holder, process.Name, key)
+        }
+        if err != nil {
+            return nil, err
+        }
     }
-    defer resp.Body.Close()
 
-    _, err = io.ReadAll(resp.Body)
+    variable := InputVariable
+    if receive := bpelProcess.InitialReceive(); receive != nil && receive.Variable != "" {
+        variable = receive.Variable
+    }
+    inst, err := s.startInstance(ctx, id, process, businessKeys, variable, input)
     if err != nil {
-        // Handle error
-        log.Printf("Error reading microservice response: %v", err)
-        return err
+        if key != "" {
+            db.ReleaseBusinessKey(process.Tenant, process.Name, key, id)
+        }
+        return nil, status.Error(codes.InvalidArgument, err.Error())
     }
-    return nil
+    inst.uniqueKey = key
+
+    runCtx, cancel := inst.runContext()
+    inst.cancel = cancel
+    s.submit(&queuedRun{ctx: runCtx, inst: inst, process: bpelProcess, limit: int(process.MaxConcurrentInstances)})
+    return inst, nil
 }
 
-func (s *Server) handleFault(invoke Invoke, err error) {
-    log.Printf("Handling fault for invoke: %v, error: %v", invoke, err)
-    for _, handler := range invoke.FaultHandlers {
-        s.callMicroservice(handler)
+func (s *Server) executeBPELProcess(ctx context.Context, inst *instance, bpelProcess *BPELProcess) {
+    state := InstanceCompleted
+    err := s.runActivity(ctx, inst, Activity{Sequence: &bpelProcess.Sequence})
+    if errors.Is(err, errSuspended) || s.isSuspended(inst) {
+        s.suspend(inst)
+        return
     }
+    switch {
+    case ctx.Err() != nil:
+        state = InstanceCancelled
+    case err != nil:
+        // Process level handlers run for their side effects; the instance still faults
+        s.handleFault(ctx, inst, bpelProcess.FaultHandlers, err)
+        state = InstanceFaulted
+    }
+
+    s.finishInstance(inst, state)
+    inst.cancel()
+    if s.isFenced(inst) {
+        return
+    }
+    s.emit(inst.tenant, "processExecuted", processEvent{Tenant: inst.tenant, ProcessId: inst.processId, InstanceId: inst.id, Status: statusText(state)})
 }
 
-func (s *Server) Publish(ctx context.Context, req *api.PublishRequest) (*emptypb.Empty, error) {
-    var result interface{}
-    var err error
+// statusText describes an instance state in ExecuteProcess responses.
+func statusText(state string) string {
+    switch state {
+    case InstanceCompleted:
+        return "BPEL process executed successfully"
+    case InstanceFaulted:
+        return "BPEL process execution failed"
+    case InstanceCancelled:
+        return "BPEL process execution cancelled"
+    case InstanceQueued:
+        return "BPEL process queued"
+    }
+    return "BPEL process running"
+}
 
-    switch req.RunMethod {
-    case "GetAllProcesses":
-        result, err = s.GetAllProcesses(ctx, &emptypb.Empty{})
-    default:
-        return nil, errors.New("unsupported runMethod")
+// invokeActivity calls the partner for an activity and journals its progress.
+func (s *Server) invokeActivity(ctx context.Context, inst *instance, activity string, invoke Invoke) error {
+    payload, err := inst.invokePayload(invoke)
+    if err != nil {
+        return &Fault{Name: FaultSelectionFailure, Err: err}
     }
 
+    s.record(inst, invokeEvent(EventActivityStarted, activity, invoke))
+    // A replayed invoke gets the outcome a previous owner recorded
+    resp, replayed, err := inst.replayedInvoke(activity, invoke)
+    if !replayed {
+        resp, err = s.callMicroservice(ctx, inst.tenant, invoke, payload)
+    }
+    if err != nil && s.isSuspended(inst) {
+        // Interrupted by a shutdown; the invoke is sent again after takeover
+        return errSuspended
+    }
     if err != nil {
-        return nil, err
+        var fault *Fault
+        if !errors.As(err, &fault) {
+            fault = &Fault{Name: FaultInvocationFailure, Err: err}
+            if errors.Is(err, errCircuitOpen) {
+                fault.Name = FaultCircuitOpen
+            }
+        }
+        log.Printf("Error invoking %s %s of instance %s with %s: %v", invoke.PartnerLink, invoke.Operation, inst.id, s.redactPayload(inst.process(), payload), err)
+        event := invokeEvent(EventFault, activity, invoke)
+        event.Fault = fault.Err.Error()
+        event.FaultName = fault.Name
+        event.RequestPayload = payload
+        event.RequestDigest = digest(payload)
+        s.record(inst, event)
+        return fault
     }
 
-    resultJSON, err := json.Marshal(result)
+    event := invokeEvent(EventActivityCompleted, activity, invoke)
+    event.RequestPayload = payload
+    event.RequestDigest = digest(payload)
+    event.ResponsePayload = resp
+    event.ResponseDigest = digest(resp)
+    s.record(inst, event)
+    if invoke.OutputVar != "" {
+        inst.setVariable(invoke.OutputVar, decodeMessage(resp))
+        event := invokeEvent(EventVariableUpdated, activity, invoke)
+        event.Variable = invoke.OutputVar
+        s.record(inst, event)
+    }
+    return nil
+}
+
+func (s *Server) callMicroservice(ctx context.Context, tenant string, invoke Invoke, payload []byte) ([]byte, error) {
+    // An open breaker fails the invoke before it waits for anything
+    start := time.Now()
+    ctx, span := startPartnerSpan(ctx, invoke)
+    breaker := s.breakerFor(tenant, invoke.PartnerLink)
+    trial, err := breaker.allow()
+    if err != nil {
+        endSpan(span, err)
+        metrics.PartnerCallDuration.WithLabelValues(invoke.PartnerLink, partnerStatus(err)).Observe(time.Since(start).Seconds())
+        return nil, err
+    }
+    resp, err := s.callPartner(ctx, breaker, invoke, payload)
+    breaker.record(ctx, trial, err)
+    endSpan(span, err)
+    metrics.PartnerCallDuration.WithLabelValues(invoke.PartnerLink, partnerStatus(err)).Observe(time.Since(start).Seconds())
     if err != nil {
         return nil, err
     }
+    return resp, nil
+}
 
-    _, err = http.Post(req.ResultsServer, "application/json", bytes.NewBuffer(resultJSON))
+// callPartner waits for the rate limit and a free slot of the partner link
+// and calls the partner.
+func (s *Server) callPartner(ctx context.Context, breaker *circuitBreaker, invoke Invoke, payload []byte) ([]byte, error) {
+    if err := breaker.wait(ctx); err != nil {
+        return nil, err
+    }
+    release, err := s.acquirePartnerLink(ctx, invoke.PartnerLink)
     if err != nil {
         return nil, err
     }
+    defer release()
 
-    return &emptypb.Empty{}, nil
+    // Partners are reached over HTTP unless a transport is set for the partner link
+    return s.transportFor(invoke.PartnerLink).Call(ctx, invoke, payload)
+}
+
+// handleFault runs the handler in handlers that catches err. It returns nil
+// when the fault was handled and err when nothing catches it.
+func (s *Server) handleFault(ctx context.Context, inst *instance, handlers *FaultHandlers, err error) error {
+    if handlers == nil || errors.Is(err, errSuspended) {
+        return err
+    }
+    log.Printf("Handling fault for instance %s: %v", inst.id, err)
+
+    handler := handlers.CatchAll
+    for i := range handlers.Catches {
+        if handlers.Catches[i].FaultName == faultName(err) {
+            handler = &handlers.Catches[i]
+            break
+        }
+    }
+    if handler == nil {
+        return err
+    }
+    return s.runActivity(ctx, inst, handler.Activity)
 }
 
+// storeError maps store errors onto gRPC status codes.
+func storeError(err error) error {
+    switch {
+    case errors.Is(err, db.ErrNotFound):
+        return status.Error(codes.NotFound, err.Error())
+    case errors.Is(err, db.ErrInvalidSort):
+        return status.Error(codes.InvalidArgument, err.Error())
+    case errors.Is(err, db.ErrDuplicate):
+        return status.Error(codes.AlreadyExists, err.Error())
+    }
+    return err
+}
+
+// Subscribe registers a URL for the events of the tenant of the caller.
 func (s *Server) Subscribe(ctx context.Context, req *api.SubscribeRequest) (*emptypb.Empty, error) {
+    eventType := qualifiedName(tenantOf(ctx), req.EventType)
     s.mu.Lock()
     defer s.mu.Unlock()
-    s.subscribers[req.EventType] = append(s.subscribers[req.EventType], req.NotifyURL)
+    s.subscribers[eventType] = append(s.subscribers[eventType], req.NotifyURL)
     return &emptypb.Empty{}, nil
 }
 
 func (s *Server) GetProcessStatus(ctx context.Context, req *api.GetProcessStatusRequest) (*api.GetProcessStatusResponse, error) {
-    // Implement logic to get the status of a process
-    return &api.GetProcessStatusResponse{Status: "Active"}, nil
+    tenant := tenantOf(ctx)
+    if req.InstanceId == "" {
+        // Running instances are those of this replica; the queue is shared
+        queued, err := db.QueueDepth(tenant, req.ProcessId)
+        if err != nil {
+            return nil, err
+        }
+        stats := s.QueueStats()
+        resp := &api.GetProcessStatusResponse{Status: "Active", QueuedInstances: int32(queued)}
+        if load, ok := stats.Processes[qualifiedName(tenant, req.ProcessId)]; ok {
+            resp.RunningInstances = int32(load.Running)
+        }
+        return resp, nil
+    }
+
+    s.mu.Lock()
+    inst, exists := s.instances[req.InstanceId]
+    s.mu.Unlock()
+    if exists && inst.tenant == tenant {
+        if req.ProcessId != "" && req.ProcessId != inst.processId {
+            return nil, status.Error(codes.NotFound, "instance not found")
+        }
+        state, output := s.instanceResult(inst)
+        return &api.GetProcessStatusResponse{
+            Status:        state,
+            State:         state,
+            Output:        output,
+            QueuePosition: int32(s.queuePosition(inst)),
+        }, nil
+    }
+
+    // Instances started before a restart are only in the store
+    record, err := db.GetInstance(tenant, req.InstanceId)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    if req.ProcessId != "" && req.ProcessId != record.ProcessId {
+        return nil, status.Error(codes.NotFound, "instance not found")
+    }
+    return &api.GetProcessStatusResponse{Status: record.State, State: record.State, Output: record.Output}, nil
 }
 
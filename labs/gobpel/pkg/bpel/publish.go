@@ -0,0 +1,258 @@
+package bpel
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "time"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/emptypb"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// Results servers that take longer to answer fail the publication
+const publishTimeout = 30 * time.Second
+
+var publishClient = &http.Client{Timeout: publishTimeout}
+
+// runMethod is a read RPC that Publish can run and push to a results server.
+type runMethod struct {
+    description string
+    args        []string
+    run         func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error)
+}
+
+var runMethods = map[string]runMethod{
+    "GetAllProcesses": {
+        description: "All process definitions",
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            return s.GetAllProcesses(ctx, &emptypb.Empty{})
+        },
+    },
+    "GetProcess": {
+        description: "A single process definition",
+        args:        []string{"processId"},
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            processId, err := stringArg(args, "processId")
+            if err != nil {
+                return nil, err
+            }
+            return s.GetProcess(ctx, &api.GetProcessRequest{ProcessId: processId})
+        },
+    },
+    "GetProcessStatus": {
+        description: "Status of a process",
+        args:        []string{"processId"},
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            processId, err := stringArg(args, "processId")
+            if err != nil {
+                return nil, err
+            }
+            return s.GetProcessStatus(ctx, &api.GetProcessStatusRequest{ProcessId: processId})
+        },
+    },
+    "GetInstanceStatus": {
+        description: "State and output of an instance",
+        args:        []string{"processId", "instanceId"},
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            processId, err := stringArg(args, "processId")
+            if err != nil {
+                return nil, err
+            }
+            instanceId, err := stringArg(args, "instanceId")
+            if err != nil {
+                return nil, err
+            }
+            return s.GetProcessStatus(ctx, &api.GetProcessStatusRequest{ProcessId: processId, InstanceId: instanceId})
+        },
+    },
+    "GetMetrics": {
+        description: "Snapshot of the worker pool, and of the instances and partner links of the tenant on this replica",
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            return s.metricsSnapshot(tenantOf(ctx))
+        },
+    },
+    "GetInstanceHistory": {
+        description: "First page of the event history of an instance",
+        args:        []string{"instanceId"},
+        run: func(ctx context.Context, s *Server, args map[string]interface{}) (proto.Message, error) {
+            instanceId, err := stringArg(args, "instanceId")
+            if err != nil {
+                return nil, err
+            }
+            return s.GetInstanceHistory(ctx, &api.GetInstanceHistoryRequest{InstanceId: instanceId})
+        },
+    },
+}
+
+func stringArg(args map[string]interface{}, name string) (string, error) {
+    value, ok := args[name].(string)
+    if !ok || value == "" {
+        return "", status.Errorf(codes.InvalidArgument, "missing string argument %q", name)
+    }
+    return value, nil
+}
+
+func (s *Server) Publish(ctx context.Context, req *api.PublishRequest) (*emptypb.Empty, error) {
+    method, ok := runMethods[req.RunMethod]
+    if !ok {
+        return nil, status.Errorf(codes.InvalidArgument, "unsupported runMethod %q", req.RunMethod)
+    }
+    if req.ResultsServer == "" {
+        return nil, status.Error(codes.InvalidArgument, "resultsServer is required")
+    }
+    args := req.Args.AsMap()
+
+    if req.IntervalSeconds <= 0 {
+        if err := s.publishOnce(ctx, method, args, req.ResultsServer); err != nil {
+            return nil, err
+        }
+        return &emptypb.Empty{}, nil
+    }
+
+    if req.PublicationId == "" {
+        return nil, status.Error(codes.InvalidArgument, "publicationId is required for scheduled publications")
+    }
+    tenant := tenantOf(ctx)
+    s.mu.Lock()
+    if _, exists := s.publications[qualifiedName(tenant, req.PublicationId)]; exists {
+        s.mu.Unlock()
+        return nil, status.Error(codes.AlreadyExists, "publication already exists")
+    }
+    pubCtx, cancel := context.WithCancel(withTenant(context.Background(), tenant))
+    s.publications[qualifiedName(tenant, req.PublicationId)] = cancel
+    s.mu.Unlock()
+
+    // Run once up front so bad arguments are reported to the caller
+    if err := s.publishOnce(ctx, method, args, req.ResultsServer); err != nil {
+        s.CancelPublication(ctx, &api.CancelPublicationRequest{PublicationId: req.PublicationId})
+        return nil, err
+    }
+
+    go s.runPublication(pubCtx, method, args, req.ResultsServer, time.Duration(req.IntervalSeconds)*time.Second)
+    return &emptypb.Empty{}, nil
+}
+
+func (s *Server) runPublication(ctx context.Context, method runMethod, args map[string]interface{}, resultsServer string, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.publishOnce(ctx, method, args, resultsServer); err != nil {
+                log.Printf("Error publishing to %s: %v", resultsServer, err)
+            }
+        }
+    }
+}
+
+func (s *Server) publishOnce(ctx context.Context, method runMethod, args map[string]interface{}, resultsServer string) error {
+    result, err := method.run(ctx, s, args)
+    if err != nil {
+        return err
+    }
+
+    resultJSON, err := protojson.Marshal(result)
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, resultsServer, bytes.NewReader(resultJSON))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := publishClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= http.StatusMultipleChoices {
+        return fmt.Errorf("results server returned %s", resp.Status)
+    }
+    return nil
+}
+
+// metricsSnapshot reports the worker pool of this replica together with the
+// queued and running instances, process loads and circuit breakers of tenant.
+func (s *Server) metricsSnapshot(tenant string) (*structpb.Struct, error) {
+    stats := s.QueueStats()
+    instances := map[string]interface{}{InstanceQueued: 0, InstanceRunning: 0}
+    processes := make(map[string]interface{})
+    breakers := make(map[string]interface{})
+
+    s.mu.Lock()
+    for _, inst := range s.instances {
+        if inst.tenant == tenant && (inst.state == InstanceQueued || inst.state == InstanceRunning) {
+            instances[inst.state] = instances[inst.state].(int) + 1
+        }
+    }
+    for _, process := range s.workflows {
+        if process.Tenant != tenant {
+            continue
+        }
+        if load, ok := stats.Processes[qualifiedName(tenant, process.Name)]; ok {
+            processes[process.Name] = map[string]interface{}{"running": load.Running, "queued": load.Queued}
+        }
+    }
+    var tenantBreakers []*circuitBreaker
+    for _, b := range s.breakers {
+        if b.tenant == tenant {
+            tenantBreakers = append(tenantBreakers, b)
+        }
+    }
+    s.mu.Unlock()
+    for _, b := range tenantBreakers {
+        b.mu.Lock()
+        breakers[b.partnerLink] = b.state
+        b.mu.Unlock()
+    }
+
+    return structpb.NewStruct(map[string]interface{}{
+        "workers":   stats.Workers,
+        "running":   stats.Running,
+        "queued":    stats.Queued,
+        "instances": instances,
+        "processes": processes,
+        "breakers":  breakers,
+    })
+}
+
+func (s *Server) CancelPublication(ctx context.Context, req *api.CancelPublicationRequest) (*emptypb.Empty, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    name := qualifiedName(tenantOf(ctx), req.PublicationId)
+    cancel, exists := s.publications[name]
+    if !exists {
+        return nil, status.Error(codes.NotFound, "publication not found")
+    }
+    cancel()
+    delete(s.publications, name)
+    return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListRunMethods(ctx context.Context, req *emptypb.Empty) (*api.ListRunMethodsResponse, error) {
+    resp := &api.ListRunMethodsResponse{}
+    for name, method := range runMethods {
+        resp.RunMethods = append(resp.RunMethods, &api.RunMethod{
+            Name:        name,
+            Description: method.description,
+            Args:        method.args,
+        })
+    }
+    sort.Slice(resp.RunMethods, func(i, j int) bool {
+        return resp.RunMethods[i].Name < resp.RunMethods[j].Name
+    })
+    return resp, nil
+}
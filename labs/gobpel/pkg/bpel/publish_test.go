@@ -0,0 +1,103 @@
+package bpel
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// resultsServer records the results posted to it and answers with code.
+func resultsServer(t *testing.T, code int) (*httptest.Server, <-chan map[string]interface{}) {
+    results := make(chan map[string]interface{}, 1)
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        var result map[string]interface{}
+        if err := json.Unmarshal(body, &result); err != nil {
+            t.Errorf("invalid result %s: %v", body, err)
+        }
+        results <- result
+        w.WriteHeader(code)
+    }))
+    t.Cleanup(server.Close)
+    return server, results
+}
+
+func TestPublish(t *testing.T) {
+    s := NewServer()
+    ctx := withTenant(context.Background(), "farm")
+    running := &instance{id: "i1", tenant: "farm", processId: "train", state: InstanceRunning, done: make(chan struct{})}
+    other := &instance{id: "i2", tenant: "other", processId: "train", state: InstanceRunning, done: make(chan struct{})}
+    s.instances[running.id], s.instances[other.id] = running, other
+    s.breakerFor("farm", "trainer")
+
+    tests := []struct {
+        name   string
+        method string
+        args   map[string]interface{}
+        check  func(t *testing.T, result map[string]interface{})
+    }{
+        {"instance status", "GetInstanceStatus", map[string]interface{}{"processId": "train", "instanceId": "i1"}, func(t *testing.T, result map[string]interface{}) {
+            if result["state"] != InstanceRunning {
+                t.Errorf("got %v", result)
+            }
+        }},
+        {"metrics", "GetMetrics", nil, func(t *testing.T, result map[string]interface{}) {
+            instances, _ := result["instances"].(map[string]interface{})
+            breakers, _ := result["breakers"].(map[string]interface{})
+            if instances[InstanceRunning] != 1.0 || breakers["trainer"] != BreakerClosed || result["workers"] == nil {
+                t.Errorf("got %v", result)
+            }
+        }},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            server, results := resultsServer(t, http.StatusOK)
+            args, err := structpb.NewStruct(test.args)
+            if err != nil {
+                t.Fatal(err)
+            }
+            _, err = s.Publish(ctx, &api.PublishRequest{ResultsServer: server.URL, RunMethod: test.method, Args: args})
+            if err != nil {
+                t.Fatal(err)
+            }
+            test.check(t, <-results)
+        })
+    }
+
+    server, _ := resultsServer(t, http.StatusInternalServerError)
+    if _, err := s.Publish(ctx, &api.PublishRequest{ResultsServer: server.URL, RunMethod: "GetMetrics"}); err == nil {
+        t.Error("published to a failing results server")
+    }
+    if _, err := s.Publish(ctx, &api.PublishRequest{ResultsServer: server.URL, RunMethod: "GetInstanceStatus"}); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("got %v without arguments, want InvalidArgument", err)
+    }
+}
+
+func TestListRunMethods(t *testing.T) {
+    resp, err := NewServer().ListRunMethods(withTenant(context.Background(), db.DefaultTenant), &emptypb.Empty{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(resp.RunMethods) != len(runMethods) {
+        t.Fatalf("got %d run methods, want %d", len(resp.RunMethods), len(runMethods))
+    }
+    for i, method := range resp.RunMethods {
+        if i > 0 && resp.RunMethods[i-1].Name >= method.Name {
+            t.Errorf("run methods not sorted: %s before %s", resp.RunMethods[i-1].Name, method.Name)
+        }
+        if method.Description == "" {
+            t.Errorf("%s has no description", method.Name)
+        }
+    }
+}
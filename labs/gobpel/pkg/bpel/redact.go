@@ -0,0 +1,108 @@
+package bpel
+
+import (
+    "encoding/json"
+    "strings"
+
+    "gobpel/api"
+
+    "google.golang.org/protobuf/proto"
+)
+
+// redactedValue replaces the values of redacted fields.
+const redactedValue = "***"
+
+// SetRedaction sets the fields of invoke payloads masked in logs, in
+// WatchInstance and WatchProcess streams and in the events returned by
+// GetInstanceHistory: fields of every process and, by
+// process, the fields of some. A field is a dotted path into the payload,
+// where * matches any key and ** any number of levels; arrays are searched
+// element by element.
+func (s *Server) SetRedaction(fields []string, processes map[string][]string) {
+    redaction := make(map[string][][]string)
+    for _, field := range fields {
+        redaction[""] = append(redaction[""], strings.Split(field, "."))
+    }
+    for process, fields := range processes {
+        for _, field := range fields {
+            redaction[process] = append(redaction[process], strings.Split(field, "."))
+        }
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.redaction = redaction
+}
+
+// redactPayload returns payload with the fields redacted for process masked.
+// Payloads that are not JSON are returned as they are.
+func (s *Server) redactPayload(process string, payload []byte) []byte {
+    s.mu.Lock()
+    paths := append(append([][]string{}, s.redaction[""]...), s.redaction[process]...)
+    s.mu.Unlock()
+    if len(paths) == 0 || len(payload) == 0 {
+        return payload
+    }
+    var value interface{}
+    if err := json.Unmarshal(payload, &value); err != nil {
+        return payload
+    }
+    for _, path := range paths {
+        value = redactValue(value, path)
+    }
+    redacted, err := json.Marshal(value)
+    if err != nil {
+        return payload
+    }
+    return redacted
+}
+
+func redactValue(value interface{}, path []string) interface{} {
+    if len(path) == 0 {
+        return redactedValue
+    }
+    switch v := value.(type) {
+    case []interface{}:
+        for i := range v {
+            v[i] = redactValue(v[i], path)
+        }
+    case map[string]interface{}:
+        if path[0] == "**" {
+            // Matches here, or further down
+            if redacted := redactValue(v, path[1:]); redacted == redactedValue {
+                return redacted
+            }
+            for key := range v {
+                v[key] = redactValue(v[key], path)
+            }
+            return v
+        }
+        for key := range v {
+            if path[0] == "*" || path[0] == key {
+                v[key] = redactValue(v[key], path[1:])
+            }
+        }
+    }
+    return value
+}
+
+// redactEvent returns event, or a copy of it with its payloads redacted.
+func (s *Server) redactEvent(event *api.InstanceEvent) *api.InstanceEvent {
+    if len(event.RequestPayload) == 0 && len(event.ResponsePayload) == 0 {
+        return event
+    }
+    process := qualifiedName(event.Tenant, event.ProcessId)
+    redacted := proto.Clone(event).(*api.InstanceEvent)
+    redacted.RequestPayload = s.redactPayload(process, event.RequestPayload)
+    redacted.ResponsePayload = s.redactPayload(process, event.ResponsePayload)
+    return redacted
+}
+
+// redactingStream redacts the events sent on a watch stream.
+type redactingStream struct {
+    server *Server
+    stream eventStream
+}
+
+func (r redactingStream) Send(event *api.InstanceEvent) error {
+    return r.stream.Send(r.server.redactEvent(event))
+}
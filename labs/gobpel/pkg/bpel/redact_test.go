@@ -0,0 +1,56 @@
+package bpel
+
+import (
+    "testing"
+
+    "gobpel/api"
+)
+
+func TestRedactPayload(t *testing.T) {
+    s := NewServer()
+    s.SetRedaction([]string{"farm.bankAccount", "**.apiKey"}, map[string][]string{
+        "AdvisoryPipeline":             {"messages.content"},
+        "ml-research/AdvisoryPipeline": {"prompt"},
+    })
+    tests := []struct {
+        name    string
+        process string
+        payload string
+        want    string
+    }{
+        {"field", "training", `{"farm":{"bankAccount":"DE89","name":"Hof"}}`, `{"farm":{"bankAccount":"***","name":"Hof"}}`},
+        {"any level", "training", `{"apiKey":"a","auth":{"partner":{"apiKey":"b"}}}`, `{"apiKey":"***","auth":{"partner":{"apiKey":"***"}}}`},
+        {"arrays", "AdvisoryPipeline", `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`, `{"messages":[{"content":"***","role":"user"},{"content":"***","role":"assistant"}]}`},
+        {"other process", "training", `{"messages":[{"content":"hi"}]}`, `{"messages":[{"content":"hi"}]}`},
+        {"tenant process", "ml-research/AdvisoryPipeline", `{"prompt":"p","messages":[{"content":"hi"}]}`, `{"messages":[{"content":"hi"}],"prompt":"***"}`},
+        {"whole object", "training", `{"farm":{"bankAccount":{"iban":"DE89"}}}`, `{"farm":{"bankAccount":"***"}}`},
+        {"not JSON", "training", `apiKey=a`, `apiKey=a`},
+        {"empty", "training", ``, ``},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got := s.redactPayload(test.process, []byte(test.payload)); string(got) != test.want {
+                t.Errorf("got %s, want %s", got, test.want)
+            }
+        })
+    }
+}
+
+func TestRedactEvent(t *testing.T) {
+    s := NewServer()
+    s.SetRedaction([]string{"apiKey"}, nil)
+    event := &api.InstanceEvent{
+        Tenant:          "ml-research",
+        ProcessId:       "training",
+        RequestPayload:  []byte(`{"apiKey":"s3cr3t"}`),
+        ResponsePayload: []byte(`{"ok":true}`),
+    }
+    redacted := s.redactEvent(event)
+    if string(redacted.RequestPayload) != `{"apiKey":"***"}` || string(redacted.ResponsePayload) != `{"ok":true}` {
+        t.Errorf("got %s %s", redacted.RequestPayload, redacted.ResponsePayload)
+    }
+    // The event journaled and stored keeps its payloads
+    if string(event.RequestPayload) != `{"apiKey":"s3cr3t"}` {
+        t.Errorf("event changed: %s", event.RequestPayload)
+    }
+}
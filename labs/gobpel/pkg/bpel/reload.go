@@ -0,0 +1,146 @@
+package bpel
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "strings"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Reloader re-reads the configuration and applies the sections that can
+// change while instances run. It returns the sections it applied and the
+// changed sections that only take effect after a restart. An invalid
+// configuration is returned as an error and nothing of it is applied.
+type Reloader func() (applied, restartRequired []string, err error)
+
+// PartnerSettings is the partner registry entry of a partner link.
+type PartnerSettings struct {
+    Transport      Transport
+    MaxConcurrency int
+    RateLimit      float64
+    Burst          int
+    Breaker        BreakerPolicy
+}
+
+// SetReloader sets what Reload and the ReloadConfig RPC run.
+func (s *Server) SetReloader(r Reloader) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.reloader = r
+}
+
+// Reload runs the reloader, one reload at a time, and records the outcome in
+// the audit log. trigger names what asked for the reload: "signal", "file"
+// or the caller of ReloadConfig.
+func (s *Server) Reload(trigger string) (applied, restartRequired []string, err error) {
+    s.reloadMu.Lock()
+    defer s.reloadMu.Unlock()
+    s.mu.Lock()
+    reloader := s.reloader
+    s.mu.Unlock()
+    if reloader == nil {
+        return nil, nil, fmt.Errorf("configuration reload is not enabled")
+    }
+
+    applied, restartRequired, err = reloader()
+    if err != nil {
+        log.Printf("Configuration reload by %s failed: %v", trigger, err)
+        s.audit(db.DefaultTenant, AuditConfigReloaded, trigger, AuditFailed, err.Error())
+        return nil, nil, err
+    }
+    detail := "applied: " + strings.Join(applied, ", ")
+    if len(applied) == 0 {
+        detail = "no changes"
+    }
+    if len(restartRequired) > 0 {
+        detail += "; restart required: " + strings.Join(restartRequired, ", ")
+    }
+    log.Printf("Configuration reloaded by %s, %s", trigger, detail)
+    s.audit(db.DefaultTenant, AuditConfigReloaded, trigger, AuditSucceeded, detail)
+    return applied, restartRequired, nil
+}
+
+func (s *Server) ReloadConfig(ctx context.Context, req *emptypb.Empty) (*api.ReloadConfigResponse, error) {
+    s.mu.Lock()
+    enabled := s.reloader != nil
+    s.mu.Unlock()
+    if !enabled {
+        return nil, status.Error(codes.FailedPrecondition, "configuration reload is not enabled")
+    }
+    applied, restartRequired, err := s.Reload(actor(ctx))
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    return &api.ReloadConfigResponse{AppliedSections: applied, RestartRequired: restartRequired}, nil
+}
+
+// SetPartners replaces the whole partner registry at once. Partner links
+// left out are invoked over HTTP without limits and with the default breaker
+// policy. Invokes already in flight finish with the settings they started
+// with; breaker state and unchanged rate limits carry over.
+func (s *Server) SetPartners(partners map[string]PartnerSettings) {
+    s.pool.mu.Lock()
+    defer s.pool.mu.Unlock()
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    transports := make(map[string]Transport)
+    partnerLinks := make(map[string]chan struct{})
+    for partnerLink, partner := range partners {
+        if partner.Transport != nil {
+            transports[partnerLink] = partner.Transport
+        }
+        if partner.MaxConcurrency > 0 {
+            slots, ok := s.pool.partnerLinks[partnerLink]
+            if !ok || cap(slots) != partner.MaxConcurrency {
+                slots = make(chan struct{}, partner.MaxConcurrency)
+            }
+            partnerLinks[partnerLink] = slots
+        }
+        // Listed for the default tenant; other tenants get theirs when they invoke
+        name := qualifiedName(db.DefaultTenant, partnerLink)
+        if _, ok := s.breakers[name]; !ok {
+            s.breakers[name] = newCircuitBreaker(db.DefaultTenant, partnerLink, partner)
+        }
+    }
+    for partnerLink, t := range s.transports {
+        if transports[partnerLink] != t {
+            closeTransport(t)
+        }
+    }
+    s.transports = transports
+    s.pool.partnerLinks = partnerLinks
+    s.partners = partners
+
+    for _, b := range s.breakers {
+        b.mu.Lock()
+        b.policy = breakerPolicy(partners[b.partnerLink].Breaker)
+        b.mu.Unlock()
+    }
+    for partnerLink := range s.limiters {
+        if _, ok := partners[partnerLink]; !ok {
+            s.setLimit(partnerLink, 0, 0)
+        }
+    }
+    for partnerLink, partner := range partners {
+        s.setLimit(partnerLink, partner.RateLimit, partner.Burst)
+    }
+}
+
+// closeTransport releases the connections of a transport taken out of the
+// registry. Transports close once their invokes in flight are done.
+func closeTransport(t Transport) {
+    if closer, ok := t.(io.Closer); ok {
+        if err := closer.Close(); err != nil {
+            log.Printf("Error closing transport: %v", err)
+        }
+    }
+}
@@ -0,0 +1,97 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// closingPartner is a partner transport that records being closed.
+type closingPartner struct {
+    Transport
+    closed bool
+}
+
+func (p *closingPartner) Close() error {
+    p.closed = true
+    return nil
+}
+
+func TestSetPartnersSwap(t *testing.T) {
+    s := NewServer()
+    old := &closingPartner{Transport: echoPartner()}
+    s.SetPartners(map[string]PartnerSettings{"partner": {Transport: old, MaxConcurrency: 2}})
+    slots := s.pool.partnerLinks["partner"]
+
+    next := &closingPartner{Transport: echoPartner()}
+    s.SetPartners(map[string]PartnerSettings{"partner": {Transport: next, MaxConcurrency: 2}})
+    if s.transportFor("partner") != next || !old.closed {
+        t.Error("the transport taken out of the registry is still in use or open")
+    }
+    if s.pool.partnerLinks["partner"] != slots {
+        t.Error("unchanged concurrency slots were replaced")
+    }
+
+    s.SetPartners(map[string]PartnerSettings{"partner": {Transport: next, MaxConcurrency: 3}})
+    if next.closed {
+        t.Error("a transport kept in the registry was closed")
+    }
+    if cap(s.pool.partnerLinks["partner"]) != 3 {
+        t.Errorf("got %d slots, want 3", cap(s.pool.partnerLinks["partner"]))
+    }
+
+    s.SetPartners(nil)
+    if _, ok := s.transportFor("partner").(httpTransport); !ok || !next.closed {
+        t.Error("a partner link left out is not invoked over HTTP")
+    }
+    if _, ok := s.pool.partnerLinks["partner"]; ok {
+        t.Error("a partner link left out is still limited")
+    }
+}
+
+func TestReload(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    if _, err := s.ReloadConfig(context.Background(), &emptypb.Empty{}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("got %v without a reloader, want FailedPrecondition", err)
+    }
+
+    invalid := errors.New("partners.ragservice.url: invalid URL")
+    s.SetReloader(func() ([]string, []string, error) { return nil, nil, invalid })
+    if _, err := s.ReloadConfig(context.Background(), &emptypb.Empty{}); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("got %v for an invalid configuration, want InvalidArgument", err)
+    }
+    s.SetReloader(func() ([]string, []string, error) {
+        return []string{"partners"}, []string{"server"}, nil
+    })
+    resp, err := s.ReloadConfig(context.Background(), &emptypb.Empty{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(resp.AppliedSections) != 1 || len(resp.RestartRequired) != 1 {
+        t.Errorf("got %v", resp)
+    }
+
+    events, err := db.ListAuditEvents(db.DefaultTenant, AuditConfigReloaded, 0, 100)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var outcomes []string
+    for _, event := range events {
+        if event.ReplicaId == s.replicaId {
+            outcomes = append(outcomes, event.Outcome+": "+event.Detail)
+        }
+    }
+    // Newest first
+    if len(outcomes) != 2 || outcomes[0] != AuditSucceeded+": applied: partners; restart required: server" ||
+        !strings.HasPrefix(outcomes[1], AuditFailed+": "+invalid.Error()) {
+        t.Errorf("got audit events %q", outcomes)
+    }
+}
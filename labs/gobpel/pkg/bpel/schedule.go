@@ -0,0 +1,355 @@
+package bpel
+
+import (
+    "context"
+    "log"
+    "math/rand"
+    "sync"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "github.com/robfig/cron/v3"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Overlap policies decide what happens when a schedule fires while the
+// instance it started last is still running.
+const (
+    OverlapSkip           = "skip"
+    OverlapQueue          = "queue"
+    OverlapCancelPrevious = "cancelPrevious"
+)
+
+const (
+    schedulerLease    = "scheduler"
+    schedulerTick     = time.Second
+    schedulerLeaseTTL = 15 * time.Second
+    queuePollInterval = 5 * time.Second
+    maxCatchUpRuns    = 100
+)
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// scheduledRuns serializes the runs of a schedule fired by this replica.
+type scheduledRuns struct {
+    mu   sync.Mutex
+    last *instance
+}
+
+// nextRunTime returns the first run of schedule after t.
+func nextRunTime(schedule *api.Schedule, t time.Time) (time.Time, error) {
+    spec, err := cronParser.Parse(schedule.Cron)
+    if err != nil {
+        return time.Time{}, err
+    }
+    location := time.UTC
+    if schedule.TimeZone != "" {
+        if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+            return time.Time{}, err
+        }
+    }
+    return spec.Next(t.In(location)), nil
+}
+
+func (s *Server) CreateSchedule(ctx context.Context, req *api.Schedule) (*api.Schedule, error) {
+    req.Tenant = tenantOf(ctx)
+    if _, err := db.GetProcess(req.Tenant, req.ProcessId); err != nil {
+        return nil, storeError(err)
+    }
+    switch req.OverlapPolicy {
+    case "":
+        req.OverlapPolicy = OverlapSkip
+    case OverlapSkip, OverlapQueue, OverlapCancelPrevious:
+    default:
+        return nil, status.Errorf(codes.InvalidArgument, "unknown overlapPolicy %q", req.OverlapPolicy)
+    }
+    if req.JitterSeconds < 0 {
+        return nil, status.Error(codes.InvalidArgument, "jitterSeconds must not be negative")
+    }
+    next, err := nextRunTime(req, time.Now())
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+
+    if req.ScheduleId == "" {
+        req.ScheduleId = newInstanceId()
+    }
+    req.NextRunTime = timestamppb.New(next)
+    req.LastRunTime = nil
+    req.LastInstanceId = ""
+    if err := checkQuota(req.Tenant, "schedules", s.quotaFor(req.Tenant).MaxSchedules, db.CountSchedules); err != nil {
+        return nil, err
+    }
+    if err := db.CreateSchedule(req); err != nil {
+        return nil, storeError(err)
+    }
+    return req, nil
+}
+
+func (s *Server) ListSchedules(ctx context.Context, req *api.ListSchedulesRequest) (*api.ListSchedulesResponse, error) {
+    limit, offset, err := listPage(req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+    schedules, err := db.ListSchedules(tenantOf(ctx), req.ProcessId, offset, limit)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    return &api.ListSchedulesResponse{
+        Schedules:     schedules,
+        NextPageToken: nextPageToken(offset, limit, len(schedules)),
+    }, nil
+}
+
+func (s *Server) PauseSchedule(ctx context.Context, req *api.ScheduleRequest) (*api.Schedule, error) {
+    schedule, err := db.SetSchedulePaused(tenantOf(ctx), req.ScheduleId, true, nil)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    return schedule, nil
+}
+
+// ResumeSchedule restarts a paused schedule from now; runs missed while it
+// was paused are not caught up.
+func (s *Server) ResumeSchedule(ctx context.Context, req *api.ScheduleRequest) (*api.Schedule, error) {
+    tenant := tenantOf(ctx)
+    schedule, err := db.GetSchedule(tenant, req.ScheduleId)
+    if err != nil {
+        return nil, storeError(err)
+    }
+    next, err := nextRunTime(schedule, time.Now())
+    if err != nil {
+        return nil, status.Error(codes.FailedPrecondition, err.Error())
+    }
+    schedule, err = db.SetSchedulePaused(tenant, req.ScheduleId, false, timestamppb.New(next))
+    if err != nil {
+        return nil, storeError(err)
+    }
+    return schedule, nil
+}
+
+func (s *Server) DeleteSchedule(ctx context.Context, req *api.ScheduleRequest) (*emptypb.Empty, error) {
+    tenant := tenantOf(ctx)
+    if err := db.DeleteSchedule(tenant, req.ScheduleId); err != nil {
+        return nil, storeError(err)
+    }
+    s.mu.Lock()
+    delete(s.scheduled, qualifiedName(tenant, req.ScheduleId))
+    s.mu.Unlock()
+    return &emptypb.Empty{}, nil
+}
+
+// RunScheduler fires due schedules until ctx is done. Replicas compete for a
+// lease and only the holder fires, so each run starts a single instance.
+func (s *Server) RunScheduler(ctx context.Context, replicaId string) {
+    ticker := time.NewTicker(schedulerTick)
+    defer ticker.Stop()
+    leader := false
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        acquired, err := db.AcquireLease(schedulerLease, replicaId, schedulerLeaseTTL)
+        if err != nil {
+            log.Printf("Error acquiring scheduler lease: %v", err)
+            continue
+        }
+        if acquired != leader {
+            leader = acquired
+            log.Printf("Replica %s scheduler leader: %v", replicaId, leader)
+        }
+        if leader {
+            s.fireDueSchedules(ctx)
+        }
+    }
+}
+
+func (s *Server) fireDueSchedules(ctx context.Context) {
+    now := time.Now()
+    schedules, err := db.DueSchedules(now)
+    if err != nil {
+        log.Printf("Error loading due schedules: %v", err)
+        return
+    }
+    for _, schedule := range schedules {
+        runs, next, err := dueRuns(schedule, now)
+        if err != nil {
+            log.Printf("Error in schedule %s: %v", schedule.ScheduleId, err)
+            continue
+        }
+        // The process is read from the store, which every replica sees, before
+        // the schedule is advanced past its runs
+        process, err := db.GetProcess(schedule.Tenant, schedule.ProcessId)
+        if err != nil {
+            log.Printf("Schedule %s: error loading process %s: %v", schedule.ScheduleId, schedule.ProcessId, err)
+            continue
+        }
+        // Advancing before firing keeps a run from firing twice when the
+        // lease changes hands
+        advanced, err := db.AdvanceSchedule(schedule.Tenant, schedule.ScheduleId, schedule.NextRunTime, timestamppb.New(next))
+        if err != nil {
+            log.Printf("Error advancing schedule %s: %v", schedule.ScheduleId, err)
+            continue
+        }
+        if !advanced {
+            continue
+        }
+        go s.fireRuns(ctx, schedule, process, runs, next)
+    }
+}
+
+// fireRuns starts the runs of schedule in order. When one cannot start, the
+// schedule is moved back from next to that run, so it fires again on a later
+// tick, possibly on another replica.
+func (s *Server) fireRuns(ctx context.Context, schedule *api.Schedule, process *api.Process, runs []time.Time, next time.Time) {
+    for _, run := range runs {
+        err := s.fireSchedule(ctx, schedule, process, run)
+        if err == nil {
+            continue
+        }
+        log.Printf("Schedule %s: error starting run at %s: %v", schedule.ScheduleId, run, err)
+        rewound, err := db.AdvanceSchedule(schedule.Tenant, schedule.ScheduleId, timestamppb.New(next), timestamppb.New(run))
+        if err != nil {
+            log.Printf("Error moving schedule %s back to %s: %v", schedule.ScheduleId, run, err)
+        } else if !rewound {
+            log.Printf("Schedule %s: not retrying run at %s, the schedule has changed", schedule.ScheduleId, run)
+        }
+        return
+    }
+}
+
+// dueRuns returns the runs of schedule due by now, which is only the latest
+// unless the schedule catches up on missed runs, and the first run after now.
+func dueRuns(schedule *api.Schedule, now time.Time) ([]time.Time, time.Time, error) {
+    var runs []time.Time
+    due := schedule.NextRunTime.AsTime()
+    for !due.After(now) {
+        runs = append(runs, due)
+        if len(runs) > maxCatchUpRuns {
+            runs = runs[1:]
+        }
+        var err error
+        if due, err = nextRunTime(schedule, due); err != nil {
+            return nil, time.Time{}, err
+        }
+    }
+    if !schedule.CatchUp && len(runs) > 1 {
+        runs = runs[len(runs)-1:]
+    }
+    return runs, due, nil
+}
+
+// fireSchedule starts the run of schedule at runTime, unless its overlap
+// policy skips it. It returns an error when the run could not start but may on
+// a later try; runs rejected for good, such as for an invalid input, are
+// only logged.
+func (s *Server) fireSchedule(ctx context.Context, schedule *api.Schedule, process *api.Process, runTime time.Time) error {
+    if schedule.JitterSeconds > 0 {
+        jitter := time.Duration(rand.Int63n(int64(schedule.JitterSeconds) * int64(time.Second)))
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(jitter):
+        }
+    }
+
+    s.mu.Lock()
+    runs, ok := s.scheduled[qualifiedName(schedule.Tenant, schedule.ScheduleId)]
+    if !ok {
+        runs = &scheduledRuns{}
+        s.scheduled[qualifiedName(schedule.Tenant, schedule.ScheduleId)] = runs
+    }
+    s.mu.Unlock()
+
+    runs.mu.Lock()
+    defer runs.mu.Unlock()
+    switch schedule.OverlapPolicy {
+    case OverlapQueue:
+        if !s.awaitPrevious(ctx, schedule, runs.last) {
+            return ctx.Err()
+        }
+    case OverlapCancelPrevious:
+        if runs.last != nil && !s.isFenced(runs.last) {
+            runs.last.cancel()
+        } else if s.previousActive(schedule, nil) {
+            // Only the replica running an instance can cancel it
+            log.Printf("Schedule %s: skipping run at %s, previous instance %s runs on another replica", schedule.ScheduleId, runTime, schedule.LastInstanceId)
+            return nil
+        }
+    default:
+        if s.previousActive(schedule, runs.last) {
+            log.Printf("Schedule %s: skipping run at %s, previous instance still queued or running", schedule.ScheduleId, runTime)
+            return nil
+        }
+    }
+
+    businessKeys := map[string]string{"scheduleId": schedule.ScheduleId}
+    for key, value := range schedule.BusinessKeys {
+        businessKeys[key] = value
+    }
+    var input interface{}
+    if schedule.Input != nil {
+        input = schedule.Input.AsMap()
+    }
+    inst, err := s.runProcess(context.Background(), process, businessKeys, input)
+    switch status.Code(err) {
+    case codes.OK:
+    case codes.InvalidArgument, codes.AlreadyExists:
+        log.Printf("Schedule %s: error starting %s: %v", schedule.ScheduleId, process.Name, err)
+        return nil
+    default:
+        return err
+    }
+    runs.last = inst
+    schedule.LastInstanceId = inst.id
+    if err := db.RecordScheduleRun(schedule.Tenant, schedule.ScheduleId, timestamppb.New(runTime), inst.id); err != nil {
+        log.Printf("Error recording run of schedule %s: %v", schedule.ScheduleId, err)
+    }
+    return nil
+}
+
+// previousActive reports whether the last instance of schedule is queued or
+// running, looking in the store when this replica does not run it.
+func (s *Server) previousActive(schedule *api.Schedule, last *instance) bool {
+    if last != nil && !s.isFenced(last) {
+        state, _ := s.instanceResult(last)
+        return state == InstanceQueued || state == InstanceRunning
+    }
+    if schedule.LastInstanceId == "" {
+        return false
+    }
+    record, err := db.GetInstance(schedule.Tenant, schedule.LastInstanceId)
+    return err == nil && (record.State == InstanceQueued || record.State == InstanceRunning)
+}
+
+// awaitPrevious waits for the last instance of schedule to finish. It returns
+// false if ctx is done first.
+func (s *Server) awaitPrevious(ctx context.Context, schedule *api.Schedule, last *instance) bool {
+    if last != nil {
+        select {
+        case <-last.done:
+        case <-ctx.Done():
+            return false
+        }
+        // An instance taken over by another replica is followed in the store
+        if !s.isFenced(last) {
+            return true
+        }
+    }
+    for s.previousActive(schedule, nil) {
+        select {
+        case <-time.After(queuePollInterval):
+        case <-ctx.Done():
+            return false
+        }
+    }
+    return true
+}
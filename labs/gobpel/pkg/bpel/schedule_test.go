@@ -0,0 +1,197 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDueRuns(t *testing.T) {
+    at := func(s string) time.Time {
+        t.Helper()
+        parsed, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return parsed
+    }
+    tests := []struct {
+        name     string
+        cron     string
+        timeZone string
+        catchUp  bool
+        next     string
+        now      string
+        runs     []string
+        nextRun  string
+    }{
+        {
+            name: "not due", cron: "0 * * * *",
+            next: "2024-03-01T10:00:00Z", now: "2024-03-01T09:59:59Z",
+            nextRun: "2024-03-01T10:00:00Z",
+        },
+        {
+            name: "due", cron: "0 * * * *",
+            next: "2024-03-01T10:00:00Z", now: "2024-03-01T10:00:00Z",
+            runs:    []string{"2024-03-01T10:00:00Z"},
+            nextRun: "2024-03-01T11:00:00Z",
+        },
+        {
+            name: "missed runs without catch up", cron: "0 * * * *",
+            next: "2024-03-01T10:00:00Z", now: "2024-03-01T12:30:00Z",
+            runs:    []string{"2024-03-01T12:00:00Z"},
+            nextRun: "2024-03-01T13:00:00Z",
+        },
+        {
+            name: "missed runs with catch up", cron: "0 * * * *", catchUp: true,
+            next: "2024-03-01T10:00:00Z", now: "2024-03-01T12:30:00Z",
+            runs:    []string{"2024-03-01T10:00:00Z", "2024-03-01T11:00:00Z", "2024-03-01T12:00:00Z"},
+            nextRun: "2024-03-01T13:00:00Z",
+        },
+        {
+            name: "time zone", cron: "0 9 * * *", timeZone: "Europe/Berlin",
+            next: "2024-03-01T08:00:00Z", now: "2024-03-01T08:00:00Z",
+            runs:    []string{"2024-03-01T08:00:00Z"},
+            nextRun: "2024-03-02T08:00:00Z",
+        },
+        {
+            name: "daylight saving time", cron: "0 9 * * *", timeZone: "Europe/Berlin", catchUp: true,
+            next: "2024-03-30T08:00:00Z", now: "2024-03-31T07:00:00Z",
+            runs:    []string{"2024-03-30T08:00:00Z", "2024-03-31T07:00:00Z"},
+            nextRun: "2024-04-01T07:00:00Z",
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            schedule := &api.Schedule{
+                Cron:        test.cron,
+                TimeZone:    test.timeZone,
+                CatchUp:     test.catchUp,
+                NextRunTime: timestamppb.New(at(test.next)),
+            }
+            runs, next, err := dueRuns(schedule, at(test.now))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(runs) != len(test.runs) {
+                t.Fatalf("got runs %v, want %v", runs, test.runs)
+            }
+            for i, run := range runs {
+                if !run.Equal(at(test.runs[i])) {
+                    t.Errorf("run %d: got %s, want %s", i, run, test.runs[i])
+                }
+            }
+            if !next.Equal(at(test.nextRun)) {
+                t.Errorf("got next run %s, want %s", next, test.nextRun)
+            }
+        })
+    }
+}
+
+func TestDueRunsCatchUpLimit(t *testing.T) {
+    start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+    schedule := &api.Schedule{Cron: "* * * * *", CatchUp: true, NextRunTime: timestamppb.New(start)}
+    now := start.Add(3 * maxCatchUpRuns * time.Minute)
+    runs, _, err := dueRuns(schedule, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(runs) != maxCatchUpRuns {
+        t.Fatalf("got %d runs, want %d", len(runs), maxCatchUpRuns)
+    }
+    if !runs[len(runs)-1].Equal(now) {
+        t.Errorf("got last run %s, want the latest %s", runs[len(runs)-1], now)
+    }
+}
+
+func TestDueRunsInvalid(t *testing.T) {
+    for _, schedule := range []*api.Schedule{
+        {Cron: "every hour"},
+        {Cron: "0 * * * *", TimeZone: "Mars/Olympus_Mons"},
+    } {
+        schedule.NextRunTime = timestamppb.New(time.Unix(0, 0))
+        if _, _, err := dueRuns(schedule, time.Now()); err == nil {
+            t.Errorf("%s in %q: got no error", schedule.Cron, schedule.TimeZone)
+        }
+    }
+}
+
+func TestPreviousActive(t *testing.T) {
+    s := NewServer()
+    schedule := &api.Schedule{ScheduleId: "nightly"}
+    for _, test := range []struct {
+        state string
+        want  bool
+    }{
+        {InstanceQueued, true},
+        {InstanceRunning, true},
+        {InstanceCompleted, false},
+        {InstanceCancelled, false},
+    } {
+        if got := s.previousActive(schedule, &instance{state: test.state}); got != test.want {
+            t.Errorf("%s: got %v, want %v", test.state, got, test.want)
+        }
+    }
+}
+
+func TestScheduleOverlapRemote(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    process := createTestProcess(t, s, tenant, "scheduled")
+
+    // The previous instance waits for a worker of another replica
+    previous := &api.Instance{InstanceId: newInstanceId(), Tenant: tenant, ProcessId: process.Name, State: InstanceQueued, Owner: tenant + "-b"}
+    if err := db.SaveInstance(previous); err != nil {
+        t.Fatal(err)
+    }
+    for _, policy := range []string{OverlapSkip, OverlapCancelPrevious} {
+        t.Run(policy, func(t *testing.T) {
+            schedule := &api.Schedule{ScheduleId: policy, Tenant: tenant, ProcessId: process.Name, OverlapPolicy: policy, LastInstanceId: previous.InstanceId}
+            if err := s.fireSchedule(context.Background(), schedule, process, time.Now()); err != nil {
+                t.Fatal(err)
+            }
+            if schedule.LastInstanceId != previous.InstanceId {
+                t.Errorf("started instance %s while the previous one is queued on another replica", schedule.LastInstanceId)
+            }
+        })
+    }
+}
+
+func TestDeleteScheduleForgetsRuns(t *testing.T) {
+    tenant := testTenant(t)
+    s := testServer(t, tenant, "a", echoPartner())
+    ctx := withTenant(context.Background(), tenant)
+    process := createTestProcess(t, s, tenant, "scheduled")
+    schedule, err := s.CreateSchedule(ctx, &api.Schedule{ProcessId: process.Name, Cron: "0 * * * *", Input: testInput(t, "x")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := s.fireSchedule(ctx, schedule, process, time.Now()); err != nil {
+        t.Fatal(err)
+    }
+    if schedule.LastInstanceId == "" {
+        t.Fatal("the run did not start")
+    }
+
+    name := qualifiedName(tenant, schedule.ScheduleId)
+    s.mu.Lock()
+    _, tracked := s.scheduled[name]
+    s.mu.Unlock()
+    if !tracked {
+        t.Fatal("the runs of the schedule are not tracked")
+    }
+    if _, err := s.DeleteSchedule(ctx, &api.ScheduleRequest{ScheduleId: schedule.ScheduleId}); err != nil {
+        t.Fatal(err)
+    }
+    s.mu.Lock()
+    _, tracked = s.scheduled[name]
+    s.mu.Unlock()
+    if tracked {
+        t.Error("the runs of a deleted schedule are still tracked")
+    }
+}
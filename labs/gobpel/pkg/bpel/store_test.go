@@ -0,0 +1,125 @@
+package bpel
+
+import (
+    "context"
+    "os"
+    "sync"
+    "testing"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+)
+
+var (
+    storeOnce sync.Once
+    storeErr  error
+)
+
+// testTenant connects to the MongoDB at GOBPEL_TEST_MONGO_URI and returns a
+// tenant of its own for the test, so that tests share the store without
+// seeing each other's records. Tests that need the store are skipped
+// without one.
+func testTenant(t *testing.T) string {
+    t.Helper()
+    uri := os.Getenv("GOBPEL_TEST_MONGO_URI")
+    if uri == "" {
+        t.Skip("GOBPEL_TEST_MONGO_URI is not set")
+    }
+    storeOnce.Do(func() {
+        storeErr = db.InitMongoDB(uri)
+    })
+    if storeErr != nil {
+        t.Fatal(storeErr)
+    }
+    return "test-" + newInstanceId()[:12]
+}
+
+// partnerFunc is a partner transport answering invokes with a function.
+type partnerFunc func(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error)
+
+func (f partnerFunc) Call(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+    return f(ctx, invoke, payload)
+}
+
+// testServer returns a server named replica whose invokes on the partner
+// link "partner" go to partner.
+func testServer(t *testing.T, tenant, replica string, partner Transport) *Server {
+    s := NewServer()
+    s.SetReplicaId(tenant + "-" + replica)
+    s.SetPartnerTransport("partner", partner)
+    return s
+}
+
+// testWorkflow invokes the partner once with its request input.
+const testWorkflow = `
+inputs: [request]
+steps:
+  - id: call
+    service: partner
+    operation: run
+    input: request
+    output: response
+outputs:
+  result: response
+`
+
+func createTestProcess(t *testing.T, s *Server, tenant, name string) *api.Process {
+    t.Helper()
+    process, err := s.CreateProcess(withTenant(context.Background(), tenant), &api.Process{
+        Name:             name,
+        DefinitionFormat: FormatYAML,
+        Definition:       "name: " + name + testWorkflow,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return process
+}
+
+// await waits for a channel to be closed or to deliver a value.
+func await[T any](t *testing.T, c <-chan T, what string) T {
+    t.Helper()
+    select {
+    case v := <-c:
+        return v
+    case <-time.After(10 * time.Second):
+        t.Fatalf("timed out waiting for %s", what)
+    }
+    var zero T
+    return zero
+}
+
+// awaitHistory waits for the stored history of an instance to end with an
+// event of type final and returns it.
+func awaitHistory(t *testing.T, tenant, instanceId, final string) []*api.InstanceEvent {
+    t.Helper()
+    deadline := time.Now().Add(10 * time.Second)
+    for {
+        history, err := instanceHistory(tenant, instanceId, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(history) > 0 && history[len(history)-1].Type == final {
+            return history
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s of instance %s", final, instanceId)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// countEvents counts the events of history by type, those of invokes on
+// the partner link "partner" as <type>/partner.
+func countEvents(history []*api.InstanceEvent) map[string]int {
+    counts := make(map[string]int)
+    for _, event := range history {
+        if event.PartnerLink != "" {
+            counts[event.Type+"/"+event.PartnerLink]++
+        } else {
+            counts[event.Type]++
+        }
+    }
+    return counts
+}
@@ -0,0 +1,90 @@
+package bpel
+
+import (
+    "context"
+
+    "gobpel/pkg/auth"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// TenantQuota limits what a tenant may keep and run. Zero is no limit.
+type TenantQuota struct {
+    MaxProcesses       int
+    MaxActiveInstances int
+    MaxSchedules       int
+}
+
+type tenantKey struct{}
+
+// withTenant returns ctx acting for tenant, for work such as schedules and
+// publications that runs without a caller.
+func withTenant(ctx context.Context, tenant string) context.Context {
+    return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantOf returns the tenant a request acts for: the tenant of the caller,
+// or the default tenant without one.
+func tenantOf(ctx context.Context) string {
+    if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+        return tenant
+    }
+    if identity, ok := auth.FromContext(ctx); ok && identity.Tenant != "" {
+        return identity.Tenant
+    }
+    return db.DefaultTenant
+}
+
+// qualifiedName names a process, schedule or publication of tenant among
+// those of every tenant. Names of the default tenant are left as they are.
+func qualifiedName(tenant, name string) string {
+    if tenant == db.DefaultTenant {
+        return name
+    }
+    return tenant + "/" + name
+}
+
+// SetTenantQuotas sets the quota of every tenant and the quotas that replace
+// it for some. Limits left at zero in quotas are taken from defaultQuota.
+func (s *Server) SetTenantQuotas(defaultQuota TenantQuota, quotas map[string]TenantQuota) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.defaultQuota = defaultQuota
+    s.quotas = quotas
+}
+
+func (s *Server) quotaFor(tenant string) TenantQuota {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    quota := s.defaultQuota
+    if q, ok := s.quotas[tenant]; ok {
+        if q.MaxProcesses != 0 {
+            quota.MaxProcesses = q.MaxProcesses
+        }
+        if q.MaxActiveInstances != 0 {
+            quota.MaxActiveInstances = q.MaxActiveInstances
+        }
+        if q.MaxSchedules != 0 {
+            quota.MaxSchedules = q.MaxSchedules
+        }
+    }
+    return quota
+}
+
+// checkQuota fails with ResourceExhausted when tenant already has limit of
+// what count counts.
+func checkQuota(tenant, what string, limit int, count func(tenant string) (int64, error)) error {
+    if limit <= 0 {
+        return nil
+    }
+    n, err := count(tenant)
+    if err != nil {
+        return err
+    }
+    if n >= int64(limit) {
+        return status.Errorf(codes.ResourceExhausted, "tenant %s has reached its quota of %d %s", tenant, limit, what)
+    }
+    return nil
+}
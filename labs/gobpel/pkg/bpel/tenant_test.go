@@ -0,0 +1,64 @@
+package bpel
+
+import (
+    "context"
+    "testing"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestTenantIsolation(t *testing.T) {
+    a, b := testTenant(t), testTenant(t)
+    s := testServer(t, a, "a", echoPartner())
+    ctxA, ctxB := withTenant(context.Background(), a), withTenant(context.Background(), b)
+    createTestProcess(t, s, a, "shared")
+    createTestProcess(t, s, b, "shared")
+
+    resp, err := s.ExecuteProcess(ctxA, &api.ExecuteProcessRequest{
+        ProcessId:    "shared",
+        Input:        testInput(t, "x"),
+        BusinessKeys: map[string]string{"farm": "42"},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The instance of a is not found by b under the same process name
+    _, err = s.GetProcessStatus(ctxB, &api.GetProcessStatusRequest{ProcessId: "shared", InstanceId: resp.InstanceId})
+    if status.Code(err) != codes.NotFound {
+        t.Errorf("got %v reading the status of another tenant's instance", err)
+    }
+    history, err := s.GetInstanceHistory(ctxB, &api.GetInstanceHistoryRequest{InstanceId: resp.InstanceId})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(history.Events) != 0 {
+        t.Errorf("b reads %d events of the instance of a", len(history.Events))
+    }
+    for _, test := range []struct {
+        ctx  context.Context
+        want int
+    }{{ctxA, 1}, {ctxB, 0}} {
+        list, err := s.ListInstances(test.ctx, &api.ListInstancesRequest{BusinessKeys: map[string]string{"farm": "42"}})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(list.Instances) != test.want {
+            t.Errorf("%s lists %d instances, want %d", tenantOf(test.ctx), len(list.Instances), test.want)
+        }
+    }
+
+    if _, err := s.DeleteAllProcesses(ctxB, &emptypb.Empty{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.GetProcess(ctxA, &api.GetProcessRequest{ProcessId: "shared"}); err != nil {
+        t.Errorf("the process of a is gone with those of b: %v", err)
+    }
+    if _, err := s.GetProcess(ctxB, &api.GetProcessRequest{ProcessId: "shared"}); status.Code(err) != codes.NotFound {
+        t.Errorf("got %v for a deleted process", err)
+    }
+}
@@ -0,0 +1,117 @@
+package bpel
+
+import (
+    "context"
+    "errors"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+    "google.golang.org/grpc/metadata"
+)
+
+// Instances, activities and partner calls are traced with the global tracer
+// provider, which records nothing until one is set. Partners receive the
+// W3C trace context of their call.
+var tracer = otel.Tracer("gobpel/pkg/bpel")
+
+// traceContext carries the span of an instance across replicas in the
+// traceParent of the instance.
+var traceContext = propagation.TraceContext{}
+
+// traceInstance starts the span of inst, a child of the span in ctx or,
+// for an instance taken over, of the span of its previous owner.
+func traceInstance(ctx context.Context, inst *instance, traceParent string) {
+    if traceParent != "" {
+        ctx = traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+    }
+    ctx, inst.span = tracer.Start(ctx, "instance "+inst.processId, trace.WithAttributes(
+        attribute.String("gobpel.tenant", inst.tenant),
+        attribute.String("gobpel.process", inst.processId),
+        attribute.String("gobpel.process_version", inst.processVersion),
+        attribute.String("gobpel.instance_id", inst.id),
+        attribute.Bool("gobpel.resumed", traceParent != ""),
+    ))
+    carrier := propagation.MapCarrier{}
+    traceContext.Inject(ctx, carrier)
+    inst.traceParent = carrier["traceparent"]
+}
+
+// runContext returns the context the activities of inst run in, carrying
+// the span of the instance but not the deadline of the call that started it.
+func (inst *instance) runContext() (context.Context, context.CancelFunc) {
+    return context.WithCancel(trace.ContextWithSpan(context.Background(), inst.span))
+}
+
+// endInstanceSpan ends the span of inst, which stopped in state.
+func endInstanceSpan(inst *instance, state string) {
+    if inst.span == nil {
+        return
+    }
+    inst.span.SetAttributes(attribute.String("gobpel.state", state))
+    if state == InstanceFaulted {
+        inst.span.SetStatus(codes.Error, "instance faulted")
+    }
+    inst.span.End()
+}
+
+// traceId is the id of the trace of inst, or "" when it is not traced.
+func (inst *instance) traceId() string {
+    if inst.span == nil || !inst.span.SpanContext().HasTraceID() {
+        return ""
+    }
+    return inst.span.SpanContext().TraceID().String()
+}
+
+// startActivitySpan starts the span of an activity of the tree.
+func startActivitySpan(ctx context.Context, a Activity) (context.Context, trace.Span) {
+    kind, name := a.Kind(), activityName(a)
+    spanName := kind
+    if name != "" {
+        spanName += " " + name
+    }
+    attributes := []attribute.KeyValue{
+        attribute.String("gobpel.activity.kind", kind),
+        attribute.String("gobpel.activity.name", name),
+    }
+    if a.Invoke != nil {
+        attributes = append(attributes,
+            attribute.String("gobpel.partner_link", a.Invoke.PartnerLink),
+            attribute.String("gobpel.operation", a.Invoke.Operation),
+        )
+    }
+    return tracer.Start(ctx, spanName, trace.WithAttributes(attributes...))
+}
+
+// startPartnerSpan starts the span of a call to the partner of invoke.
+func startPartnerSpan(ctx context.Context, invoke Invoke) (context.Context, trace.Span) {
+    return tracer.Start(ctx, invoke.PartnerLink+"/"+invoke.Operation,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            attribute.String("gobpel.partner_link", invoke.PartnerLink),
+            attribute.String("gobpel.operation", invoke.Operation),
+        ))
+}
+
+// endSpan ends span, marking it failed with err. Activities interrupted by
+// a shutdown are not failures.
+func endSpan(span trace.Span, err error) {
+    if err != nil && !errors.Is(err, errSuspended) {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// outgoingTraceContext adds the trace context of ctx to the gRPC metadata
+// sent with a call.
+func outgoingTraceContext(ctx context.Context) context.Context {
+    carrier := propagation.MapCarrier{}
+    otel.GetTextMapPropagator().Inject(ctx, carrier)
+    for key, value := range carrier {
+        ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+    }
+    return ctx
+}
@@ -0,0 +1,105 @@
+package bpel
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "gobpel/api"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+)
+
+var (
+    recorderOnce sync.Once
+    recorder     *tracetest.SpanRecorder
+)
+
+// spanRecorder records the spans of the global tracer provider. The
+// provider is set once, as tracers obtained before delegate to the first.
+func spanRecorder() *tracetest.SpanRecorder {
+    recorderOnce.Do(func() {
+        recorder = tracetest.NewSpanRecorder()
+        otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+        otel.SetTextMapPropagator(propagation.TraceContext{})
+    })
+    return recorder
+}
+
+// endedSpan waits for the span called name of trace to end.
+func endedSpan(t *testing.T, traceId trace.TraceID, name string) sdktrace.ReadOnlySpan {
+    t.Helper()
+    deadline := time.Now().Add(10 * time.Second)
+    for time.Now().Before(deadline) {
+        for _, span := range spanRecorder().Ended() {
+            if span.SpanContext().TraceID() == traceId && span.Name() == name {
+                return span
+            }
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("span %s was not ended", name)
+    return nil
+}
+
+func TestTracing(t *testing.T) {
+    tenant := testTenant(t)
+    spanRecorder()
+    traceParents := make(chan string, 1)
+    partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        traceParents <- r.Header.Get("traceparent")
+        body, _ := io.ReadAll(r.Body)
+        w.Write(body)
+    }))
+    defer partner.Close()
+    s := testServer(t, tenant, "a", NewHTTPTransport(partner.URL, nil, nil, nil))
+    createTestProcess(t, s, tenant, "traced")
+
+    // The span of the RPC that starts the instance
+    ctx, rpc := otel.Tracer("test").Start(withTenant(context.Background(), tenant), "ExecuteProcess")
+    resp, err := s.ExecuteProcess(ctx, &api.ExecuteProcessRequest{ProcessId: "traced", Input: testInput(t, "x")})
+    rpc.End()
+    if err != nil {
+        t.Fatal(err)
+    }
+    traceId := rpc.SpanContext().TraceID()
+
+    instance := endedSpan(t, traceId, "instance traced")
+    call := endedSpan(t, traceId, "partner/run")
+    if instance.Parent().SpanID() != rpc.SpanContext().SpanID() {
+        t.Error("the instance span is not a child of the RPC span")
+    }
+    if call.SpanKind() != trace.SpanKindClient {
+        t.Errorf("got partner span kind %s", call.SpanKind())
+    }
+    var state string
+    for _, attribute := range instance.Attributes() {
+        if attribute.Key == "gobpel.state" {
+            state = attribute.Value.AsString()
+        }
+    }
+    if state != InstanceCompleted {
+        t.Errorf("got instance span state %q", state)
+    }
+
+    // The partner continues the trace from the partner span
+    traceParent := await(t, traceParents, "the partner call")
+    if want := call.SpanContext().TraceID().String() + "-" + call.SpanContext().SpanID().String(); !strings.Contains(traceParent, want) {
+        t.Errorf("got traceparent %q, want it to carry %s", traceParent, want)
+    }
+
+    for _, event := range awaitHistory(t, tenant, resp.InstanceId, EventInstanceCompleted) {
+        if event.TraceId != traceId.String() {
+            t.Errorf("%s event has trace id %q, want %s", event.Type, event.TraceId, traceId)
+        }
+    }
+}
@@ -0,0 +1,112 @@
+package bpel
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "gobpel/pkg/secrets"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+)
+
+// Transport delivers an invoke payload to a partner and returns its response.
+type Transport interface {
+    Call(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error)
+}
+
+// httpTransport posts the payload to <baseURL>/<operation>, where baseURL
+// defaults to http://<partnerLink>.
+type httpTransport struct {
+    baseURL string
+    headers map[string]string
+    secrets secrets.Provider
+    client  *http.Client
+}
+
+// NewHTTPTransport returns a transport posting invokes to baseURL with the
+// given extra request headers. References to secrets in header values are
+// resolved from provider on every invoke, so values are never stored.
+// HTTPS connections use tlsConfig when it is set.
+func NewHTTPTransport(baseURL string, headers map[string]string, provider secrets.Provider, tlsConfig *tls.Config) Transport {
+    t := &httpTransport{baseURL: strings.TrimSuffix(baseURL, "/"), headers: headers, secrets: provider}
+    if tlsConfig != nil {
+        t.client = &http.Client{Transport: &http.Transport{
+            Proxy:             http.ProxyFromEnvironment,
+            TLSClientConfig:   tlsConfig,
+            ForceAttemptHTTP2: true,
+            IdleConnTimeout:   90 * time.Second,
+        }}
+    }
+    return t
+}
+
+func (t httpTransport) Call(ctx context.Context, invoke Invoke, payload []byte) ([]byte, error) {
+    baseURL := t.baseURL
+    if baseURL == "" {
+        baseURL = "http://" + invoke.PartnerLink
+    }
+    url := baseURL + "/" + invoke.Operation
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+    if err != nil {
+        return nil, err
+    }
+    for name, value := range t.headers {
+        value, err := secrets.Expand(ctx, t.secrets, value)
+        if err != nil {
+            return nil, fmt.Errorf("header %s: %v", name, err)
+        }
+        req.Header.Set(name, value)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+    client := t.client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode >= http.StatusBadRequest {
+        return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+    }
+    return body, nil
+}
+
+// Close drops the idle connections of a transport with its own client.
+func (t httpTransport) Close() error {
+    if t.client != nil {
+        t.client.CloseIdleConnections()
+    }
+    return nil
+}
+
+// SetPartnerTransport routes invokes on partnerLink through t instead of HTTP.
+func (s *Server) SetPartnerTransport(partnerLink string, t Transport) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.transports[partnerLink] = t
+}
+
+func (s *Server) transportFor(partnerLink string) Transport {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if t, ok := s.transports[partnerLink]; ok {
+        return t
+    }
+    return httpTransport{}
+}
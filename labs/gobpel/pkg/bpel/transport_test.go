@@ -0,0 +1,91 @@
+package bpel
+
+import (
+    "context"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "gobpel/pkg/secrets"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/metadata"
+)
+
+type secretMap map[string]string
+
+func (m secretMap) Secret(ctx context.Context, name string) (string, error) {
+    value, ok := m[name]
+    if !ok {
+        return "", secrets.ErrNotFound
+    }
+    return value, nil
+}
+
+var (
+    testHeaders = map[string]string{"Authorization": "Bearer ${secret:api-key}"}
+    testSecrets = secretMap{"api-key": "sk-123"}
+)
+
+func TestHTTPTransportHeaders(t *testing.T) {
+    var got http.Header
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r.Header
+        body, _ := io.ReadAll(r.Body)
+        w.Write(body)
+    }))
+    defer server.Close()
+
+    invoke := Invoke{PartnerLink: "partner", Operation: "run"}
+    resp, err := NewHTTPTransport(server.URL, testHeaders, testSecrets, nil).Call(context.Background(), invoke, []byte(`{"x":1}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(resp) != `{"x":1}` || got.Get("Authorization") != "Bearer sk-123" {
+        t.Errorf("got %s with Authorization %q", resp, got.Get("Authorization"))
+    }
+
+    _, err = NewHTTPTransport(server.URL, testHeaders, secretMap{}, nil).Call(context.Background(), invoke, nil)
+    if err == nil || !strings.Contains(err.Error(), "secret api-key") {
+        t.Errorf("got %v without the secret", err)
+    }
+}
+
+func TestGRPCTransportHeaders(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var method string
+    var got metadata.MD
+    server := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+        method, _ = grpc.MethodFromServerStream(stream)
+        got, _ = metadata.FromIncomingContext(stream.Context())
+        var payload []byte
+        if err := stream.RecvMsg(&payload); err != nil {
+            return err
+        }
+        return stream.SendMsg(payload)
+    }))
+    go server.Serve(listener)
+    defer server.Stop()
+
+    transport, err := NewGRPCTransport(listener.Addr().String(), "trainer", testHeaders, testSecrets, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer transport.(io.Closer).Close()
+    resp, err := transport.Call(context.Background(), Invoke{PartnerLink: "partner", Operation: "Train"}, []byte(`{"x":1}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(resp) != `{"x":1}` || method != "/trainer/Train" {
+        t.Errorf("got %s from %s", resp, method)
+    }
+    if values := got.Get("authorization"); len(values) != 1 || values[0] != "Bearer sk-123" {
+        t.Errorf("got authorization metadata %q", values)
+    }
+}
@@ -0,0 +1,238 @@
+package bpel
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+
+    "gobpel/api"
+    "gobpel/pkg/db"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// journalSize is how many recent events are kept for watchers to resume from.
+const journalSize = 10000
+
+// watchPollInterval is how often the history of an instance another replica
+// runs is read for new events.
+const watchPollInterval = time.Second
+
+// watcherBuffer is how many events a watcher may lag behind before it is
+// disconnected and has to resume.
+const watcherBuffer = 256
+
+// journal sequences instance events and fans them out to watchers.
+type journal struct {
+    mu       sync.Mutex
+    sequence int64
+    events   []*api.InstanceEvent
+    watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+    match  func(event *api.InstanceEvent) bool
+    events chan *api.InstanceEvent
+}
+
+func newJournal() *journal {
+    return &journal{watchers: make(map[*watcher]struct{})}
+}
+
+func (j *journal) append(event *api.InstanceEvent) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.sequence++
+    event.Sequence = j.sequence
+    j.events = append(j.events, event)
+    if len(j.events) > journalSize {
+        j.events = j.events[len(j.events)-journalSize:]
+    }
+
+    for w := range j.watchers {
+        if !w.match(event) {
+            continue
+        }
+        select {
+        case w.events <- event:
+        default:
+            // The watcher fell behind; closing lets it report where to resume
+            close(w.events)
+            delete(j.watchers, w)
+        }
+    }
+}
+
+// advance makes later events follow sequence, e.g. the last event of an
+// instance taken over from another replica.
+func (j *journal) advance(sequence int64) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if sequence > j.sequence {
+        j.sequence = sequence
+    }
+}
+
+// watch replays journaled events after afterSequence that match and then
+// follows new ones. It fails if events after afterSequence were discarded,
+// unless afterSequence is 0, which replays the events still journaled.
+func (j *journal) watch(afterSequence int64, match func(event *api.InstanceEvent) bool) (*watcher, []*api.InstanceEvent, error) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if afterSequence > 0 && len(j.events) > 0 && afterSequence < j.events[0].Sequence-1 {
+        return nil, nil, status.Errorf(codes.OutOfRange, "events after sequence %d are no longer available", afterSequence)
+    }
+    w, backlog := j.follow(afterSequence, match)
+    return w, backlog, nil
+}
+
+// follow is watch for callers that replay discarded events from the store.
+// j.mu must be held.
+func (j *journal) follow(afterSequence int64, match func(event *api.InstanceEvent) bool) (*watcher, []*api.InstanceEvent) {
+    var backlog []*api.InstanceEvent
+    for _, event := range j.events {
+        if event.Sequence > afterSequence && match(event) {
+            backlog = append(backlog, event)
+        }
+    }
+    w := &watcher{match: match, events: make(chan *api.InstanceEvent, watcherBuffer)}
+    j.watchers[w] = struct{}{}
+    return w, backlog
+}
+
+func (j *journal) unwatch(w *watcher) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    delete(j.watchers, w)
+}
+
+type eventStream interface {
+    Send(*api.InstanceEvent) error
+}
+
+// stream sends the backlog and then live events until the client goes away,
+// or until done reports that the watched subject is finished.
+func (j *journal) stream(w *watcher, backlog []*api.InstanceEvent, send eventStream, done <-chan struct{}, last func(event *api.InstanceEvent) bool) error {
+    defer j.unwatch(w)
+    sequence := int64(0)
+    for _, event := range backlog {
+        if event.Sequence <= sequence {
+            continue
+        }
+        if err := send.Send(event); err != nil {
+            return err
+        }
+        sequence = event.Sequence
+        if last(event) {
+            return nil
+        }
+    }
+    for {
+        select {
+        case <-done:
+            return nil
+        case event, ok := <-w.events:
+            if !ok {
+                return status.Errorf(codes.ResourceExhausted, "watcher fell behind; resume after sequence %d", sequence)
+            }
+            if event.Sequence <= sequence {
+                continue
+            }
+            if err := send.Send(event); err != nil {
+                return err
+            }
+            sequence = event.Sequence
+            if last(event) {
+                return nil
+            }
+        }
+    }
+}
+
+// WatchInstance replays the stored history of an instance and then follows
+// its new events, until it is finished. Instances this replica does not run
+// are followed in the store.
+func (s *Server) WatchInstance(req *api.WatchInstanceRequest, stream api.BPELProcessService_WatchInstanceServer) error {
+    tenant := tenantOf(stream.Context())
+    send := redactingStream{s, stream}
+    s.mu.Lock()
+    inst, exists := s.instances[req.InstanceId]
+    s.mu.Unlock()
+    if !exists || inst.tenant != tenant || s.isFenced(inst) {
+        if _, err := db.GetInstance(tenant, req.InstanceId); err != nil {
+            return status.Errorf(codes.NotFound, "instance %s not found", req.InstanceId)
+        }
+        return s.watchStored(stream.Context(), tenant, req.InstanceId, req.AfterSequence, send)
+    }
+
+    // The watcher is registered before the history is read, so events
+    // recorded in between are either stored or journaled
+    s.journal.mu.Lock()
+    w, backlog := s.journal.follow(req.AfterSequence, func(event *api.InstanceEvent) bool {
+        return event.Tenant == tenant && event.InstanceId == req.InstanceId
+    })
+    s.journal.mu.Unlock()
+    history, err := instanceHistory(tenant, req.InstanceId, req.AfterSequence)
+    if err != nil {
+        s.journal.unwatch(w)
+        return storeError(err)
+    }
+    backlog = append(history, backlog...)
+    sort.SliceStable(backlog, func(i, j int) bool { return backlog[i].Sequence < backlog[j].Sequence })
+    return s.journal.stream(w, backlog, send, stream.Context().Done(), finalEvent)
+}
+
+// watchStored streams the stored history of an instance run by another
+// replica, or no longer run, polling for new events until it is finished.
+func (s *Server) watchStored(ctx context.Context, tenant, instanceId string, afterSequence int64, send eventStream) error {
+    for {
+        // The state is read first, so the events of a finished instance are
+        // all stored when it is read
+        record, err := db.GetInstance(tenant, instanceId)
+        if err != nil {
+            return storeError(err)
+        }
+        events, err := instanceHistory(tenant, instanceId, afterSequence)
+        if err != nil {
+            return storeError(err)
+        }
+        for _, event := range events {
+            if err := send.Send(event); err != nil {
+                return err
+            }
+            afterSequence = event.Sequence
+            if finalEvent(event) {
+                return nil
+            }
+        }
+        switch record.State {
+        case InstanceCompleted, InstanceFaulted, InstanceCancelled:
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-time.After(watchPollInterval):
+        }
+    }
+}
+
+// finalEvent reports whether event is the last of its instance.
+func finalEvent(event *api.InstanceEvent) bool {
+    return event.Type == EventInstanceCompleted || event.Type == EventInstanceFaulted || event.Type == EventInstanceCancelled
+}
+
+func (s *Server) WatchProcess(req *api.WatchProcessRequest, stream api.BPELProcessService_WatchProcessServer) error {
+    tenant := tenantOf(stream.Context())
+    w, backlog, err := s.journal.watch(req.AfterSequence, func(event *api.InstanceEvent) bool {
+        return event.Tenant == tenant && event.ProcessId == req.ProcessId
+    })
+    if err != nil {
+        return err
+    }
+    return s.journal.stream(w, backlog, redactingStream{s, stream}, stream.Context().Done(), func(event *api.InstanceEvent) bool {
+        return false
+    })
+}
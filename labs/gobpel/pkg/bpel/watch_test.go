@@ -0,0 +1,78 @@
+package bpel
+
+import (
+    "testing"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+type sentEvents []*api.InstanceEvent
+
+func (s *sentEvents) Send(event *api.InstanceEvent) error {
+    *s = append(*s, event)
+    return nil
+}
+
+func TestJournalWatch(t *testing.T) {
+    j := newJournal()
+    for i := 0; i < journalSize+10; i++ {
+        j.append(&api.InstanceEvent{InstanceId: "a", Type: EventActivityStarted})
+    }
+    all := func(*api.InstanceEvent) bool { return true }
+
+    tests := []struct {
+        name          string
+        afterSequence int64
+        backlog       int
+        code          codes.Code
+    }{
+        {"from the start", 0, journalSize, codes.OK},
+        {"oldest journaled", 10, journalSize, codes.OK},
+        {"recent", journalSize + 5, 5, codes.OK},
+        {"discarded", 9, 0, codes.OutOfRange},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            w, backlog, err := j.watch(test.afterSequence, all)
+            if status.Code(err) != test.code {
+                t.Fatalf("got %v, want %s", err, test.code)
+            }
+            if err != nil {
+                return
+            }
+            defer j.unwatch(w)
+            if len(backlog) != test.backlog {
+                t.Errorf("got %d events, want %d", len(backlog), test.backlog)
+            }
+        })
+    }
+}
+
+func TestJournalStream(t *testing.T) {
+    j := newJournal()
+    match := func(event *api.InstanceEvent) bool { return event.InstanceId == "a" }
+    j.append(&api.InstanceEvent{InstanceId: "a", Type: EventInstanceStarted})
+    w, backlog, err := j.watch(0, match)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Stored events may repeat journaled ones
+    backlog = append([]*api.InstanceEvent{backlog[0]}, backlog...)
+    j.append(&api.InstanceEvent{InstanceId: "b", Type: EventInstanceStarted})
+    j.append(&api.InstanceEvent{InstanceId: "a", Type: EventInstanceCompleted})
+    j.append(&api.InstanceEvent{InstanceId: "a", Type: EventActivityStarted})
+
+    var sent sentEvents
+    if err := j.stream(w, backlog, &sent, make(chan struct{}), finalEvent); err != nil {
+        t.Fatal(err)
+    }
+    if len(sent) != 2 || sent[0].Sequence != 1 || sent[1].Sequence != 3 {
+        t.Errorf("got %v, want the events of a up to the last", sent)
+    }
+    if len(j.watchers) != 0 {
+        t.Errorf("watcher still registered")
+    }
+}
@@ -0,0 +1,309 @@
+package bpel
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Definition formats accepted in Process.definitionFormat. Workflows written
+// as YAML or JSON steps are compiled to the same activity tree as BPEL XML.
+const (
+    FormatBPEL = "bpel"
+    FormatYAML = "yaml"
+    FormatJSON = "json"
+)
+
+// Workflow is the step format described in research/GenAI-Pipeline-Orchestration.md.
+type Workflow struct {
+    Name            string            `yaml:"name" json:"name"`
+    TargetNamespace string            `yaml:"targetNamespace" json:"targetNamespace"`
+    Inputs          []string          `yaml:"inputs" json:"inputs"`
+    Outputs         map[string]string `yaml:"outputs" json:"outputs"`
+    Steps           []Step            `yaml:"steps" json:"steps"`
+    FaultHandlers   []FaultHandler    `yaml:"faultHandlers" json:"faultHandlers"`
+}
+
+// Step calls operation on service. Input is either a variable name, an
+// expression starting with "$" or a map whose values are one of those or
+// literals; the response is stored in the Output variable. Type is one of
+// stepTypes, "invoke" when it is empty.
+type Step struct {
+    Id            string         `yaml:"id" json:"id"`
+    Type          string         `yaml:"type" json:"type"`
+    Service       string         `yaml:"service" json:"service"`
+    Operation     string         `yaml:"operation" json:"operation"`
+    Input         interface{}    `yaml:"input" json:"input"`
+    Output        string         `yaml:"output" json:"output"`
+    When          string         `yaml:"when" json:"when"`
+    FaultHandlers []FaultHandler `yaml:"faultHandlers" json:"faultHandlers"`
+}
+
+// FaultHandler calls operation on service when a fault is raised. Type is
+// "catch", for the fault named in Fault, or "catchAll".
+type FaultHandler struct {
+    Id        string      `yaml:"id" json:"id"`
+    Type      string      `yaml:"type" json:"type"`
+    Fault     string      `yaml:"fault" json:"fault"`
+    Service   string      `yaml:"service" json:"service"`
+    Operation string      `yaml:"operation" json:"operation"`
+    Input     interface{} `yaml:"input" json:"input"`
+}
+
+// stepTypes are the types a step may have. Every type is compiled to an
+// invoke; the others name the kind of service invoked.
+var stepTypes = map[string]bool{
+    "invoke":             true,
+    "dataRetrieval":      true,
+    "dataPreProcessing":  true,
+    "featureEngineering": true,
+    "modelTraining":      true,
+    "modelEvaluation":    true,
+    "modelDeployment":    true,
+    "prompting":          true,
+    "rag":                true,
+}
+
+// CompileWorkflow parses a YAML or JSON workflow and compiles it to a process.
+func CompileWorkflow(definition []byte, format string) (*BPELProcess, error) {
+    workflow := Workflow{}
+    var err error
+    switch format {
+    case FormatYAML:
+        err = yaml.Unmarshal(definition, &workflow)
+    case FormatJSON:
+        err = json.Unmarshal(definition, &workflow)
+    default:
+        return nil, fmt.Errorf("unsupported definition format %q", format)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return workflow.Compile()
+}
+
+// workflowCompiler keeps the partner links and variables the compiled process declares.
+type workflowCompiler struct {
+    partnerLinks []PartnerLink
+    variables    []Variable
+    declared     map[string]bool
+}
+
+// Compile turns the workflow into an activity tree: each step becomes an
+// assign of its input followed by an invoke, wrapped in a scope when it has
+// fault handlers and in an if when it has a condition.
+func (w *Workflow) Compile() (*BPELProcess, error) {
+    if w.Name == "" {
+        return nil, errors.New("workflow name is required")
+    }
+    if len(w.Steps) == 0 {
+        return nil, errors.New("workflow has no steps")
+    }
+
+    c := &workflowCompiler{declared: make(map[string]bool)}
+    process := &BPELProcess{Name: w.Name, TargetNS: w.TargetNamespace}
+    if len(w.Inputs) > 0 {
+        // Inputs are the fields of the ExecuteProcess input
+        c.declare(InputVariable)
+        assign := &Assign{Name: "inputs"}
+        for _, input := range w.Inputs {
+            assign.Copies = append(assign.Copies, Copy{
+                From: From{Variable: InputVariable, Part: input},
+                To:   To{Variable: input},
+            })
+            c.declare(input)
+        }
+        process.Sequence.Activities = append(process.Sequence.Activities, Activity{Assign: assign})
+    }
+
+    ids := make(map[string]bool)
+    for i, step := range w.Steps {
+        if step.Id == "" {
+            return nil, fmt.Errorf("step %d: id is required", i+1)
+        }
+        if ids[step.Id] {
+            return nil, fmt.Errorf("step %s: duplicate id", step.Id)
+        }
+        ids[step.Id] = true
+
+        activity, err := c.step(step)
+        if err != nil {
+            return nil, fmt.Errorf("step %s: %v", step.Id, err)
+        }
+        process.Sequence.Activities = append(process.Sequence.Activities, activity)
+    }
+
+    if len(w.Outputs) > 0 {
+        names := make([]string, 0, len(w.Outputs))
+        for name := range w.Outputs {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        assign := &Assign{Name: "outputs"}
+        for _, name := range names {
+            assign.Copies = append(assign.Copies, Copy{
+                From: c.from(w.Outputs[name]),
+                To:   To{Variable: OutputVariable, Part: name},
+            })
+        }
+        c.declare(OutputVariable)
+        process.Sequence.Activities = append(process.Sequence.Activities, Activity{Assign: assign})
+    }
+
+    handlers, err := c.faultHandlers("process", w.FaultHandlers)
+    if err != nil {
+        return nil, err
+    }
+    process.FaultHandlers = handlers
+    process.PartnerLinks = c.partnerLinks
+    process.Variables = c.variables
+    return process, nil
+}
+
+func (c *workflowCompiler) step(step Step) (Activity, error) {
+    if step.Type != "" && !stepTypes[step.Type] {
+        return Activity{}, fmt.Errorf("unknown type %q", step.Type)
+    }
+    activities, err := c.call(step.Id, step.Service, step.Operation, step.Input)
+    if err != nil {
+        return Activity{}, err
+    }
+    if step.Output != "" {
+        activities[len(activities)-1].Invoke.OutputVar = step.Output
+        c.declare(step.Output)
+    }
+
+    activity := Activity{Sequence: &Sequence{Name: step.Id, Activities: activities}}
+    if len(step.FaultHandlers) > 0 {
+        handlers, err := c.faultHandlers(step.Id, step.FaultHandlers)
+        if err != nil {
+            return Activity{}, err
+        }
+        activity = Activity{Scope: &Scope{Name: step.Id, FaultHandlers: handlers, Activity: activity}}
+    }
+    if step.When != "" {
+        activity = Activity{If: &If{Name: step.Id, Condition: step.When, Activity: activity}}
+    }
+    return activity, nil
+}
+
+// call compiles an invoke named id together with the assign preparing its input.
+func (c *workflowCompiler) call(id, service, operation string, input interface{}) ([]Activity, error) {
+    if service == "" || operation == "" {
+        return nil, errors.New("service and operation are required")
+    }
+    c.partnerLink(service)
+
+    invoke := &Invoke{Name: id, PartnerLink: service, Operation: operation}
+    if name, ok := input.(string); ok && c.declared[name] {
+        invoke.InputVar = name
+        return []Activity{{Invoke: invoke}}, nil
+    }
+
+    invoke.InputVar = id + "Request"
+    c.declare(invoke.InputVar)
+    assign := &Assign{Name: id + "Input"}
+    switch value := input.(type) {
+    case map[string]interface{}:
+        keys := make([]string, 0, len(value))
+        for key := range value {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        if len(keys) == 0 {
+            assign.Copies = append(assign.Copies, Copy{From: literal(value), To: To{Variable: invoke.InputVar}})
+        }
+        for _, key := range keys {
+            assign.Copies = append(assign.Copies, Copy{
+                From: c.value(value[key]),
+                To:   To{Variable: invoke.InputVar, Part: key},
+            })
+        }
+    case nil:
+        assign.Copies = append(assign.Copies, Copy{From: literal(map[string]interface{}{}), To: To{Variable: invoke.InputVar}})
+    default:
+        assign.Copies = append(assign.Copies, Copy{From: c.value(value), To: To{Variable: invoke.InputVar}})
+    }
+    return []Activity{{Assign: assign}, {Invoke: invoke}}, nil
+}
+
+func (c *workflowCompiler) faultHandlers(scope string, handlers []FaultHandler) (*FaultHandlers, error) {
+    if len(handlers) == 0 {
+        return nil, nil
+    }
+    compiled := &FaultHandlers{}
+    for i, handler := range handlers {
+        id := handler.Id
+        if id == "" {
+            id = fmt.Sprintf("%sFaultHandler%d", scope, i+1)
+        }
+        activities, err := c.call(id, handler.Service, handler.Operation, handler.Input)
+        if err != nil {
+            return nil, fmt.Errorf("fault handler %s: %v", id, err)
+        }
+        activity := Activity{Sequence: &Sequence{Name: id, Activities: activities}}
+
+        switch handler.Type {
+        case "catch":
+            if handler.Fault == "" {
+                return nil, fmt.Errorf("fault handler %s: catch requires a fault", id)
+            }
+            compiled.Catches = append(compiled.Catches, Catch{FaultName: handler.Fault, Activity: activity})
+        case "catchAll", "":
+            if compiled.CatchAll != nil {
+                return nil, fmt.Errorf("fault handler %s: only one catchAll is allowed", id)
+            }
+            compiled.CatchAll = &Catch{Activity: activity}
+        default:
+            return nil, fmt.Errorf("fault handler %s: unknown type %q", id, handler.Type)
+        }
+    }
+    return compiled, nil
+}
+
+// from compiles a reference to a variable or an expression.
+func (c *workflowCompiler) from(ref string) From {
+    if c.declared[ref] {
+        return From{Variable: ref}
+    }
+    return From{Expression: ref}
+}
+
+// value compiles an input value: "$" expressions and names of variables are
+// evaluated, anything else is passed as a literal.
+func (c *workflowCompiler) value(value interface{}) From {
+    if s, ok := value.(string); ok && (strings.HasPrefix(s, "$") || c.declared[s]) {
+        return c.from(s)
+    }
+    return literal(value)
+}
+
+func literal(value interface{}) From {
+    data, err := json.Marshal(value)
+    if err != nil {
+        data = []byte(fmt.Sprint(value))
+    }
+    text := string(data)
+    return From{Literal: &text}
+}
+
+func (c *workflowCompiler) partnerLink(name string) {
+    for _, pl := range c.partnerLinks {
+        if pl.Name == name {
+            return
+        }
+    }
+    c.partnerLinks = append(c.partnerLinks, PartnerLink{Name: name})
+}
+
+func (c *workflowCompiler) declare(name string) {
+    if c.declared[name] {
+        return
+    }
+    c.declared[name] = true
+    c.variables = append(c.variables, Variable{Name: name})
+}
@@ -0,0 +1,141 @@
+package bpel
+
+import (
+    "strings"
+    "testing"
+)
+
+const evaluateAndDeploy = `
+name: EvaluateAndDeploy
+inputs: [dataset]
+steps:
+  - id: train
+    type: modelTraining
+    service: TrainingService
+    operation: trainModel
+    input:
+      data: dataset
+      epochs: 10
+    output: trainedModel
+  - id: deploy
+    service: DeploymentService
+    operation: deployModel
+    when: $trainedModel.accuracy >= 0.9
+    input: trainedModel
+    output: deploymentStatus
+    faultHandlers:
+      - type: catch
+        fault: invocationFailure
+        service: LogService
+        operation: logError
+outputs:
+  status: deploymentStatus
+faultHandlers:
+  - type: catchAll
+    service: LogService
+    operation: logError
+`
+
+func TestCompileWorkflow(t *testing.T) {
+    process, err := CompileWorkflow([]byte(evaluateAndDeploy), FormatYAML)
+    if err != nil {
+        t.Fatal(err)
+    }
+    activities := process.Sequence.Activities
+    if len(activities) != 4 {
+        t.Fatalf("got %d activities, want inputs, train, deploy and outputs", len(activities))
+    }
+
+    inputs := activities[0].Assign
+    if inputs == nil || len(inputs.Copies) != 1 || inputs.Copies[0].From.Part != "dataset" || inputs.Copies[0].To.Variable != "dataset" {
+        t.Errorf("inputs: got %+v", activities[0])
+    }
+
+    train := activities[1].Sequence
+    if train == nil || len(train.Activities) != 2 {
+        t.Fatalf("train: got %+v", activities[1])
+    }
+    assign, invoke := train.Activities[0].Assign, train.Activities[1].Invoke
+    if assign == nil || len(assign.Copies) != 2 {
+        t.Fatalf("train input: got %+v", train.Activities[0])
+    }
+    // Map keys are assigned in order; variables are copied, other values are literals
+    if c := assign.Copies[0]; c.To.Part != "data" || c.From.Variable != "dataset" {
+        t.Errorf("train input data: got %+v", c)
+    }
+    if c := assign.Copies[1]; c.To.Part != "epochs" || c.From.Literal == nil || *c.From.Literal != "10" {
+        t.Errorf("train input epochs: got %+v", c)
+    }
+    if invoke.PartnerLink != "TrainingService" || invoke.Operation != "trainModel" || invoke.InputVar != "trainRequest" || invoke.OutputVar != "trainedModel" {
+        t.Errorf("train invoke: got %+v", invoke)
+    }
+
+    deploy := activities[2].If
+    if deploy == nil || deploy.Condition != "$trainedModel.accuracy >= 0.9" {
+        t.Fatalf("deploy: got %+v", activities[2])
+    }
+    scope := deploy.Activity.Scope
+    if scope == nil || len(scope.FaultHandlers.Catches) != 1 || scope.FaultHandlers.Catches[0].FaultName != FaultInvocationFailure {
+        t.Fatalf("deploy scope: got %+v", deploy.Activity)
+    }
+    steps := scope.Activity.Sequence.Activities
+    if len(steps) != 1 || steps[0].Invoke.InputVar != "trainedModel" {
+        t.Errorf("deploy passes its input variable as is: got %+v", steps)
+    }
+
+    outputs := activities[3].Assign
+    if outputs == nil || outputs.Copies[0].From.Variable != "deploymentStatus" || outputs.Copies[0].To.Variable != OutputVariable {
+        t.Errorf("outputs: got %+v", activities[3])
+    }
+    if process.FaultHandlers == nil || process.FaultHandlers.CatchAll == nil {
+        t.Errorf("process fault handlers: got %+v", process.FaultHandlers)
+    }
+
+    var partnerLinks []string
+    for _, pl := range process.PartnerLinks {
+        partnerLinks = append(partnerLinks, pl.Name)
+    }
+    if got := strings.Join(partnerLinks, ","); got != "TrainingService,DeploymentService,LogService" {
+        t.Errorf("partner links: got %s", got)
+    }
+}
+
+func TestCompileWorkflowJSON(t *testing.T) {
+    definition := `{"name": "Prompt", "steps": [{"id": "ask", "type": "prompting", "service": "promptingservice", "operation": "prompt", "input": {"question": "$input.question"}}]}`
+    process, err := CompileWorkflow([]byte(definition), FormatJSON)
+    if err != nil {
+        t.Fatal(err)
+    }
+    assign := process.Sequence.Activities[0].Sequence.Activities[0].Assign
+    if assign.Copies[0].From.Expression != "$input.question" {
+        t.Errorf("got %+v", assign.Copies[0])
+    }
+}
+
+func TestCompileWorkflowErrors(t *testing.T) {
+    tests := []struct {
+        name       string
+        definition string
+        format     string
+        err        string
+    }{
+        {"format", "name: x", "toml", `unsupported definition format "toml"`},
+        {"name", "steps: [{id: a, service: s, operation: o}]", FormatYAML, "workflow name is required"},
+        {"no steps", "name: x", FormatYAML, "workflow has no steps"},
+        {"step id", "name: x\nsteps: [{service: s, operation: o}]", FormatYAML, "step 1: id is required"},
+        {"duplicate id", "name: x\nsteps: [{id: a, service: s, operation: o}, {id: a, service: s, operation: o}]", FormatYAML, "step a: duplicate id"},
+        {"service", "name: x\nsteps: [{id: a, operation: o}]", FormatYAML, "step a: service and operation are required"},
+        {"unknown type", "name: x\nsteps: [{id: a, type: modelTrainig, service: s, operation: o}]", FormatYAML, `step a: unknown type "modelTrainig"`},
+        {"catch without fault", "name: x\nsteps: [{id: a, service: s, operation: o, faultHandlers: [{type: catch, service: s, operation: o}]}]", FormatYAML, "catch requires a fault"},
+        {"two catchAll", "name: x\nsteps: [{id: a, service: s, operation: o}]\nfaultHandlers: [{service: s, operation: o}, {type: catchAll, service: s, operation: o}]", FormatYAML, "only one catchAll is allowed"},
+        {"handler type", "name: x\nsteps: [{id: a, service: s, operation: o}]\nfaultHandlers: [{type: finally, service: s, operation: o}]", FormatYAML, `unknown type "finally"`},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := CompileWorkflow([]byte(test.definition), test.format)
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
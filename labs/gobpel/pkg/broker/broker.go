@@ -0,0 +1,45 @@
+package broker
+
+import (
+    "context"
+    "errors"
+)
+
+var ErrNoResponders = errors.New("no responders for subject")
+
+// Broker is the message broker used for partner invocations and engine events.
+// Subjects are dot separated; subscriptions may use "*" to match a single
+// token and a trailing ">" to match the remaining tokens.
+type Broker interface {
+    Publish(ctx context.Context, subject string, data []byte) error
+    Request(ctx context.Context, subject string, data []byte) ([]byte, error)
+    Subscribe(subject string, handler Handler) (Subscription, error)
+    Close() error
+}
+
+// QueueSubscriber is implemented by brokers that can load balance a subject
+// across a named group of subscribers.
+type QueueSubscriber interface {
+    QueueSubscribe(subject, queue string, handler Handler) (Subscription, error)
+}
+
+type Handler func(msg *Message)
+
+type Subscription interface {
+    Unsubscribe() error
+}
+
+type Message struct {
+    Subject string
+    Data    []byte
+    respond func(data []byte) error
+}
+
+// Respond replies to a message sent with Request. It is a no-op for messages
+// that were published without expecting a reply.
+func (m *Message) Respond(data []byte) error {
+    if m.respond == nil {
+        return nil
+    }
+    return m.respond(data)
+}
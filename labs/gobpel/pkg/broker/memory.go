@@ -0,0 +1,147 @@
+package broker
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+)
+
+// memoryBroker is an in-process Broker for running the engine and its
+// partners locally without a NATS server.
+type memoryBroker struct {
+    mu     sync.Mutex
+    subs   map[*memorySubscription]struct{}
+    next   map[string]int
+    closed bool
+}
+
+type memorySubscription struct {
+    broker  *memoryBroker
+    subject string
+    queue   string
+    handler Handler
+}
+
+func NewMemory() Broker {
+    return &memoryBroker{
+        subs: make(map[*memorySubscription]struct{}),
+        next: make(map[string]int),
+    }
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, subject string, data []byte) error {
+    subs, err := b.match(subject)
+    if err != nil {
+        return err
+    }
+    for _, sub := range subs {
+        go sub.handler(&Message{Subject: subject, Data: data})
+    }
+    return nil
+}
+
+func (b *memoryBroker) Request(ctx context.Context, subject string, data []byte) ([]byte, error) {
+    subs, err := b.match(subject)
+    if err != nil {
+        return nil, err
+    }
+    if len(subs) == 0 {
+        return nil, ErrNoResponders
+    }
+
+    reply := make(chan []byte, len(subs))
+    for _, sub := range subs {
+        msg := &Message{Subject: subject, Data: data}
+        msg.respond = func(data []byte) error {
+            select {
+            case reply <- data:
+            default:
+            }
+            return nil
+        }
+        go sub.handler(msg)
+    }
+
+    select {
+    case data := <-reply:
+        return data, nil
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+func (b *memoryBroker) Subscribe(subject string, handler Handler) (Subscription, error) {
+    return b.QueueSubscribe(subject, "", handler)
+}
+
+func (b *memoryBroker) QueueSubscribe(subject, queue string, handler Handler) (Subscription, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.closed {
+        return nil, errors.New("broker closed")
+    }
+    sub := &memorySubscription{broker: b, subject: subject, queue: queue, handler: handler}
+    b.subs[sub] = struct{}{}
+    return sub, nil
+}
+
+func (b *memoryBroker) Close() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.closed = true
+    b.subs = make(map[*memorySubscription]struct{})
+    return nil
+}
+
+// match returns the subscriptions a message on subject is delivered to: every
+// plain subscription and one member of each queue group, chosen round robin.
+func (b *memoryBroker) match(subject string) ([]*memorySubscription, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.closed {
+        return nil, errors.New("broker closed")
+    }
+
+    var matched []*memorySubscription
+    groups := make(map[string][]*memorySubscription)
+    for sub := range b.subs {
+        if !subjectMatches(sub.subject, subject) {
+            continue
+        }
+        if sub.queue == "" {
+            matched = append(matched, sub)
+            continue
+        }
+        groups[sub.queue] = append(groups[sub.queue], sub)
+    }
+    for queue, members := range groups {
+        matched = append(matched, members[b.next[queue]%len(members)])
+        b.next[queue]++
+    }
+    return matched, nil
+}
+
+func (s *memorySubscription) Unsubscribe() error {
+    s.broker.mu.Lock()
+    defer s.broker.mu.Unlock()
+    delete(s.broker.subs, s)
+    return nil
+}
+
+func subjectMatches(pattern, subject string) bool {
+    patternTokens := strings.Split(pattern, ".")
+    subjectTokens := strings.Split(subject, ".")
+    for i, token := range patternTokens {
+        if token == ">" {
+            return i < len(subjectTokens)
+        }
+        if i >= len(subjectTokens) {
+            return false
+        }
+        if token != "*" && token != subjectTokens[i] {
+            return false
+        }
+    }
+    return len(patternTokens) == len(subjectTokens)
+}
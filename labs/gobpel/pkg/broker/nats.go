@@ -0,0 +1,58 @@
+package broker
+
+import (
+    "context"
+    "errors"
+
+    "github.com/nats-io/nats.go"
+)
+
+type natsBroker struct {
+    conn *nats.Conn
+}
+
+// NewNATS connects to the NATS server at url.
+func NewNATS(url string, opts ...nats.Option) (Broker, error) {
+    conn, err := nats.Connect(url, opts...)
+    if err != nil {
+        return nil, err
+    }
+    return &natsBroker{conn: conn}, nil
+}
+
+func (b *natsBroker) Publish(ctx context.Context, subject string, data []byte) error {
+    return b.conn.Publish(subject, data)
+}
+
+func (b *natsBroker) Request(ctx context.Context, subject string, data []byte) ([]byte, error) {
+    msg, err := b.conn.RequestWithContext(ctx, subject, data)
+    if err != nil {
+        if errors.Is(err, nats.ErrNoResponders) {
+            return nil, ErrNoResponders
+        }
+        return nil, err
+    }
+    return msg.Data, nil
+}
+
+func (b *natsBroker) Subscribe(subject string, handler Handler) (Subscription, error) {
+    return b.conn.Subscribe(subject, natsHandler(handler))
+}
+
+func (b *natsBroker) QueueSubscribe(subject, queue string, handler Handler) (Subscription, error) {
+    return b.conn.QueueSubscribe(subject, queue, natsHandler(handler))
+}
+
+func (b *natsBroker) Close() error {
+    return b.conn.Drain()
+}
+
+func natsHandler(handler Handler) nats.MsgHandler {
+    return func(msg *nats.Msg) {
+        m := &Message{Subject: msg.Subject, Data: msg.Data}
+        if msg.Reply != "" {
+            m.respond = msg.Respond
+        }
+        handler(m)
+    }
+}
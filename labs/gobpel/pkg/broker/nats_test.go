@@ -0,0 +1,183 @@
+package broker
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    natstest "github.com/nats-io/nats-server/v2/test"
+)
+
+// newTestNATS connects to a NATS server embedded in the test.
+func newTestNATS(t *testing.T) (Broker, string) {
+    t.Helper()
+    server := natstest.RunRandClientPortServer()
+    t.Cleanup(server.Shutdown)
+    b, err := NewNATS(server.ClientURL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { b.Close() })
+    return b, server.ClientURL()
+}
+
+func receive(t *testing.T, messages <-chan *Message) *Message {
+    t.Helper()
+    select {
+    case msg := <-messages:
+        return msg
+    case <-time.After(5 * time.Second):
+        t.Fatal("no message received")
+    }
+    return nil
+}
+
+func TestNATSPublishSubscribe(t *testing.T) {
+    b, _ := newTestNATS(t)
+    tests := []struct {
+        name      string
+        subscribe string
+        publish   string
+        match     bool
+    }{
+        {"subject", "gobpel.events.instanceCompleted", "gobpel.events.instanceCompleted", true},
+        {"token wildcard", "gobpel.events.*", "gobpel.events.instanceFaulted", true},
+        {"tail wildcard", "gobpel.tenants.ml-research.>", "gobpel.tenants.ml-research.events.instanceCompleted", true},
+        {"other tenant", "gobpel.tenants.ml-research.>", "gobpel.tenants.other.events.instanceCompleted", false},
+        {"token wildcard depth", "gobpel.events.*", "gobpel.events.a.b", false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            messages := make(chan *Message, 1)
+            sub, err := b.Subscribe(test.subscribe, func(msg *Message) { messages <- msg })
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer sub.Unsubscribe()
+            // The subscription reaches the server before the message
+            if err := b.(*natsBroker).conn.Flush(); err != nil {
+                t.Fatal(err)
+            }
+            if err := b.Publish(context.Background(), test.publish, []byte(`{"ok":true}`)); err != nil {
+                t.Fatal(err)
+            }
+            if !test.match {
+                select {
+                case msg := <-messages:
+                    t.Fatalf("got message on %s", msg.Subject)
+                case <-time.After(100 * time.Millisecond):
+                }
+                return
+            }
+            msg := receive(t, messages)
+            if msg.Subject != test.publish || string(msg.Data) != `{"ok":true}` {
+                t.Errorf("got %s %s", msg.Subject, msg.Data)
+            }
+            // Published messages expect no reply
+            if err := msg.Respond([]byte("ignored")); err != nil {
+                t.Error(err)
+            }
+        })
+    }
+}
+
+func TestNATSRequest(t *testing.T) {
+    b, _ := newTestNATS(t)
+    sub, err := b.Subscribe("gobpel.partners.ragservice.retrieve", func(msg *Message) {
+        msg.Respond(append([]byte("answer to "), msg.Data...))
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer sub.Unsubscribe()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    resp, err := b.Request(ctx, "gobpel.partners.ragservice.retrieve", []byte("question"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(resp) != "answer to question" {
+        t.Errorf("got %s", resp)
+    }
+
+    if _, err := b.Request(ctx, "gobpel.partners.missing.retrieve", nil); !errors.Is(err, ErrNoResponders) {
+        t.Errorf("got %v, want ErrNoResponders", err)
+    }
+}
+
+// TestNATSTriggerStart starts instances the way process triggers do: every
+// replica queue subscribes to the receive subject, one of them starts the
+// instance for each message and replies with its outcome.
+func TestNATSTriggerStart(t *testing.T) {
+    b, url := newTestNATS(t)
+    other, err := NewNATS(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer other.Close()
+
+    var started int32
+    var mu sync.Mutex
+    replicas := make(map[string]int)
+    subject := "gobpel.receive.client.start"
+    for name, replica := range map[string]Broker{"a": b, "b": other} {
+        name := name
+        sub, err := replica.(QueueSubscriber).QueueSubscribe(subject, "gobpel", func(msg *Message) {
+            atomic.AddInt32(&started, 1)
+            mu.Lock()
+            replicas[name]++
+            mu.Unlock()
+            msg.Respond([]byte(`{"status":"BPEL process executed successfully"}`))
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer sub.Unsubscribe()
+        if err := replica.(*natsBroker).conn.Flush(); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    const messages = 20
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    for i := 0; i < messages; i++ {
+        resp, err := b.Request(ctx, subject, []byte(`{"input":1}`))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(resp) != `{"status":"BPEL process executed successfully"}` {
+            t.Fatalf("got %s", resp)
+        }
+    }
+    if n := atomic.LoadInt32(&started); n != messages {
+        t.Errorf("started %d instances for %d messages", n, messages)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    if replicas["a"]+replicas["b"] != messages {
+        t.Errorf("got %v", replicas)
+    }
+}
+
+func TestNATSClose(t *testing.T) {
+    b, _ := newTestNATS(t)
+    if err := b.Close(); err != nil {
+        t.Fatal(err)
+    }
+    // Drain finishes in the background
+    deadline := time.Now().Add(5 * time.Second)
+    for !b.(*natsBroker).conn.IsClosed() {
+        if time.Now().After(deadline) {
+            t.Fatal("connection not closed")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if err := b.Publish(context.Background(), "gobpel.events.x", nil); err == nil {
+        t.Error("published after close")
+    }
+}
@@ -0,0 +1,230 @@
+package certs
+
+import (
+    "bytes"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// Files are checked for changes at most this often, when a handshake needs
+// them. Renewed certificates are picked up without a restart.
+const checkInterval = 10 * time.Second
+
+// Certificates is a certificate and a CA bundle loaded from files and
+// reloaded when the files change. Either may be absent.
+type Certificates struct {
+    certFile string
+    keyFile  string
+    caFile   string
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    pool    *x509.CertPool
+    version string
+    checked time.Time
+}
+
+// Load reads the certificate and key in certFile and keyFile and the CA
+// bundle in caFile. Empty names are left out.
+func Load(certFile, keyFile, caFile string) (*Certificates, error) {
+    c := &Certificates{certFile: certFile, keyFile: keyFile, caFile: caFile}
+    if err := c.Reload(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// Reload reads the files again if they changed. On error the certificates
+// loaded before are kept.
+func (c *Certificates) Reload() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.checked = time.Now()
+    version := c.fileVersion()
+    if version == c.version && (c.cert != nil || c.pool != nil) {
+        return nil
+    }
+
+    var cert *tls.Certificate
+    if c.certFile != "" {
+        loaded, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+        if err != nil {
+            return err
+        }
+        if loaded.Leaf, err = x509.ParseCertificate(loaded.Certificate[0]); err != nil {
+            return err
+        }
+        cert = &loaded
+    }
+    var pool *x509.CertPool
+    if c.caFile != "" {
+        pem, err := os.ReadFile(c.caFile)
+        if err != nil {
+            return err
+        }
+        pool = x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return fmt.Errorf("%s: no certificates found", c.caFile)
+        }
+    }
+    if c.version != "" {
+        log.Printf("Reloaded certificates %s %s", c.certFile, c.caFile)
+    }
+    c.cert, c.pool, c.version = cert, pool, version
+    return nil
+}
+
+// fileVersion identifies the current contents of the files by their
+// modification times and sizes.
+func (c *Certificates) fileVersion() string {
+    var version string
+    for _, name := range []string{c.certFile, c.keyFile, c.caFile} {
+        if name == "" {
+            continue
+        }
+        if info, err := os.Stat(name); err == nil {
+            version += fmt.Sprintf("%v/%d;", info.ModTime(), info.Size())
+        }
+    }
+    return version
+}
+
+// current returns the certificate and CA bundle, reloading them when the
+// files may have changed.
+func (c *Certificates) current() (*tls.Certificate, *x509.CertPool) {
+    c.mu.Lock()
+    stale := time.Since(c.checked) >= checkInterval
+    c.mu.Unlock()
+    if stale {
+        if err := c.Reload(); err != nil {
+            log.Printf("Error reloading certificates %s %s: %v", c.certFile, c.caFile, err)
+        }
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.cert, c.pool
+}
+
+// ServerConfig serves the certificate. With a CA bundle, client certificates
+// are verified against it, and required unless optional is set. The server
+// certificate itself is accepted as a client certificate so the server can
+// call itself, as the REST gateway does.
+func (c *Certificates) ServerConfig(optional bool) *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+            cert, _ := c.current()
+            return cert, nil
+        },
+        GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+            cert, pool := c.current()
+            if cert == nil {
+                return nil, errors.New("no server certificate")
+            }
+            config := &tls.Config{
+                MinVersion:   tls.VersionTLS12,
+                Certificates: []tls.Certificate{*cert},
+                NextProtos:   []string{"h2", "http/1.1"},
+            }
+            if pool != nil {
+                config.ClientAuth = tls.RequireAnyClientCert
+                if optional {
+                    config.ClientAuth = tls.RequestClientCert
+                }
+                config.VerifyConnection = func(cs tls.ConnectionState) error {
+                    return verifyClient(cs, cert, pool)
+                }
+            }
+            return config, nil
+        },
+    }
+}
+
+func verifyClient(cs tls.ConnectionState, server *tls.Certificate, pool *x509.CertPool) error {
+    if len(cs.PeerCertificates) == 0 {
+        // Only requested, not required
+        return nil
+    }
+    leaf := cs.PeerCertificates[0]
+    if bytes.Equal(leaf.Raw, server.Leaf.Raw) {
+        return nil
+    }
+    intermediates := x509.NewCertPool()
+    for _, cert := range cs.PeerCertificates[1:] {
+        intermediates.AddCert(cert)
+    }
+    _, err := leaf.Verify(x509.VerifyOptions{
+        Roots:         pool,
+        Intermediates: intermediates,
+        KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+    })
+    return err
+}
+
+// ClientConfig presents the certificate, if there is one, to servers and
+// verifies them against the CA bundle, or the system roots without one.
+// serverName overrides the name the server certificate is checked for.
+func (c *Certificates) ClientConfig(serverName string) *tls.Config {
+    config := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        ServerName: serverName,
+        GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            if cert, _ := c.current(); cert != nil {
+                return cert, nil
+            }
+            return &tls.Certificate{}, nil
+        },
+    }
+    if c.caFile != "" {
+        // Verified against the current bundle in VerifyConnection instead of
+        // a RootCAs fixed at creation
+        config.InsecureSkipVerify = true
+        config.VerifyConnection = func(cs tls.ConnectionState) error {
+            _, pool := c.current()
+            return verifyServer(cs, pool)
+        }
+    }
+    return config
+}
+
+// LoopbackConfig connects to the server serving these certificates. It
+// presents the server certificate and accepts only that certificate.
+func (c *Certificates) LoopbackConfig() *tls.Config {
+    return &tls.Config{
+        MinVersion:         tls.VersionTLS12,
+        InsecureSkipVerify: true,
+        GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            cert, _ := c.current()
+            return cert, nil
+        },
+        VerifyConnection: func(cs tls.ConnectionState) error {
+            cert, _ := c.current()
+            if len(cs.PeerCertificates) == 0 || !bytes.Equal(cs.PeerCertificates[0].Raw, cert.Leaf.Raw) {
+                return errors.New("unexpected server certificate")
+            }
+            return nil
+        },
+    }
+}
+
+func verifyServer(cs tls.ConnectionState, pool *x509.CertPool) error {
+    if len(cs.PeerCertificates) == 0 {
+        return errors.New("no server certificate")
+    }
+    intermediates := x509.NewCertPool()
+    for _, cert := range cs.PeerCertificates[1:] {
+        intermediates.AddCert(cert)
+    }
+    _, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+        DNSName:       cs.ServerName,
+        Roots:         pool,
+        Intermediates: intermediates,
+    })
+    return err
+}
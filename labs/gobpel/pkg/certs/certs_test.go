@@ -0,0 +1,230 @@
+package certs
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// testCA issues certificates for localhost.
+type testCA struct {
+    cert *x509.Certificate
+    key  *ecdsa.PrivateKey
+    pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "test CA"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of a new certificate
+// for localhost, valid for servers and clients.
+func (ca *testCA) issue(t *testing.T, serial int64) ([]byte, []byte) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: "localhost"},
+        DNSNames:     []string{"localhost"},
+        IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+        pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFiles writes the files in dir and moves their modification times
+// forward so a reload sees them change.
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+    t.Helper()
+    later := time.Now().Add(time.Minute)
+    for name, data := range files {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, data, 0600); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(path, later, later); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// loadFiles writes a certificate, key and CA bundle and loads them.
+func loadFiles(t *testing.T, ca *testCA, serial int64) (*Certificates, string) {
+    t.Helper()
+    dir := t.TempDir()
+    cert, key := ca.issue(t, serial)
+    writeFiles(t, dir, map[string][]byte{"tls.crt": cert, "tls.key": key, "ca.crt": ca.pem})
+    c, err := Load(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return c, dir
+}
+
+// serve accepts TLS connections and writes "ok" on each that completes
+// its handshake.
+func serve(t *testing.T, config *tls.Config) string {
+    t.Helper()
+    listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                if conn.(*tls.Conn).Handshake() == nil {
+                    conn.Write([]byte("ok"))
+                }
+            }()
+        }
+    }()
+    return listener.Addr().String()
+}
+
+// dial connects with config and returns the serial number of the server
+// certificate once the server accepted the connection.
+func dial(addr string, config *tls.Config) (int64, error) {
+    conn, err := tls.Dial("tcp", addr, config)
+    if err != nil {
+        return 0, err
+    }
+    defer conn.Close()
+    // A rejected client certificate shows on the first read with TLS 1.3
+    if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+        return 0, err
+    }
+    return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestMutualTLS(t *testing.T) {
+    ca := newTestCA(t)
+    server, _ := loadFiles(t, ca, 10)
+    client, dir := loadFiles(t, ca, 20)
+    stranger, _ := loadFiles(t, newTestCA(t), 30)
+    // Trusts the server but has no certificate to present
+    anonymous, err := Load("", "", filepath.Join(dir, "ca.crt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    required, optional := serve(t, server.ServerConfig(false)), serve(t, server.ServerConfig(true))
+
+    tests := []struct {
+        name   string
+        addr   string
+        config *tls.Config
+        ok     bool
+    }{
+        {"client certificate", required, client.ClientConfig(""), true},
+        {"loopback", required, server.LoopbackConfig(), true},
+        {"no client certificate", required, anonymous.ClientConfig(""), false},
+        {"optional client certificate", optional, anonymous.ClientConfig(""), true},
+        {"untrusted client certificate", optional, stranger.ClientConfig(""), false},
+        {"server name", required, client.ClientConfig("localhost"), true},
+        {"wrong server name", required, client.ClientConfig("example.com"), false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := dial(test.addr, test.config)
+            if (err == nil) != test.ok {
+                t.Errorf("got %v", err)
+            }
+        })
+    }
+
+    // The loopback client accepts only the server's own certificate
+    other := serve(t, client.ServerConfig(false))
+    if _, err := dial(other, server.LoopbackConfig()); err == nil {
+        t.Error("the loopback client accepted another server")
+    }
+}
+
+func TestReload(t *testing.T) {
+    ca := newTestCA(t)
+    server, dir := loadFiles(t, ca, 10)
+    client, _ := loadFiles(t, ca, 20)
+    addr := serve(t, server.ServerConfig(false))
+
+    serial, err := dial(addr, client.ClientConfig(""))
+    if err != nil || serial != 10 {
+        t.Fatalf("got serial %d, %v", serial, err)
+    }
+
+    // Renewed files are served once the check interval passed
+    cert, key := ca.issue(t, 11)
+    writeFiles(t, dir, map[string][]byte{"tls.crt": cert, "tls.key": key})
+    if serial, _ := dial(addr, client.ClientConfig("")); serial != 10 {
+        t.Errorf("got serial %d before the check interval passed", serial)
+    }
+    server.mu.Lock()
+    server.checked = time.Time{}
+    server.mu.Unlock()
+    if serial, err := dial(addr, client.ClientConfig("")); err != nil || serial != 11 {
+        t.Errorf("got serial %d, %v after renewal", serial, err)
+    }
+
+    // A broken renewal keeps the certificate loaded before
+    writeFiles(t, dir, map[string][]byte{"tls.crt": []byte("broken")})
+    if err := server.Reload(); err == nil {
+        t.Error("reloaded a broken certificate")
+    }
+    server.mu.Lock()
+    server.checked = time.Time{}
+    server.mu.Unlock()
+    if serial, err := dial(addr, client.ClientConfig("")); err != nil || serial != 11 {
+        t.Errorf("got serial %d, %v after a broken renewal", serial, err)
+    }
+}
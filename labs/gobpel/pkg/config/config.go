@@ -1,38 +1,329 @@
 package config
 
 import (
-    "log"
+    "bytes"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
     "os"
+    "time"
 
     "github.com/joho/godotenv"
+    "gopkg.in/yaml.v3"
 )
 
+// Config is the server configuration. It is built from defaults, a YAML
+// file, environment variables and command line flags, each overriding the
+// ones before it.
 type Config struct {
-    Server struct {
-        Port string
-    }
-    MongoDB struct {
-        URI string
+    Server        ServerConfig              `yaml:"server"`
+    Storage       StorageConfig             `yaml:"storage"`
+    Broker        BrokerConfig              `yaml:"broker"`
+    Partners      map[string]*PartnerConfig `yaml:"partners"`
+    Secrets       SecretsConfig             `yaml:"secrets"`
+    TLS           TLSConfig                 `yaml:"tls"`
+    Auth          AuthConfig                `yaml:"auth"`
+    Authorization AuthorizationConfig       `yaml:"authorization"`
+    Tenants       TenantsConfig             `yaml:"tenants"`
+    Encryption    EncryptionConfig          `yaml:"encryption"`
+    Redaction     RedactionConfig           `yaml:"redaction"`
+    WorkerPool    WorkerPoolConfig          `yaml:"workerPool"`
+    Observability ObservabilityConfig       `yaml:"observability"`
+    Tracing       TracingConfig             `yaml:"tracing"`
+
+    // File is the configuration file read, if any
+    File string `yaml:"-"`
+}
+
+// ServerConfig holds the addresses the server listens on. Metrics are served
+// at /metrics of the gateway, and also on MetricsAddr when it is set.
+type ServerConfig struct {
+    GRPCAddr            string        `yaml:"grpcAddr"`
+    GatewayAddr         string        `yaml:"gatewayAddr"`
+    MetricsAddr         string        `yaml:"metricsAddr"`
+    ReplicaId           string        `yaml:"replicaId"`
+    ShutdownGracePeriod time.Duration `yaml:"shutdownGracePeriod"`
+    IdempotencyWindow   time.Duration `yaml:"idempotencyWindow"`
+}
+
+type StorageConfig struct {
+    Backend string        `yaml:"backend"`
+    MongoDB MongoDBConfig `yaml:"mongodb"`
+}
+
+type MongoDBConfig struct {
+    URI string `yaml:"uri"`
+}
+
+type BrokerConfig struct {
+    NATSURL       string        `yaml:"natsURL"`
+    InvokeTimeout time.Duration `yaml:"invokeTimeout"`
+}
+
+// PartnerConfig is the partner registry entry of a partner link. Partners
+// are invoked with an HTTP POST to <url>/<operation>, http://<partnerLink>
+// by default, over the broker when Transport is "broker", or as the gRPC
+// method /<service>/<operation> at a grpc:// or grpcs:// url when Transport
+// is "grpc".
+type PartnerConfig struct {
+    Transport      string            `yaml:"transport"`
+    URL            string            `yaml:"url"`
+    Service        string            `yaml:"service"`
+    Headers        map[string]string `yaml:"headers"`
+    TLS            *PartnerTLSConfig `yaml:"tls"`
+    MaxConcurrency int               `yaml:"maxConcurrency"`
+    RateLimit      float64           `yaml:"rateLimit"`
+    Burst          int               `yaml:"burst"`
+    CircuitBreaker *BreakerConfig    `yaml:"circuitBreaker"`
+}
+
+// SecretsConfig selects where the ${secret:name} references in partner
+// headers are resolved: environment variables named by Env.Prefix, the
+// default, an encrypted file, or a Vault compatible server.
+type SecretsConfig struct {
+    Provider string            `yaml:"provider"`
+    Env      SecretsEnvConfig  `yaml:"env"`
+    File     SecretsFileConfig `yaml:"file"`
+    Vault    VaultConfig       `yaml:"vault"`
+}
+
+type SecretsEnvConfig struct {
+    Prefix string `yaml:"prefix"`
+}
+
+type SecretsFileConfig struct {
+    Path    string `yaml:"path"`
+    KeyFile string `yaml:"keyFile"`
+}
+
+// VaultConfig is the KV version 2 engine secrets are read from. Secrets are
+// cached for CacheTTL.
+type VaultConfig struct {
+    Addr     string        `yaml:"addr"`
+    Token    string        `yaml:"token"`
+    Mount    string        `yaml:"mount"`
+    CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// PartnerTLSConfig is the client certificate presented to an https:// or
+// grpcs:// partner and the CA bundle its certificate is verified against,
+// the system roots by default.
+type PartnerTLSConfig struct {
+    CertFile   string `yaml:"certFile"`
+    KeyFile    string `yaml:"keyFile"`
+    CAFile     string `yaml:"caFile"`
+    ServerName string `yaml:"serverName"`
+}
+
+type BreakerConfig struct {
+    FailureThreshold int           `yaml:"failureThreshold"`
+    OpenTimeout      time.Duration `yaml:"openTimeout"`
+}
+
+// TLSConfig holds the server certificate and the CA that client
+// certificates are verified against. ClientAuth is "require", the default,
+// or "optional" to verify only the client certificates presented.
+type TLSConfig struct {
+    CertFile     string `yaml:"certFile"`
+    KeyFile      string `yaml:"keyFile"`
+    ClientCAFile string `yaml:"clientCAFile"`
+    ClientAuth   string `yaml:"clientAuth"`
+}
+
+// AuthConfig describes the issuer of the JWTs callers authenticate with and
+// where its signing keys are published. TenantClaim is the claim naming the
+// tenant of the caller; tokens without it act for DefaultTenant, or are
+// rejected when it is empty.
+type AuthConfig struct {
+    Issuer        string `yaml:"issuer"`
+    Audience      string `yaml:"audience"`
+    JWKSFile      string `yaml:"jwksFile"`
+    JWKSURL       string `yaml:"jwksURL"`
+    TenantClaim   string `yaml:"tenantClaim"`
+    DefaultTenant string `yaml:"defaultTenant"`
+}
+
+// AuthorizationConfig names the policy authenticated callers are authorized
+// by, roles from the roles claim of their token by default.
+type AuthorizationConfig struct {
+    PolicyFile string `yaml:"policyFile"`
+}
+
+// TenantsConfig limits what each tenant may keep and run. DefaultQuota
+// applies to every tenant, except for the limits set for it in Quotas. Zero
+// is no limit.
+type TenantsConfig struct {
+    DefaultQuota QuotaConfig             `yaml:"defaultQuota"`
+    Quotas       map[string]*QuotaConfig `yaml:"quotas"`
+}
+
+type QuotaConfig struct {
+    MaxProcesses       int `yaml:"maxProcesses"`
+    MaxActiveInstances int `yaml:"maxActiveInstances"`
+    MaxSchedules       int `yaml:"maxSchedules"`
+}
+
+// EncryptionConfig holds the key encryption keys instance variables and
+// history payloads are encrypted with in the store, by key id. Keys are
+// base64 encoded 32 byte AES keys or ${secret:name} references. New values
+// are encrypted with ActiveKey; without one nothing is encrypted.
+type EncryptionConfig struct {
+    ActiveKey string            `yaml:"activeKey"`
+    Keys      map[string]string `yaml:"keys"`
+}
+
+// RedactionConfig lists the payload fields masked in logs and watch streams,
+// of every process in Fields and of some processes in Processes.
+type RedactionConfig struct {
+    Fields    []string            `yaml:"fields"`
+    Processes map[string][]string `yaml:"processes"`
+}
+
+type WorkerPoolConfig struct {
+    Workers int `yaml:"workers"`
+}
+
+type ObservabilityConfig struct {
+    LogLevel string `yaml:"logLevel"`
+}
+
+// TracingConfig selects where spans are exported: nowhere, the default, an
+// OpenTelemetry collector at Endpoint over OTLP/gRPC, or stdout. Endpoint is
+// an http:// URL for a plaintext collector or https:// for TLS. SampleRatio
+// is the share of new traces recorded; calls that carry a trace context
+// follow the sampling decision of their caller.
+type TracingConfig struct {
+    Exporter    string  `yaml:"exporter"`
+    Endpoint    string  `yaml:"endpoint"`
+    SampleRatio float64 `yaml:"sampleRatio"`
+    ServiceName string  `yaml:"serviceName"`
+}
+
+// Default returns the configuration used where nothing else is set.
+func Default() *Config {
+    return &Config{
+        Server: ServerConfig{
+            GRPCAddr:            ":50051",
+            GatewayAddr:         ":8090",
+            ShutdownGracePeriod: 30 * time.Second,
+            IdempotencyWindow:   24 * time.Hour,
+        },
+        Storage: StorageConfig{
+            Backend: "mongodb",
+            MongoDB: MongoDBConfig{URI: "mongodb://mongodb:27017"},
+        },
+        Broker:        BrokerConfig{InvokeTimeout: 30 * time.Minute},
+        Partners:      make(map[string]*PartnerConfig),
+        Secrets: SecretsConfig{
+            Provider: "env",
+            Env:      SecretsEnvConfig{Prefix: "GOBPEL_SECRET_"},
+            Vault:    VaultConfig{Mount: "secret", CacheTTL: 5 * time.Minute},
+        },
+        Auth:          AuthConfig{TenantClaim: "tenant"},
+        Tenants:       TenantsConfig{Quotas: make(map[string]*QuotaConfig)},
+        Encryption:    EncryptionConfig{Keys: make(map[string]string)},
+        Redaction:     RedactionConfig{Processes: make(map[string][]string)},
+        WorkerPool:    WorkerPoolConfig{Workers: 64},
+        Observability: ObservabilityConfig{LogLevel: "info"},
+        Tracing: TracingConfig{
+            Exporter:    "none",
+            Endpoint:    "http://localhost:4317",
+            SampleRatio: 1,
+            ServiceName: "gobpel",
+        },
     }
 }
 
-var config *Config
+// Load builds the configuration from the command line arguments args. The
+// file named by -config or GOBPEL_CONFIG is read first, then variables from
+// the environment and a .env file, if there is one, and then flags. All
+// problems found are reported together.
+func Load(args []string) (*Config, error) {
+    flags := flag.NewFlagSet("gobpel", flag.ContinueOnError)
+    flags.SetOutput(io.Discard)
+    configFile := flags.String("config", os.Getenv("GOBPEL_CONFIG"), "YAML configuration file")
+    values := make(map[string]*string)
+    for _, s := range settings {
+        if s.flag != "" {
+            values[s.flag] = flags.String(s.flag, "", s.usage)
+        }
+    }
+    if err := flags.Parse(args); err != nil {
+        return nil, err
+    }
+
+    if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf(".env: %v", err)
+    }
 
-func LoadConfig() *Config {
-    if config == nil {
-        err := godotenv.Load()
-        if err != nil {
-            log.Fatalf("Error loading .env file: %v", err)
+    config := Default()
+    if *configFile != "" {
+        if err := config.readFile(*configFile); err != nil {
+            return nil, err
         }
+        config.File = *configFile
+    }
 
-        config = &Config{}
-        config.Server.Port = os.Getenv("SERVER_PORT")
-        config.MongoDB.URI = os.Getenv("MONGO_URI")
+    var errs []error
+    for _, s := range settings {
+        if value := os.Getenv(s.env); value != "" {
+            if err := s.set(config, value); err != nil {
+                errs = append(errs, fmt.Errorf("%s: %v", s.env, err))
+            }
+        }
+    }
+    flags.Visit(func(f *flag.Flag) {
+        if value, ok := values[f.Name]; ok {
+            if err := settingByFlag(f.Name).set(config, *value); err != nil {
+                errs = append(errs, fmt.Errorf("-%s: %v", f.Name, err))
+            }
+        }
+    })
+    errs = append(errs, config.validate()...)
+    if len(errs) > 0 {
+        return nil, errors.Join(errs...)
+    }
+    return config, nil
+}
 
-        if config.Server.Port == "" || config.MongoDB.URI == "" {
-            log.Fatalf("Missing required environment variables")
+// Usage describes the flags and environment variables Load reads.
+func Usage() string {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "  -config, GOBPEL_CONFIG\n        YAML configuration file\n")
+    for _, s := range settings {
+        if s.flag != "" {
+            fmt.Fprintf(&b, "  -%s, %s\n        %s\n", s.flag, s.env, s.usage)
+        } else {
+            fmt.Fprintf(&b, "  %s\n        %s\n", s.env, s.usage)
         }
     }
-    return config
+    return b.String()
+}
+
+func (c *Config) readFile(name string) error {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return err
+    }
+    decoder := yaml.NewDecoder(bytes.NewReader(data))
+    // Misspelled keys are errors rather than silently ignored
+    decoder.KnownFields(true)
+    if err := decoder.Decode(c); err != nil && err != io.EOF {
+        return fmt.Errorf("%s: %v", name, err)
+    }
+    return nil
 }
 
+// Partner returns the registry entry of partnerLink, adding it if needed.
+func (c *Config) Partner(partnerLink string) *PartnerConfig {
+    if c.Partners == nil {
+        c.Partners = make(map[string]*PartnerConfig)
+    }
+    partner, ok := c.Partners[partnerLink]
+    if !ok || partner == nil {
+        partner = &PartnerConfig{}
+        c.Partners[partnerLink] = partner
+    }
+    return partner
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "reflect"
+    "time"
+)
+
+// Sections applied by a reload without restarting the server. Changes to
+// the other sections are reported but take effect after a restart.
+var reloadableSections = map[string]bool{
+    "partners":      true,
+    "secrets":       true,
+    "authorization": true,
+    "tenants":       true,
+    "encryption":    true,
+    "redaction":     true,
+    "workerPool":    true,
+    "observability": true,
+}
+
+// Diff returns the sections that differ between c and next, split into those
+// a reload applies and those that need a restart.
+func (c *Config) Diff(next *Config) (reloadable, restartRequired []string) {
+    current, changed := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+    for i := 0; i < current.NumField(); i++ {
+        field := current.Type().Field(i)
+        section := field.Tag.Get("yaml")
+        if section == "-" || reflect.DeepEqual(current.Field(i).Interface(), changed.Field(i).Interface()) {
+            continue
+        }
+        if reloadableSections[section] {
+            reloadable = append(reloadable, section)
+        } else {
+            restartRequired = append(restartRequired, section)
+        }
+    }
+    return reloadable, restartRequired
+}
+
+// Watch calls changed whenever the file name is modified, checking every
+// interval until ctx is done.
+func Watch(ctx context.Context, name string, interval time.Duration, changed func()) {
+    last := modified(name)
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        if m := modified(name); m != last {
+            last = m
+            changed()
+        }
+    }
+}
+
+// modified identifies a version of a file by its modification time and size.
+func modified(name string) string {
+    info, err := os.Stat(name)
+    if err != nil {
+        return ""
+    }
+    return fmt.Sprintf("%v/%d", info.ModTime(), info.Size())
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestDiff(t *testing.T) {
+    tests := []struct {
+        name            string
+        change          func(c *Config)
+        reloadable      []string
+        restartRequired []string
+    }{
+        {"unchanged", func(c *Config) {}, nil, nil},
+        {"partner", func(c *Config) { c.Partner("ragservice").URL = "http://ragservice:8080" }, []string{"partners"}, nil},
+        {"log level and workers", func(c *Config) {
+            c.Observability.LogLevel = "debug"
+            c.WorkerPool.Workers = 8
+        }, []string{"workerPool", "observability"}, nil},
+        {"server", func(c *Config) { c.Server.GRPCAddr = ":50052" }, nil, []string{"server"}},
+        {"mixed", func(c *Config) {
+            c.Storage.MongoDB.URI = "mongodb://other:27017"
+            c.Redaction.Processes["train"] = []string{"token"}
+        }, []string{"redaction"}, []string{"storage"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            next := Default()
+            test.change(next)
+            reloadable, restartRequired := Default().Diff(next)
+            if !reflect.DeepEqual(reloadable, test.reloadable) || !reflect.DeepEqual(restartRequired, test.restartRequired) {
+                t.Errorf("got %v and %v, want %v and %v", reloadable, restartRequired, test.reloadable, test.restartRequired)
+            }
+        })
+    }
+}
+
+func TestWatch(t *testing.T) {
+    name := filepath.Join(t.TempDir(), "gobpel.yaml")
+    if err := os.WriteFile(name, []byte("workerPool:\n  workers: 8\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    changed := make(chan struct{}, 10)
+    go Watch(ctx, name, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+    select {
+    case <-changed:
+        t.Fatal("reported a change of an unmodified file")
+    case <-time.After(50 * time.Millisecond):
+    }
+    if err := os.WriteFile(name, []byte("workerPool:\n  workers: 16\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case <-changed:
+    case <-time.After(5 * time.Second):
+        t.Fatal("no change reported for a modified file")
+    }
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// setting is a value that can be set by an environment variable and, when
+// flag is not empty, by a command line flag.
+type setting struct {
+    env   string
+    flag  string
+    usage string
+    set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+    {"GRPC_ADDR", "grpc-addr", "address the gRPC server listens on", func(c *Config, v string) error {
+        c.Server.GRPCAddr = v
+        return nil
+    }},
+    {"SERVER_PORT", "", "port the gRPC server listens on, superseded by GRPC_ADDR", func(c *Config, v string) error {
+        if _, err := strconv.Atoi(v); err != nil {
+            return fmt.Errorf("invalid port %q", v)
+        }
+        c.Server.GRPCAddr = ":" + v
+        return nil
+    }},
+    {"GATEWAY_ADDR", "gateway-addr", "address the REST gateway listens on", func(c *Config, v string) error {
+        c.Server.GatewayAddr = v
+        return nil
+    }},
+    {"METRICS_ADDR", "metrics-addr", "address /metrics is also served on, without authentication", func(c *Config, v string) error {
+        c.Server.MetricsAddr = v
+        return nil
+    }},
+    {"REPLICA_ID", "replica-id", "name of this replica, the hostname with a random suffix by default", func(c *Config, v string) error {
+        c.Server.ReplicaId = v
+        return nil
+    }},
+    {"SHUTDOWN_GRACE_PERIOD", "shutdown-grace-period", "time running activities get to reach a checkpoint on shutdown", func(c *Config, v string) error {
+        return setDuration(&c.Server.ShutdownGracePeriod, v)
+    }},
+    {"IDEMPOTENCY_WINDOW", "idempotency-window", "how long idempotency keys are remembered", func(c *Config, v string) error {
+        return setDuration(&c.Server.IdempotencyWindow, v)
+    }},
+    {"STORAGE_BACKEND", "storage-backend", "storage backend, mongodb", func(c *Config, v string) error {
+        c.Storage.Backend = v
+        return nil
+    }},
+    {"MONGO_URI", "mongo-uri", "MongoDB connection string", func(c *Config, v string) error {
+        c.Storage.MongoDB.URI = v
+        return nil
+    }},
+    {"NATS_URL", "nats-url", "NATS server of the message broker, none by default", func(c *Config, v string) error {
+        c.Broker.NATSURL = v
+        return nil
+    }},
+    {"BROKER_PARTNER_LINKS", "", "partner links invoked over the broker, as ragservice,...", func(c *Config, v string) error {
+        for _, partnerLink := range strings.Split(v, ",") {
+            if partnerLink != "" {
+                c.Partner(partnerLink).Transport = "broker"
+            }
+        }
+        return nil
+    }},
+    {"PARTNER_URLS", "", "partner link base URLs, as ragservice=http://ragservice:8086,...", func(c *Config, v string) error {
+        return partnerLinkSettings(c, v, func(p *PartnerConfig, value string) error {
+            p.URL = value
+            return nil
+        })
+    }},
+    {"PARTNER_LINK_CONCURRENCY", "", "invokes in flight per partner link, as trainingService=2,...", func(c *Config, v string) error {
+        return partnerLinkSettings(c, v, func(p *PartnerConfig, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil {
+                return fmt.Errorf("invalid concurrency %q", value)
+            }
+            p.MaxConcurrency = n
+            return nil
+        })
+    }},
+    {"PARTNER_LINK_RATE_LIMITS", "", "invokes a second and burst per partner link, as ragservice=5:10,...", func(c *Config, v string) error {
+        return partnerLinkSettings(c, v, func(p *PartnerConfig, value string) error {
+            rate, burst, _ := strings.Cut(value, ":")
+            r, err := strconv.ParseFloat(rate, 64)
+            b, err2 := strconv.Atoi(burst)
+            if err != nil || err2 != nil {
+                return fmt.Errorf("invalid rate limit %q", value)
+            }
+            p.RateLimit, p.Burst = r, b
+            return nil
+        })
+    }},
+    {"CIRCUIT_BREAKERS", "", "failures and open time per partner link, as ragservice=3:1m,...", func(c *Config, v string) error {
+        return partnerLinkSettings(c, v, func(p *PartnerConfig, value string) error {
+            failures, timeout, _ := strings.Cut(value, ":")
+            f, err := strconv.Atoi(failures)
+            t, err2 := time.ParseDuration(timeout)
+            if err != nil || err2 != nil {
+                return fmt.Errorf("invalid circuit breaker %q", value)
+            }
+            p.CircuitBreaker = &BreakerConfig{FailureThreshold: f, OpenTimeout: t}
+            return nil
+        })
+    }},
+    {"SECRETS_PROVIDER", "secrets-provider", "where ${secret:name} references are resolved: env, file or vault", func(c *Config, v string) error {
+        c.Secrets.Provider = v
+        return nil
+    }},
+    {"SECRETS_ENV_PREFIX", "", "prefix of the environment variables holding secrets", func(c *Config, v string) error {
+        c.Secrets.Env.Prefix = v
+        return nil
+    }},
+    {"SECRETS_FILE", "secrets-file", "encrypted secrets file", func(c *Config, v string) error {
+        c.Secrets.File.Path = v
+        return nil
+    }},
+    {"SECRETS_KEY_FILE", "secrets-key-file", "key of the encrypted secrets file", func(c *Config, v string) error {
+        c.Secrets.File.KeyFile = v
+        return nil
+    }},
+    {"VAULT_ADDR", "vault-addr", "address of the Vault server secrets are read from", func(c *Config, v string) error {
+        c.Secrets.Vault.Addr = v
+        return nil
+    }},
+    {"VAULT_TOKEN", "", "token secrets are read from Vault with", func(c *Config, v string) error {
+        c.Secrets.Vault.Token = v
+        return nil
+    }},
+    {"VAULT_MOUNT", "vault-mount", "path of the Vault KV version 2 engine", func(c *Config, v string) error {
+        c.Secrets.Vault.Mount = v
+        return nil
+    }},
+    {"TLS_CERT_FILE", "tls-cert-file", "server certificate", func(c *Config, v string) error {
+        c.TLS.CertFile = v
+        return nil
+    }},
+    {"TLS_KEY_FILE", "tls-key-file", "server certificate key", func(c *Config, v string) error {
+        c.TLS.KeyFile = v
+        return nil
+    }},
+    {"TLS_CLIENT_CA_FILE", "tls-client-ca-file", "CA client certificates are verified against", func(c *Config, v string) error {
+        c.TLS.ClientCAFile = v
+        return nil
+    }},
+    {"TLS_CLIENT_AUTH", "tls-client-auth", "require or optional client certificates", func(c *Config, v string) error {
+        c.TLS.ClientAuth = v
+        return nil
+    }},
+    {"AUTH_ISSUER", "auth-issuer", "issuer of the tokens callers authenticate with", func(c *Config, v string) error {
+        c.Auth.Issuer = v
+        return nil
+    }},
+    {"AUTH_AUDIENCE", "auth-audience", "audience tokens must be issued for", func(c *Config, v string) error {
+        c.Auth.Audience = v
+        return nil
+    }},
+    {"AUTH_JWKS_FILE", "auth-jwks-file", "file with the keys tokens are signed with", func(c *Config, v string) error {
+        c.Auth.JWKSFile = v
+        return nil
+    }},
+    {"AUTH_JWKS_URL", "auth-jwks-url", "URL of the keys tokens are signed with", func(c *Config, v string) error {
+        c.Auth.JWKSURL = v
+        return nil
+    }},
+    {"AUTHZ_POLICY_FILE", "policy-file", "file of the roles and process permissions of callers", func(c *Config, v string) error {
+        c.Authorization.PolicyFile = v
+        return nil
+    }},
+    {"AUTH_TENANT_CLAIM", "auth-tenant-claim", "token claim naming the tenant of the caller", func(c *Config, v string) error {
+        c.Auth.TenantClaim = v
+        return nil
+    }},
+    {"AUTH_DEFAULT_TENANT", "auth-default-tenant", "tenant of callers whose token has no tenant claim", func(c *Config, v string) error {
+        c.Auth.DefaultTenant = v
+        return nil
+    }},
+    {"DEFAULT_TENANT_QUOTA", "", "processes, active instances and schedules per tenant, as 100:1000:20", func(c *Config, v string) error {
+        return setQuota(&c.Tenants.DefaultQuota, v)
+    }},
+    {"TENANT_QUOTAS", "", "quota per tenant, as ml-research=100:1000:20,...", func(c *Config, v string) error {
+        for _, entry := range strings.Split(v, ",") {
+            if entry == "" {
+                continue
+            }
+            tenant, value, ok := strings.Cut(entry, "=")
+            if !ok || tenant == "" {
+                return fmt.Errorf("invalid entry %q", entry)
+            }
+            quota := &QuotaConfig{}
+            if err := setQuota(quota, value); err != nil {
+                return fmt.Errorf("%s: %v", tenant, err)
+            }
+            if c.Tenants.Quotas == nil {
+                c.Tenants.Quotas = make(map[string]*QuotaConfig)
+            }
+            c.Tenants.Quotas[tenant] = quota
+        }
+        return nil
+    }},
+    {"ENCRYPTION_ACTIVE_KEY", "encryption-active-key", "id of the key new values are encrypted with in the store", func(c *Config, v string) error {
+        c.Encryption.ActiveKey = v
+        return nil
+    }},
+    {"ENCRYPTION_KEYS", "", "keys values are encrypted with in the store, as k1=<base64 key>,k2=${secret:name},...", func(c *Config, v string) error {
+        for _, entry := range strings.Split(v, ",") {
+            if entry == "" {
+                continue
+            }
+            keyId, key, ok := strings.Cut(entry, "=")
+            if !ok || keyId == "" {
+                return fmt.Errorf("invalid entry %q", entry)
+            }
+            if c.Encryption.Keys == nil {
+                c.Encryption.Keys = make(map[string]string)
+            }
+            c.Encryption.Keys[keyId] = key
+        }
+        return nil
+    }},
+    {"REDACT_FIELDS", "", "payload fields masked in logs and watch streams, as farm.bankAccount,**.apiKey,...", func(c *Config, v string) error {
+        c.Redaction.Fields = nil
+        for _, field := range strings.Split(v, ",") {
+            if field != "" {
+                c.Redaction.Fields = append(c.Redaction.Fields, field)
+            }
+        }
+        return nil
+    }},
+    {"WORKER_POOL_SIZE", "workers", "instances running at the same time", func(c *Config, v string) error {
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            return fmt.Errorf("invalid worker count %q", v)
+        }
+        c.WorkerPool.Workers = n
+        return nil
+    }},
+    {"LOG_LEVEL", "log-level", "debug, info, warn or error", func(c *Config, v string) error {
+        c.Observability.LogLevel = v
+        return nil
+    }},
+    {"TRACING_EXPORTER", "tracing-exporter", "where spans are exported: none, otlp or stdout", func(c *Config, v string) error {
+        c.Tracing.Exporter = v
+        return nil
+    }},
+    {"TRACING_ENDPOINT", "tracing-endpoint", "OTLP/gRPC collector URL, e.g. http://localhost:4317", func(c *Config, v string) error {
+        c.Tracing.Endpoint = v
+        return nil
+    }},
+    {"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "share of new traces recorded, from 0 to 1", func(c *Config, v string) error {
+        ratio, err := strconv.ParseFloat(v, 64)
+        if err != nil {
+            return fmt.Errorf("invalid ratio %q", v)
+        }
+        c.Tracing.SampleRatio = ratio
+        return nil
+    }},
+    {"TRACING_SERVICE_NAME", "", "service name spans are reported under", func(c *Config, v string) error {
+        c.Tracing.ServiceName = v
+        return nil
+    }},
+}
+
+func settingByFlag(name string) setting {
+    for _, s := range settings {
+        if s.flag == name {
+            return s
+        }
+    }
+    panic("config: unknown flag " + name)
+}
+
+func setDuration(d *time.Duration, value string) error {
+    parsed, err := time.ParseDuration(value)
+    if err != nil {
+        return err
+    }
+    *d = parsed
+    return nil
+}
+
+// setQuota parses processes:instances:schedules.
+func setQuota(quota *QuotaConfig, value string) error {
+    parts := strings.Split(value, ":")
+    if len(parts) != 3 {
+        return fmt.Errorf("invalid quota %q", value)
+    }
+    limits := make([]int, len(parts))
+    for i, part := range parts {
+        n, err := strconv.Atoi(part)
+        if err != nil {
+            return fmt.Errorf("invalid quota %q", value)
+        }
+        limits[i] = n
+    }
+    quota.MaxProcesses, quota.MaxActiveInstances, quota.MaxSchedules = limits[0], limits[1], limits[2]
+    return nil
+}
+
+// partnerLinkSettings sets a value of the form partnerLink=value,... on the
+// registry entries of the partner links.
+func partnerLinkSettings(c *Config, value string, set func(p *PartnerConfig, value string) error) error {
+    for _, entry := range strings.Split(value, ",") {
+        if entry == "" {
+            continue
+        }
+        partnerLink, v, ok := strings.Cut(entry, "=")
+        if !ok || partnerLink == "" {
+            return fmt.Errorf("invalid entry %q", entry)
+        }
+        if err := set(c.Partner(partnerLink), v); err != nil {
+            return fmt.Errorf("%s: %v", partnerLink, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+    "encoding/base64"
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+
+    "gobpel/pkg/auth"
+    "gobpel/pkg/authz"
+    "gobpel/pkg/secrets"
+)
+
+var keyIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// validate returns every problem of the configuration, named by the YAML
+// path of the offending value.
+func (c *Config) validate() []error {
+    var errs []error
+    fail := func(path, format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+    }
+
+    if _, _, err := net.SplitHostPort(c.Server.GRPCAddr); err != nil {
+        fail("server.grpcAddr", "invalid address %q", c.Server.GRPCAddr)
+    }
+    if _, _, err := net.SplitHostPort(c.Server.GatewayAddr); err != nil {
+        fail("server.gatewayAddr", "invalid address %q", c.Server.GatewayAddr)
+    }
+    if _, _, err := net.SplitHostPort(c.Server.MetricsAddr); c.Server.MetricsAddr != "" && err != nil {
+        fail("server.metricsAddr", "invalid address %q", c.Server.MetricsAddr)
+    }
+    if c.Server.ShutdownGracePeriod < 0 {
+        fail("server.shutdownGracePeriod", "must not be negative")
+    }
+    if c.Server.IdempotencyWindow <= 0 {
+        fail("server.idempotencyWindow", "must be positive")
+    }
+
+    switch c.Storage.Backend {
+    case "mongodb":
+        uri := c.Storage.MongoDB.URI
+        if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+            fail("storage.mongodb.uri", "must be a mongodb:// or mongodb+srv:// connection string")
+        }
+    default:
+        fail("storage.backend", "unsupported backend %q, expected mongodb", c.Storage.Backend)
+    }
+
+    if c.Broker.NATSURL != "" {
+        if u, err := url.Parse(c.Broker.NATSURL); err != nil || u.Host == "" {
+            fail("broker.natsURL", "invalid URL %q", c.Broker.NATSURL)
+        }
+    }
+    if c.Broker.InvokeTimeout <= 0 {
+        fail("broker.invokeTimeout", "must be positive")
+    }
+
+    partnerLinks := make([]string, 0, len(c.Partners))
+    for partnerLink := range c.Partners {
+        partnerLinks = append(partnerLinks, partnerLink)
+    }
+    sort.Strings(partnerLinks)
+    for _, partnerLink := range partnerLinks {
+        p := c.Partners[partnerLink]
+        path := "partners." + partnerLink
+        if p == nil {
+            continue
+        }
+        secure := false
+        switch p.Transport {
+        case "", "http":
+            if p.URL != "" {
+                u, err := url.Parse(p.URL)
+                if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+                    fail(path+".url", "invalid URL %q, expected http:// or https://", p.URL)
+                }
+                secure = err == nil && u.Scheme == "https"
+            }
+        case "broker":
+            if c.Broker.NATSURL == "" {
+                fail(path+".transport", "broker transport requires broker.natsURL")
+            }
+            if len(p.Headers) > 0 {
+                fail(path+".headers", "not supported by the broker transport")
+            }
+        case "grpc":
+            u, err := url.Parse(p.URL)
+            if err != nil || (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Host == "" {
+                fail(path+".url", "invalid URL %q, expected grpc:// or grpcs://", p.URL)
+            }
+            secure = err == nil && u.Scheme == "grpcs"
+        default:
+            fail(path+".transport", "unsupported transport %q, expected http, grpc or broker", p.Transport)
+        }
+        if p.TLS != nil {
+            if !secure {
+                fail(path+".tls", "requires an https:// or grpcs:// url")
+            }
+            if (p.TLS.CertFile == "") != (p.TLS.KeyFile == "") {
+                fail(path+".tls", "certFile and keyFile must be set together")
+            }
+            for _, file := range []struct{ path, name string }{
+                {path + ".tls.certFile", p.TLS.CertFile},
+                {path + ".tls.keyFile", p.TLS.KeyFile},
+                {path + ".tls.caFile", p.TLS.CAFile},
+            } {
+                if file.name == "" {
+                    continue
+                }
+                if _, err := os.Stat(file.name); err != nil {
+                    fail(file.path, "%v", err)
+                }
+            }
+        }
+        names := make([]string, 0, len(p.Headers))
+        for name := range p.Headers {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            if _, err := secrets.References(p.Headers[name]); err != nil {
+                fail(path+".headers."+name, "%v", err)
+            }
+        }
+        if p.MaxConcurrency < 0 {
+            fail(path+".maxConcurrency", "must not be negative")
+        }
+        if p.RateLimit < 0 {
+            fail(path+".rateLimit", "must not be negative")
+        }
+        if p.Burst < 0 {
+            fail(path+".burst", "must not be negative")
+        }
+        if p.CircuitBreaker != nil {
+            if p.CircuitBreaker.FailureThreshold < 0 {
+                fail(path+".circuitBreaker.failureThreshold", "must not be negative")
+            }
+            if p.CircuitBreaker.OpenTimeout < 0 {
+                fail(path+".circuitBreaker.openTimeout", "must not be negative")
+            }
+        }
+    }
+
+    switch c.Secrets.Provider {
+    case "env":
+    case "file":
+        if c.Secrets.File.Path == "" {
+            fail("secrets.file.path", "required by the file provider")
+        }
+        if c.Secrets.File.KeyFile == "" {
+            fail("secrets.file.keyFile", "required by the file provider")
+        } else if _, err := secrets.ReadKey(c.Secrets.File.KeyFile); err != nil {
+            fail("secrets.file.keyFile", "%v", err)
+        }
+    case "vault":
+        if u, err := url.Parse(c.Secrets.Vault.Addr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            fail("secrets.vault.addr", "invalid URL %q", c.Secrets.Vault.Addr)
+        }
+        if c.Secrets.Vault.Mount == "" {
+            fail("secrets.vault.mount", "must not be empty")
+        }
+        if c.Secrets.Vault.CacheTTL < 0 {
+            fail("secrets.vault.cacheTTL", "must not be negative")
+        }
+    default:
+        fail("secrets.provider", "unsupported provider %q, expected env, file or vault", c.Secrets.Provider)
+    }
+
+    if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+        fail("tls", "certFile and keyFile must be set together")
+    }
+    if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+        fail("tls.clientCAFile", "requires certFile and keyFile")
+    }
+    switch c.TLS.ClientAuth {
+    case "", "require", "optional":
+        if c.TLS.ClientAuth != "" && c.TLS.ClientCAFile == "" {
+            fail("tls.clientAuth", "requires clientCAFile")
+        }
+    default:
+        fail("tls.clientAuth", "unsupported value %q, expected require or optional", c.TLS.ClientAuth)
+    }
+    for _, file := range []struct{ path, name string }{
+        {"tls.certFile", c.TLS.CertFile},
+        {"tls.keyFile", c.TLS.KeyFile},
+        {"tls.clientCAFile", c.TLS.ClientCAFile},
+        {"auth.jwksFile", c.Auth.JWKSFile},
+    } {
+        if file.name == "" {
+            continue
+        }
+        if _, err := os.Stat(file.name); err != nil {
+            fail(file.path, "%v", err)
+        }
+    }
+
+    if c.Auth.JWKSFile != "" && c.Auth.JWKSURL != "" {
+        fail("auth", "jwksFile and jwksURL are exclusive")
+    }
+    if c.Auth.JWKSURL != "" {
+        if u, err := url.Parse(c.Auth.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            fail("auth.jwksURL", "invalid URL %q", c.Auth.JWKSURL)
+        }
+    }
+    if (c.Auth.Issuer != "" || c.Auth.Audience != "") && !c.Auth.Enabled() {
+        fail("auth", "issuer and audience require jwksFile or jwksURL")
+    }
+    if c.Auth.Enabled() && c.Auth.TenantClaim == "" {
+        fail("auth.tenantClaim", "must not be empty")
+    }
+    if c.Auth.DefaultTenant != "" {
+        if !c.Auth.Enabled() {
+            fail("auth.defaultTenant", "requires jwksFile or jwksURL")
+        } else if !auth.ValidTenant(c.Auth.DefaultTenant) {
+            fail("auth.defaultTenant", "invalid tenant name")
+        }
+    }
+    if c.Authorization.PolicyFile != "" {
+        if !c.Auth.Enabled() {
+            fail("authorization.policyFile", "requires auth")
+        } else if _, err := authz.LoadPolicy(c.Authorization.PolicyFile); err != nil {
+            fail("authorization.policyFile", "%v", err)
+        }
+    }
+
+    checkQuota := func(path string, quota *QuotaConfig) {
+        if quota != nil && (quota.MaxProcesses < 0 || quota.MaxActiveInstances < 0 || quota.MaxSchedules < 0) {
+            fail(path, "limits must not be negative")
+        }
+    }
+    checkQuota("tenants.defaultQuota", &c.Tenants.DefaultQuota)
+    tenants := make([]string, 0, len(c.Tenants.Quotas))
+    for tenant := range c.Tenants.Quotas {
+        tenants = append(tenants, tenant)
+    }
+    sort.Strings(tenants)
+    for _, tenant := range tenants {
+        if !auth.ValidTenant(tenant) {
+            fail("tenants.quotas."+tenant, "invalid tenant name")
+        }
+        checkQuota("tenants.quotas."+tenant, c.Tenants.Quotas[tenant])
+    }
+
+    keyIds := make([]string, 0, len(c.Encryption.Keys))
+    for keyId := range c.Encryption.Keys {
+        keyIds = append(keyIds, keyId)
+    }
+    sort.Strings(keyIds)
+    for _, keyId := range keyIds {
+        path := "encryption.keys." + keyId
+        if !keyIdPattern.MatchString(keyId) {
+            fail(path, "invalid key id")
+        }
+        key := c.Encryption.Keys[keyId]
+        if names, err := secrets.References(key); err != nil {
+            fail(path, "%v", err)
+        } else if len(names) > 0 {
+            if key != "${secret:"+names[0]+"}" {
+                fail(path, "must be a key or a single ${secret:name} reference")
+            }
+        } else if data, err := base64.StdEncoding.DecodeString(key); err != nil || len(data) != 32 {
+            fail(path, "expected a base64 encoded 32 byte key")
+        }
+    }
+    if _, ok := c.Encryption.Keys[c.Encryption.ActiveKey]; c.Encryption.ActiveKey != "" && !ok {
+        fail("encryption.activeKey", "key %s is not in encryption.keys", c.Encryption.ActiveKey)
+    }
+
+    checkFields := func(path string, fields []string) {
+        for _, field := range fields {
+            for _, segment := range strings.Split(field, ".") {
+                if segment == "" {
+                    fail(path, "invalid field %q", field)
+                    break
+                }
+            }
+        }
+    }
+    checkFields("redaction.fields", c.Redaction.Fields)
+    processes := make([]string, 0, len(c.Redaction.Processes))
+    for process := range c.Redaction.Processes {
+        processes = append(processes, process)
+    }
+    sort.Strings(processes)
+    for _, process := range processes {
+        checkFields("redaction.processes."+process, c.Redaction.Processes[process])
+    }
+
+    if c.WorkerPool.Workers < 1 {
+        fail("workerPool.workers", "must be at least 1")
+    }
+
+    switch c.Observability.LogLevel {
+    case "debug", "info", "warn", "error":
+    default:
+        fail("observability.logLevel", "unsupported level %q, expected debug, info, warn or error", c.Observability.LogLevel)
+    }
+
+    switch c.Tracing.Exporter {
+    case "none", "stdout":
+    case "otlp":
+        if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+            fail("tracing.endpoint", "invalid collector URL %q, expected http://host:port or https://host:port", c.Tracing.Endpoint)
+        }
+    default:
+        fail("tracing.exporter", "unsupported exporter %q, expected none, otlp or stdout", c.Tracing.Exporter)
+    }
+    if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+        fail("tracing.sampleRatio", "must be between 0 and 1")
+    }
+    if c.Tracing.ServiceName == "" {
+        fail("tracing.serviceName", "must not be empty")
+    }
+    return errs
+}
+
+// Enabled reports whether the server is configured with TLS.
+func (t TLSConfig) Enabled() bool {
+    return t.CertFile != ""
+}
+
+// Enabled reports whether callers must authenticate.
+func (a AuthConfig) Enabled() bool {
+    return a.JWKSFile != "" || a.JWKSURL != ""
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+    "encoding/base64"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+func TestValidate(t *testing.T) {
+    dir := t.TempDir()
+    missing, present := filepath.Join(dir, "missing.pem"), filepath.Join(dir, "present.pem")
+    if err := os.WriteFile(present, nil, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name   string
+        change func(c *Config)
+        err    string
+    }{
+        {"default", func(c *Config) {}, ""},
+        {"grpc address", func(c *Config) { c.Server.GRPCAddr = "50051" }, `server.grpcAddr: invalid address "50051"`},
+        {"metrics address", func(c *Config) { c.Server.MetricsAddr = "metrics" }, "server.metricsAddr"},
+        {"idempotency window", func(c *Config) { c.Server.IdempotencyWindow = 0 }, "server.idempotencyWindow: must be positive"},
+        {"backend", func(c *Config) { c.Storage.Backend = "postgres" }, `storage.backend: unsupported backend "postgres"`},
+        {"mongodb uri", func(c *Config) { c.Storage.MongoDB.URI = "mongodb:27017" }, "storage.mongodb.uri"},
+        {"nats url", func(c *Config) { c.Broker.NATSURL = "nats" }, `broker.natsURL: invalid URL "nats"`},
+        {"broker transport", func(c *Config) { c.Partner("ragservice").Transport = "broker" }, "partners.ragservice.transport: broker transport requires broker.natsURL"},
+        {"broker headers", func(c *Config) {
+            c.Broker.NATSURL = "nats://nats:4222"
+            p := c.Partner("trainer")
+            p.Transport, p.Headers = "broker", map[string]string{"X-Api-Key": "${secret:key}"}
+        }, "partners.trainer.headers: not supported by the broker transport"},
+        {"partner url", func(c *Config) { c.Partner("ragservice").URL = "ftp://ragservice" }, "partners.ragservice.url"},
+        {"grpc partner url", func(c *Config) {
+            p := c.Partner("trainer")
+            p.Transport, p.URL = "grpc", "http://trainer:9000"
+        }, "expected grpc:// or grpcs://"},
+        {"partner transport", func(c *Config) { c.Partner("ragservice").Transport = "amqp" }, `unsupported transport "amqp"`},
+        {"partner tls over http", func(c *Config) {
+            p := c.Partner("ragservice")
+            p.URL, p.TLS = "http://ragservice", &PartnerTLSConfig{}
+        }, "partners.ragservice.tls: requires an https:// or grpcs:// url"},
+        {"partner certificate", func(c *Config) {
+            p := c.Partner("ragservice")
+            p.URL, p.TLS = "https://ragservice", &PartnerTLSConfig{CertFile: present}
+        }, "certFile and keyFile must be set together"},
+        {"partner header", func(c *Config) { c.Partner("ragservice").Headers = map[string]string{"X-Api-Key": "${secret:}"} }, "partners.ragservice.headers.X-Api-Key"},
+        {"partner rate limit", func(c *Config) { c.Partner("ragservice").RateLimit = -1 }, "partners.ragservice.rateLimit: must not be negative"},
+        {"circuit breaker", func(c *Config) { c.Partner("ragservice").CircuitBreaker = &BreakerConfig{OpenTimeout: -time.Second} }, "partners.ragservice.circuitBreaker.openTimeout"},
+        {"secrets provider", func(c *Config) { c.Secrets.Provider = "kms" }, `secrets.provider: unsupported provider "kms"`},
+        {"secrets file", func(c *Config) {
+            c.Secrets.Provider, c.Secrets.File.KeyFile = "file", missing
+        }, "secrets.file.path: required by the file provider"},
+        {"vault address", func(c *Config) {
+            c.Secrets.Provider, c.Secrets.Vault.Addr = "vault", "vault:8200"
+        }, "secrets.vault.addr"},
+        {"tls key", func(c *Config) { c.TLS.CertFile = present }, "tls: certFile and keyFile must be set together"},
+        {"tls file", func(c *Config) { c.TLS.CertFile, c.TLS.KeyFile = missing, missing }, "tls.certFile"},
+        {"client auth", func(c *Config) { c.TLS.ClientAuth = "require" }, "tls.clientAuth: requires clientCAFile"},
+        {"client auth value", func(c *Config) { c.TLS.ClientAuth = "always" }, `unsupported value "always"`},
+        {"jwks file and url", func(c *Config) {
+            c.Auth.JWKSURL = "https://login.example.com/jwks"
+            c.Auth.JWKSFile = present
+        }, "auth: jwksFile and jwksURL are exclusive"},
+        {"issuer without keys", func(c *Config) { c.Auth.Issuer = "https://login.example.com" }, "auth: issuer and audience require jwksFile or jwksURL"},
+        {"tenant claim", func(c *Config) {
+            c.Auth.JWKSURL, c.Auth.TenantClaim = "https://login.example.com/jwks", ""
+        }, "auth.tenantClaim: must not be empty"},
+        {"default tenant without auth", func(c *Config) { c.Auth.DefaultTenant = "default" }, "auth.defaultTenant: requires jwksFile or jwksURL"},
+        {"default tenant name", func(c *Config) {
+            c.Auth.JWKSURL, c.Auth.DefaultTenant = "https://login.example.com/jwks", "../admin"
+        }, "auth.defaultTenant: invalid tenant name"},
+        {"policy without auth", func(c *Config) { c.Authorization.PolicyFile = missing }, "authorization.policyFile: requires auth"},
+        {"default quota", func(c *Config) { c.Tenants.DefaultQuota.MaxSchedules = -1 }, "tenants.defaultQuota: limits must not be negative"},
+        {"tenant name", func(c *Config) { c.Tenants.Quotas["../admin"] = &QuotaConfig{} }, "tenants.quotas.../admin: invalid tenant name"},
+        {"tenant quota", func(c *Config) { c.Tenants.Quotas["ml-research"] = &QuotaConfig{MaxProcesses: -1} }, "tenants.quotas.ml-research"},
+        {"null tenant quota", func(c *Config) { c.Tenants.Quotas["ml-research"] = nil }, ""},
+        {"encryption key", func(c *Config) { c.Encryption.Keys["k1"] = testKey }, ""},
+        {"secret encryption key", func(c *Config) { c.Encryption.Keys["k1"] = "${secret:kek}" }, ""},
+        {"short encryption key", func(c *Config) { c.Encryption.Keys["k1"] = "c2hvcnQ=" }, "encryption.keys.k1: expected a base64 encoded 32 byte key"},
+        {"mixed encryption key", func(c *Config) { c.Encryption.Keys["k1"] = "x${secret:kek}" }, "must be a key or a single ${secret:name} reference"},
+        {"encryption key id", func(c *Config) { c.Encryption.Keys["k/1"] = testKey }, "encryption.keys.k/1: invalid key id"},
+        {"active key", func(c *Config) { c.Encryption.ActiveKey = "k2" }, "encryption.activeKey: key k2 is not in encryption.keys"},
+        {"redaction field", func(c *Config) { c.Redaction.Fields = []string{"farm..iban"} }, `redaction.fields: invalid field "farm..iban"`},
+        {"process redaction field", func(c *Config) { c.Redaction.Processes["training"] = []string{"apiKey."} }, "redaction.processes.training"},
+        {"workers", func(c *Config) { c.WorkerPool.Workers = 0 }, "workerPool.workers: must be at least 1"},
+        {"log level", func(c *Config) { c.Observability.LogLevel = "trace" }, `observability.logLevel: unsupported level "trace"`},
+        {"tracing exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, `tracing.exporter: unsupported exporter "jaeger"`},
+        {"tracing endpoint", func(c *Config) {
+            c.Tracing.Exporter, c.Tracing.Endpoint = "otlp", "localhost:4317"
+        }, "tracing.endpoint"},
+        {"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sampleRatio: must be between 0 and 1"},
+        {"service name", func(c *Config) { c.Tracing.ServiceName = "" }, "tracing.serviceName: must not be empty"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            c := Default()
+            test.change(c)
+            errs := c.validate()
+            if test.err == "" {
+                if len(errs) > 0 {
+                    t.Errorf("got %v", errs)
+                }
+                return
+            }
+            for _, err := range errs {
+                if strings.Contains(err.Error(), test.err) {
+                    return
+                }
+            }
+            t.Errorf("got %v, want %s", errs, test.err)
+        })
+    }
+}
+
+func TestValidateAllErrors(t *testing.T) {
+    c := Default()
+    c.Server.GRPCAddr = "50051"
+    c.WorkerPool.Workers = 0
+    c.Tracing.SampleRatio = -1
+    if errs := c.validate(); len(errs) != 3 {
+        t.Errorf("got %v, want 3 errors", errs)
+    }
+}
+
+func TestLoad(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "gobpel.yaml")
+    yaml := "server:\n  grpcAddr: \":9000\"\nworkerPool:\n  workers: 8\ntenants:\n  quotas: null\nencryption:\n  keys: null\n"
+    if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("GOBPEL_CONFIG", "")
+    t.Setenv("WORKER_POOL_SIZE", "16")
+    t.Setenv("TENANT_QUOTAS", "ml-research=10:100:2")
+    t.Setenv("ENCRYPTION_KEYS", "k1="+testKey)
+    t.Setenv("PARTNER_URLS", "ragservice=http://ragservice:8086")
+
+    c, err := Load([]string{"-config", file, "-workers", "32", "-encryption-active-key", "k1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if c.File != file || c.Server.GRPCAddr != ":9000" || c.Server.GatewayAddr != ":8090" {
+        t.Errorf("got %+v", c.Server)
+    }
+    // Flags override the environment, which overrides the file
+    if c.WorkerPool.Workers != 32 {
+        t.Errorf("got %d workers, want 32", c.WorkerPool.Workers)
+    }
+    if q := c.Tenants.Quotas["ml-research"]; q == nil || *q != (QuotaConfig{MaxProcesses: 10, MaxActiveInstances: 100, MaxSchedules: 2}) {
+        t.Errorf("got quota %+v", q)
+    }
+    if c.Encryption.ActiveKey != "k1" || c.Encryption.Keys["k1"] != testKey {
+        t.Errorf("got %+v", c.Encryption)
+    }
+    if p := c.Partners["ragservice"]; p == nil || p.URL != "http://ragservice:8086" {
+        t.Errorf("got partner %+v", p)
+    }
+}
+
+func TestLoadErrors(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, content string) string {
+        file := filepath.Join(dir, name)
+        if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+        return file
+    }
+    t.Setenv("GOBPEL_CONFIG", "")
+
+    tests := []struct {
+        name string
+        env  map[string]string
+        args []string
+        err  []string
+    }{
+        {"unknown flag", nil, []string{"-grpc-port", "1"}, []string{"flag provided but not defined"}},
+        {"missing file", nil, []string{"-config", filepath.Join(dir, "missing.yaml")}, []string{"no such file"}},
+        {"unknown key", nil, []string{"-config", write("unknown.yaml", "server:\n  grpcPort: 1\n")}, []string{"field grpcPort not found"}},
+        {"invalid duration", map[string]string{"IDEMPOTENCY_WINDOW": "1d"}, nil, []string{"IDEMPOTENCY_WINDOW:"}},
+        {"invalid quota", map[string]string{"TENANT_QUOTAS": "ml-research=10:100"}, nil, []string{`TENANT_QUOTAS: ml-research: invalid quota "10:100"`}},
+        {"invalid entry", map[string]string{"ENCRYPTION_KEYS": "k1"}, nil, []string{`ENCRYPTION_KEYS: invalid entry "k1"`}},
+        {"invalid flag value", nil, []string{"-workers", "many"}, []string{`-workers: invalid worker count "many"`}},
+        {"all problems", map[string]string{"SERVER_PORT": "http", "CIRCUIT_BREAKERS": "ragservice=3"}, []string{"-log-level", "trace"}, []string{
+            `SERVER_PORT: invalid port "http"`,
+            `CIRCUIT_BREAKERS: ragservice: invalid circuit breaker "3"`,
+            "observability.logLevel",
+        }},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            for name, value := range test.env {
+                t.Setenv(name, value)
+            }
+            _, err := Load(test.args)
+            if err == nil {
+                t.Fatal("configuration loaded")
+            }
+            for _, want := range test.err {
+                if !strings.Contains(err.Error(), want) {
+                    t.Errorf("got %v, want %s", err, want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+    "context"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "gobpel/api"
+)
+
+func InsertAuditEvent(event *api.AuditEvent) error {
+    collection := client.Database("gobpel").Collection("audit")
+    _, err := collection.InsertOne(context.Background(), event)
+    return err
+}
+
+// ListAuditEvents returns up to limit audit events of tenant, of action when
+// it is set, newest first and skipping the first offset.
+func ListAuditEvents(tenant, action string, offset, limit int64) ([]*api.AuditEvent, error) {
+    query := bson.M{"tenant": tenant}
+    if action != "" {
+        query["action"] = action
+    }
+    collection := client.Database("gobpel").Collection("audit")
+    cursor, err := collection.Find(context.Background(), query, findPage("time.seconds", true, offset, limit))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var events []*api.AuditEvent
+    for cursor.Next(context.Background()) {
+        var event api.AuditEvent
+        if err := cursor.Decode(&event); err != nil {
+            return nil, err
+        }
+        events = append(events, &event)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return events, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+    "reflect"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/bsoncodec"
+    "go.mongodb.org/mongo-driver/bson/bsonrw"
+    "go.mongodb.org/mongo-driver/bson/bsontype"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// registry stores protobuf Structs as plain documents. The generated Go types
+// hold their values in oneofs, which the default codecs cannot decode.
+func registry() *bsoncodec.Registry {
+    r := bson.NewRegistry()
+    structType := reflect.TypeOf(&structpb.Struct{})
+    r.RegisterTypeEncoder(structType, bsoncodec.ValueEncoderFunc(encodeStruct))
+    r.RegisterTypeDecoder(structType, bsoncodec.ValueDecoderFunc(decodeStruct))
+    return r
+}
+
+func encodeStruct(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+    if val.IsNil() {
+        return vw.WriteNull()
+    }
+    data, err := protojson.Marshal(val.Interface().(*structpb.Struct))
+    if err != nil {
+        return err
+    }
+    var doc bson.Raw
+    if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+        return err
+    }
+    return bsonrw.Copier{}.CopyDocumentFromBytes(vw, doc)
+}
+
+func decodeStruct(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+    if vr.Type() == bsontype.Null {
+        val.Set(reflect.Zero(val.Type()))
+        return vr.ReadNull()
+    }
+    _, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+    if err != nil {
+        return err
+    }
+    js, err := bson.MarshalExtJSON(bson.Raw(data), false, false)
+    if err != nil {
+        return err
+    }
+    s := &structpb.Struct{}
+    if err := protojson.Unmarshal(js, s); err != nil {
+        return err
+    }
+    val.Set(reflect.ValueOf(s))
+    return nil
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+    "context"
+    "encoding/base64"
+    "fmt"
+    "strings"
+    "sync"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "gobpel/api"
+    "gobpel/pkg/envelope"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/structpb"
+)
+
+// sealedField holds the sealed output of an instance in place of its
+// variables. Variable names cannot contain a colon, so it is never one.
+const sealedField = "gobpel:sealed"
+
+var (
+    keysMu sync.RWMutex
+    keys   envelope.KeyProvider
+)
+
+// SetKeyProvider encrypts the input and output of instances and the
+// payloads of their history in the store with envelope encryption under p.
+// Without a provider, or without an active key, they are stored in plain;
+// values stored sealed can only be read while their key is in p.
+func SetKeyProvider(p envelope.KeyProvider) {
+    keysMu.Lock()
+    defer keysMu.Unlock()
+    keys = p
+}
+
+func keyProvider() envelope.KeyProvider {
+    keysMu.RLock()
+    defer keysMu.RUnlock()
+    return keys
+}
+
+// sealing returns the provider values are sealed with, or nil to store them
+// in plain.
+func sealing() envelope.KeyProvider {
+    p := keyProvider()
+    if p == nil || p.ActiveKeyId() == "" {
+        return nil
+    }
+    return p
+}
+
+// fieldData ties a sealed value to the record and field it is stored in.
+func fieldData(id, field string) []byte {
+    return []byte(id + "/" + field)
+}
+
+func sealBytes(p envelope.KeyProvider, data []byte, id, field string) ([]byte, error) {
+    if len(data) == 0 {
+        return data, nil
+    }
+    return envelope.Seal(p, data, fieldData(id, field))
+}
+
+func openBytes(data []byte, id, field string) ([]byte, error) {
+    if !envelope.IsSealed(data) {
+        // Stored before encryption was enabled
+        return data, nil
+    }
+    p := keyProvider()
+    if p == nil {
+        return nil, fmt.Errorf("%s of %s is encrypted and no keys are configured", field, id)
+    }
+    plain, err := envelope.Open(p, data, fieldData(id, field))
+    if err != nil {
+        return nil, fmt.Errorf("%s of %s: %v", field, id, err)
+    }
+    return plain, nil
+}
+
+// sealInstance returns a copy of instance with its input and output sealed.
+func sealInstance(instance *api.Instance) (*api.Instance, error) {
+    p := sealing()
+    if p == nil {
+        return instance, nil
+    }
+    sealed := proto.Clone(instance).(*api.Instance)
+    var err error
+    if sealed.Input, err = sealBytes(p, instance.Input, instance.InstanceId, "input"); err != nil {
+        return nil, err
+    }
+    if instance.Output != nil {
+        data, err := protojson.Marshal(instance.Output)
+        if err != nil {
+            return nil, err
+        }
+        if data, err = sealBytes(p, data, instance.InstanceId, "output"); err != nil {
+            return nil, err
+        }
+        sealed.Output = &structpb.Struct{Fields: map[string]*structpb.Value{
+            sealedField: structpb.NewStringValue(base64.StdEncoding.EncodeToString(data)),
+        }}
+    }
+    return sealed, nil
+}
+
+// openInstance decrypts the input and output of a stored instance.
+func openInstance(instance *api.Instance) error {
+    var err error
+    if instance.Input, err = openBytes(instance.Input, instance.InstanceId, "input"); err != nil {
+        return err
+    }
+    value, ok := instance.Output.GetFields()[sealedField]
+    if !ok {
+        return nil
+    }
+    data, err := base64.StdEncoding.DecodeString(value.GetStringValue())
+    if err != nil {
+        return fmt.Errorf("output of %s: %v", instance.InstanceId, err)
+    }
+    if data, err = openBytes(data, instance.InstanceId, "output"); err != nil {
+        return err
+    }
+    output := &structpb.Struct{}
+    if err := protojson.Unmarshal(data, output); err != nil {
+        return fmt.Errorf("output of %s: %v", instance.InstanceId, err)
+    }
+    instance.Output = output
+    return nil
+}
+
+// eventId identifies an event among those of every instance.
+func eventId(event *api.InstanceEvent) string {
+    return fmt.Sprintf("%s/%d", event.InstanceId, event.Sequence)
+}
+
+// sealEvent returns a copy of event with its payloads sealed.
+func sealEvent(event *api.InstanceEvent) (*api.InstanceEvent, error) {
+    p := sealing()
+    if p == nil || len(event.RequestPayload) == 0 && len(event.ResponsePayload) == 0 {
+        return event, nil
+    }
+    sealed := proto.Clone(event).(*api.InstanceEvent)
+    var err error
+    if sealed.RequestPayload, err = sealBytes(p, event.RequestPayload, eventId(event), "request"); err != nil {
+        return nil, err
+    }
+    if sealed.ResponsePayload, err = sealBytes(p, event.ResponsePayload, eventId(event), "response"); err != nil {
+        return nil, err
+    }
+    return sealed, nil
+}
+
+// openEvent decrypts the payloads of a stored event.
+func openEvent(event *api.InstanceEvent) error {
+    var err error
+    if event.RequestPayload, err = openBytes(event.RequestPayload, eventId(event), "request"); err != nil {
+        return err
+    }
+    event.ResponsePayload, err = openBytes(event.ResponsePayload, eventId(event), "response")
+    return err
+}
+
+// RewrapKeys wraps the data keys of every stored value sealed with another
+// key than the active one with the active key, so that retired keys can be
+// removed. It returns how many values it rewrapped.
+func RewrapKeys(ctx context.Context) (int, error) {
+    p := sealing()
+    if p == nil {
+        return 0, envelope.ErrNoActiveKey
+    }
+    rewrapped := 0
+    for _, target := range []struct {
+        collection string
+        fields     []string
+    }{
+        {"instances", []string{"input", "output." + sealedField}},
+        {"history", []string{"requestpayload", "responsepayload"}},
+    } {
+        collection := client.Database("gobpel").Collection(target.collection)
+        for _, field := range target.fields {
+            cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$type": bson.A{"binData", "string"}}})
+            if err != nil {
+                return rewrapped, err
+            }
+            for cursor.Next(ctx) {
+                old, ok := lookupSealed(cursor.Current, field)
+                if !ok {
+                    continue
+                }
+                data, changed, err := envelope.Rewrap(p, old)
+                if err != nil {
+                    cursor.Close(ctx)
+                    return rewrapped, fmt.Errorf("%s %v: %v", target.collection, cursor.Current.Lookup("_id"), err)
+                }
+                if !changed {
+                    continue
+                }
+                // The record may have been saved again since it was read,
+                // sealed with the active key
+                var value interface{} = data
+                current := interface{}(primitive.Binary{Data: old})
+                if field == "output."+sealedField {
+                    value = base64.StdEncoding.EncodeToString(data)
+                    current = base64.StdEncoding.EncodeToString(old)
+                }
+                filter := bson.M{"_id": cursor.Current.Lookup("_id"), field: current}
+                result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{field: value}})
+                if err != nil {
+                    cursor.Close(ctx)
+                    return rewrapped, err
+                }
+                rewrapped += int(result.ModifiedCount)
+            }
+            err = cursor.Err()
+            cursor.Close(ctx)
+            if err != nil {
+                return rewrapped, err
+            }
+        }
+    }
+    return rewrapped, nil
+}
+
+// lookupSealed returns the sealed value stored in field of doc.
+func lookupSealed(doc bson.Raw, field string) ([]byte, bool) {
+    value, err := doc.LookupErr(strings.Split(field, ".")...)
+    if err != nil {
+        return nil, false
+    }
+    var data []byte
+    if s, ok := value.StringValueOK(); ok {
+        if data, err = base64.StdEncoding.DecodeString(s); err != nil {
+            return nil, false
+        }
+    } else if _, b, ok := value.BinaryOK(); ok {
+        data = b
+    }
+    return data, envelope.IsSealed(data)
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+    "context"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "gobpel/api"
+)
+
+// AppendInstanceEvent adds an event to the append-only history of its
+// instance, which must still hold fencingToken. It returns ErrFenced when
+// another replica has taken the instance over.
+func AppendInstanceEvent(event *api.InstanceEvent, fencingToken int64) error {
+    instances := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"instanceid": event.InstanceId, "fencingtoken": fencingToken}
+    n, err := instances.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrFenced
+    }
+
+    collection := client.Database("gobpel").Collection("history")
+    sealed, err := sealEvent(event)
+    if err != nil {
+        return err
+    }
+    _, err = collection.InsertOne(context.Background(), sealed)
+    return err
+}
+
+// GetInstanceHistory returns up to limit events of an instance of tenant
+// with a sequence greater than afterSequence, oldest first.
+func GetInstanceHistory(tenant, instanceId string, afterSequence int64, limit int64) ([]*api.InstanceEvent, error) {
+    collection := client.Database("gobpel").Collection("history")
+    filter := bson.M{"tenant": tenant, "instanceid": instanceId, "sequence": bson.M{"$gt": afterSequence}}
+    opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}).SetLimit(limit)
+    cursor, err := collection.Find(context.Background(), filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var events []*api.InstanceEvent
+    for cursor.Next(context.Background()) {
+        var event api.InstanceEvent
+        if err := cursor.Decode(&event); err != nil {
+            return nil, err
+        }
+        if err := openEvent(&event); err != nil {
+            return nil, err
+        }
+        events = append(events, &event)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return events, nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrDuplicate = errors.New("duplicate key")
+
+// IdempotencyRecord remembers a request made with an idempotency key until
+// ExpiresAt. Resource identifies what the request created once it has.
+type IdempotencyRecord struct {
+    Tenant        string
+    Scope         string
+    Key           string
+    RequestDigest string
+    Resource      string
+    ExpiresAt     time.Time
+}
+
+// ReserveIdempotencyKey stores record unless a live record with the same
+// tenant, scope and key exists, in which case that record is returned.
+func ReserveIdempotencyKey(record *IdempotencyRecord) (*IdempotencyRecord, error) {
+    collection := client.Database("gobpel").Collection("idempotency")
+    filter := bson.M{"tenant": record.Tenant, "scope": record.Scope, "key": record.Key}
+    for {
+        _, err := collection.InsertOne(context.Background(), record)
+        if err == nil {
+            return nil, nil
+        }
+        if !mongo.IsDuplicateKeyError(err) {
+            return nil, err
+        }
+
+        var existing IdempotencyRecord
+        err = collection.FindOne(context.Background(), filter).Decode(&existing)
+        if err == mongo.ErrNoDocuments {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        if existing.ExpiresAt.After(time.Now()) {
+            return &existing, nil
+        }
+        // Expired records linger until the TTL monitor removes them
+        expired := bson.M{"tenant": record.Tenant, "scope": record.Scope, "key": record.Key, "expiresat": bson.M{"$lte": time.Now()}}
+        if _, err := collection.DeleteOne(context.Background(), expired); err != nil {
+            return nil, err
+        }
+    }
+}
+
+// SetIdempotencyResource records what the request reserving key created and
+// keeps the record until expiresAt. It fails with ErrNotFound when the
+// reservation has been released or has expired.
+func SetIdempotencyResource(tenant, scope, key, resource string, expiresAt time.Time) error {
+    collection := client.Database("gobpel").Collection("idempotency")
+    filter := bson.M{"tenant": tenant, "scope": scope, "key": key, "resource": "", "expiresat": bson.M{"$gt": time.Now()}}
+    update := bson.M{"$set": bson.M{"resource": resource, "expiresat": expiresAt}}
+    result, err := collection.UpdateOne(context.Background(), filter, update)
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+// ReleaseIdempotencyKey forgets a reservation whose request failed so that it
+// can be retried.
+func ReleaseIdempotencyKey(tenant, scope, key string) error {
+    collection := client.Database("gobpel").Collection("idempotency")
+    _, err := collection.DeleteOne(context.Background(), bson.M{"tenant": tenant, "scope": scope, "key": key})
+    return err
+}
+
+// AcquireBusinessKey records instanceId as the active instance of processId
+// of tenant for key. If another instance holds the key, its id is returned
+// with ErrDuplicate.
+func AcquireBusinessKey(tenant, processId, key, instanceId string) (string, error) {
+    collection := client.Database("gobpel").Collection("businesskeys")
+    doc := bson.M{"tenant": tenant, "processid": processId, "key": key, "instanceid": instanceId}
+    _, err := collection.InsertOne(context.Background(), doc)
+    if err == nil {
+        return "", nil
+    }
+    if !mongo.IsDuplicateKeyError(err) {
+        return "", err
+    }
+
+    var holder struct {
+        InstanceId string `bson:"instanceid"`
+    }
+    filter := bson.M{"tenant": tenant, "processid": processId, "key": key}
+    if err := collection.FindOne(context.Background(), filter).Decode(&holder); err != nil {
+        return "", err
+    }
+    return holder.InstanceId, ErrDuplicate
+}
+
+func ReleaseBusinessKey(tenant, processId, key, instanceId string) error {
+    collection := client.Database("gobpel").Collection("businesskeys")
+    filter := bson.M{"tenant": tenant, "processid": processId, "key": key, "instanceid": instanceId}
+    _, err := collection.DeleteOne(context.Background(), filter)
+    return err
+}
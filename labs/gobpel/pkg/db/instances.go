@@ -0,0 +1,184 @@
+package db
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "gobpel/api"
+)
+
+// InstanceFilter selects instances of Tenant in ListInstances. Other zero
+// values match everything.
+type InstanceFilter struct {
+    Tenant         string
+    ProcessId      string
+    ProcessVersion string
+    State          string
+    StartedAfter   time.Time
+    StartedBefore  time.Time
+    BusinessKeys   map[string]string
+}
+
+// Sort keys accepted by the List functions, mapped to their stored fields
+var instanceSortFields = map[string]string{
+    "":          "starttime.seconds",
+    "startTime": "starttime.seconds",
+    "endTime":   "endtime.seconds",
+    "processId": "processid",
+    "state":     "state",
+}
+
+var processSortFields = map[string]string{
+    "":        "name",
+    "name":    "name",
+    "version": "version",
+}
+
+// SaveInstance creates or replaces the stored record of an instance. It
+// returns ErrFenced when another replica has taken the instance over since
+// its fencing token was issued.
+func SaveInstance(instance *api.Instance) error {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"instanceid": instance.InstanceId, "fencingtoken": instance.FencingToken}
+    sealed, err := sealInstance(instance)
+    if err != nil {
+        return err
+    }
+    _, err = collection.ReplaceOne(context.Background(), filter, sealed, options.Replace().SetUpsert(true))
+    if mongo.IsDuplicateKeyError(err) {
+        // The record exists with a newer token
+        return ErrFenced
+    }
+    return err
+}
+
+func GetInstance(tenant, instanceId string) (*api.Instance, error) {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"tenant": tenant, "instanceid": instanceId}
+    var instance api.Instance
+    err := collection.FindOne(context.Background(), filter).Decode(&instance)
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    if err := openInstance(&instance); err != nil {
+        return nil, err
+    }
+    return &instance, nil
+}
+
+// ListInstances returns up to limit instances matching filter, skipping the
+// first offset in orderBy order.
+func ListInstances(filter InstanceFilter, orderBy string, descending bool, offset, limit int64) ([]*api.Instance, error) {
+    sortField, ok := instanceSortFields[orderBy]
+    if !ok {
+        return nil, fmt.Errorf("%w: cannot order instances by %q", ErrInvalidSort, orderBy)
+    }
+
+    query := bson.M{"tenant": filter.Tenant}
+    if filter.ProcessId != "" {
+        query["processid"] = filter.ProcessId
+    }
+    if filter.ProcessVersion != "" {
+        query["processversion"] = filter.ProcessVersion
+    }
+    if filter.State != "" {
+        query["state"] = filter.State
+    }
+    startTime := bson.M{}
+    if !filter.StartedAfter.IsZero() {
+        startTime["$gte"] = filter.StartedAfter.Unix()
+    }
+    if !filter.StartedBefore.IsZero() {
+        startTime["$lt"] = filter.StartedBefore.Unix()
+    }
+    if len(startTime) > 0 {
+        query["starttime.seconds"] = startTime
+    }
+    for key, value := range filter.BusinessKeys {
+        query["businesskeys."+key] = value
+    }
+
+    collection := client.Database("gobpel").Collection("instances")
+    cursor, err := collection.Find(context.Background(), query, findPage(sortField, descending, offset, limit))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var instances []*api.Instance
+    for cursor.Next(context.Background()) {
+        var instance api.Instance
+        if err := cursor.Decode(&instance); err != nil {
+            return nil, err
+        }
+        if err := openInstance(&instance); err != nil {
+            return nil, err
+        }
+        instances = append(instances, &instance)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return instances, nil
+}
+
+// CountActiveInstances counts the queued and running instances of tenant.
+func CountActiveInstances(tenant string) (int64, error) {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"tenant": tenant, "state": bson.M{"$in": bson.A{"queued", "running"}}}
+    return collection.CountDocuments(context.Background(), filter)
+}
+
+// ListProcesses returns up to limit process definitions of tenant with the
+// given name and version, skipping the first offset in orderBy order.
+func ListProcesses(tenant, name, version, orderBy string, descending bool, offset, limit int64) ([]*api.Process, error) {
+    sortField, ok := processSortFields[orderBy]
+    if !ok {
+        return nil, fmt.Errorf("%w: cannot order processes by %q", ErrInvalidSort, orderBy)
+    }
+
+    query := bson.M{"tenant": tenant}
+    if name != "" {
+        query["name"] = name
+    }
+    if version != "" {
+        query["version"] = version
+    }
+
+    collection := client.Database("gobpel").Collection("processes")
+    cursor, err := collection.Find(context.Background(), query, findPage(sortField, descending, offset, limit))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var processes []*api.Process
+    for cursor.Next(context.Background()) {
+        var process api.Process
+        if err := cursor.Decode(&process); err != nil {
+            return nil, err
+        }
+        processes = append(processes, &process)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return processes, nil
+}
+
+func findPage(sortField string, descending bool, offset, limit int64) *options.FindOptions {
+    direction := 1
+    if descending {
+        direction = -1
+    }
+    // _id breaks ties so pages stay stable
+    sort := bson.D{{Key: sortField, Value: direction}, {Key: "_id", Value: direction}}
+    return options.Find().SetSort(sort).SetSkip(offset).SetLimit(limit)
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AcquireLease takes or renews the lease called name for holder until ttl
+// from now. It returns false while another holder's lease has not expired.
+func AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+    collection := client.Database("gobpel").Collection("leases")
+    now := time.Now()
+    filter := bson.M{
+        "name": name,
+        "$or": bson.A{
+            bson.M{"holder": holder},
+            bson.M{"expiresat": bson.M{"$lt": now}},
+        },
+    }
+    update := bson.M{"$set": bson.M{"holder": holder, "expiresat": now.Add(ttl)}}
+    _, err := collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+    if mongo.IsDuplicateKeyError(err) {
+        // The lease exists and is held by someone else
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// ReleaseLease gives up the lease called name if holder holds it.
+func ReleaseLease(name, holder string) error {
+    collection := client.Database("gobpel").Collection("leases")
+    update := bson.M{"$set": bson.M{"expiresat": time.Unix(0, 0)}}
+    _, err := collection.UpdateOne(context.Background(), bson.M{"name": name, "holder": holder}, update)
+    return err
+}
@@ -5,37 +5,173 @@ import (
     "errors"
 
     "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/event"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
     "gobpel/api"
+    "gobpel/pkg/metrics"
 )
 
 var client *mongo.Client
 
+var (
+    ErrNotFound    = errors.New("no documents found")
+    ErrInvalidSort = errors.New("invalid sort order")
+)
+
+// DefaultTenant owns records stored before tenants were introduced and the
+// requests of callers that do not belong to a tenant.
+const DefaultTenant = "default"
+
+// Collections whose records belong to a tenant
+var tenantCollections = []string{"processes", "instances", "history", "schedules", "queue", "businesskeys", "idempotency", "audit"}
+
+// Indexes replaced by ones that start with the tenant
+var supersededIndexes = map[string][]string{
+    "businesskeys": {"processid_1_key_1"},
+    "idempotency":  {"scope_1_key_1"},
+    "schedules":    {"scheduleid_1"},
+    "instances": {
+        "tenant_1_state_1",
+        "processid_1_processversion_1_starttime.seconds_-1",
+        "state_1_starttime.seconds_-1",
+        "starttime.seconds_-1",
+        "businesskeys.$**_1",
+    },
+    "processes": {"name_1_version_1"},
+}
+
 // Initialize the MongoDB client
 func InitMongoDB(uri string) error {
     var err error
-    client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+    opts := options.Client().ApplyURI(uri).SetRegistry(registry()).SetMonitor(commandMonitor())
+    client, err = mongo.Connect(context.TODO(), opts)
+    if err != nil {
+        return err
+    }
+    err = client.Ping(context.TODO(), nil)
     if err != nil {
         return err
     }
-    return client.Ping(context.TODO(), nil)
+    if err := migrateTenants(); err != nil {
+        return err
+    }
+    return createIndexes()
+}
+
+// commandMonitor observes the duration of every MongoDB command.
+func commandMonitor() *event.CommandMonitor {
+    return &event.CommandMonitor{
+        Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+            metrics.MongoDBDuration.WithLabelValues(e.CommandName, "ok").Observe(e.Duration.Seconds())
+        },
+        Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+            metrics.MongoDBDuration.WithLabelValues(e.CommandName, "error").Observe(e.Duration.Seconds())
+        },
+    }
+}
+
+// migrateTenants assigns records without a tenant to the default tenant and
+// drops the indexes replaced by ones that start with it.
+func migrateTenants() error {
+    for _, name := range tenantCollections {
+        collection := client.Database("gobpel").Collection(name)
+        filter := bson.M{"tenant": bson.M{"$exists": false}}
+        if _, err := collection.UpdateMany(context.TODO(), filter, bson.M{"$set": bson.M{"tenant": DefaultTenant}}); err != nil {
+            return err
+        }
+    }
+    for name, indexes := range supersededIndexes {
+        collection := client.Database("gobpel").Collection(name)
+        for _, index := range indexes {
+            _, err := collection.Indexes().DropOne(context.TODO(), index)
+            var cmdErr mongo.CommandError
+            if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+                continue
+            }
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Disconnect closes the MongoDB client once its operations have finished.
+func Disconnect(ctx context.Context) error {
+    return client.Disconnect(ctx)
+}
+
+func createIndexes() error {
+    indexes := map[string][]mongo.IndexModel{
+        "history": {
+            {Keys: bson.D{{Key: "instanceid", Value: 1}, {Key: "sequence", Value: 1}}, Options: options.Index().SetUnique(true)},
+        },
+        "instances": {
+            {Keys: bson.D{{Key: "instanceid", Value: 1}}, Options: options.Index().SetUnique(true)},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "state", Value: 1}, {Key: "starttime.seconds", Value: -1}}},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "processid", Value: 1}, {Key: "processversion", Value: 1}, {Key: "starttime.seconds", Value: -1}}},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "starttime.seconds", Value: -1}}},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "businesskeys.$**", Value: 1}}},
+            // Leases are renewed and taken over across tenants
+            {Keys: bson.D{{Key: "owner", Value: 1}, {Key: "state", Value: 1}}},
+            {Keys: bson.D{{Key: "state", Value: 1}, {Key: "leaseexpiresat.seconds", Value: 1}}},
+        },
+        "processes": {
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "name", Value: 1}, {Key: "version", Value: 1}}},
+        },
+        "idempotency": {
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "scope", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+            {Keys: bson.D{{Key: "expiresat", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+        },
+        "businesskeys": {
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "processid", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+        },
+        "schedules": {
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "scheduleid", Value: 1}}, Options: options.Index().SetUnique(true)},
+            {Keys: bson.D{{Key: "paused", Value: 1}, {Key: "nextruntime.seconds", Value: 1}}},
+        },
+        "queue": {
+            {Keys: bson.D{{Key: "instanceid", Value: 1}}, Options: options.Index().SetUnique(true)},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "processid", Value: 1}}},
+        },
+        "leases": {
+            {Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+        },
+        "audit": {
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "time.seconds", Value: -1}}},
+            {Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "action", Value: 1}, {Key: "time.seconds", Value: -1}}},
+        },
+    }
+    for name, models := range indexes {
+        collection := client.Database("gobpel").Collection(name)
+        if _, err := collection.Indexes().CreateMany(context.TODO(), models); err != nil {
+            return err
+        }
+    }
+    return nil
 }
 
+// CreateProcess stores a process. Names are unique within the tenant of the
+// process.
 func CreateProcess(process *api.Process) error {
     collection := client.Database("gobpel").Collection("processes")
     _, err := collection.InsertOne(context.Background(), process)
+    if mongo.IsDuplicateKeyError(err) {
+        return ErrDuplicate
+    }
     return err
 }
 
-func GetProcess(processId string) (*api.Process, error) {
+func GetProcess(tenant, processId string) (*api.Process, error) {
     collection := client.Database("gobpel").Collection("processes")
-    filter := bson.M{"name": processId}
+    filter := bson.M{"tenant": tenant, "name": processId}
     var process api.Process
     err := collection.FindOne(context.Background(), filter).Decode(&process)
     if err != nil {
         if err == mongo.ErrNoDocuments {
-            return nil, errors.New("no documents found")
+            return nil, ErrNotFound
         }
         return nil, err
     }
@@ -44,37 +180,45 @@ func GetProcess(processId string) (*api.Process, error) {
 
 func UpdateProcess(process *api.Process) (*api.Process, error) {
     collection := client.Database("gobpel").Collection("processes")
-    filter := bson.M{"name": process.Name}
+    filter := bson.M{"tenant": process.Tenant, "name": process.Name}
     update := bson.M{"$set": process}
-    _, err := collection.UpdateOne(context.Background(), filter, update)
+    result, err := collection.UpdateOne(context.Background(), filter, update)
     if err != nil {
         return nil, err
     }
+    if result.MatchedCount == 0 {
+        return nil, ErrNotFound
+    }
     return process, nil
 }
 
-func DeleteProcess(processId string) (bool, error) {
+func DeleteProcess(tenant, processId string) (bool, error) {
     collection := client.Database("gobpel").Collection("processes")
-    filter := bson.M{"name": processId}
+    filter := bson.M{"tenant": tenant, "name": processId}
     result, err := collection.DeleteOne(context.Background(), filter)
     if err != nil {
         return false, err
     }
     if result.DeletedCount == 0 {
-        return false, errors.New("no documents deleted")
+        return false, ErrNotFound
     }
     return true, nil
 }
 
-func DeleteAllProcesses() error {
+func DeleteAllProcesses(tenant string) error {
     collection := client.Database("gobpel").Collection("processes")
-    _, err := collection.DeleteMany(context.Background(), bson.M{})
+    _, err := collection.DeleteMany(context.Background(), bson.M{"tenant": tenant})
     return err
 }
 
-func GetAllProcesses() ([]*api.Process, error) {
+func CountProcesses(tenant string) (int64, error) {
+    collection := client.Database("gobpel").Collection("processes")
+    return collection.CountDocuments(context.Background(), bson.M{"tenant": tenant})
+}
+
+func GetAllProcesses(tenant string) ([]*api.Process, error) {
     collection := client.Database("gobpel").Collection("processes")
-    cursor, err := collection.Find(context.Background(), bson.M{})
+    cursor, err := collection.Find(context.Background(), bson.M{"tenant": tenant})
     if err != nil {
         return nil, err
     }
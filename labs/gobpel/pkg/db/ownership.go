@@ -0,0 +1,118 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "gobpel/api"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrFenced = errors.New("instance is owned by another replica")
+
+// Instances in these states are owned by the replica running them. The
+// owner renews their leases; once a lease expires any replica may take the
+// instance over, which increments its fencing token.
+var activeStates = bson.A{"queued", "running"}
+
+// RenewInstanceLeases extends the leases of the instances of instanceIds that
+// are active and still owned by owner until expiresAt and returns the ids of
+// those instances. Leases of other instances of owner, left over from before
+// a restart, expire so that they are taken over.
+func RenewInstanceLeases(owner string, instanceIds []string, expiresAt time.Time) (map[string]bool, error) {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"owner": owner, "instanceid": bson.M{"$in": instanceIds}, "state": bson.M{"$in": activeStates}}
+    update := bson.M{"$set": bson.M{"leaseexpiresat": timestamppb.New(expiresAt)}}
+    if _, err := collection.UpdateMany(context.Background(), filter, update); err != nil {
+        return nil, err
+    }
+
+    opts := options.Find().SetProjection(bson.M{"instanceid": 1})
+    cursor, err := collection.Find(context.Background(), filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    owned := make(map[string]bool)
+    for cursor.Next(context.Background()) {
+        var instance api.Instance
+        if err := cursor.Decode(&instance); err != nil {
+            return nil, err
+        }
+        owned[instance.InstanceId] = true
+    }
+    return owned, cursor.Err()
+}
+
+// ExpiredInstances returns up to limit active instances whose lease expired
+// before now, oldest first.
+func ExpiredInstances(now time.Time, limit int64) ([]*api.Instance, error) {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"state": bson.M{"$in": activeStates}, "leaseexpiresat.seconds": bson.M{"$lt": now.Unix()}}
+    opts := options.Find().SetSort(bson.D{{Key: "starttime.seconds", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(limit)
+    cursor, err := collection.Find(context.Background(), filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var instances []*api.Instance
+    for cursor.Next(context.Background()) {
+        var instance api.Instance
+        if err := cursor.Decode(&instance); err != nil {
+            return nil, err
+        }
+        if err := openInstance(&instance); err != nil {
+            return nil, err
+        }
+        instances = append(instances, &instance)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return instances, nil
+}
+
+// TakeOverInstance makes owner the owner of an instance whose lease has
+// expired and issues it the next fencing token. It returns nil when another
+// replica took the instance first or its owner renewed the lease.
+func TakeOverInstance(instance *api.Instance, owner string, expiresAt time.Time) (*api.Instance, error) {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{
+        "instanceid":             instance.InstanceId,
+        "fencingtoken":           instance.FencingToken,
+        "leaseexpiresat.seconds": bson.M{"$lt": time.Now().Unix()},
+    }
+    update := bson.M{
+        "$set": bson.M{"owner": owner, "leaseexpiresat": timestamppb.New(expiresAt)},
+        "$inc": bson.M{"fencingtoken": 1},
+    }
+    opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+    var taken api.Instance
+    err := collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&taken)
+    if err == mongo.ErrNoDocuments {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := openInstance(&taken); err != nil {
+        return nil, err
+    }
+    return &taken, nil
+}
+
+// ReleaseInstanceLease gives up the lease of an instance so that another
+// replica takes it over without waiting for the lease to expire.
+func ReleaseInstanceLease(instanceId string, fencingToken int64) error {
+    collection := client.Database("gobpel").Collection("instances")
+    filter := bson.M{"instanceid": instanceId, "fencingtoken": fencingToken}
+    update := bson.M{"$set": bson.M{"owner": "", "leaseexpiresat": timestamppb.New(time.Unix(0, 0))}}
+    _, err := collection.UpdateOne(context.Background(), filter, update)
+    return err
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// QueueEntry is an instance waiting for a worker.
+type QueueEntry struct {
+    Tenant     string
+    InstanceId string
+    ProcessId  string
+    EnqueuedAt time.Time
+}
+
+func EnqueueInstance(entry *QueueEntry) error {
+    collection := client.Database("gobpel").Collection("queue")
+    _, err := collection.InsertOne(context.Background(), entry)
+    return err
+}
+
+func DequeueInstance(instanceId string) error {
+    collection := client.Database("gobpel").Collection("queue")
+    _, err := collection.DeleteOne(context.Background(), bson.M{"instanceid": instanceId})
+    return err
+}
+
+// QueueDepth counts the instances of processId of tenant queued on any
+// replica, or of every process of tenant when processId is empty.
+func QueueDepth(tenant, processId string) (int64, error) {
+    collection := client.Database("gobpel").Collection("queue")
+    filter := bson.M{"tenant": tenant}
+    if processId != "" {
+        filter["processid"] = processId
+    }
+    return collection.CountDocuments(context.Background(), filter)
+}
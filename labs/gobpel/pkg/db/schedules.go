@@ -0,0 +1,130 @@
+package db
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "gobpel/api"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func CreateSchedule(schedule *api.Schedule) error {
+    collection := client.Database("gobpel").Collection("schedules")
+    _, err := collection.InsertOne(context.Background(), schedule)
+    if mongo.IsDuplicateKeyError(err) {
+        return ErrDuplicate
+    }
+    return err
+}
+
+func GetSchedule(tenant, scheduleId string) (*api.Schedule, error) {
+    collection := client.Database("gobpel").Collection("schedules")
+    var schedule api.Schedule
+    err := collection.FindOne(context.Background(), bson.M{"tenant": tenant, "scheduleid": scheduleId}).Decode(&schedule)
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    return &schedule, nil
+}
+
+// ListSchedules returns up to limit schedules of tenant, of processId when
+// it is set, ordered by id and skipping the first offset.
+func ListSchedules(tenant, processId string, offset, limit int64) ([]*api.Schedule, error) {
+    query := bson.M{"tenant": tenant}
+    if processId != "" {
+        query["processid"] = processId
+    }
+    return findSchedules(query, findPage("scheduleid", false, offset, limit))
+}
+
+func CountSchedules(tenant string) (int64, error) {
+    collection := client.Database("gobpel").Collection("schedules")
+    return collection.CountDocuments(context.Background(), bson.M{"tenant": tenant})
+}
+
+// DueSchedules returns the schedules that are not paused and whose next run
+// time is not after now.
+func DueSchedules(now time.Time) ([]*api.Schedule, error) {
+    query := bson.M{"paused": false, "nextruntime.seconds": bson.M{"$lte": now.Unix()}}
+    return findSchedules(query, options.Find())
+}
+
+func findSchedules(query bson.M, opts *options.FindOptions) ([]*api.Schedule, error) {
+    collection := client.Database("gobpel").Collection("schedules")
+    cursor, err := collection.Find(context.Background(), query, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(context.Background())
+
+    var schedules []*api.Schedule
+    for cursor.Next(context.Background()) {
+        var schedule api.Schedule
+        if err := cursor.Decode(&schedule); err != nil {
+            return nil, err
+        }
+        schedules = append(schedules, &schedule)
+    }
+    if err := cursor.Err(); err != nil {
+        return nil, err
+    }
+    return schedules, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule. Resumed schedules run next
+// at nextRunTime.
+func SetSchedulePaused(tenant, scheduleId string, paused bool, nextRunTime *timestamppb.Timestamp) (*api.Schedule, error) {
+    collection := client.Database("gobpel").Collection("schedules")
+    set := bson.M{"paused": paused}
+    if nextRunTime != nil {
+        set["nextruntime"] = nextRunTime
+    }
+    opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+    var schedule api.Schedule
+    filter := bson.M{"tenant": tenant, "scheduleid": scheduleId}
+    err := collection.FindOneAndUpdate(context.Background(), filter, bson.M{"$set": set}, opts).Decode(&schedule)
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    return &schedule, nil
+}
+
+// AdvanceSchedule moves the next run time of a schedule from due to next. It
+// returns false when another replica has already advanced it.
+func AdvanceSchedule(tenant, scheduleId string, due, next *timestamppb.Timestamp) (bool, error) {
+    collection := client.Database("gobpel").Collection("schedules")
+    filter := bson.M{"tenant": tenant, "scheduleid": scheduleId, "nextruntime.seconds": due.Seconds, "nextruntime.nanos": due.Nanos}
+    result, err := collection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"nextruntime": next}})
+    if err != nil {
+        return false, err
+    }
+    return result.ModifiedCount == 1, nil
+}
+
+func RecordScheduleRun(tenant, scheduleId string, runTime *timestamppb.Timestamp, instanceId string) error {
+    collection := client.Database("gobpel").Collection("schedules")
+    update := bson.M{"$set": bson.M{"lastruntime": runTime, "lastinstanceid": instanceId}}
+    _, err := collection.UpdateOne(context.Background(), bson.M{"tenant": tenant, "scheduleid": scheduleId}, update)
+    return err
+}
+
+func DeleteSchedule(tenant, scheduleId string) error {
+    collection := client.Database("gobpel").Collection("schedules")
+    result, err := collection.DeleteOne(context.Background(), bson.M{"tenant": tenant, "scheduleid": scheduleId})
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
@@ -0,0 +1,212 @@
+// Package envelope seals values with envelope encryption. Every value is
+// encrypted with its own data key, and the data key is wrapped with a key
+// encryption key of a KeyProvider. Rotating the key encryption key only
+// re-wraps data keys; values are not encrypted again.
+package envelope
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "fmt"
+)
+
+// KeyProvider wraps and unwraps data keys with key encryption keys named by
+// key ids. New data keys are wrapped with the active key.
+type KeyProvider interface {
+    ActiveKeyId() string
+    WrapKey(keyId string, dataKey []byte) ([]byte, error)
+    UnwrapKey(keyId string, wrapped []byte) ([]byte, error)
+}
+
+// Sealed values start with magic, which no JSON document does, followed by
+// the key id, the wrapped data key and the encrypted value:
+// magic | len(keyId) | keyId | len(wrapped) uint16 | wrapped | nonce | ciphertext
+var magic = []byte{0, 'g', 'b', 'e', 1}
+
+var ErrNoActiveKey = errors.New("no active key")
+
+// IsSealed reports whether data is a sealed value.
+func IsSealed(data []byte) bool {
+    return bytes.HasPrefix(data, magic)
+}
+
+// Seal encrypts plain with a new data key wrapped by the active key of p.
+// The value can only be opened with the same additional data, which ties
+// it to the record and field it is stored in.
+func Seal(p KeyProvider, plain, additionalData []byte) ([]byte, error) {
+    keyId := p.ActiveKeyId()
+    if keyId == "" {
+        return nil, ErrNoActiveKey
+    }
+    dataKey := make([]byte, 32)
+    if _, err := rand.Read(dataKey); err != nil {
+        return nil, err
+    }
+    wrapped, err := p.WrapKey(keyId, dataKey)
+    if err != nil {
+        return nil, err
+    }
+    sealed := header(keyId, wrapped)
+    return seal(dataKey, sealed, plain, additionalData)
+}
+
+// Open decrypts a sealed value.
+func Open(p KeyProvider, sealed, additionalData []byte) ([]byte, error) {
+    keyId, wrapped, ciphertext, err := parse(sealed)
+    if err != nil {
+        return nil, err
+    }
+    dataKey, err := p.UnwrapKey(keyId, wrapped)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := newAEAD(dataKey)
+    if err != nil {
+        return nil, err
+    }
+    if len(ciphertext) < aead.NonceSize() {
+        return nil, errors.New("sealed value is truncated")
+    }
+    plain, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+    if err != nil {
+        return nil, errors.New("cannot open sealed value")
+    }
+    return plain, nil
+}
+
+// KeyId returns the id of the key the data key of a sealed value is wrapped
+// with.
+func KeyId(sealed []byte) (string, error) {
+    keyId, _, _, err := parse(sealed)
+    return keyId, err
+}
+
+// Rewrap wraps the data key of a sealed value with the active key of p. It
+// returns the value unchanged and false when it already is.
+func Rewrap(p KeyProvider, sealed []byte) ([]byte, bool, error) {
+    keyId, wrapped, ciphertext, err := parse(sealed)
+    if err != nil {
+        return nil, false, err
+    }
+    active := p.ActiveKeyId()
+    if active == "" {
+        return nil, false, ErrNoActiveKey
+    }
+    if keyId == active {
+        return sealed, false, nil
+    }
+    dataKey, err := p.UnwrapKey(keyId, wrapped)
+    if err != nil {
+        return nil, false, err
+    }
+    if wrapped, err = p.WrapKey(active, dataKey); err != nil {
+        return nil, false, err
+    }
+    return append(header(active, wrapped), ciphertext...), true, nil
+}
+
+func header(keyId string, wrapped []byte) []byte {
+    h := append([]byte{}, magic...)
+    h = append(h, byte(len(keyId)))
+    h = append(h, keyId...)
+    h = binary.BigEndian.AppendUint16(h, uint16(len(wrapped)))
+    return append(h, wrapped...)
+}
+
+func parse(sealed []byte) (keyId string, wrapped, ciphertext []byte, err error) {
+    if !IsSealed(sealed) {
+        return "", nil, nil, errors.New("not a sealed value")
+    }
+    rest := sealed[len(magic):]
+    if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+        return "", nil, nil, errors.New("sealed value is truncated")
+    }
+    keyId, rest = string(rest[1:1+int(rest[0])]), rest[1+int(rest[0]):]
+    n := int(binary.BigEndian.Uint16(rest))
+    if len(rest) < 2+n {
+        return "", nil, nil, errors.New("sealed value is truncated")
+    }
+    return keyId, rest[2 : 2+n], rest[2+n:], nil
+}
+
+// seal appends the nonce and the encryption of plain with key to dst.
+func seal(key, dst, plain, additionalData []byte) ([]byte, error) {
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    dst = append(dst, nonce...)
+    return aead.Seal(dst, nonce, plain, additionalData), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// Keyring is a KeyProvider holding AES-256 key encryption keys in memory.
+// Keys stay in the keyring after they are replaced as the active key, so
+// values sealed with them can still be opened.
+type Keyring struct {
+    active string
+    keys   map[string][]byte
+}
+
+// NewKeyring returns a keyring of keys, by key id, that wraps new data keys
+// with active. Without an active key values can be opened but not sealed.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+    for keyId, key := range keys {
+        if keyId == "" || len(keyId) > 255 {
+            return nil, fmt.Errorf("invalid key id %q", keyId)
+        }
+        if len(key) != 32 {
+            return nil, fmt.Errorf("key %s: expected 32 bytes", keyId)
+        }
+    }
+    if _, ok := keys[active]; active != "" && !ok {
+        return nil, fmt.Errorf("active key %s is not in the keyring", active)
+    }
+    return &Keyring{active: active, keys: keys}, nil
+}
+
+func (k *Keyring) ActiveKeyId() string {
+    return k.active
+}
+
+func (k *Keyring) WrapKey(keyId string, dataKey []byte) ([]byte, error) {
+    key, ok := k.keys[keyId]
+    if !ok {
+        return nil, fmt.Errorf("key %s is not in the keyring", keyId)
+    }
+    return seal(key, nil, dataKey, []byte(keyId))
+}
+
+func (k *Keyring) UnwrapKey(keyId string, wrapped []byte) ([]byte, error) {
+    key, ok := k.keys[keyId]
+    if !ok {
+        return nil, fmt.Errorf("key %s is not in the keyring", keyId)
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(wrapped) < aead.NonceSize() {
+        return nil, errors.New("wrapped key is truncated")
+    }
+    dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyId))
+    if err != nil {
+        return nil, fmt.Errorf("cannot unwrap data key with key %s", keyId)
+    }
+    return dataKey, nil
+}
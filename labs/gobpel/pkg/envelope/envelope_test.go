@@ -0,0 +1,172 @@
+package envelope
+
+import (
+    "bytes"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func testKey(b byte) []byte {
+    return bytes.Repeat([]byte{b}, 32)
+}
+
+func testKeyring(t *testing.T, active string) *Keyring {
+    t.Helper()
+    k, err := NewKeyring(active, map[string][]byte{"2023": testKey(1), "2024": testKey(2)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return k
+}
+
+func TestSealOpen(t *testing.T) {
+    k := testKeyring(t, "2024")
+    aad := []byte("instances/42/variables")
+    for _, plain := range [][]byte{[]byte(`{"apiKey":"s3cr3t"}`), {}, bytes.Repeat([]byte("x"), 1<<16)} {
+        sealed, err := Seal(k, plain, aad)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !IsSealed(sealed) || bytes.Contains(sealed, []byte("s3cr3t")) {
+            t.Fatalf("not sealed: %q", sealed)
+        }
+        if keyId, err := KeyId(sealed); err != nil || keyId != "2024" {
+            t.Errorf("got key %q, %v", keyId, err)
+        }
+        opened, err := Open(k, sealed, aad)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(opened, plain) {
+            t.Errorf("got %q, want %q", opened, plain)
+        }
+    }
+
+    // Every value has its own data key and nonce
+    a, _ := Seal(k, []byte("same"), aad)
+    b, _ := Seal(k, []byte("same"), aad)
+    if bytes.Equal(a, b) {
+        t.Error("equal values sealed alike")
+    }
+}
+
+func TestOpenErrors(t *testing.T) {
+    k := testKeyring(t, "2024")
+    aad := []byte("instances/42/variables")
+    sealed, err := Seal(k, []byte(`{"apiKey":"s3cr3t"}`), aad)
+    if err != nil {
+        t.Fatal(err)
+    }
+    flip := func(i int) []byte {
+        b := append([]byte{}, sealed...)
+        b[i] ^= 1
+        return b
+    }
+    headerLen := len(magic) + 1 + len("2024")
+    other, _ := NewKeyring("2024", map[string][]byte{"2024": testKey(9)})
+
+    tests := []struct {
+        name   string
+        keys   KeyProvider
+        sealed []byte
+        aad    []byte
+        err    string
+    }{
+        {"other record", k, sealed, []byte("instances/43/variables"), "cannot open sealed value"},
+        {"no additional data", k, sealed, nil, "cannot open sealed value"},
+        {"ciphertext", k, flip(len(sealed) - 1), aad, "cannot open sealed value"},
+        {"wrapped key", k, flip(headerLen + 2), aad, "cannot unwrap data key with key 2024"},
+        {"key id", k, flip(len(magic) + 1), aad, "is not in the keyring"},
+        {"other keyring", other, sealed, aad, "cannot unwrap data key"},
+        {"plain JSON", k, []byte(`{"apiKey":"s3cr3t"}`), aad, "not a sealed value"},
+        {"truncated header", k, sealed[:headerLen], aad, "truncated"},
+        {"truncated wrapped key", k, sealed[:headerLen+4], aad, "truncated"},
+        {"truncated ciphertext", k, sealed[:len(sealed)-len(`{"apiKey":"s3cr3t"}`)-20], aad, "truncated"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := Open(test.keys, test.sealed, test.aad)
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+}
+
+func TestRewrap(t *testing.T) {
+    aad := []byte("history/7/responsePayload")
+    old := testKeyring(t, "2023")
+    sealed, err := Seal(old, []byte("payload"), aad)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    rotated := testKeyring(t, "2024")
+    rewrapped, changed, err := Rewrap(rotated, sealed)
+    if err != nil || !changed {
+        t.Fatalf("got %v, %v", changed, err)
+    }
+    if keyId, _ := KeyId(rewrapped); keyId != "2024" {
+        t.Errorf("got key %s", keyId)
+    }
+    // Only the data key is wrapped again; the value keeps its ciphertext
+    if !bytes.HasSuffix(rewrapped, sealed[len(sealed)-len("payload")-16:]) {
+        t.Error("value encrypted again")
+    }
+    if plain, err := Open(rotated, rewrapped, aad); err != nil || string(plain) != "payload" {
+        t.Errorf("got %q, %v", plain, err)
+    }
+    if _, err := Open(rotated, rewrapped, []byte("history/8/responsePayload")); err == nil {
+        t.Error("rewrapped value opened for another record")
+    }
+
+    again, changed, err := Rewrap(rotated, rewrapped)
+    if err != nil || changed || !bytes.Equal(again, rewrapped) {
+        t.Errorf("rewrapped twice: %v, %v", changed, err)
+    }
+
+    retired, _ := NewKeyring("2024", map[string][]byte{"2024": testKey(2)})
+    if _, _, err := Rewrap(retired, sealed); err == nil {
+        t.Error("rewrapped without the old key")
+    }
+    readOnly := testKeyring(t, "")
+    if _, _, err := Rewrap(readOnly, sealed); !errors.Is(err, ErrNoActiveKey) {
+        t.Errorf("got %v, want ErrNoActiveKey", err)
+    }
+}
+
+func TestKeyring(t *testing.T) {
+    tests := []struct {
+        name   string
+        active string
+        keys   map[string][]byte
+        err    string
+    }{
+        {"valid", "a", map[string][]byte{"a": testKey(1)}, ""},
+        {"open only", "", map[string][]byte{"a": testKey(1)}, ""},
+        {"short key", "a", map[string][]byte{"a": testKey(1)[:16]}, "key a: expected 32 bytes"},
+        {"empty key id", "", map[string][]byte{"": testKey(1)}, `invalid key id ""`},
+        {"long key id", "", map[string][]byte{strings.Repeat("k", 256): testKey(1)}, "invalid key id"},
+        {"missing active key", "b", map[string][]byte{"a": testKey(1)}, "active key b is not in the keyring"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := NewKeyring(test.active, test.keys)
+            if test.err == "" {
+                if err != nil {
+                    t.Error(err)
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("got %v, want %s", err, test.err)
+            }
+        })
+    }
+
+    k := testKeyring(t, "")
+    if _, err := Seal(k, []byte("x"), nil); !errors.Is(err, ErrNoActiveKey) {
+        t.Errorf("got %v, want ErrNoActiveKey", err)
+    }
+}
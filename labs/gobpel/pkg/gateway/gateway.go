@@ -0,0 +1,49 @@
+package gateway
+
+import (
+    "context"
+    "expvar"
+    "net/http"
+
+    "gobpel/api"
+    "gobpel/pkg/metrics"
+
+    "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+    "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+)
+
+// New returns an HTTP handler serving the BPELProcessService as REST/JSON by
+// proxying to the gRPC server at grpcAddr, connecting with creds. gRPC status
+// codes are mapped to HTTP statuses and returned as a JSON status body. The
+// OpenAPI document is served at /openapi.json and the engine counters, such
+// as the execution queue, at /debug/vars and the Prometheus metrics at
+// /metrics. The trace context of requests is
+// passed on to the gRPC server.
+func New(ctx context.Context, grpcAddr string, creds credentials.TransportCredentials) (http.Handler, error) {
+    mux := runtime.NewServeMux(runtime.WithErrorHandler(runtime.DefaultHTTPErrorHandler))
+    opts := []grpc.DialOption{
+        grpc.WithTransportCredentials(creds),
+        grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+    }
+    err := api.RegisterBPELProcessServiceHandlerFromEndpoint(ctx, mux, grpcAddr, opts)
+    if err != nil {
+        return nil, err
+    }
+
+    handler := http.NewServeMux()
+    handler.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+        mux.ServeHTTP(w, r.WithContext(ctx))
+    }))
+    handler.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(api.OpenAPI)
+    })
+    handler.Handle("/debug/vars", expvar.Handler())
+    handler.Handle("/metrics", metrics.Handler())
+    return handler, nil
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+    "context"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "gobpel/api"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+)
+
+// stubServer answers the RPCs the tests call and leaves the others
+// unimplemented.
+type stubServer struct {
+    api.UnimplementedBPELProcessServiceServer
+}
+
+func (stubServer) GetProcess(ctx context.Context, req *api.GetProcessRequest) (*api.Process, error) {
+    if req.ProcessId == "missing" {
+        return nil, status.Error(codes.NotFound, "process not found")
+    }
+    return &api.Process{Name: req.ProcessId, Version: "1"}, nil
+}
+
+func (stubServer) ExecuteProcess(ctx context.Context, req *api.ExecuteProcessRequest) (*api.ExecuteProcessResponse, error) {
+    if req.Input.AsMap()["request"] != "dataset-v3" {
+        return nil, status.Errorf(codes.InvalidArgument, "unexpected input %v", req.Input.AsMap())
+    }
+    return &api.ExecuteProcessResponse{InstanceId: req.ProcessId + "-1"}, nil
+}
+
+func (stubServer) ListInstances(ctx context.Context, req *api.ListInstancesRequest) (*api.ListInstancesResponse, error) {
+    return &api.ListInstancesResponse{Instances: []*api.Instance{{State: req.State, BusinessKeys: req.BusinessKeys}}}, nil
+}
+
+func testGateway(t *testing.T) *httptest.Server {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := grpc.NewServer()
+    api.RegisterBPELProcessServiceServer(server, stubServer{})
+    go server.Serve(lis)
+    t.Cleanup(server.Stop)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    handler, err := New(ctx, lis.Addr().String(), insecure.NewCredentials())
+    if err != nil {
+        t.Fatal(err)
+    }
+    gateway := httptest.NewServer(handler)
+    t.Cleanup(gateway.Close)
+    return gateway
+}
+
+func TestGateway(t *testing.T) {
+    gateway := testGateway(t)
+    tests := []struct {
+        name   string
+        method string
+        path   string
+        body   string
+        code   int
+        want   string
+    }{
+        {"get", http.MethodGet, "/v1/processes/train", "", http.StatusOK, `"name":"train"`},
+        {"not found", http.MethodGet, "/v1/processes/missing", "", http.StatusNotFound, `"code":5`},
+        {"body and path", http.MethodPost, "/v1/processes/train:execute", `{"input":{"request":"dataset-v3"}}`, http.StatusOK, `"instanceId":"train-1"`},
+        {"invalid argument", http.MethodPost, "/v1/processes/train:execute", `{"input":{}}`, http.StatusBadRequest, `"code":3`},
+        {"malformed body", http.MethodPost, "/v1/processes/train:execute", `{"input":`, http.StatusBadRequest, `"code":3`},
+        {"query", http.MethodGet, "/v1/instances?state=running&businessKeys[run]=4", "", http.StatusOK, `"businessKeys":{"run":"4"}`},
+        {"unimplemented", http.MethodGet, "/v1/runMethods", "", http.StatusNotImplemented, `"code":12`},
+        {"unknown path", http.MethodGet, "/v2/processes", "", http.StatusNotFound, ""},
+        {"openapi", http.MethodGet, "/openapi.json", "", http.StatusOK, `"swagger"`},
+        {"metrics", http.MethodGet, "/metrics", "", http.StatusOK, ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            req, err := http.NewRequest(test.method, gateway.URL+test.path, strings.NewReader(test.body))
+            if err != nil {
+                t.Fatal(err)
+            }
+            resp, err := http.DefaultClient.Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer resp.Body.Close()
+            body, _ := io.ReadAll(resp.Body)
+            if resp.StatusCode != test.code || !strings.Contains(string(body), test.want) {
+                t.Errorf("got %d %s, want %d with %s", resp.StatusCode, body, test.code, test.want)
+            }
+        })
+    }
+}
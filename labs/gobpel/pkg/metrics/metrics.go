@@ -0,0 +1,112 @@
+// Package metrics holds the Prometheus metrics of the server. Handler serves
+// them, with those of the Go runtime and the process, in the Prometheus text
+// format.
+package metrics
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/status"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+    RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: "gobpel",
+        Name:      "rpc_duration_seconds",
+        Help:      "Duration of RPCs by method and status code.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"method", "code"})
+
+    InstancesFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: "gobpel",
+        Name:      "instances_finished_total",
+        Help:      "Instances finished by process and final state.",
+    }, []string{"process", "state"})
+
+    ActivityDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: "gobpel",
+        Name:      "activity_duration_seconds",
+        Help:      "Duration of activities by process and activity type.",
+        Buckets:   []float64{.001, .01, .1, .5, 1, 5, 30, 60, 300, 900, 3600},
+    }, []string{"process", "activity"})
+
+    PartnerCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: "gobpel",
+        Name:      "partner_call_duration_seconds",
+        Help:      "Duration of partner calls by partner link and status.",
+        Buckets:   []float64{.005, .01, .05, .1, .5, 1, 5, 30, 60, 300, 900, 3600},
+    }, []string{"partner_link", "status"})
+
+    // Replays counts work done again rather than anew; partner calls are
+    // not retried by the engine
+    Replays = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: "gobpel",
+        Name:      "replays_total",
+        Help:      "Requests repeated with an idempotency key already used and instances resumed after a takeover.",
+    }, []string{"reason"})
+
+    MongoDBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: "gobpel",
+        Name:      "mongodb_operation_duration_seconds",
+        Help:      "Duration of MongoDB commands by command and status.",
+        Buckets:   []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5},
+    }, []string{"command", "status"})
+)
+
+func init() {
+    registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+        RPCDuration,
+        InstancesFinished,
+        ActivityDuration,
+        PartnerCallDuration,
+        Replays,
+        MongoDBDuration,
+    )
+}
+
+// Register adds collectors of the server state, such as the queue depth, to
+// the metrics served by Handler.
+func Register(cs ...prometheus.Collector) error {
+    for _, c := range cs {
+        if err := registry.Register(c); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+    return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// UnaryServerInterceptor observes the duration of unary RPCs.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        start := time.Now()
+        resp, err := handler(ctx, req)
+        RPCDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+        return resp, err
+    }
+}
+
+// StreamServerInterceptor observes the duration of streaming RPCs, such as
+// WatchInstance, from their start until they end.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+    return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+        start := time.Now()
+        err := handler(srv, stream)
+        RPCDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+        return err
+    }
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+    "context"
+    "io"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+    interceptor := UnaryServerInterceptor()
+    info := &grpc.UnaryServerInfo{FullMethod: "/bpel.BPELProcessService/GetProcess"}
+    interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+        return nil, nil
+    })
+    interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+        return nil, status.Error(codes.NotFound, "process not found")
+    })
+
+    rec := httptest.NewRecorder()
+    Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+    body, _ := io.ReadAll(rec.Body)
+    for _, want := range []string{
+        `gobpel_rpc_duration_seconds_count{code="OK",method="/bpel.BPELProcessService/GetProcess"} 1`,
+        `gobpel_rpc_duration_seconds_count{code="NotFound",method="/bpel.BPELProcessService/GetProcess"} 1`,
+        "go_goroutines",
+        "process_cpu_seconds_total",
+    } {
+        if !strings.Contains(string(body), want) {
+            t.Errorf("metrics do not contain %s", want)
+        }
+    }
+}
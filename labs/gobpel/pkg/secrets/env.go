@@ -0,0 +1,31 @@
+package secrets
+
+import (
+    "context"
+    "os"
+    "strings"
+)
+
+// Env reads secrets from environment variables named by Prefix and the
+// secret name in upper case, with characters other than letters and digits
+// replaced by _: openai-key is GOBPEL_SECRET_OPENAI_KEY by default.
+type Env struct {
+    Prefix string
+}
+
+func (e Env) Secret(ctx context.Context, name string) (string, error) {
+    variable := e.Prefix + strings.Map(func(r rune) rune {
+        switch {
+        case r >= 'a' && r <= 'z':
+            return r - 'a' + 'A'
+        case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+            return r
+        }
+        return '_'
+    }, name)
+    value, ok := os.LookupEnv(variable)
+    if !ok {
+        return "", ErrNotFound
+    }
+    return value, nil
+}
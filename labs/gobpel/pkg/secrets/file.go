@@ -0,0 +1,178 @@
+package secrets
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The secrets file is checked for changes at most this often
+const fileCheckInterval = 10 * time.Second
+
+// encryptedFile is the JSON form of a secrets file: the secrets as a JSON
+// object sealed with AES-256-GCM.
+type encryptedFile struct {
+    Nonce []byte `json:"nonce"`
+    Data  []byte `json:"data"`
+}
+
+// NewKey returns a random key for a secrets file.
+func NewKey() ([]byte, error) {
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
+
+// ReadKey reads a key file holding a base64 encoded 32 byte key.
+func ReadKey(name string) ([]byte, error) {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return nil, err
+    }
+    key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil || len(key) != 32 {
+        return nil, fmt.Errorf("%s: expected a base64 encoded 32 byte key", name)
+    }
+    return key, nil
+}
+
+// WriteKey writes key to a new key file readable only by its owner.
+func WriteKey(name string, key []byte) error {
+    return os.WriteFile(name, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+}
+
+// ReadFile decrypts the secrets file name with key. A missing file holds no
+// secrets.
+func ReadFile(name string, key []byte) (map[string]string, error) {
+    data, err := os.ReadFile(name)
+    if errors.Is(err, os.ErrNotExist) {
+        return map[string]string{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var file encryptedFile
+    if err := json.Unmarshal(data, &file); err != nil {
+        return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+    if len(file.Nonce) != aead.NonceSize() {
+        return nil, fmt.Errorf("%s: invalid nonce", name)
+    }
+    plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+    if err != nil {
+        return nil, fmt.Errorf("%s: cannot decrypt, wrong key or corrupted file", name)
+    }
+    secrets := make(map[string]string)
+    if err := json.Unmarshal(plain, &secrets); err != nil {
+        return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    return secrets, nil
+}
+
+// WriteFile encrypts secrets with key into the file name, replacing it.
+func WriteFile(name string, key []byte, secrets map[string]string) error {
+    plain, err := json.Marshal(secrets)
+    if err != nil {
+        return err
+    }
+    aead, err := newAEAD(key)
+    if err != nil {
+        return err
+    }
+    file := encryptedFile{Nonce: make([]byte, aead.NonceSize())}
+    if _, err := rand.Read(file.Nonce); err != nil {
+        return err
+    }
+    file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+    data, err := json.MarshalIndent(file, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := name + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, name)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// File serves the secrets of an encrypted secrets file, reloaded when the
+// file changes.
+type File struct {
+    name string
+    key  []byte
+
+    mu      sync.Mutex
+    secrets map[string]string
+    version string
+    checked time.Time
+}
+
+// OpenFile reads the secrets file name with the key in keyFile.
+func OpenFile(name, keyFile string) (*File, error) {
+    key, err := ReadKey(keyFile)
+    if err != nil {
+        return nil, err
+    }
+    f := &File{name: name, key: key}
+    if err := f.reload(); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+func (f *File) reload() error {
+    f.checked = time.Now()
+    var version string
+    if info, err := os.Stat(f.name); err == nil {
+        version = fmt.Sprintf("%v/%d", info.ModTime(), info.Size())
+    }
+    if version == f.version && f.secrets != nil {
+        return nil
+    }
+    secrets, err := ReadFile(f.name, f.key)
+    if err != nil {
+        return err
+    }
+    f.secrets, f.version = secrets, version
+    return nil
+}
+
+func (f *File) Secret(ctx context.Context, name string) (string, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if time.Since(f.checked) >= fileCheckInterval {
+        // Secrets loaded before are kept while the file cannot be read
+        if err := f.reload(); err != nil {
+            log.Printf("Error reloading secrets file %s: %v", f.name, err)
+        }
+    }
+    value, ok := f.secrets[name]
+    if !ok {
+        return "", ErrNotFound
+    }
+    return value, nil
+}
@@ -0,0 +1,71 @@
+// Package secrets resolves ${secret:name} references, such as the API keys
+// in partner headers, against a secret provider. Resolved values are only
+// handed to the caller and never stored by the engine.
+package secrets
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Provider looks up secrets by name.
+type Provider interface {
+    Secret(ctx context.Context, name string) (string, error)
+}
+
+var ErrNotFound = errors.New("secret not found")
+
+const referencePrefix = "${secret:"
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./#-]*$`)
+
+// References returns the names of the secrets referenced in s.
+func References(s string) ([]string, error) {
+    var names []string
+    for {
+        start := strings.Index(s, referencePrefix)
+        if start < 0 {
+            return names, nil
+        }
+        s = s[start+len(referencePrefix):]
+        end := strings.IndexByte(s, '}')
+        if end < 0 {
+            return nil, errors.New("unterminated ${secret: reference")
+        }
+        name := s[:end]
+        if !namePattern.MatchString(name) {
+            return nil, fmt.Errorf("invalid secret name %q", name)
+        }
+        names = append(names, name)
+        s = s[end+1:]
+    }
+}
+
+// Expand replaces the references in s with the secrets of p. Errors name
+// the secret but never contain a value.
+func Expand(ctx context.Context, p Provider, s string) (string, error) {
+    names, err := References(s)
+    if err != nil || len(names) == 0 {
+        return s, err
+    }
+    if p == nil {
+        return "", errors.New("no secret provider")
+    }
+    var b strings.Builder
+    for _, name := range names {
+        value, err := p.Secret(ctx, name)
+        if err != nil {
+            return "", fmt.Errorf("secret %s: %w", name, err)
+        }
+        reference := referencePrefix + name + "}"
+        i := strings.Index(s, reference)
+        b.WriteString(s[:i])
+        b.WriteString(value)
+        s = s[i+len(reference):]
+    }
+    b.WriteString(s)
+    return b.String(), nil
+}
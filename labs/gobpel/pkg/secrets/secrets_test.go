@@ -0,0 +1,120 @@
+package secrets
+
+import (
+    "context"
+    "errors"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+type mapProvider map[string]string
+
+func (m mapProvider) Secret(ctx context.Context, name string) (string, error) {
+    value, ok := m[name]
+    if !ok {
+        return "", ErrNotFound
+    }
+    return value, nil
+}
+
+func TestExpand(t *testing.T) {
+    provider := mapProvider{"openai-key": "sk-123", "user": "alice"}
+    tests := []struct {
+        name  string
+        value string
+        want  string
+        err   string
+    }{
+        {"plain", "application/json", "application/json", ""},
+        {"reference", "Bearer ${secret:openai-key}", "Bearer sk-123", ""},
+        {"several", "${secret:user}:${secret:openai-key}", "alice:sk-123", ""},
+        {"missing", "Bearer ${secret:other}", "", "secret other: secret not found"},
+        {"unterminated", "${secret:openai-key", "", "unterminated"},
+        {"invalid name", "${secret:a b}", "", `invalid secret name "a b"`},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := Expand(context.Background(), provider, test.value)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got %q, %v, want error %s", got, err, test.err)
+                }
+                if strings.Contains(err.Error(), "sk-123") {
+                    t.Errorf("error %v contains a secret", err)
+                }
+                return
+            }
+            if err != nil || got != test.want {
+                t.Errorf("got %q, %v, want %q", got, err, test.want)
+            }
+        })
+    }
+}
+
+func TestEnv(t *testing.T) {
+    t.Setenv("TEST_SECRET_OPENAI_KEY", "sk-123")
+    env := Env{Prefix: "TEST_SECRET_"}
+    if value, err := env.Secret(context.Background(), "openai-key"); err != nil || value != "sk-123" {
+        t.Errorf("got %q, %v", value, err)
+    }
+    if _, err := env.Secret(context.Background(), "other"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("got %v for a missing secret", err)
+    }
+}
+
+func TestFile(t *testing.T) {
+    dir := t.TempDir()
+    name, keyFile := filepath.Join(dir, "secrets.json"), filepath.Join(dir, "secrets.key")
+    key, err := NewKey()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := WriteKey(keyFile, key); err != nil {
+        t.Fatal(err)
+    }
+    if err := WriteFile(name, key, map[string]string{"openai-key": "sk-123"}); err != nil {
+        t.Fatal(err)
+    }
+
+    f, err := OpenFile(name, keyFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if value, err := f.Secret(context.Background(), "openai-key"); err != nil || value != "sk-123" {
+        t.Errorf("got %q, %v", value, err)
+    }
+    if _, err := f.Secret(context.Background(), "other"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("got %v for a missing secret", err)
+    }
+
+    other, err := NewKey()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ReadFile(name, other); err == nil || !strings.Contains(err.Error(), "wrong key") {
+        t.Errorf("got %v decrypting with another key", err)
+    }
+}
+
+func TestVault(t *testing.T) {
+    stub := NewVaultStub("token", "secret")
+    stub.Put("openai/api-key", "value", "sk-123")
+    stub.Put("openai/api-key", "org", "org-1")
+    server := httptest.NewServer(stub)
+    defer server.Close()
+
+    v := NewVault(server.URL, "token", "secret", 0)
+    for name, want := range map[string]string{"openai/api-key": "sk-123", "openai/api-key#org": "org-1"} {
+        if value, err := v.Secret(context.Background(), name); err != nil || value != want {
+            t.Errorf("%s: got %q, %v, want %q", name, value, err, want)
+        }
+    }
+    if _, err := v.Secret(context.Background(), "other"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("got %v for a missing secret", err)
+    }
+    if _, err := NewVault(server.URL, "wrong", "secret", 0).Secret(context.Background(), "openai/api-key"); err == nil {
+        t.Error("read a secret with a wrong token")
+    }
+}
@@ -0,0 +1,176 @@
+package secrets
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Vault reads secrets from the KV version 2 engine of a Vault compatible
+// server. The secret openai/api-key#token is the field token of the entry
+// openai/api-key; without a field it is the field value. Secrets are kept
+// for the cache TTL so invokes do not each call the server.
+type Vault struct {
+    addr   string
+    token  string
+    mount  string
+    ttl    time.Duration
+    client *http.Client
+
+    mu     sync.Mutex
+    cached map[string]cachedSecret
+}
+
+type cachedSecret struct {
+    value   string
+    expires time.Time
+}
+
+// NewVault reads secrets from the engine mounted at mount on the server at
+// addr, authenticating with token.
+func NewVault(addr, token, mount string, ttl time.Duration) *Vault {
+    return &Vault{
+        addr:   strings.TrimSuffix(addr, "/"),
+        token:  token,
+        mount:  strings.Trim(mount, "/"),
+        ttl:    ttl,
+        client: &http.Client{Timeout: 10 * time.Second},
+        cached: make(map[string]cachedSecret),
+    }
+}
+
+func (v *Vault) Secret(ctx context.Context, name string) (string, error) {
+    v.mu.Lock()
+    cached, ok := v.cached[name]
+    v.mu.Unlock()
+    if ok && time.Now().Before(cached.expires) {
+        return cached.value, nil
+    }
+
+    path, field, _ := strings.Cut(name, "#")
+    if field == "" {
+        field = "value"
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.mount+"/data/"+path, nil)
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("X-Vault-Token", v.token)
+    resp, err := v.client.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound {
+        return "", ErrNotFound
+    }
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("vault returned %s", resp.Status)
+    }
+    var body struct {
+        Data struct {
+            Data map[string]interface{} `json:"data"`
+        } `json:"data"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+        return "", fmt.Errorf("invalid vault response: %v", err)
+    }
+    value, ok := body.Data.Data[field].(string)
+    if !ok {
+        return "", ErrNotFound
+    }
+
+    if v.ttl > 0 {
+        v.mu.Lock()
+        v.cached[name] = cachedSecret{value: value, expires: time.Now().Add(v.ttl)}
+        v.mu.Unlock()
+    }
+    return value, nil
+}
+
+// VaultStub is an in-memory stand-in for the KV version 2 engine of Vault,
+// for local development and tests. It serves reads and writes of entries
+// under /v1/<mount>/data/ to callers presenting its token.
+type VaultStub struct {
+    token string
+    mount string
+
+    mu      sync.Mutex
+    entries map[string]map[string]interface{}
+}
+
+// NewVaultStub serves the engine mounted at mount to callers with token.
+func NewVaultStub(token, mount string) *VaultStub {
+    return &VaultStub{token: token, mount: strings.Trim(mount, "/"), entries: make(map[string]map[string]interface{})}
+}
+
+// Put sets a field of the entry at path.
+func (s *VaultStub) Put(path, field, value string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.entries[path] == nil {
+        s.entries[path] = make(map[string]interface{})
+    }
+    s.entries[path][field] = value
+}
+
+func (s *VaultStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("X-Vault-Token") != s.token {
+        writeVaultError(w, http.StatusForbidden, "permission denied")
+        return
+    }
+    path, ok := strings.CutPrefix(r.URL.Path, "/v1/"+s.mount+"/data/")
+    if !ok || path == "" {
+        writeVaultError(w, http.StatusNotFound, "no handler for route")
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+        s.mu.Lock()
+        data, ok := s.entries[path]
+        s.mu.Unlock()
+        if !ok {
+            writeVaultError(w, http.StatusNotFound, "")
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "data": map[string]interface{}{"data": data},
+        })
+    case http.MethodPut, http.MethodPost:
+        var body struct {
+            Data map[string]interface{} `json:"data"`
+        }
+        if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil || body.Data == nil {
+            writeVaultError(w, http.StatusBadRequest, "expected {\"data\": {...}}")
+            return
+        }
+        s.mu.Lock()
+        s.entries[path] = body.Data
+        s.mu.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+    case http.MethodDelete:
+        s.mu.Lock()
+        delete(s.entries, path)
+        s.mu.Unlock()
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        writeVaultError(w, http.StatusMethodNotAllowed, "unsupported method")
+    }
+}
+
+func writeVaultError(w http.ResponseWriter, code int, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    errs := []string{}
+    if message != "" {
+        errs = append(errs, message)
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}